@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const auditBucket = "audit_log"
+
+// auditEntry records an account-level action. It holds IDs only, so the
+// log stays valid after the account's personal data is gone.
+type auditEntry struct {
+	At     time.Time `json:"at"`
+	Action string    `json:"action"`
+	UserID string    `json:"user_id"`
+	Actor  string    `json:"actor"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+func audit(action, userID, actor, detail string) {
+	e := auditEntry{At: time.Now().UTC(), Action: action, UserID: userID, Actor: actor, Detail: detail}
+	if err := store.Put(auditBucket, e.At.Format(time.RFC3339Nano)+"/"+userID, e); err != nil {
+		slog.Error("could not write audit entry", "action", action, "user", userID, "error", err)
+	}
+}
+
+func getAuditLog(c *gin.Context) {
+	entries := []auditEntry{}
+	store.ForEach(auditBucket, func(_ string, value []byte) error {
+		var e auditEntry
+		if json.Unmarshal(value, &e) == nil && (c.Query("user_id") == "" || e.UserID == c.Query("user_id")) {
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].At.After(entries[j].At) })
+	respondList(c, http.StatusOK, entries, listMeta{Total: len(entries)}, entries)
+}
+
+// forEachUserValue calls fn for every value in bucket under the user's
+// "<id>/" prefix.
+func forEachUserValue(bucket, userID string, fn func(value []byte)) {
+	store.ForEachPrefix(bucket, userID+"/", func(_ string, value []byte) error {
+		fn(value)
+		return nil
+	})
+}
+
+func userComments(userID string) []Comment {
+	comments := []Comment{}
+	store.ForEach(commentsBucket, func(_ string, value []byte) error {
+		var cm Comment
+		if json.Unmarshal(value, &cm) == nil && cm.UserID == userID {
+			comments = append(comments, cm)
+		}
+		return nil
+	})
+	return comments
+}
+
+// oauthIdentities returns the provider identities linked to the user.
+func oauthIdentities(userID string) []string {
+	identities := []string{}
+	store.ForEach(oauthIdentitiesBucket, func(key string, value []byte) error {
+		var id string
+		if json.Unmarshal(value, &id) == nil && id == userID {
+			identities = append(identities, key)
+		}
+		return nil
+	})
+	return identities
+}
+
+func userSessions(userID string) []browserSession {
+	sessions := []browserSession{}
+	store.ForEach(sessionsBucket, func(_ string, value []byte) error {
+		var s browserSession
+		if json.Unmarshal(value, &s) == nil && s.UserID == userID {
+			sessions = append(sessions, s)
+		}
+		return nil
+	})
+	return sessions
+}
+
+// getExport returns everything stored about the caller as one JSON
+// document. Credentials (password hash, session IDs and CSRF tokens,
+// webhook secrets) are left out.
+func getExport(c *gin.Context) {
+	u := currentUser(c)
+
+	follows := []Follow{}
+	forEachUserValue(followsBucket, u.ID, func(value []byte) {
+		var f Follow
+		if json.Unmarshal(value, &f) == nil {
+			follows = append(follows, f)
+		}
+	})
+	progress := []SeriesProgress{}
+	forEachUserValue(progressBucket, u.ID, func(value []byte) {
+		var p SeriesProgress
+		if json.Unmarshal(value, &p) == nil {
+			progress = append(progress, p)
+		}
+	})
+	webhooks := []Webhook{}
+	forEachUserValue(webhooksBucket, u.ID, func(value []byte) {
+		var h Webhook
+		if json.Unmarshal(value, &h) == nil {
+			h.Secret = ""
+			webhooks = append(webhooks, h)
+		}
+	})
+	trash := []trashEntry{}
+	forEachUserValue(trashBucket, u.ID, func(value []byte) {
+		var e trashEntry
+		if json.Unmarshal(value, &e) == nil {
+			trash = append(trash, e)
+		}
+	})
+	sessions := []gin.H{}
+	for _, s := range userSessions(u.ID) {
+		sessions = append(sessions, gin.H{"created_at": s.CreatedAt, "expires_at": s.ExpiresAt})
+	}
+	profile := u.public()
+	profile["digest_enabled"] = u.DigestEnabled
+
+	audit("account_exported", u.ID, "user:"+u.ID, "")
+	c.Header("Content-Disposition", `attachment; filename="movie-api-export-`+u.ID+`.json"`)
+	c.JSON(http.StatusOK, gin.H{
+		"format_version":   1,
+		"exported_at":      time.Now().UTC(),
+		"profile":          profile,
+		"watches":          userWatches(u.ID),
+		"lists":            userLists(u.ID, nil),
+		"comments":         userComments(u.ID),
+		"follows":          follows,
+		"series_progress":  progress,
+		"webhooks":         webhooks,
+		"trash":            trash,
+		"tags":             ownerTags(u.ID),
+		"saved_searches":   userSavedSearches(u.ID),
+		"linked_providers": oauthIdentities(u.ID),
+		"sessions":         sessions,
+	})
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+	// Confirm must repeat the account email for accounts without a
+	// password (OAuth sign-in only).
+	Confirm string `json:"confirm"`
+}
+
+// deleteMe schedules the caller's account for deletion after the
+// configured grace period, or deletes it right away if there is none.
+// The request must prove it comes from the account holder: the password,
+// or the account email for passwordless accounts.
+func deleteMe(c *gin.Context) {
+	u := currentUser(c)
+	var req deleteAccountRequest
+	c.ShouldBindJSON(&req)
+	verified := false
+	if len(u.PasswordHash) > 0 {
+		verified = bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(req.Password)) == nil
+	} else {
+		verified = req.Confirm != "" && strings.EqualFold(strings.TrimSpace(req.Confirm), u.Email)
+	}
+	if !verified {
+		audit("account_deletion_rejected", u.ID, "user:"+u.ID, "verification failed")
+		c.JSON(http.StatusForbidden, gin.H{"error": `confirm with {"password": "..."}, or {"confirm": "<your email>"} if you sign in with a provider`})
+		return
+	}
+
+	grace := time.Duration(cfg().AccountDeletionGrace)
+	if grace <= 0 {
+		audit("account_deletion_requested", u.ID, "user:"+u.ID, "immediate")
+		if err := deleteAccount(u); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete account"})
+			return
+		}
+		clearSessionCookies(c)
+		c.Status(http.StatusNoContent)
+		return
+	}
+	at := time.Now().UTC().Add(grace)
+	u.DeletionScheduledAt = &at
+	if err := store.Put(usersBucket, u.ID, u); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not schedule deletion"})
+		return
+	}
+	audit("account_deletion_requested", u.ID, "user:"+u.ID, "scheduled for "+at.Format(time.RFC3339))
+	c.JSON(http.StatusAccepted, gin.H{
+		"deletion_scheduled_at": at,
+		"message":               "your account will be deleted then; DELETE /api/users/me/deletion cancels",
+	})
+}
+
+func cancelAccountDeletion(c *gin.Context) {
+	u := currentUser(c)
+	if u.DeletionScheduledAt == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no deletion is scheduled"})
+		return
+	}
+	u.DeletionScheduledAt = nil
+	if err := store.Put(usersBucket, u.ID, u); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not cancel deletion"})
+		return
+	}
+	audit("account_deletion_cancelled", u.ID, "user:"+u.ID, "")
+	c.JSON(http.StatusOK, u.public())
+}
+
+// deleteAccount erases the user's data. Comments stay in their threads as
+// "[removed]" placeholders so replies keep their context, but lose their
+// body and author.
+func deleteAccount(u *User) error {
+	for _, w := range userWatches(u.ID) {
+		store.Delete(watchesBucket, u.ID+"/"+w.ID)
+	}
+	for _, l := range userLists(u.ID, nil) {
+		store.Delete(listsBucket, l.ID)
+	}
+	for _, cm := range userComments(u.ID) {
+		cm.Status, cm.DeletedBy, cm.Body, cm.AuthorName, cm.UserID, cm.Reports = commentDeleted, deletedByAuthor, "", "", "", nil
+		store.Put(commentsBucket, commentKey(cm.TenantID, cm.IMDBID, cm.ID), cm)
+	}
+	forEachUserValue(webhooksBucket, u.ID, func(value []byte) {
+		var h Webhook
+		if json.Unmarshal(value, &h) == nil {
+			deletePrefix(webhookDeliveriesBucket, h.ID+"/")
+		}
+	})
+	for _, bucket := range []string{followsBucket, progressBucket, webhooksBucket, trashBucket, titleTagsBucket, savedSearchesBucket} {
+		deletePrefix(bucket, u.ID+"/")
+	}
+	for _, s := range userSessions(u.ID) {
+		store.Delete(sessionsBucket, s.ID)
+	}
+	for _, identity := range oauthIdentities(u.ID) {
+		store.Delete(oauthIdentitiesBucket, identity)
+	}
+	for _, key := range []string{tenantKey(u.TenantID, u.Email), u.Email} {
+		var owner string
+		if found, _ := store.Get(usersByEmailBucket, key, &owner); found && owner == u.ID {
+			store.Delete(usersByEmailBucket, key)
+		}
+	}
+	if u.Handle != "" {
+		store.Delete(usersByHandleBucket, tenantKey(u.TenantID, u.Handle))
+	}
+	if err := store.Delete(usersBucket, u.ID); err != nil {
+		return err
+	}
+	audit("account_deleted", u.ID, "system", "")
+	return nil
+}
+
+func deletePrefix(bucket, prefix string) {
+	var keys []string
+	store.ForEachPrefix(bucket, prefix, func(key string, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	for _, key := range keys {
+		store.Delete(bucket, key)
+	}
+}
+
+// purgeAccounts periodically deletes accounts whose grace period is over.
+func purgeAccounts(every time.Duration) {
+	for range time.Tick(every) {
+		leaderOnly("account_purge", purgeDueAccounts)
+	}
+}
+
+func purgeDueAccounts() {
+	var due []*User
+	now := time.Now()
+	store.ForEach(usersBucket, func(_ string, value []byte) error {
+		var u User
+		if json.Unmarshal(value, &u) == nil && u.DeletionScheduledAt != nil && now.After(*u.DeletionScheduledAt) {
+			due = append(due, &u)
+		}
+		return nil
+	})
+	for _, u := range due {
+		if err := deleteAccount(u); err != nil {
+			slog.Error("account deletion failed", "user", u.ID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// deleteNow deletes the signed-in user's account without a grace period.
+func deleteNow(t *testing.T, user map[string]string) {
+	t.Helper()
+	withConfig(t, func(c *Config) { c.AccountDeletionGrace = 0 })
+	w := request(http.MethodDelete, "/api/users/me", user, `{"password":"correct horse"}`)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete account: status %d; body %s", w.Code, w.Body)
+	}
+}
+
+// exported returns a section of the signed-in user's account export.
+func exported(t *testing.T, user map[string]string, section string) []interface{} {
+	t.Helper()
+	w := request(http.MethodGet, "/api/users/me/export", user, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: status %d; body %s", w.Code, w.Body)
+	}
+	items, ok := decodeObject(t, w)[section].([]interface{})
+	if !ok {
+		t.Fatalf("export has no %s list; body %s", section, w.Body)
+	}
+	return items
+}
+
+func TestAccountTags(t *testing.T) {
+	user, id := newUser(t)
+	w := request(http.MethodPost, "/api/movies/tt0133093/tags", user, `{"tag":"rewatch"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("tag: status %d; body %s", w.Code, w.Body)
+	}
+	tags := exported(t, user, "tags")
+	if len(tags) != 1 || fmt.Sprint(tags[0].(map[string]interface{})["tag"]) != "rewatch" {
+		t.Errorf("export has tags %v, want the one added", tags)
+	}
+	deleteNow(t, user)
+	if tags := ownerTags(id); len(tags) != 0 {
+		t.Errorf("a deleted user's tags remain: %v", tags)
+	}
+}
+
+func TestAccountSavedSearches(t *testing.T) {
+	user, id := newUser(t)
+	w := request(http.MethodPost, "/api/users/me/saved-searches", user, `{"name":"heists","filter":{"q":"heat"}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("save search: status %d; body %s", w.Code, w.Body)
+	}
+	searches := exported(t, user, "saved_searches")
+	if len(searches) != 1 || fmt.Sprint(searches[0].(map[string]interface{})["name"]) != "heists" {
+		t.Errorf("export has saved searches %v, want the one saved", searches)
+	}
+	deleteNow(t, user)
+	if searches := userSavedSearches(id); len(searches) != 0 {
+		t.Errorf("a deleted user's saved searches remain, and would still notify: %v", searches)
+	}
+}
+
+func TestAccountExport(t *testing.T) {
+	user, id := newUser(t)
+	newList(t, user)
+	newComment(t, user)
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "export",
+			path:        "/api/users/me/export",
+			headers:     user,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"format_version": "1"},
+			wantKeys:    []string{"profile", "watches", "lists", "comments", "follows", "webhooks", "trash", "tags", "saved_searches", "sessions"},
+			wantHeaders: map[string]string{"Content-Disposition": `attachment; filename="movie-api-export-` + id + `.json"`},
+			wantLookups: 0,
+		},
+		{
+			name:        "anonymous",
+			path:        "/api/users/me/export",
+			wantStatus:  http.StatusUnauthorized,
+			wantLookups: 0,
+		},
+	})
+	if lists := exported(t, user, "lists"); len(lists) != 1 {
+		t.Errorf("export has %d lists, want 1", len(lists))
+	}
+	if comments := exported(t, user, "comments"); len(comments) != 1 {
+		t.Errorf("export has %d comments, want 1", len(comments))
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	user, _ := newUser(t)
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "wrong password",
+			method:      http.MethodDelete,
+			path:        "/api/users/me",
+			headers:     user,
+			body:        `{"password":"wrong horse"}`,
+			wantStatus:  http.StatusForbidden,
+			wantLookups: 0,
+		},
+		{
+			name:        "no deletion to cancel",
+			method:      http.MethodDelete,
+			path:        "/api/users/me/deletion",
+			headers:     user,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "schedule deletion",
+			method:      http.MethodDelete,
+			path:        "/api/users/me",
+			headers:     user,
+			body:        `{"password":"correct horse"}`,
+			wantStatus:  http.StatusAccepted,
+			wantKeys:    []string{"deletion_scheduled_at"},
+			wantLookups: 0,
+		},
+		{
+			name:        "cancel it",
+			method:      http.MethodDelete,
+			path:        "/api/users/me/deletion",
+			headers:     user,
+			wantStatus:  http.StatusOK,
+			wantNoKeys:  []string{"deletion_scheduled_at"},
+			wantLookups: 0,
+		},
+	})
+
+	user, id := newUser(t)
+	list, comment := newList(t, user), newComment(t, user)
+	email := decodeObject(t, request(http.MethodGet, "/api/users/me", user, ""))["email"].(string)
+	deleteNow(t, user)
+	if _, ok := loadList(list); ok {
+		t.Error("a deleted user's list remains")
+	}
+	if cm, ok := loadComment("", "tt0133093", comment); !ok || cm.Status != commentDeleted || cm.DeletedBy != deletedByAuthor || cm.Body != "" || cm.UserID != "" {
+		t.Errorf("a deleted user's comment = %+v, want an anonymous placeholder", cm)
+	}
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "the token no longer works",
+			path:        "/api/users/me",
+			headers:     user,
+			wantStatus:  http.StatusUnauthorized,
+			wantJSON:    map[string]string{"error": "user no longer exists"},
+			wantLookups: 0,
+		},
+		{
+			name:        "the email is free again",
+			method:      http.MethodPost,
+			path:        "/api/auth/register",
+			body:        `{"email":"` + email + `","password":"correct horse"}`,
+			wantStatus:  http.StatusCreated,
+			wantLookups: 0,
+		},
+	})
+	if _, ok := loadUser(id); ok {
+		t.Error("the deleted user remains")
+	}
+}
+
+func TestScheduledAccountDeletion(t *testing.T) {
+	user, id := newUser(t)
+	w := request(http.MethodDelete, "/api/users/me", user, `{"password":"correct horse"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("schedule deletion: status %d; body %s", w.Code, w.Body)
+	}
+	purgeDueAccounts()
+	u, ok := loadUser(id)
+	if !ok {
+		t.Fatal("deleted before the grace period ran out")
+	}
+	past := time.Now().Add(-time.Minute)
+	u.DeletionScheduledAt = &past
+	store.Put(usersBucket, u.ID, u)
+	purgeDueAccounts()
+	if _, ok := loadUser(id); ok {
+		t.Error("still there after the grace period ran out")
+	}
+}
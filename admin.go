@@ -0,0 +1,92 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, memoryCache.Stats())
+}
+
+// purgeCache drops a single movie/search entry (?id=, ?title=, ?search=) or,
+// with no parameters, the whole cache, on every replica. An entry is
+// dropped for every tenant, or only for ?tenant= (empty for the default
+// tenant) when given.
+func purgeCache(c *gin.Context) {
+	var params map[string]string
+	switch {
+	case c.Query("id") != "":
+		params = map[string]string{"i": c.Query("id")}
+	case c.Query("title") != "":
+		params = map[string]string{"t": c.Query("title")}
+	case c.Query("search") != "":
+		params = map[string]string{"s": c.Query("search"), "type": "movie", "page": c.DefaultQuery("page", "1")}
+	}
+	msg := invalidation{Op: "purge"}
+	if params != nil {
+		tenants, ok := c.GetQueryArray("tenant")
+		if !ok {
+			tenants = append([]string{""}, tenantIDs()...)
+		}
+		msg = invalidation{Op: "delete"}
+		for _, tenant := range tenants {
+			scoped := maps.Clone(params)
+			if tenant != "" {
+				scoped[tenantParam] = tenant
+			}
+			msg.Keys = append(msg.Keys, cacheKey(scoped))
+		}
+	}
+
+	if err := invalidate(c.Request.Context(), msg); err != nil {
+		c.JSON(http.StatusAccepted, gin.H{"purged": msg, "warning": "purged locally but failed to notify other replicas: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"purged": msg})
+}
+
+func getConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, redactedConfig(cfg()))
+}
+
+func postConfigReload(c *gin.Context) {
+	config, err := reloadConfig()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, redactedConfig(config))
+}
+
+// redactedConfig is c as the admin API shows it. Flag API keys are cut to
+// their last four characters, enough to tell them apart, or to nothing if
+// short; chat hook URLs, which carry their own token, to scheme and host.
+func redactedConfig(c *Config) Config {
+	out := *c
+	out.Flags = make(map[string]Flag, len(c.Flags))
+	for name, f := range c.Flags {
+		keys := make([]string, len(f.APIKeys))
+		for i, key := range f.APIKeys {
+			keys[i] = "…"
+			if len(key) >= 16 {
+				keys[i] += key[len(key)-4:]
+			}
+		}
+		f.APIKeys = keys
+		out.Flags[name] = f
+	}
+	out.ChatHooks = make([]ChatHook, len(c.ChatHooks))
+	for i, h := range c.ChatHooks {
+		if u, err := url.Parse(h.URL); err == nil && u.Host != "" {
+			h.URL = u.Scheme + "://" + u.Host + "/…"
+		} else {
+			h.URL = "…"
+		}
+		out.ChatHooks[i] = h
+	}
+	return out
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPurgeCacheReachesTenants(t *testing.T) {
+	tenantKey := map[string]string{"X-API-Key": newAPIKey(t, roleUser, newTenant(t))}
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "tenant lookup",
+			path:        "/api/movie?id=tt0068646",
+			headers:     tenantKey,
+			wantStatus:  http.StatusOK,
+			wantLookups: 1,
+		},
+		{
+			name:        "default tenant lookup",
+			path:        "/api/movie?id=tt0068646",
+			wantStatus:  http.StatusOK,
+			wantLookups: 1,
+		},
+		{
+			name:        "purge the title",
+			method:      http.MethodPost,
+			path:        "/admin/cache/purge?id=tt0068646",
+			headers:     adminHeaders,
+			wantStatus:  http.StatusOK,
+			wantLookups: 0,
+		},
+		{
+			name:        "tenant lookup after the purge",
+			path:        "/api/movie?id=tt0068646",
+			headers:     tenantKey,
+			wantStatus:  http.StatusOK,
+			wantLookups: 1,
+		},
+		{
+			name:        "default tenant lookup after the purge",
+			path:        "/api/movie?id=tt0068646",
+			wantStatus:  http.StatusOK,
+			wantLookups: 1,
+		},
+		{
+			name:        "purge for the default tenant only",
+			method:      http.MethodPost,
+			path:        "/admin/cache/purge?id=tt0068646&tenant=",
+			headers:     adminHeaders,
+			wantStatus:  http.StatusOK,
+			wantLookups: 0,
+		},
+		{
+			name:        "tenant entry is kept",
+			path:        "/api/movie?id=tt0068646",
+			headers:     tenantKey,
+			wantStatus:  http.StatusOK,
+			wantLookups: 0,
+		},
+		{
+			name:        "default tenant entry is gone",
+			path:        "/api/movie?id=tt0068646",
+			wantStatus:  http.StatusOK,
+			wantLookups: 1,
+		},
+	})
+}
+
+func TestConfigRedactsSecrets(t *testing.T) {
+	key := newAPIKey(t, roleUser, "")
+	hook := "https://hooks.slack.com/services/T000/B000/secret-token"
+	withConfig(t, func(c *Config) {
+		c.Flags = map[string]Flag{flagNLSearch: {APIKeys: []string{key}}}
+		c.ChatHooks = []ChatHook{{Kind: "slack", URL: hook}}
+	})
+	w := request(http.MethodGet, "/admin/config", adminHeaders, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d; body %s", w.Code, w.Body)
+	}
+	for _, secret := range []string{key, "secret-token"} {
+		if strings.Contains(w.Body.String(), secret) {
+			t.Errorf("config shows %q; body %s", secret, w.Body)
+		}
+	}
+	got := decodeObject(t, w)
+	keys := got["flags"].(map[string]interface{})[flagNLSearch].(map[string]interface{})["api_keys"].([]interface{})
+	if want := "…" + key[len(key)-4:]; len(keys) != 1 || keys[0] != want {
+		t.Errorf("api_keys = %v, want [%s]", keys, want)
+	}
+	if cfg().Flags[flagNLSearch].APIKeys[0] != key {
+		t.Error("redaction changed the live config")
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const analyticsBucket = "analytics"
+
+// latencyBoundsMs are the upper bounds of the latency histogram buckets;
+// the last bucket counts everything slower.
+var latencyBoundsMs = []int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// usageHour is the unit analytics are stored in: everything that happened
+// during one UTC hour.
+type usageHour struct {
+	Hour      time.Time                 `json:"hour"`
+	Routes    map[string]*routeUsage    `json:"routes"`
+	Consumers map[string]*consumerUsage `json:"consumers"`
+	Titles    map[string]int64          `json:"titles"`
+}
+
+type routeUsage struct {
+	Requests      int64   `json:"requests"`
+	UpstreamCalls int64   `json:"upstream_calls"`
+	ClientErrors  int64   `json:"client_errors"`
+	ServerErrors  int64   `json:"server_errors"`
+	TotalMs       int64   `json:"total_ms"`
+	Latency       []int64 `json:"latency"`
+}
+
+type consumerUsage struct {
+	Requests      int64            `json:"requests"`
+	UpstreamCalls int64            `json:"upstream_calls"`
+	Errors        int64            `json:"errors"`
+	Routes        map[string]int64 `json:"routes"`
+}
+
+func newUsageHour(hour time.Time) *usageHour {
+	return &usageHour{
+		Hour:      hour,
+		Routes:    map[string]*routeUsage{},
+		Consumers: map[string]*consumerUsage{},
+		Titles:    map[string]int64{},
+	}
+}
+
+func (h *usageHour) merge(o *usageHour) {
+	for name, r := range o.Routes {
+		mine := h.Routes[name]
+		if mine == nil {
+			mine = &routeUsage{Latency: make([]int64, len(latencyBoundsMs)+1)}
+			h.Routes[name] = mine
+		}
+		mine.Requests += r.Requests
+		mine.UpstreamCalls += r.UpstreamCalls
+		mine.ClientErrors += r.ClientErrors
+		mine.ServerErrors += r.ServerErrors
+		mine.TotalMs += r.TotalMs
+		for i := range r.Latency {
+			if i < len(mine.Latency) {
+				mine.Latency[i] += r.Latency[i]
+			}
+		}
+	}
+	for name, cu := range o.Consumers {
+		mine := h.Consumers[name]
+		if mine == nil {
+			mine = &consumerUsage{Routes: map[string]int64{}}
+			h.Consumers[name] = mine
+		}
+		mine.Requests += cu.Requests
+		mine.UpstreamCalls += cu.UpstreamCalls
+		mine.Errors += cu.Errors
+		for route, n := range cu.Routes {
+			mine.Routes[route] += n
+		}
+	}
+	for id, n := range o.Titles {
+		h.Titles[id] += n
+	}
+}
+
+// usageRecorder aggregates requests in memory per hour and flushes the
+// touched hours to the store periodically, like the title catalog.
+type usageRecorder struct {
+	mu      sync.Mutex
+	hours   map[string]*usageHour
+	flushMu sync.Mutex
+}
+
+var usage = &usageRecorder{hours: map[string]*usageHour{}}
+
+func usageKey(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15")
+}
+
+func (u *usageRecorder) Record(route, consumer, title string, status int, elapsed time.Duration, upstreamCalls int64) {
+	now := time.Now().UTC()
+	ms := elapsed.Milliseconds()
+	slot := len(latencyBoundsMs)
+	for i, bound := range latencyBoundsMs {
+		if ms <= bound {
+			slot = i
+			break
+		}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	key := usageKey(now)
+	h := u.hours[key]
+	if h == nil {
+		h = newUsageHour(now.Truncate(time.Hour))
+		u.hours[key] = h
+	}
+	r := h.Routes[route]
+	if r == nil {
+		r = &routeUsage{Latency: make([]int64, len(latencyBoundsMs)+1)}
+		h.Routes[route] = r
+	}
+	r.Requests++
+	r.UpstreamCalls += upstreamCalls
+	r.TotalMs += ms
+	r.Latency[slot]++
+	switch {
+	case status >= 500:
+		r.ServerErrors++
+	case status >= 400:
+		r.ClientErrors++
+	}
+
+	cu := h.Consumers[consumer]
+	if cu == nil {
+		cu = &consumerUsage{Routes: map[string]int64{}}
+		h.Consumers[consumer] = cu
+	}
+	cu.Requests++
+	cu.UpstreamCalls += upstreamCalls
+	cu.Routes[route]++
+	if status >= 400 {
+		cu.Errors++
+	}
+	if title != "" {
+		h.Titles[title]++
+	}
+}
+
+// Flush merges the in-memory hours into what's already stored and drops
+// stored hours older than the retention period.
+func (u *usageRecorder) Flush(retention time.Duration) {
+	u.flushMu.Lock()
+	defer u.flushMu.Unlock()
+	u.mu.Lock()
+	pending := u.hours
+	u.hours = map[string]*usageHour{}
+	u.mu.Unlock()
+
+	for key, h := range pending {
+		stored := newUsageHour(h.Hour)
+		found, err := store.Modify(analyticsBucket, key, stored, func() error {
+			stored.merge(h)
+			return nil
+		})
+		if err == nil && !found {
+			err = store.Put(analyticsBucket, key, h)
+		}
+		if err != nil {
+			slog.Warn("analytics flush failed", "hour", key, "error", err)
+		}
+	}
+
+	cutoff := usageKey(time.Now().Add(-retention))
+	var expired []string
+	store.ForEach(analyticsBucket, func(key string, _ []byte) error {
+		if key < cutoff {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	for _, key := range expired {
+		store.Delete(analyticsBucket, key)
+	}
+}
+
+func flushUsage(every, retention time.Duration) {
+	for range time.Tick(every) {
+		usage.Flush(retention)
+	}
+}
+
+// trackTitle attributes the current request to a title in the analytics.
+func trackTitle(c *gin.Context, imdbID string) {
+	c.Set("analytics.title", imdbID)
+}
+
+func consumerOf(c *gin.Context) string {
+	p := currentPrincipal(c)
+	if p.Kind == "" {
+		return "anonymous"
+	}
+	return p.Kind + ":" + p.ID
+}
+
+func recordUsage(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	title := c.GetString("analytics.title")
+	if title == "" {
+		title = c.Param("imdbID")
+	}
+	var upstreamCalls int64
+	if cost := costOf(c); cost != nil {
+		upstreamCalls = cost.upstreamCalls.Load()
+	}
+	usage.Record(c.Request.Method+" "+route, consumerOf(c), title, c.Writer.Status(), time.Since(start), upstreamCalls)
+	// Fixture titles aren't real demand, and would only get warmed live.
+	if title != "" && c.Writer.Status() < 400 && !costOf(c).usesFixtures() {
+		popularity.Served(title)
+	}
+}
+
+type countEntry struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+func topN(counts map[string]int64, n int) []countEntry {
+	out := make([]countEntry, 0, len(counts))
+	for name, count := range counts {
+		out = append(out, countEntry{name, count})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// percentileMs estimates a percentile from the histogram as the upper
+// bound of the bucket it falls in.
+func percentileMs(latency []int64, total int64, p float64) int64 {
+	want := int64(float64(total) * p)
+	var seen int64
+	for i, n := range latency {
+		seen += n
+		if seen > want {
+			if i < len(latencyBoundsMs) {
+				return latencyBoundsMs[i]
+			}
+			break
+		}
+	}
+	return latencyBoundsMs[len(latencyBoundsMs)-1]
+}
+
+func usageReport(h *usageHour, limit int) gin.H {
+	var requests, errors, upstreamCalls int64
+	consumers := map[string]int64{}
+	upstreamConsumers := map[string]int64{}
+	routes := gin.H{}
+	for name, r := range h.Routes {
+		requests += r.Requests
+		errors += r.ClientErrors + r.ServerErrors
+		upstreamCalls += r.UpstreamCalls
+		routes[name] = gin.H{
+			"requests":       r.Requests,
+			"upstream_calls": r.UpstreamCalls,
+			"client_errors":  r.ClientErrors,
+			"server_errors":  r.ServerErrors,
+			"error_rate":     roundTo(float64(r.ClientErrors+r.ServerErrors)/float64(r.Requests), 4),
+			"avg_ms":         roundTo(float64(r.TotalMs)/float64(r.Requests), 1),
+			"p50_ms":         percentileMs(r.Latency, r.Requests, 0.5),
+			"p95_ms":         percentileMs(r.Latency, r.Requests, 0.95),
+			"p99_ms":         percentileMs(r.Latency, r.Requests, 0.99),
+			"histogram":      r.Latency,
+		}
+	}
+	for name, cu := range h.Consumers {
+		consumers[name] = cu.Requests
+		if cu.UpstreamCalls > 0 {
+			upstreamConsumers[name] = cu.UpstreamCalls
+		}
+	}
+	errorRate := 0.0
+	if requests > 0 {
+		errorRate = roundTo(float64(errors)/float64(requests), 4)
+	}
+	return gin.H{
+		"start":                  h.Hour,
+		"requests":               requests,
+		"errors":                 errors,
+		"error_rate":             errorRate,
+		"upstream_calls":         upstreamCalls,
+		"top_consumers":          topN(consumers, limit),
+		"top_upstream_consumers": topN(upstreamConsumers, limit),
+		"top_titles":             topN(h.Titles, limit),
+		"routes":                 routes,
+	}
+}
+
+// getAnalytics reports usage between ?from and ?to (RFC 3339 or
+// 2006-01-02, default the last 24 hours) in ?bucket=hour|day|total slices.
+func getAnalytics(c *gin.Context) {
+	now := time.Now().UTC()
+	from, to := now.Add(-24*time.Hour), now
+	for name, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		v := c.Query(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.Parse("2006-01-02", v)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be RFC 3339 or YYYY-MM-DD"})
+			return
+		}
+		*dst = t.UTC()
+	}
+	bucket := c.DefaultQuery("bucket", "hour")
+	if bucket != "hour" && bucket != "day" && bucket != "total" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bucket must be hour, day or total"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 {
+		limit = 10
+	}
+
+	usage.Flush(envDuration("ANALYTICS_RETENTION", 90*24*time.Hour))
+	byStart := map[time.Time]*usageHour{}
+	total := newUsageHour(from)
+	store.ForEach(analyticsBucket, func(key string, value []byte) error {
+		if key < usageKey(from) || key > usageKey(to) {
+			return nil
+		}
+		var h usageHour
+		if json.Unmarshal(value, &h) != nil {
+			return nil
+		}
+		total.merge(&h)
+		start := h.Hour
+		if bucket == "day" {
+			start = start.Truncate(24 * time.Hour)
+		}
+		if byStart[start] == nil {
+			byStart[start] = newUsageHour(start)
+		}
+		byStart[start].merge(&h)
+		return nil
+	})
+
+	reports := []gin.H{}
+	if bucket != "total" {
+		starts := make([]time.Time, 0, len(byStart))
+		for start := range byStart {
+			starts = append(starts, start)
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+		for _, start := range starts {
+			reports = append(reports, usageReport(byStart[start], limit))
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from,
+		"to":      to,
+		"bucket":  bucket,
+		"total":   usageReport(total, limit),
+		"buckets": reports,
+	})
+}
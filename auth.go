@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	usersBucket         = "users"
+	usersByEmailBucket  = "users_by_email"
+	usersByHandleBucket = "users_by_handle"
+	tokenTTL            = 7 * 24 * time.Hour
+)
+
+type User struct {
+	ID           string `json:"id"`
+	Email        string `json:"email"`
+	Name         string `json:"name,omitempty"`
+	Handle       string `json:"handle,omitempty"`
+	PasswordHash []byte `json:"password_hash,omitempty"`
+	// ProfilePublic exposes the profile and public lists at /u/{handle}.
+	ProfilePublic bool      `json:"profile_public"`
+	Role          string    `json:"role,omitempty"`
+	TenantID      string    `json:"tenant_id,omitempty"`
+	DigestEnabled bool      `json:"digest_enabled,omitempty"`
+	DigestSentAt  time.Time `json:"digest_sent_at,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	// DeletionScheduledAt is set while an account deletion is pending.
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+}
+
+func (u User) role() string {
+	if u.Role == "" {
+		return roleUser
+	}
+	return u.Role
+}
+
+// public strips secrets before a user is returned from the API.
+func (u User) public() gin.H {
+	out := gin.H{
+		"id":             u.ID,
+		"email":          u.Email,
+		"name":           u.Name,
+		"handle":         u.Handle,
+		"profile_public": u.ProfilePublic,
+		"role":           u.role(),
+		"tenant_id":      u.TenantID,
+		"created_at":     u.CreatedAt,
+	}
+	if u.DeletionScheduledAt != nil {
+		out["deletion_scheduled_at"] = u.DeletionScheduledAt
+	}
+	return out
+}
+
+// jwtKeys holds the HMAC secret tokens were signed with before signing
+// keys, and after a rotation the one before it. They still verify
+// tokens without a kid until those expire, and sign unsubscribe links.
+var jwtKeys struct {
+	sync.RWMutex
+	current   []byte
+	previous  []byte
+	rotatedAt time.Time
+}
+
+func initJWTSecret() {
+	key := secret("JWT_SECRET")
+	if key == "" {
+		slog.Warn("JWT_SECRET not set; using a random secret, unsubscribe links will not survive a restart")
+		key = randomHex(32)
+	}
+	jwtKeys.Lock()
+	jwtKeys.current = []byte(key)
+	jwtKeys.Unlock()
+}
+
+func rotateJWTSecret(key string) {
+	if key == "" {
+		slog.Warn("JWT_SECRET was removed from the secrets; keeping the current one")
+		return
+	}
+	jwtKeys.Lock()
+	jwtKeys.previous, jwtKeys.current, jwtKeys.rotatedAt = jwtKeys.current, []byte(key), time.Now()
+	jwtKeys.Unlock()
+}
+
+func jwtSigningKey() []byte {
+	jwtKeys.RLock()
+	defer jwtKeys.RUnlock()
+	return jwtKeys.current
+}
+
+// jwtVerificationKeys returns the current secret, then the previous one
+// while tokens signed with it can still be valid.
+func jwtVerificationKeys() [][]byte {
+	jwtKeys.RLock()
+	defer jwtKeys.RUnlock()
+	keys := [][]byte{jwtKeys.current}
+	if jwtKeys.previous != nil && time.Since(jwtKeys.rotatedAt) < tokenTTL {
+		keys = append(keys, jwtKeys.previous)
+	}
+	return keys
+}
+
+func issueToken(u *User) (string, time.Time, error) {
+	k := activeSigningKey()
+	if k == nil {
+		return "", time.Time{}, errors.New("no active signing key")
+	}
+	expires := time.Now().Add(tokenTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		Subject:   u.ID,
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(expires),
+	})
+	token.Header["kid"] = k.KID
+	signed, err := token.SignedString(k.key)
+	return signed, expires, err
+}
+
+func parseToken(raw string) (string, error) {
+	var claims jwt.RegisteredClaims
+	unverified, _, err := jwt.NewParser().ParseUnverified(raw, &claims)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := unverified.Header["kid"]; !ok {
+		return parseLegacyToken(raw)
+	}
+	_, err = jwt.ParseWithClaims(raw, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if key := verifyingKey(kid); key != nil {
+			return key, nil
+		}
+		return nil, errUnknownKeyID
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}))
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// parseLegacyToken accepts HS256 tokens issued before signing keys.
+func parseLegacyToken(raw string) (string, error) {
+	var err error
+	for _, key := range jwtVerificationKeys() {
+		var claims jwt.RegisteredClaims
+		_, err = jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (interface{}, error) {
+			return key, nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+		if err == nil {
+			return claims.Subject, nil
+		}
+	}
+	return "", err
+}
+
+func loadUser(id string) (*User, bool) {
+	var u User
+	found, err := store.Get(usersBucket, id, &u)
+	if err != nil || !found {
+		return nil, false
+	}
+	return &u, true
+}
+
+// requireUser only lets through requests authenticated as a user (not an
+// API key) and stores the user in the context under "user".
+func requireUser(c *gin.Context) {
+	p := currentPrincipal(c)
+	if p.User == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing bearer token"})
+		return
+	}
+	c.Set("user", p.User)
+	c.Next()
+}
+
+func currentUser(c *gin.Context) *User {
+	return c.MustGet("user").(*User)
+}
+
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+}
+
+func postRegister(c *gin.Context) {
+	var req credentials
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if _, err := mail.ParseAddress(email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email"})
+		return
+	}
+	if len(req.Password) < 8 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password must be at least 8 characters"})
+		return
+	}
+
+	tenant := currentPrincipal(c).Tenant
+	var existing string
+	if found, _ := store.Get(usersByEmailBucket, tenantKey(tenant, email), &existing); found {
+		c.JSON(http.StatusConflict, gin.H{"error": "email already registered"})
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not hash password"})
+		return
+	}
+
+	u := &User{ID: randomHex(12), Email: email, Name: req.Name, PasswordHash: hash, TenantID: tenant, CreatedAt: time.Now().UTC()}
+	if err := store.Put(usersBucket, u.ID, u); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save user"})
+		return
+	}
+	store.Put(usersByEmailBucket, tenantKey(tenant, email), u.ID)
+
+	respondWithToken(c, http.StatusCreated, u)
+}
+
+func postLogin(c *gin.Context) {
+	var req credentials
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	u, ok := checkCredentials(currentPrincipal(c).Tenant, req)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid email or password"})
+		return
+	}
+	respondWithToken(c, http.StatusOK, u)
+}
+
+// checkCredentials verifies an email/password pair within a tenant.
+// Accounts created via OAuth have no password and never match.
+func checkCredentials(tenant string, req credentials) (*User, bool) {
+	var id string
+	found, _ := store.Get(usersByEmailBucket, tenantKey(tenant, strings.ToLower(strings.TrimSpace(req.Email))), &id)
+	u, ok := loadUser(id)
+	if !found || !ok || bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(req.Password)) != nil {
+		return nil, false
+	}
+	return u, true
+}
+
+func respondWithToken(c *gin.Context, status int, u *User) {
+	token, expires, err := issueToken(u)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not issue token"})
+		return
+	}
+	c.JSON(status, gin.H{"token": token, "expires_at": expires, "user": u.public()})
+}
+
+func getMe(c *gin.Context) {
+	c.JSON(http.StatusOK, currentUser(c).public())
+}
+
+type profileUpdate struct {
+	Name          *string `json:"name"`
+	Handle        *string `json:"handle"`
+	ProfilePublic *bool   `json:"profile_public"`
+}
+
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,30}$`)
+
+func patchMe(c *gin.Context) {
+	var req profileUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	u := currentUser(c)
+	if req.Handle != nil && *req.Handle != u.Handle {
+		handle := strings.ToLower(*req.Handle)
+		if !handlePattern.MatchString(handle) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "handle must be 3-30 lowercase letters, digits or underscores"})
+			return
+		}
+		var owner string
+		if found, _ := store.Get(usersByHandleBucket, tenantKey(u.TenantID, handle), &owner); found && owner != u.ID {
+			c.JSON(http.StatusConflict, gin.H{"error": "handle already taken"})
+			return
+		}
+		if u.Handle != "" {
+			store.Delete(usersByHandleBucket, tenantKey(u.TenantID, u.Handle))
+		}
+		store.Put(usersByHandleBucket, tenantKey(u.TenantID, handle), u.ID)
+		u.Handle = handle
+	}
+	if req.Name != nil {
+		u.Name = *req.Name
+	}
+	if req.ProfilePublic != nil {
+		if *req.ProfilePublic && u.Handle == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "choose a handle before making the profile public"})
+			return
+		}
+		u.ProfilePublic = *req.ProfilePublic
+	}
+	if err := store.Put(usersBucket, u.ID, u); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save user"})
+		return
+	}
+	c.JSON(http.StatusOK, u.public())
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// `movie-api bench` replays a traffic profile against a running instance,
+// or against the handlers in-process with fixtures standing in for OMDb,
+// and reports latency percentiles per endpoint:
+//
+//	movie-api bench -profile traffic.jsonl -target http://localhost:8080 -c 16
+//	movie-api bench -profile traffic.jsonl -n 5000 -upstream-latency 80ms
+//
+// A profile has one request per line. TRAFFIC_RECORD_FILE makes a server
+// record the requests it serves in that format.
+
+// trafficEntry is one request of a traffic profile.
+type trafficEntry struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Body is sent as JSON. Recorded profiles leave it out.
+	Body json.RawMessage `json:"body,omitempty"`
+	// Name groups requests in the report, e.g. "GET /api/movie"; it
+	// defaults to the method and the path without its query.
+	Name string `json:"name,omitempty"`
+	// AtMs is when the request came, from the start of the recording, for
+	// replays at the recorded pace.
+	AtMs int64 `json:"at_ms"`
+}
+
+// trafficRecorder appends the requests a server serves to a profile.
+type trafficRecorder struct {
+	mu    sync.Mutex
+	out   io.WriteCloser
+	start time.Time
+}
+
+var trafficLog *trafficRecorder
+
+func newTrafficRecorderFromEnv() (*trafficRecorder, error) {
+	path := envString("TRAFFIC_RECORD_FILE", "")
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &trafficRecorder{out: f, start: time.Now()}, nil
+}
+
+// secretQueryParams are dropped from recorded paths.
+var secretQueryParams = []string{"api_key", "token"}
+
+func recordTraffic(c *gin.Context) {
+	if trafficLog == nil {
+		c.Next()
+		return
+	}
+	e := trafficEntry{Method: c.Request.Method, AtMs: time.Since(trafficLog.start).Milliseconds()}
+	u := *c.Request.URL
+	q := u.Query()
+	for _, name := range secretQueryParams {
+		q.Del(name)
+	}
+	u.RawQuery = q.Encode()
+	e.Path = u.RequestURI()
+	if route := c.FullPath(); route != "" {
+		e.Name = c.Request.Method + " " + route
+	}
+	line, err := json.Marshal(e)
+	if err == nil {
+		trafficLog.mu.Lock()
+		trafficLog.out.Write(append(line, '\n'))
+		trafficLog.mu.Unlock()
+	}
+	c.Next()
+}
+
+func (r *trafficRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.out.Close()
+}
+
+func loadTrafficProfile(path string) ([]trafficEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []trafficEntry
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var e trafficEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		if e.Method == "" {
+			e.Method = http.MethodGet
+		}
+		if !strings.HasPrefix(e.Path, "/") {
+			return nil, fmt.Errorf("%s:%d: path must start with /", path, i+1)
+		}
+		if e.Name == "" {
+			e.Name = e.Method + " " + strings.SplitN(e.Path, "?", 2)[0]
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s has no requests", path)
+	}
+	return entries, nil
+}
+
+// headerFlags collects repeated -H "Name: value" flags.
+type headerFlags http.Header
+
+func (h headerFlags) String() string { return "" }
+
+func (h headerFlags) Set(v string) error {
+	name, value, ok := strings.Cut(v, ":")
+	if !ok {
+		return errors.New(`want "Name: value"`)
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	return nil
+}
+
+type benchOptions struct {
+	entries     []trafficEntry
+	requests    int
+	concurrency int
+	// speed replays at the recorded pace times speed; 0 sends requests as
+	// fast as the workers take them.
+	speed   float64
+	headers http.Header
+}
+
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	profile := fs.String("profile", "", "traffic profile to replay, one JSON request per line (required)")
+	target := fs.String("target", "", "base URL of a running instance; without it the handlers run in-process")
+	requests := fs.Int("n", 0, "requests to send, cycling through the profile (default: the profile once)")
+	concurrency := fs.Int("c", 8, "requests in flight at once")
+	speed := fs.Float64("speed", 0, "replay at the recorded pace times this; 0 is as fast as possible")
+	upstreamLatency := fs.Duration("upstream-latency", 20*time.Millisecond, "latency of the mock OMDb, in-process only")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	headers := headerFlags{}
+	fs.Var(headers, "H", `header to send with every request, e.g. -H "Authorization: Bearer ..." (repeatable)`)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *profile == "" || *concurrency < 1 || *requests < 0 || *speed < 0 {
+		fmt.Fprintln(os.Stderr, "bench: -profile is required; -c must be at least 1; -n and -speed can't be negative")
+		fs.Usage()
+		return 2
+	}
+	entries, err := loadTrafficProfile(*profile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bench:", err)
+		return 1
+	}
+	opts := benchOptions{
+		entries:     entries,
+		requests:    *requests,
+		concurrency: *concurrency,
+		speed:       *speed,
+		headers:     http.Header(headers),
+	}
+	if opts.requests == 0 {
+		opts.requests = len(entries)
+	}
+
+	var report *benchReport
+	if *target != "" {
+		base, err := url.Parse(*target)
+		if err != nil || base.Host == "" {
+			fmt.Fprintln(os.Stderr, "bench: -target should be a URL like http://localhost:8080")
+			return 2
+		}
+		client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: opts.concurrency}, Timeout: time.Minute}
+		report = replay(opts, func(req *http.Request) (int, error) {
+			req.URL.Scheme, req.URL.Host = base.Scheme, base.Host
+			resp, err := client.Do(req)
+			if err != nil {
+				return 0, err
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			return resp.StatusCode, nil
+		})
+	} else {
+		report, err = benchInProcess(opts, *upstreamLatency)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "bench:", err)
+			return 1
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		report.print(os.Stdout)
+	}
+	return 0
+}
+
+// integrationEnv is every variable that connects the server to something
+// outside the process: shared state, notifications, paid APIs and the
+// places secrets and config come from, which could set any of these.
+var integrationEnv = []string{
+	"STORE_URL", "REDIS_URL", "CACHE_DISK_PATH", "TRAFFIC_RECORD_FILE",
+	"CONFIG_FILE", "SECRETS_PROVIDER", "VAULT_ADDR", "AWS_SECRET_ID", "SOPS_FILE",
+	"SLACK_WEBHOOK_URL", "DISCORD_WEBHOOK_URL", "ERROR_REPORT_URL", "RESPONSE_HOOK_PLUGINS", "SMTP_HOST",
+	"LLM_PROVIDER", "LLM_URL", "LLM_API_KEY", "OPENAI_API_KEY",
+	"EMBEDDINGS_PROVIDER", "EMBEDDINGS_URL", "EMBEDDINGS_API_KEY",
+	"TRANSLATION_PROVIDER", "TRANSLATION_URL", "TRANSLATION_API_KEY",
+	"TMDB_API_KEY", "FALLBACK_PROVIDERS", "EXCHANGE_RATES_URL",
+	"OAUTH_GITHUB_CLIENT_ID", "OAUTH_GOOGLE_CLIENT_ID",
+}
+
+// isolate clears integrationEnv, so a server run in-process touches
+// nothing a deployment shares.
+func isolate() {
+	for _, name := range integrationEnv {
+		os.Unsetenv(name)
+	}
+}
+
+// benchInProcess starts the server as main does but without listening, on a
+// throwaway store and with a mock OMDb that answers from the fixtures after
+// latency, and replays against its handlers directly.
+func benchInProcess(opts benchOptions, latency time.Duration) (*benchReport, error) {
+	dir, err := os.MkdirTemp("", "movie-api-bench")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	upstream := httptest.NewServer(mockOMDb(latency))
+	defer upstream.Close()
+
+	isolate()
+	os.Setenv("STORE_PATH", filepath.Join(dir, "bench.db"))
+	os.Setenv("OMDB_BASE_URL", upstream.URL+"/")
+	os.Setenv("CACHE_WARM_TITLES", "0")
+	if os.Getenv("OMDB_API_KEY") == "" {
+		os.Setenv("OMDB_API_KEY", "bench")
+	}
+	if os.Getenv("LOG_LEVEL") == "" {
+		os.Setenv("LOG_LEVEL", "warn")
+	}
+	gin.SetMode(gin.ReleaseMode)
+	gin.DefaultWriter = io.Discard
+
+	var report *benchReport
+	run(func(h http.Handler) error {
+		report = replay(opts, func(req *http.Request) (int, error) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			return w.Code, nil
+		})
+		return nil
+	})
+	return report, nil
+}
+
+// mockOMDb answers like OMDb from the fixtures. A title it doesn't know by
+// ID is answered with a copy of the first fixture under that ID, so
+// recorded traffic for any title finds one.
+func mockOMDb(latency time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(latency)
+		params := map[string]string{}
+		for k, v := range r.URL.Query() {
+			if k != "apikey" {
+				params[k] = v[0]
+			}
+		}
+		body, err := fixtures.Lookup(params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if id := params["i"]; id != "" && params["Season"] == "" && bytes.Contains(body, []byte(`"Response":"False"`)) {
+			m := *fixtures.titles[0]
+			m.IMDBID, m.Title = id, "Fixture "+id
+			body, _ = json.Marshal(m)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
+
+type benchResult struct {
+	name    string
+	status  int
+	latency time.Duration
+	err     error
+}
+
+// replay sends opts.requests requests, cycling through the profile, from
+// opts.concurrency workers.
+func replay(opts benchOptions, send func(*http.Request) (int, error)) *benchReport {
+	n := len(opts.entries)
+	span := time.Duration(opts.entries[n-1].AtMs-opts.entries[0].AtMs+1) * time.Millisecond
+	jobs := make(chan int)
+	results := make(chan benchResult, opts.concurrency)
+	var workers sync.WaitGroup
+	for range opts.concurrency {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range jobs {
+				e := opts.entries[i%n]
+				var body io.Reader
+				if len(e.Body) > 0 {
+					body = bytes.NewReader(e.Body)
+				}
+				req := httptest.NewRequest(e.Method, e.Path, body)
+				req.RequestURI = ""
+				for name, values := range opts.headers {
+					req.Header[name] = values
+				}
+				if body != nil {
+					req.Header.Set("Content-Type", "application/json")
+				}
+				start := time.Now()
+				status, err := send(req)
+				results <- benchResult{name: e.Name, status: status, latency: time.Since(start), err: err}
+			}
+		}()
+	}
+
+	start := time.Now()
+	go func() {
+		for i := range opts.requests {
+			if opts.speed > 0 {
+				e := opts.entries[i%n]
+				at := time.Duration(i/n)*span + time.Duration(e.AtMs-opts.entries[0].AtMs)*time.Millisecond
+				time.Sleep(time.Until(start.Add(time.Duration(float64(at) / opts.speed))))
+			}
+			jobs <- i
+		}
+		close(jobs)
+		workers.Wait()
+		close(results)
+	}()
+
+	byName := map[string]*endpointStats{}
+	all := &endpointStats{Name: "all", Statuses: map[int]int{}}
+	for r := range results {
+		s := byName[r.name]
+		if s == nil {
+			s = &endpointStats{Name: r.name, Statuses: map[int]int{}}
+			byName[r.name] = s
+		}
+		s.add(r)
+		all.add(r)
+	}
+	elapsed := time.Since(start)
+
+	report := &benchReport{
+		Requests:       all.Requests,
+		Concurrency:    opts.concurrency,
+		ElapsedMs:      roundTo(float64(elapsed)/float64(time.Millisecond), 1),
+		RequestsPerSec: roundTo(float64(all.Requests)/elapsed.Seconds(), 1),
+		Endpoints:      []*endpointStats{},
+	}
+	for _, s := range byName {
+		s.summarize()
+		report.Endpoints = append(report.Endpoints, s)
+	}
+	sort.Slice(report.Endpoints, func(i, j int) bool {
+		if report.Endpoints[i].Requests != report.Endpoints[j].Requests {
+			return report.Endpoints[i].Requests > report.Endpoints[j].Requests
+		}
+		return report.Endpoints[i].Name < report.Endpoints[j].Name
+	})
+	all.summarize()
+	report.Overall = all
+	return report
+}
+
+type benchReport struct {
+	Requests       int              `json:"requests"`
+	Concurrency    int              `json:"concurrency"`
+	ElapsedMs      float64          `json:"elapsed_ms"`
+	RequestsPerSec float64          `json:"requests_per_sec"`
+	Endpoints      []*endpointStats `json:"endpoints"`
+	Overall        *endpointStats   `json:"overall"`
+}
+
+// endpointStats are one endpoint's latencies; Errors counts failed
+// requests and responses of 400 and above.
+type endpointStats struct {
+	Name     string      `json:"name"`
+	Requests int         `json:"requests"`
+	Errors   int         `json:"errors"`
+	Statuses map[int]int `json:"statuses"`
+	P50Ms    float64     `json:"p50_ms"`
+	P90Ms    float64     `json:"p90_ms"`
+	P95Ms    float64     `json:"p95_ms"`
+	P99Ms    float64     `json:"p99_ms"`
+	MaxMs    float64     `json:"max_ms"`
+
+	latencies []time.Duration
+}
+
+func (s *endpointStats) add(r benchResult) {
+	s.Requests++
+	s.Statuses[r.status]++
+	if r.err != nil || r.status >= 400 {
+		s.Errors++
+	}
+	s.latencies = append(s.latencies, r.latency)
+}
+
+func (s *endpointStats) summarize() {
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	s.P50Ms, s.P90Ms, s.P95Ms, s.P99Ms = s.percentile(0.5), s.percentile(0.9), s.percentile(0.95), s.percentile(0.99)
+	s.MaxMs = s.percentile(1)
+}
+
+// percentile is the latency q of the requests were at or under, in ms;
+// latencies must be sorted.
+func (s *endpointStats) percentile(q float64) float64 {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	i := max(int(math.Ceil(q*float64(len(s.latencies))))-1, 0)
+	return roundTo(float64(s.latencies[i])/float64(time.Millisecond), 2)
+}
+
+func (r *benchReport) print(w io.Writer) {
+	width := len("endpoint")
+	for _, s := range r.Endpoints {
+		width = max(width, len(s.Name))
+	}
+	row := func(s *endpointStats) {
+		fmt.Fprintf(w, "%-*s %8d %7d %9.2f %9.2f %9.2f %9.2f %9.2f\n", width, s.Name, s.Requests, s.Errors, s.P50Ms, s.P90Ms, s.P95Ms, s.P99Ms, s.MaxMs)
+	}
+	fmt.Fprintf(w, "%-*s %8s %7s %9s %9s %9s %9s %9s\n", width, "endpoint", "requests", "errors", "p50 ms", "p90 ms", "p95 ms", "p99 ms", "max ms")
+	for _, s := range r.Endpoints {
+		row(s)
+	}
+	row(r.Overall)
+	fmt.Fprintf(w, "\n%d requests in %.0f ms at concurrency %d: %.1f requests/s\n", r.Requests, r.ElapsedMs, r.Concurrency, r.RequestsPerSec)
+	if r.Overall.Errors > 0 {
+		statuses := make([]string, 0, len(r.Overall.Statuses))
+		for status, count := range r.Overall.Statuses {
+			statuses = append(statuses, fmt.Sprintf("%d: %d", status, count))
+		}
+		sort.Strings(statuses)
+		fmt.Fprintf(w, "%d failed (status 0 is a connection error): %s\n", r.Overall.Errors, strings.Join(statuses, ", "))
+	}
+}
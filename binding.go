@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+
+	"movie-api/omdb"
+)
+
+// Query structs for the lookup endpoints. The form tags name the query
+// parameters; binding tags are go-playground/validator rules plus the
+// imdbid and year rules registered below.
+type movieQuery struct {
+	Title string `form:"title" binding:"required_without=ID,max=200"`
+	ID    string `form:"id" binding:"omitempty,imdbid"`
+	Year  string `form:"year" binding:"omitempty,year"`
+	Type  string `form:"type" binding:"omitempty,oneof=movie series episode game"`
+	TZ    string `form:"tz" binding:"omitempty,timezone"`
+	// Currency converts the box office figure.
+	Currency string `form:"currency" binding:"omitempty,iso4217"`
+	// Labels adds display labels for the fields in the request language.
+	Labels bool `form:"labels"`
+	// Lang also translates the plot when a translation provider is set.
+	Lang string `form:"lang" binding:"omitempty,bcp47_language_tag"`
+	// SpoilerFree shortens the plot unless the caller has watched the title.
+	SpoilerFree bool `form:"spoiler_free"`
+}
+
+type searchQuery struct {
+	Q    string `form:"q" binding:"required_without=Cursor,max=200"`
+	Page int    `form:"page,default=1" binding:"min=1,max=100"`
+	Year string `form:"year" binding:"omitempty,year"`
+	Type string `form:"type,default=movie" binding:"oneof=movie series episode game"`
+	// Tag keeps only results carrying the tag, global or the caller's.
+	Tag string `form:"tag" binding:"max=40"`
+	// Pages merges that many search pages, from page on, into one list.
+	Pages int `form:"pages,default=1" binding:"min=1,max=5"`
+	// Cursor, when given, replaces q, page, pages, year, type and tag.
+	Cursor string `form:"cursor"`
+	// Enrich adds each hit's rating, genres and runtime.
+	Enrich bool `form:"enrich"`
+}
+
+type episodeQuery struct {
+	ID          string `form:"id" binding:"omitempty,imdbid"`
+	SeriesID    string `form:"series_id" binding:"omitempty,imdbid"`
+	SeriesTitle string `form:"series_title" binding:"max=200"`
+	Season      int    `form:"season" binding:"required_without=ID,omitempty,min=1,max=1000"`
+	Episode     int    `form:"episode_number" binding:"required_without=ID,omitempty,min=1,max=10000"`
+	TZ          string `form:"tz" binding:"omitempty,timezone"`
+	SpoilerFree bool   `form:"spoiler_free"`
+}
+
+type seriesQuery struct {
+	Title string `form:"title" binding:"required_without=ID,max=200"`
+	ID    string `form:"id" binding:"omitempty,imdbid"`
+}
+
+type skippableQuery struct {
+	seriesQuery
+	Threshold float64 `form:"threshold,default=6.5" binding:"min=0,max=10"`
+}
+
+type genreQuery struct {
+	Genre  string `form:"genre" binding:"required,max=50"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=50"`
+	Cursor string `form:"cursor"`
+	// Broaden lets titles of related genres top up a sparse list.
+	Broaden bool   `form:"broaden"`
+	Sort    string `form:"sort,default=weighted" binding:"oneof=weighted rating"`
+	titleFilter
+}
+
+type recommendationsQuery struct {
+	FavoriteMovie string `form:"favorite_movie" binding:"required,max=200"`
+	titleFilter
+}
+
+type relatedGenresQuery struct {
+	Genre string `form:"genre" binding:"required,max=50"`
+	Limit int    `form:"limit,default=5" binding:"min=1,max=30"`
+}
+
+// titleFilter is the filters shared by the list endpoints.
+type titleFilter struct {
+	regionQuery
+	criticsQuery
+}
+
+func (f titleFilter) matches(m *MovieResponse) bool {
+	return f.regionQuery.matches(m) && f.criticsQuery.matches(m)
+}
+
+// params lists the filter's settings by query parameter, leaving out
+// those that are off.
+func (f titleFilter) params() map[string]interface{} {
+	p := map[string]interface{}{}
+	if f.Country != "" {
+		p["country"] = f.Country
+	}
+	if f.Language != "" {
+		p["language"] = f.Language
+	}
+	if f.MinMetascore > 0 {
+		p["min_metascore"] = f.MinMetascore
+	}
+	if f.MinRT > 0 {
+		p["min_rt"] = f.MinRT
+	}
+	return p
+}
+
+// criticsQuery keeps titles the critics scored at least this well. Titles
+// without the score are left out while the filter is on.
+type criticsQuery struct {
+	MinMetascore int `form:"min_metascore" binding:"min=0,max=100"`
+	MinRT        int `form:"min_rt" binding:"min=0,max=100"`
+}
+
+func (q criticsQuery) matches(m *MovieResponse) bool {
+	if q.MinMetascore > 0 {
+		if n, ok := metascore(m); !ok || n < q.MinMetascore {
+			return false
+		}
+	}
+	if q.MinRT > 0 {
+		if n, ok := rottenTomatoes(m); !ok || n < q.MinRT {
+			return false
+		}
+	}
+	return true
+}
+
+// regionQuery narrows a list to titles from a country or in a language,
+// matched against OMDb's comma-separated fields ignoring case.
+type regionQuery struct {
+	Country  string `form:"country" binding:"max=50"`
+	Language string `form:"language" binding:"max=50"`
+}
+
+func (r regionQuery) matches(m *MovieResponse) bool {
+	return (r.Country == "" || containsFold(m.Country, r.Country)) &&
+		(r.Language == "" || containsFold(m.Language, r.Language))
+}
+
+type decadeQuery struct {
+	Decade string `form:"decade" binding:"required,decade"`
+	Genre  string `form:"genre" binding:"max=50"`
+	Type   string `form:"type,default=movie" binding:"oneof=movie series episode game"`
+	Limit  int    `form:"limit,default=20" binding:"min=1,max=100"`
+	Cursor string `form:"cursor"`
+	Sort   string `form:"sort,default=weighted" binding:"oneof=weighted rating"`
+	titleFilter
+}
+
+type tonightQuery struct {
+	Minutes   int     `form:"minutes" binding:"omitempty,min=1,max=1000"`
+	Mood      string  `form:"mood" binding:"max=30"`
+	MinRating float64 `form:"min_rating" binding:"min=0,max=10"`
+	Limit     int     `form:"limit,default=3" binding:"min=1,max=10"`
+}
+
+// pageQuery opts a list endpoint into cursor pagination.
+type pageQuery struct {
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=200"`
+	Cursor string `form:"cursor"`
+}
+
+// numberedPageQuery pages a list the server builds itself by number.
+type numberedPageQuery struct {
+	Page  int `form:"page,default=1" binding:"min=1"`
+	Limit int `form:"limit,default=50" binding:"min=1,max=100"`
+}
+
+type idQuery struct {
+	ID    string `form:"id" binding:"required,imdbid"`
+	Limit int    `form:"limit,default=10" binding:"min=1,max=100"`
+}
+
+type summaryQuery struct {
+	ID    string `form:"id" binding:"required,imdbid"`
+	Style string `form:"style,default=spoiler_free" binding:"oneof=spoiler_free one_line detailed"`
+}
+
+type quizQuery struct {
+	Genre string `form:"genre" binding:"max=50"`
+	Count int    `form:"count,default=10" binding:"min=1,max=50"`
+}
+
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterValidation("imdbid", func(fl validator.FieldLevel) bool {
+			return omdb.IMDBIDPattern.MatchString(fl.Field().String())
+		})
+		v.RegisterValidation("year", func(fl validator.FieldLevel) bool {
+			return omdb.YearPattern.MatchString(fl.Field().String())
+		})
+		v.RegisterValidation("decade", func(fl validator.FieldLevel) bool {
+			return decadePattern.MatchString(fl.Field().String())
+		})
+	}
+}
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// bindQuery binds and validates the query string into v. On failure it
+// writes a 400 listing every invalid parameter and returns false.
+func bindQuery(c *gin.Context, v interface{}) bool {
+	err := c.ShouldBindQuery(v)
+	if err == nil {
+		return true
+	}
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid query parameters", "fields": []fieldError{{Message: err.Error()}}})
+		return false
+	}
+	fields := make([]fieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		fields = append(fields, fieldError{Field: queryName(v, fe), Message: validationMessage(fe)})
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "invalid query parameters", "fields": fields})
+	return false
+}
+
+// queryName maps a struct field back to its query parameter name.
+func queryName(v interface{}, fe validator.FieldError) string {
+	if f, ok := reflect.TypeOf(v).Elem().FieldByName(fe.StructField()); ok {
+		name, _, _ := strings.Cut(f.Tag.Get("form"), ",")
+		return name
+	}
+	return strings.ToLower(fe.Field())
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "required_without":
+		return "is required unless " + strings.ToLower(fe.Param()) + " is given"
+	case "min":
+		return "must be at least " + fe.Param()
+	case "max":
+		if fe.Kind().String() == "string" {
+			return "must be at most " + fe.Param() + " characters"
+		}
+		return "must be at most " + fe.Param()
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "imdbid":
+		return "must be an IMDb ID like tt0133093"
+	case "year":
+		return "must be a four-digit year like 1984"
+	case "iso4217":
+		return "must be an ISO 4217 currency code like EUR"
+	case "bcp47_language_tag":
+		return "must be a language tag like de or pt-BR"
+	case "timezone":
+		return "must be an IANA time zone like Europe/Berlin"
+	case "decade":
+		return "must be a decade like 1980s"
+	}
+	return fmt.Sprintf("failed the %s check", fe.Tag())
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+// Cache is implemented by every cache layer that can hold upstream bodies.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	// Peek is Get for callers only checking what is cached: it counts no
+	// hit or miss and leaves recency and expired entries alone.
+	Peek(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+	Delete(key string)
+	Purge()
+}
+
+type cacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+type CacheStats struct {
+	Entries     int   `json:"entries"`
+	Bytes       int64 `json:"bytes"`
+	MaxEntries  int   `json:"max_entries"`
+	MaxBytes    int64 `json:"max_bytes"`
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Evictions   int64 `json:"evictions"`
+	Expirations int64 `json:"expirations"`
+}
+
+// responseCache is an LRU cache of raw upstream response bodies bounded by
+// both entry count and total byte size. A zero limit disables that bound.
+type responseCache struct {
+	mu         sync.Mutex
+	ll         *list.List
+	items      map[string]*list.Element
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	stats      CacheStats
+	// priority, when set, ranks entries for eviction; see victim.
+	priority func(key string) float64
+}
+
+func newResponseCache(maxEntries int, maxBytes int64) *responseCache {
+	return &responseCache{
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+	}
+}
+
+func (c *responseCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.removeElement(el)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	c.stats.Hits++
+	return entry.value, true
+}
+
+func (c *responseCache) Peek(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *responseCache) Set(key string, value []byte, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	size := int64(len(key) + len(value))
+
+	c.mu.Lock()
+	if c.maxBytes > 0 && size > c.maxBytes {
+		c.mu.Unlock()
+		return
+	}
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	el := c.ll.PushFront(&cacheEntry{key: key, value: value, expires: time.Now().Add(ttl)})
+	c.items[key] = el
+	c.bytes += size
+	c.mu.Unlock()
+
+	c.evict()
+}
+
+// evictionSample is how many of the least recently used entries victim
+// weighs against each other.
+const evictionSample = 8
+
+// SetPriority makes eviction prefer entries that priority ranks low over
+// strict LRU order.
+func (c *responseCache) SetPriority(priority func(key string) float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.priority = priority
+}
+
+// evict removes entries until the cache is within its limits. The
+// priority function takes locks of its own, so the sampled entries are
+// ranked with c.mu released and the victim picked once it is retaken.
+func (c *responseCache) evict() {
+	for {
+		c.mu.Lock()
+		if !c.overLimit() {
+			c.mu.Unlock()
+			return
+		}
+		priority := c.priority
+		var keys []string
+		if priority != nil {
+			el := c.ll.Back()
+			for i := 0; i < evictionSample && el != nil; i, el = i+1, el.Prev() {
+				keys = append(keys, el.Value.(*cacheEntry).key)
+			}
+		}
+		c.mu.Unlock()
+
+		var ranks map[string]float64
+		if priority != nil {
+			ranks = make(map[string]float64, len(keys))
+			for _, key := range keys {
+				ranks[key] = priority(key)
+			}
+		}
+
+		c.mu.Lock()
+		victim := c.victim(ranks)
+		if victim == nil || !c.overLimit() {
+			c.mu.Unlock()
+			return
+		}
+		c.removeElement(victim)
+		c.stats.Evictions++
+		c.mu.Unlock()
+	}
+}
+
+// victim picks the entry to evict: an expired one among the least
+// recently used few if there is one, else the lowest ranked among them,
+// else the least recently used. Entries missing from ranks, such as ones
+// added since they were ranked, are passed over.
+func (c *responseCache) victim(ranks map[string]float64) *list.Element {
+	oldest := c.ll.Back()
+	if ranks == nil || oldest == nil {
+		return oldest
+	}
+	now := time.Now()
+	victim, lowest := oldest, math.Inf(1)
+	el := oldest
+	for i := 0; i < evictionSample && el != nil; i, el = i+1, el.Prev() {
+		entry := el.Value.(*cacheEntry)
+		if now.After(entry.expires) {
+			return el
+		}
+		if p, ok := ranks[entry.key]; ok && p < lowest {
+			victim, lowest = el, p
+		}
+	}
+	return victim
+}
+
+// TTL reports how long key has left, or 0 if it isn't cached.
+func (c *responseCache) TTL(key string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		return max(time.Until(el.Value.(*cacheEntry).expires), 0)
+	}
+	return 0
+}
+
+func (c *responseCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *responseCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+// Resize changes the bounds, evicting immediately if the cache is now over
+// either limit.
+func (c *responseCache) Resize(maxEntries int, maxBytes int64) {
+	c.mu.Lock()
+	c.maxEntries = maxEntries
+	c.maxBytes = maxBytes
+	c.mu.Unlock()
+	c.evict()
+}
+
+func (c *responseCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = c.ll.Len()
+	s.Bytes = c.bytes
+	s.MaxEntries = c.maxEntries
+	s.MaxBytes = c.maxBytes
+	return s
+}
+
+func (c *responseCache) overLimit() bool {
+	if c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		return true
+	}
+	return c.maxBytes > 0 && c.bytes > c.maxBytes
+}
+
+func (c *responseCache) removeElement(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	c.ll.Remove(el)
+	delete(c.items, entry.key)
+	c.bytes -= int64(len(entry.key) + len(entry.value))
+}
+
+// tieredCache fronts the persistent disk cache with the in-memory LRU.
+// Disk hits are promoted into memory with their remaining TTL.
+type tieredCache struct {
+	mem  *responseCache
+	disk *diskCache
+}
+
+func (t *tieredCache) Get(key string) ([]byte, bool) {
+	if body, ok := t.mem.Get(key); ok {
+		return body, true
+	}
+	body, ok := t.disk.Get(key)
+	if !ok {
+		return nil, false
+	}
+	t.mem.Set(key, body, t.disk.TTL(key))
+	return body, true
+}
+
+func (t *tieredCache) Peek(key string) ([]byte, bool) {
+	if body, ok := t.mem.Peek(key); ok {
+		return body, true
+	}
+	return t.disk.Peek(key)
+}
+
+func (t *tieredCache) TTL(key string) time.Duration {
+	return t.mem.TTL(key)
+}
+
+func (t *tieredCache) Set(key string, value []byte, ttl time.Duration) {
+	t.mem.Set(key, value, ttl)
+	t.disk.Set(key, value, ttl)
+}
+
+func (t *tieredCache) Delete(key string) {
+	t.mem.Delete(key)
+	t.disk.Delete(key)
+}
+
+func (t *tieredCache) Purge() {
+	t.mem.Purge()
+	t.disk.Purge()
+}
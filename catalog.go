@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const titlesBucket = "titles"
+
+// titleCatalog is an index of every title detail we've fetched from OMDb.
+// Features that work "from what we already know" (quizzes, browsing,
+// similarity) read from here instead of spending upstream quota. It is
+// kept in memory and flushed to the store periodically.
+type titleCatalog struct {
+	mu     sync.RWMutex
+	titles map[string]*MovieResponse
+	dirty  map[string]bool
+}
+
+var catalog = &titleCatalog{
+	titles: map[string]*MovieResponse{},
+	dirty:  map[string]bool{},
+}
+
+func (tc *titleCatalog) Load() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	store.ForEach(titlesBucket, func(key string, value []byte) error {
+		var m MovieResponse
+		if json.Unmarshal(value, &m) == nil {
+			tc.titles[key] = &m
+		}
+		return nil
+	})
+}
+
+func (tc *titleCatalog) Add(m *MovieResponse) {
+	if m.IMDBID == "" {
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.titles[m.IMDBID] = m
+	tc.dirty[m.IMDBID] = true
+	searchIndex.Enqueue(m)
+}
+
+func (tc *titleCatalog) Get(id string) (*MovieResponse, bool) {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	m, ok := tc.titles[id]
+	return m, ok
+}
+
+// All returns the titles accepted by keep. Passing nil returns everything.
+func (tc *titleCatalog) All(keep func(*MovieResponse) bool) []*MovieResponse {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	out := make([]*MovieResponse, 0, len(tc.titles))
+	for _, m := range tc.titles {
+		if keep == nil || keep(m) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func (tc *titleCatalog) Len() int {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return len(tc.titles)
+}
+
+func (tc *titleCatalog) Flush() {
+	tc.mu.Lock()
+	pending := make(map[string]interface{}, len(tc.dirty))
+	for id := range tc.dirty {
+		pending[id] = tc.titles[id]
+	}
+	tc.dirty = map[string]bool{}
+	tc.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+	if err := store.PutAll(titlesBucket, pending); err != nil {
+		slog.Warn("catalog flush failed", "titles", len(pending), "error", err)
+	}
+}
+
+func flushCatalog(every time.Duration) {
+	for range time.Tick(every) {
+		catalog.Flush()
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+const catalogOverridesBucket = "catalog_overrides"
+
+// titleOverride is local data for a title, imported by an admin and
+// merged over whatever OMDb says about it. Empty fields leave OMDb's
+// value alone.
+type titleOverride struct {
+	IMDBID   string `json:"imdbID" binding:"required,imdbid"`
+	Title    string `json:"Title,omitempty" binding:"max=200"`
+	Year     string `json:"Year,omitempty" binding:"omitempty,year"`
+	Plot     string `json:"Plot,omitempty" binding:"max=2000"`
+	Director string `json:"Director,omitempty" binding:"max=200"`
+	Genre    string `json:"Genre,omitempty" binding:"max=200"`
+	Actors   string `json:"Actors,omitempty" binding:"max=500"`
+	Country  string `json:"Country,omitempty" binding:"max=200"`
+	Language string `json:"Language,omitempty" binding:"max=200"`
+	Rated    string `json:"Rated,omitempty" binding:"max=20"`
+	Runtime  string `json:"Runtime,omitempty" binding:"max=20"`
+	Poster   string `json:"Poster,omitempty" binding:"omitempty,url"`
+	// Availability says whether the title can be watched in-house.
+	Availability string   `json:"availability,omitempty" binding:"omitempty,oneof=available unavailable coming_soon"`
+	Tags         []string `json:"tags,omitempty" binding:"max=20,dive,min=1,max=40"`
+
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// catalogOverrides holds every override in memory; they are applied to
+// each title fetched, so lookups mustn't touch the store.
+var catalogOverrides = struct {
+	mu sync.RWMutex
+	m  map[string]*titleOverride
+}{m: map[string]*titleOverride{}}
+
+// loadCatalogOverrides (re)reads every override from the store; other
+// replicas call it when an import is broadcast.
+func loadCatalogOverrides() {
+	m := map[string]*titleOverride{}
+	store.ForEach(catalogOverridesBucket, func(key string, value []byte) error {
+		var o titleOverride
+		if json.Unmarshal(value, &o) == nil {
+			m[key] = &o
+		}
+		return nil
+	})
+	catalogOverrides.mu.Lock()
+	catalogOverrides.m = m
+	catalogOverrides.mu.Unlock()
+}
+
+// applyOverride merges the title's local override, if any, into m.
+func applyOverride(m *MovieResponse) {
+	catalogOverrides.mu.RLock()
+	o, ok := catalogOverrides.m[m.IMDBID]
+	catalogOverrides.mu.RUnlock()
+	if !ok {
+		return
+	}
+	set := func(field *string, value string) {
+		if value != "" {
+			*field = value
+		}
+	}
+	set(&m.Title, o.Title)
+	set(&m.Year, o.Year)
+	set(&m.Plot, o.Plot)
+	set(&m.Director, o.Director)
+	set(&m.Genre, o.Genre)
+	set(&m.Actors, o.Actors)
+	set(&m.Country, o.Country)
+	set(&m.Language, o.Language)
+	set(&m.Rated, o.Rated)
+	set(&m.Runtime, o.Runtime)
+	set(&m.Poster, o.Poster)
+	m.Availability = o.Availability
+	m.Tags = o.Tags
+}
+
+type catalogImportQuery struct {
+	DryRun bool `form:"dry_run"`
+	// Replace drops every override not in the upload.
+	Replace bool `form:"replace"`
+}
+
+type importProblem struct {
+	Row    int          `json:"row"`
+	IMDBID string       `json:"imdbID,omitempty"`
+	Fields []fieldError `json:"fields"`
+}
+
+// postCatalogImport uploads local title data as JSON (an array of
+// overrides) or CSV (text/csv, a header row naming the fields; tags are
+// separated by "|"). Nothing is saved unless every row is valid, and
+// ?dry_run=true only reports what would change.
+func postCatalogImport(c *gin.Context) {
+	var q catalogImportQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, 10<<20))
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "send the titles as CSV or JSON in the request body"})
+		return
+	}
+	var rows []titleOverride
+	if strings.Contains(c.ContentType(), "csv") {
+		rows, err = parseOverridesCSV(data)
+	} else {
+		err = json.Unmarshal(data, &rows)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not parse titles: " + err.Error()})
+		return
+	}
+
+	problems := []importProblem{}
+	seen := map[string]int{}
+	for i := range rows {
+		o := &rows[i]
+		o.IMDBID = strings.TrimSpace(o.IMDBID)
+		if fields := validateOverride(o); len(fields) > 0 {
+			problems = append(problems, importProblem{Row: i + 1, IMDBID: o.IMDBID, Fields: fields})
+			continue
+		}
+		if first, dup := seen[o.IMDBID]; dup {
+			problems = append(problems, importProblem{Row: i + 1, IMDBID: o.IMDBID, Fields: []fieldError{{Field: "imdbID", Message: fmt.Sprintf("repeats row %d", first)}}})
+			continue
+		}
+		seen[o.IMDBID] = i + 1
+	}
+	if len(problems) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "some rows are invalid; nothing was imported", "problems": problems})
+		return
+	}
+
+	var created, updated, unchanged []string
+	catalogOverrides.mu.RLock()
+	for _, o := range rows {
+		old, ok := catalogOverrides.m[o.IMDBID]
+		switch {
+		case !ok:
+			created = append(created, o.IMDBID)
+		case sameOverride(*old, o):
+			unchanged = append(unchanged, o.IMDBID)
+		default:
+			updated = append(updated, o.IMDBID)
+		}
+	}
+	var removed []string
+	if q.Replace {
+		for id := range catalogOverrides.m {
+			if _, keep := seen[id]; !keep {
+				removed = append(removed, id)
+			}
+		}
+	}
+	catalogOverrides.mu.RUnlock()
+
+	summary := gin.H{
+		"dry_run":   q.DryRun,
+		"titles":    len(rows),
+		"created":   nonNil(created),
+		"updated":   nonNil(updated),
+		"unchanged": len(unchanged),
+		"removed":   nonNil(removed),
+	}
+	if q.DryRun {
+		c.JSON(http.StatusOK, summary)
+		return
+	}
+
+	now := time.Now().UTC()
+	pending := make(map[string]interface{}, len(rows))
+	for i := range rows {
+		rows[i].ImportedAt = now
+		pending[rows[i].IMDBID] = &rows[i]
+	}
+	if err := store.PutAll(catalogOverridesBucket, pending); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save titles"})
+		return
+	}
+	for _, id := range removed {
+		if err := store.Delete(catalogOverridesBucket, id); err != nil {
+			slog.Warn("could not delete catalog override", "imdbID", id, "error", err)
+		}
+	}
+	catalogOverrides.mu.Lock()
+	for i := range rows {
+		catalogOverrides.m[rows[i].IMDBID] = &rows[i]
+	}
+	for _, id := range removed {
+		delete(catalogOverrides.m, id)
+	}
+	catalogOverrides.mu.Unlock()
+
+	// Titles already in the catalog pick up their new local data now
+	// rather than on their next fetch. A removed override's availability
+	// and tags go at once; fields it replaced revert on the next fetch.
+	for _, id := range append(append(created, updated...), removed...) {
+		if m, ok := catalog.Get(id); ok {
+			merged := *m
+			merged.Availability, merged.Tags = "", nil
+			applyOverride(&merged)
+			catalog.Add(&merged)
+		}
+	}
+	if err := cacheBus.Publish(c.Request.Context(), invalidation{Origin: instanceID, Op: "reload_overrides"}); err != nil {
+		summary["warning"] = "imported, but other replicas were not told to reload: " + err.Error()
+	}
+	slog.Info("imported catalog data", "titles", len(rows), "created", len(created), "updated", len(updated), "removed", len(removed))
+	c.JSON(http.StatusOK, summary)
+}
+
+func nonNil(ids []string) []string {
+	if ids == nil {
+		return []string{}
+	}
+	return ids
+}
+
+func sameOverride(a, b titleOverride) bool {
+	a.ImportedAt, b.ImportedAt = time.Time{}, time.Time{}
+	return reflect.DeepEqual(a, b)
+}
+
+// validateOverride returns the row's invalid fields, named as in the
+// upload.
+func validateOverride(o *titleOverride) []fieldError {
+	err := binding.Validator.ValidateStruct(o)
+	if err == nil {
+		return nil
+	}
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return []fieldError{{Message: err.Error()}}
+	}
+	fields := make([]fieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		name := fe.Field()
+		if f, ok := reflect.TypeOf(*o).FieldByName(fe.StructField()); ok {
+			name, _, _ = strings.Cut(f.Tag.Get("json"), ",")
+		}
+		message := validationMessage(fe)
+		if fe.Tag() == "url" {
+			message = "must be a URL"
+		}
+		fields = append(fields, fieldError{Field: name, Message: message})
+	}
+	return fields
+}
+
+// parseOverridesCSV maps header names onto titleOverride's JSON names,
+// ignoring case, and leaves unknown columns out.
+func parseOverridesCSV(data []byte) ([]titleOverride, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read CSV header: %w", err)
+	}
+	var rows []titleOverride
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := map[string]interface{}{}
+		for i, name := range header {
+			if i >= len(record) || strings.TrimSpace(record[i]) == "" {
+				continue
+			}
+			value := strings.TrimSpace(record[i])
+			key := overrideField(strings.TrimSpace(name))
+			if key == "tags" {
+				var tags []string
+				for _, t := range strings.Split(value, "|") {
+					if t = strings.TrimSpace(t); t != "" {
+						tags = append(tags, t)
+					}
+				}
+				row[key] = tags
+			} else if key != "" {
+				row[key] = value
+			}
+		}
+		var o titleOverride
+		b, _ := json.Marshal(row)
+		if err := json.Unmarshal(b, &o); err != nil {
+			return nil, err
+		}
+		rows = append(rows, o)
+	}
+	return rows, nil
+}
+
+func overrideField(header string) string {
+	t := reflect.TypeOf(titleOverride{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "imported_at" && strings.EqualFold(name, header) {
+			return name
+		}
+	}
+	return ""
+}
+
+func getCatalogOverrides(c *gin.Context) {
+	catalogOverrides.mu.RLock()
+	out := make([]*titleOverride, 0, len(catalogOverrides.m))
+	for _, o := range catalogOverrides.m {
+		out = append(out, o)
+	}
+	catalogOverrides.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].IMDBID < out[j].IMDBID })
+	c.JSON(http.StatusOK, gin.H{"titles": out, "total": len(out)})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const chatSessionsBucket = "chat_sessions"
+
+// maxChatTurns bounds how much history is kept per session and sent to the
+// model.
+const maxChatTurns = 20
+
+type chatMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Cited   []string `json:"cited,omitempty"`
+}
+
+type chatSession struct {
+	ID        string        `json:"id"`
+	Owner     string        `json:"owner,omitempty"`
+	Messages  []chatMessage `json:"messages"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// seen returns every title cited so far, so we don't suggest them again.
+func (s *chatSession) seen() map[string]bool {
+	seen := map[string]bool{}
+	for _, m := range s.Messages {
+		for _, id := range m.Cited {
+			seen[id] = true
+		}
+	}
+	return seen
+}
+
+type chatRequest struct {
+	SessionID string `json:"session_id"`
+	Message   string `json:"message"`
+}
+
+var chatReference = regexp.MustCompile(`(?i)(?:after|like|similar to|about|watched|loved)\s+["“]?([^"”?!.,]+)`)
+
+func postChat(c *gin.Context) {
+	var req chatRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Message) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide {"message": "...", "session_id": optional}`})
+		return
+	}
+
+	session := &chatSession{ID: randomHex(16), Owner: apiKey(c), CreatedAt: time.Now().UTC()}
+	if req.SessionID != "" {
+		found, err := store.Get(chatSessionsBucket, req.SessionID, session)
+		if err != nil || !found || session.Owner != apiKey(c) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "chat session not found"})
+			return
+		}
+	}
+
+	candidates, anchor := chatCandidates(c, req.Message, session.seen())
+
+	answer, mode, err := "", "rules", error(nil)
+	if llm != nil {
+		answer, err = chatWithLLM(c, session, req.Message, anchor, candidates)
+		mode = "llm"
+	}
+	if llm == nil || err != nil {
+		answer = chatFallbackAnswer(anchor, candidates)
+		mode = "rules"
+	}
+
+	cited := []gin.H{}
+	citedIDs := []string{}
+	for _, m := range candidates {
+		if mode == "rules" || strings.Contains(answer, m.IMDBID) || strings.Contains(answer, m.Title) {
+			cited = append(cited, gin.H{"imdbID": m.IMDBID, "Title": m.Title, "Year": m.Year})
+			citedIDs = append(citedIDs, m.IMDBID)
+		}
+	}
+
+	session.Messages = append(session.Messages,
+		chatMessage{Role: "user", Content: req.Message},
+		chatMessage{Role: "assistant", Content: answer, Cited: citedIDs},
+	)
+	if len(session.Messages) > maxChatTurns*2 {
+		session.Messages = session.Messages[len(session.Messages)-maxChatTurns*2:]
+	}
+	session.UpdatedAt = time.Now().UTC()
+	if err := store.Put(chatSessionsBucket, session.ID, session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save chat session"})
+		return
+	}
+
+	resp := gin.H{
+		"session_id": session.ID,
+		"answer":     answer,
+		"cited":      cited,
+		"mode":       mode,
+		"truncated":  costOf(c).isTruncated(),
+	}
+	if err != nil {
+		resp["warning"] = "llm unavailable, answered from metadata only: " + err.Error()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// chatCandidates gathers titles relevant to message: if it mentions a
+// title ("what should I watch after Dark?") we recommend from that title,
+// otherwise we treat the message as a natural-language search.
+func chatCandidates(c *gin.Context, message string, seen map[string]bool) ([]*MovieResponse, *MovieResponse) {
+	var anchor *MovieResponse
+	if m := chatReference.FindStringSubmatch(message); m != nil {
+		if movie, err := fetchMovie(scopedParams(c, map[string]string{"t": strings.TrimSpace(m[1])})); err == nil {
+			anchor = movie
+			seen[movie.IMDBID] = true
+		}
+	}
+
+	var seeds []string
+	filters := parseNaturalQuery(message)
+	if anchor != nil {
+		seeds = strings.Split(anchor.Genre, ",")
+		filters = searchFilters{}
+	} else {
+		seeds = filters.seeds()
+	}
+
+	candidates := []*MovieResponse{}
+	lookups := 0
+	// Past the route's deadline or budget we answer from what we have.
+gather:
+	for _, seed := range seeds {
+		seed = strings.TrimSpace(seed)
+		if seed == "" || seed == "N/A" {
+			continue
+		}
+		search, err := fetchSearch(scopedParams(c, searchParams(seed, 1)))
+		if errors.Is(err, errRequestLimit) {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		for _, item := range search.Search {
+			if seen[item.IMDBID] || lookups >= 20 || len(candidates) >= 5 {
+				break
+			}
+			seen[item.IMDBID] = true
+			lookups++
+			movie, err := fetchMovie(scopedParams(c, map[string]string{"i": item.IMDBID}))
+			if errors.Is(err, errRequestLimit) {
+				break gather
+			}
+			if err != nil || !filters.matches(movie) {
+				continue
+			}
+			if anchor != nil && !sharesGenre(anchor, movie) {
+				continue
+			}
+			candidates = append(candidates, movie)
+		}
+	}
+	return candidates, anchor
+}
+
+func sharesGenre(a, b *MovieResponse) bool {
+	for _, g := range strings.Split(a.Genre, ",") {
+		if containsFold(b.Genre, strings.TrimSpace(g)) {
+			return true
+		}
+	}
+	return false
+}
+
+const chatSystemPrompt = `You are a friendly movie and TV assistant. Answer briefly.
+Only recommend titles from the CANDIDATES list and mention each recommended title's imdbID in parentheses.
+If no candidate fits, say so instead of inventing titles.`
+
+func chatWithLLM(c *gin.Context, session *chatSession, message string, anchor *MovieResponse, candidates []*MovieResponse) (string, error) {
+	var b strings.Builder
+	for _, m := range session.Messages {
+		fmt.Fprintf(&b, "%s: %s\n", m.Role, m.Content)
+	}
+	if anchor != nil {
+		fmt.Fprintf(&b, "\nUSER IS ASKING ABOUT: %s (%s, %s) %s\n", anchor.Title, anchor.Year, anchor.IMDBID, anchor.Genre)
+	}
+	b.WriteString("\nCANDIDATES:\n")
+	for _, m := range candidates {
+		fmt.Fprintf(&b, "- %s (%s) imdbID=%s genre=%s director=%s imdbRating=%s plot=%s\n",
+			m.Title, m.Year, m.IMDBID, m.Genre, m.Director, m.IMDBRating, m.Plot)
+	}
+	fmt.Fprintf(&b, "\nuser: %s", message)
+	return llm.Complete(c.Request.Context(), chatSystemPrompt, b.String())
+}
+
+func chatFallbackAnswer(anchor *MovieResponse, candidates []*MovieResponse) string {
+	if len(candidates) == 0 {
+		return "I couldn't find anything matching that. Try naming a title you liked or a genre."
+	}
+	var b strings.Builder
+	if anchor != nil {
+		fmt.Fprintf(&b, "If you liked %s, you could try: ", anchor.Title)
+	} else {
+		b.WriteString("Here are some titles that match: ")
+	}
+	for i, m := range candidates {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		fmt.Fprintf(&b, "%s (%s, IMDb %s)", m.Title, m.Year, m.IMDBRating)
+	}
+	b.WriteString(".")
+	return b.String()
+}
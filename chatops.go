@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operational events posted to chat. eventSeriesNewEpisode can also be
+// routed to chat, once per episode rather than once per follower.
+const (
+	eventUpstreamOutage       = "upstream.outage"
+	eventUpstreamRecovered    = "upstream.recovered"
+	eventQuotaNearlyExhausted = "quota.nearly_exhausted"
+)
+
+// ChatHook is a Slack or Discord incoming webhook. Kind is inferred from
+// the URL when empty. An empty Events list receives every event.
+type ChatHook struct {
+	Kind   string   `json:"kind"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+func (h ChatHook) kind() string {
+	if h.Kind != "" {
+		return h.Kind
+	}
+	if strings.Contains(h.URL, "discord.com") || strings.Contains(h.URL, "discordapp.com") {
+		return "discord"
+	}
+	return "slack"
+}
+
+func (h ChatHook) wants(event string) bool {
+	return len(h.Events) == 0 || slices.Contains(h.Events, event)
+}
+
+// announce posts text to every configured chat hook subscribed to event.
+// Delivery is best effort: failures are logged, not retried.
+func announce(event, text string) {
+	for _, h := range cfg().ChatHooks {
+		if h.wants(event) {
+			go postChatHook(h, event, text)
+		}
+	}
+}
+
+func postChatHook(h ChatHook, event, text string) {
+	payload := map[string]string{"text": text}
+	if h.kind() == "discord" {
+		payload = map[string]string{"content": text}
+	}
+	body, _ := json.Marshal(payload)
+	resp, err := webhookClient.Post(h.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("chat notification failed", "event", event, "kind", h.kind(), "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		slog.Warn("chat notification rejected", "event", event, "kind", h.kind(), "status", resp.StatusCode)
+	}
+}
+
+// upstreamHealth turns individual OMDb failures into outage and recovery
+// announcements. An outage is declared after outageThreshold consecutive
+// failures; quota warnings are sent at most once per quotaWarnEvery.
+type upstreamHealth struct {
+	mu            sync.Mutex
+	failures      int
+	down          bool
+	lastQuotaWarn time.Time
+}
+
+const (
+	outageThreshold = 3
+	quotaWarnEvery  = time.Hour
+)
+
+var omdbHealth = &upstreamHealth{}
+
+func (u *upstreamHealth) record(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err == nil {
+		if u.down {
+			announce(eventUpstreamRecovered, "OMDb is responding again.")
+		}
+		u.failures, u.down = 0, false
+		return
+	}
+	u.failures++
+	if !u.down && u.failures >= outageThreshold {
+		u.down = true
+		// A transport error's URL carries the API key.
+		announce(eventUpstreamOutage, fmt.Sprintf("OMDb looks down: %d consecutive failures, last error: %v", u.failures, withoutURL(err)))
+	}
+}
+
+func (u *upstreamHealth) quotaExhausted(message string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if time.Since(u.lastQuotaWarn) < quotaWarnEvery {
+		return
+	}
+	u.lastQuotaWarn = time.Now()
+	announce(eventQuotaNearlyExhausted, "OMDb API key quota is exhausted: "+message)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutageAnnouncementLeavesOutTheKey(t *testing.T) {
+	hook, posts := chatHookRecorder(t)
+	withConfig(t, func(c *Config) {
+		c.ChatHooks = []ChatHook{hook}
+		// Nothing listens on port 1, so every lookup fails in transport.
+		c.OMDbBaseURL = "http://127.0.0.1:1/"
+	})
+	old := omdbHealth
+	omdbHealth = &upstreamHealth{}
+	t.Cleanup(func() { omdbHealth = old })
+
+	for range outageThreshold {
+		if _, err := (omdbProvider{}).Lookup(map[string]string{"i": "tt0133093"}); err == nil {
+			t.Fatal("lookup against a closed port succeeded")
+		}
+	}
+	select {
+	case text := <-posts:
+		if !strings.Contains(text, "OMDb looks down") {
+			t.Errorf("announced %q, want an outage", text)
+		}
+		if strings.Contains(text, "apikey") || strings.Contains(text, omdbAPIKey()) {
+			t.Errorf("outage announcement carries the API key: %q", text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no outage was announced")
+	}
+}
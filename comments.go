@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const commentsBucket = "comments"
+
+const (
+	commentVisible = "visible"
+	commentHidden  = "hidden"
+	commentDeleted = "deleted"
+)
+
+// Who deleted a comment.
+const (
+	deletedByAuthor    = "author"
+	deletedByModerator = "moderator"
+)
+
+var (
+	errCommentNotFound      = errors.New("comment not found")
+	errCommentAuthorDeleted = errors.New("the author deleted this comment")
+)
+
+type commentReport struct {
+	UserID string    `json:"user_id"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+type Comment struct {
+	ID         string `json:"id"`
+	TenantID   string `json:"tenant_id,omitempty"`
+	IMDBID     string `json:"imdbID"`
+	ParentID   string `json:"parent_id,omitempty"`
+	UserID     string `json:"user_id"`
+	AuthorName string `json:"author"`
+	Body       string `json:"body"`
+	Status     string `json:"status"`
+	// DeletedBy is "author" or "moderator" for a deleted comment. Only
+	// the author can bring back what they deleted, from their trash.
+	DeletedBy string          `json:"deleted_by,omitempty"`
+	Reports   []commentReport `json:"reports,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	Replies   []*Comment      `json:"replies,omitempty"`
+}
+
+var commentLimiter = newWindowLimiter("comments")
+
+// commentKey is <imdbID>/<id>, scoped to the tenant whose users wrote it.
+func commentKey(tenant, imdbID, id string) string {
+	return tenantKey(tenant, imdbID+"/"+id)
+}
+
+func loadComment(tenant, imdbID, id string) (*Comment, bool) {
+	var cm Comment
+	found, err := store.Get(commentsBucket, commentKey(tenant, imdbID, id), &cm)
+	if err != nil || !found {
+		return nil, false
+	}
+	return &cm, true
+}
+
+// updateComment applies fn to a stored comment in one transaction, so
+// concurrent reports and moderation don't overwrite each other. fn may
+// return errStopIteration to leave the comment as it is.
+func updateComment(tenant, imdbID, id string, fn func(cm *Comment) error) (*Comment, error) {
+	var cm Comment
+	found, err := store.Modify(commentsBucket, commentKey(tenant, imdbID, id), &cm, func() error {
+		return fn(&cm)
+	})
+	if err == nil && !found {
+		err = errCommentNotFound
+	}
+	return &cm, err
+}
+
+// respondComment writes the outcome of updateComment: the comment, or what
+// went wrong while doing what.
+func respondComment(c *gin.Context, cm *Comment, err error, what string) {
+	switch {
+	case err == nil || errors.Is(err, errStopIteration):
+		c.JSON(http.StatusOK, cm)
+	case errors.Is(err, errCommentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errCommentAuthorDeleted):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not " + what})
+	}
+}
+
+func movieComments(tenant, imdbID string) []*Comment {
+	comments := []*Comment{}
+	store.ForEachPrefix(commentsBucket, tenantKey(tenant, imdbID+"/"), func(_ string, value []byte) error {
+		var cm Comment
+		if json.Unmarshal(value, &cm) == nil {
+			comments = append(comments, &cm)
+		}
+		return nil
+	})
+	return comments
+}
+
+// commentThreads nests replies under their parents. Hidden and deleted
+// comments keep their place in the thread so replies still make sense, but
+// their author and body are blanked.
+func commentThreads(comments []*Comment) []*Comment {
+	byID := map[string]*Comment{}
+	for _, cm := range comments {
+		cm.Reports = nil
+		if cm.Status != commentVisible {
+			cm.Body = "[removed]"
+			cm.AuthorName = ""
+			cm.UserID = ""
+		}
+		byID[cm.ID] = cm
+	}
+	roots := []*Comment{}
+	sort.Slice(comments, func(i, j int) bool { return comments[i].CreatedAt.Before(comments[j].CreatedAt) })
+	for _, cm := range comments {
+		if parent, ok := byID[cm.ParentID]; ok {
+			parent.Replies = append(parent.Replies, cm)
+		} else {
+			roots = append(roots, cm)
+		}
+	}
+	return roots
+}
+
+func getComments(c *gin.Context) {
+	c.JSON(http.StatusOK, commentThreads(movieComments(currentPrincipal(c).Tenant, c.Param("id"))))
+}
+
+type commentRequest struct {
+	Body     string `json:"body"`
+	ParentID string `json:"parent_id"`
+}
+
+func postComment(c *gin.Context) {
+	u := currentUser(c)
+	limits := cfg().Comments
+	if ok, retry := commentLimiter.Allow(u.ID, limits.PerUserLimit, time.Duration(limits.Window)); !ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "you're commenting too fast; try again later"})
+		return
+	}
+
+	var req commentRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Body) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide {"body": "...", "parent_id": optional}`})
+		return
+	}
+	if len(req.Body) > limits.MaxLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "comment is too long"})
+		return
+	}
+
+	imdbID := c.Param("id")
+	if req.ParentID != "" {
+		if _, ok := loadComment(u.TenantID, imdbID, req.ParentID); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "parent comment not found"})
+			return
+		}
+	} else if _, err := fetchMovie(scopedParams(c, map[string]string{"i": imdbID})); err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+
+	author := u.Name
+	if author == "" {
+		author = u.Handle
+	}
+	cm := &Comment{
+		ID:         strconv.FormatInt(time.Now().UnixNano(), 36),
+		TenantID:   u.TenantID,
+		IMDBID:     imdbID,
+		ParentID:   req.ParentID,
+		UserID:     u.ID,
+		AuthorName: author,
+		Body:       strings.TrimSpace(req.Body),
+		Status:     commentVisible,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := store.Put(commentsBucket, commentKey(cm.TenantID, imdbID, cm.ID), cm); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save comment"})
+		return
+	}
+	c.JSON(http.StatusCreated, cm)
+}
+
+// deleteOwnComment soft-deletes so that replies keep their context, and
+// puts the comment in the author's trash so it can be restored.
+func deleteOwnComment(c *gin.Context) {
+	tenant, userID := currentPrincipal(c).Tenant, currentUser(c).ID
+	ownVisible := func(cm *Comment) error {
+		if cm.UserID != userID || cm.Status == commentDeleted {
+			return errCommentNotFound
+		}
+		return nil
+	}
+	cm, ok := loadComment(tenant, c.Param("id"), c.Param("commentID"))
+	if !ok || ownVisible(cm) != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+		return
+	}
+	if err := moveCommentToTrash(cm); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete comment"})
+		return
+	}
+	cm, err := updateComment(tenant, cm.IMDBID, cm.ID, func(cm *Comment) error {
+		if err := ownVisible(cm); err != nil {
+			return err
+		}
+		cm.Status, cm.DeletedBy = commentDeleted, deletedByAuthor
+		return nil
+	})
+	respondComment(c, cm, err, "delete comment")
+}
+
+func postCommentReport(c *gin.Context) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	c.ShouldBindJSON(&req)
+
+	u := currentUser(c)
+	_, err := updateComment(currentPrincipal(c).Tenant, c.Param("id"), c.Param("commentID"), func(cm *Comment) error {
+		if cm.Status != commentVisible {
+			return errCommentNotFound
+		}
+		for _, r := range cm.Reports {
+			if r.UserID == u.ID {
+				return errStopIteration
+			}
+		}
+		cm.Reports = append(cm.Reports, commentReport{UserID: u.ID, Reason: req.Reason, At: time.Now().UTC()})
+		if threshold := cfg().Comments.AutoHideReports; threshold > 0 && len(cm.Reports) >= threshold {
+			cm.Status = commentHidden
+		}
+		return nil
+	})
+	switch {
+	case err == nil || errors.Is(err, errStopIteration):
+		c.Status(http.StatusNoContent)
+	case errors.Is(err, errCommentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save report"})
+	}
+}
+
+// getModerationQueue lists the caller's tenant's reported comments, most
+// reported first, including ones auto-hidden by the report threshold.
+func getModerationQueue(c *gin.Context) {
+	tenant := currentPrincipal(c).Tenant
+	queue := []*Comment{}
+	store.ForEach(commentsBucket, func(_ string, value []byte) error {
+		var cm Comment
+		if json.Unmarshal(value, &cm) == nil && cm.TenantID == tenant && len(cm.Reports) > 0 && cm.Status != commentDeleted {
+			queue = append(queue, &cm)
+		}
+		return nil
+	})
+	sort.Slice(queue, func(i, j int) bool {
+		if len(queue[i].Reports) != len(queue[j].Reports) {
+			return len(queue[i].Reports) > len(queue[j].Reports)
+		}
+		return queue[i].CreatedAt.Before(queue[j].CreatedAt)
+	})
+	c.JSON(http.StatusOK, queue)
+}
+
+// moderateComment sets a comment's status. A comment its author deleted
+// is theirs to restore from their trash, so moderators can't touch it.
+func moderateComment(status string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cm, err := updateComment(currentPrincipal(c).Tenant, c.Param("id"), c.Param("commentID"), func(cm *Comment) error {
+			if cm.Status == commentDeleted && cm.DeletedBy == deletedByAuthor {
+				return errCommentAuthorDeleted
+			}
+			if status == commentVisible {
+				// Restoring a comment means the reports were reviewed and rejected.
+				cm.Reports = nil
+			}
+			cm.Status, cm.DeletedBy = status, ""
+			if status == commentDeleted {
+				cm.DeletedBy = deletedByModerator
+			}
+			return nil
+		})
+		respondComment(c, cm, err, "update comment")
+	}
+}
+
+// dismissReports clears reports without changing the comment.
+func dismissReports(c *gin.Context) {
+	cm, err := updateComment(currentPrincipal(c).Tenant, c.Param("id"), c.Param("commentID"), func(cm *Comment) error {
+		cm.Reports = nil
+		return nil
+	})
+	respondComment(c, cm, err, "update comment")
+}
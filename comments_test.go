@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// newComment posts a comment on The Matrix as user and returns its ID.
+func newComment(t *testing.T, user map[string]string) string {
+	t.Helper()
+	w := request(http.MethodPost, "/api/movies/tt0133093/comments", user, `{"body":"Whoa."}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("comment: status %d; body %s", w.Code, w.Body)
+	}
+	return decodeObject(t, w)["id"].(string)
+}
+
+func TestCommentModeration(t *testing.T) {
+	author, _ := newUser(t)
+	reporter, _ := newUser(t)
+	moderator := map[string]string{"X-API-Key": newAPIKey(t, roleModerator, "")}
+	reported, ownDeleted, modDeleted := newComment(t, author), newComment(t, author), newComment(t, author)
+
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "report a comment",
+			method:      http.MethodPost,
+			path:        "/api/movies/tt0133093/comments/" + reported + "/report",
+			headers:     reporter,
+			body:        `{"reason":"spoilers"}`,
+			wantStatus:  http.StatusNoContent,
+			wantLookups: 0,
+		},
+		{
+			name:        "reporting twice is a no-op",
+			method:      http.MethodPost,
+			path:        "/api/movies/tt0133093/comments/" + reported + "/report",
+			headers:     reporter,
+			wantStatus:  http.StatusNoContent,
+			wantLookups: 0,
+		},
+		{
+			name:        "report a missing comment",
+			method:      http.MethodPost,
+			path:        "/api/movies/tt0133093/comments/nope/report",
+			headers:     reporter,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "users can't moderate",
+			method:      http.MethodPost,
+			path:        "/admin/moderation/comments/tt0133093/" + reported + "/hide",
+			headers:     reporter,
+			wantStatus:  http.StatusForbidden,
+			wantLookups: 0,
+		},
+		{
+			name:        "hide",
+			method:      http.MethodPost,
+			path:        "/admin/moderation/comments/tt0133093/" + reported + "/hide",
+			headers:     moderator,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"status": commentHidden},
+			wantLookups: 0,
+		},
+		{
+			name:        "restore clears the reports",
+			method:      http.MethodPost,
+			path:        "/admin/moderation/comments/tt0133093/" + reported + "/restore",
+			headers:     moderator,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"status": commentVisible},
+			wantNoKeys:  []string{"reports"},
+			wantLookups: 0,
+		},
+		{
+			name:        "author deletes",
+			method:      http.MethodDelete,
+			path:        "/api/movies/tt0133093/comments/" + ownDeleted,
+			headers:     author,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"status": commentDeleted, "deleted_by": deletedByAuthor},
+			wantLookups: 0,
+		},
+		{
+			name:        "moderators can't restore what the author deleted",
+			method:      http.MethodPost,
+			path:        "/admin/moderation/comments/tt0133093/" + ownDeleted + "/restore",
+			headers:     moderator,
+			wantStatus:  http.StatusConflict,
+			wantLookups: 0,
+		},
+		{
+			name:        "moderator deletes",
+			method:      http.MethodDelete,
+			path:        "/admin/moderation/comments/tt0133093/" + modDeleted,
+			headers:     moderator,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"status": commentDeleted, "deleted_by": deletedByModerator},
+			wantLookups: 0,
+		},
+		{
+			name:        "moderator restores their deletion",
+			method:      http.MethodPost,
+			path:        "/admin/moderation/comments/tt0133093/" + modDeleted + "/restore",
+			headers:     moderator,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"status": commentVisible},
+			wantNoKeys:  []string{"deleted_by"},
+			wantLookups: 0,
+		},
+		{
+			name:        "moderate a missing comment",
+			method:      http.MethodPost,
+			path:        "/admin/moderation/comments/tt0133093/nope/hide",
+			headers:     moderator,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+	})
+}
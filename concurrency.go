@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// adaptiveLimit caps how many upstream lookups a pool runs at once and
+// moves the cap with how OMDb copes, AIMD-style: every lookup that comes
+// back fine and about as fast as usual adds 1/limit (one per limit's worth
+// of lookups), and an overload halves it. A lookup is an overload when
+// OMDb fails or rate-limits it, or when recent latency has risen to twice
+// the long-run baseline. The cap stays between 1 and
+// deepening.max_concurrency.
+type adaptiveLimit struct {
+	name string
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    float64
+	inFlight int
+	// latency follows the last few lookups; baseline the long run.
+	latency      time.Duration
+	baseline     time.Duration
+	lastDecrease time.Time
+
+	lookups   int64
+	overloads int64
+	increases int64
+	decreases int64
+}
+
+const (
+	initialConcurrency = 4
+	// latencyTolerance is how far above baseline latency may rise before
+	// it counts as overload.
+	latencyTolerance = 2.0
+	// Smoothing of the recent and the long-run latency.
+	recentLatencyWeight   = 0.3
+	baselineLatencyWeight = 0.02
+	// minDecreaseInterval keeps one burst of failures from halving the
+	// limit again and again.
+	minDecreaseInterval = 100 * time.Millisecond
+)
+
+var (
+	genreLookups          = newAdaptiveLimit("genre")
+	recommendationLookups = newAdaptiveLimit("recommendations")
+)
+
+func newAdaptiveLimit(name string) *adaptiveLimit {
+	l := &adaptiveLimit{name: name, limit: initialConcurrency}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func maxConcurrency() float64 {
+	return float64(max(cfg().Deepening.MaxConcurrency, 1))
+}
+
+// Acquire waits for a free slot.
+func (l *adaptiveLimit) Acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inFlight >= int(min(l.limit, maxConcurrency())) {
+		l.cond.Wait()
+	}
+	l.inFlight++
+}
+
+// Release frees the slot of a lookup that took d and ended with err, and
+// adjusts the limit by it. Lookups cut short by the request's own limits
+// or skipped by a dry run say nothing about OMDb and are left out.
+func (l *adaptiveLimit) Release(d time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer l.cond.Broadcast()
+	l.inFlight--
+
+	ok := err == nil || isNotFound(err)
+	overloaded := isOverload(err)
+	if !ok && !overloaded {
+		return
+	}
+	l.lookups++
+	if l.baseline == 0 {
+		l.latency, l.baseline = d, d
+	} else {
+		l.latency = time.Duration(recentLatencyWeight*float64(d) + (1-recentLatencyWeight)*float64(l.latency))
+		l.baseline = time.Duration(baselineLatencyWeight*float64(d) + (1-baselineLatencyWeight)*float64(l.baseline))
+	}
+	if float64(l.latency) > latencyTolerance*float64(l.baseline) {
+		overloaded = true
+	}
+
+	ceiling := maxConcurrency()
+	switch {
+	case overloaded:
+		l.overloads++
+		if time.Since(l.lastDecrease) >= max(l.latency, minDecreaseInterval) {
+			l.limit = max(l.limit/2, 1)
+			l.lastDecrease = time.Now()
+			l.decreases++
+			slog.Debug("lowered upstream concurrency", "pool", l.name, "limit", int(l.limit), "latency", l.latency, "baseline", l.baseline, "error", err)
+		}
+	case l.limit < ceiling:
+		l.limit = min(l.limit+1/l.limit, ceiling)
+		l.increases++
+	}
+	l.limit = min(l.limit, ceiling)
+}
+
+// isOverload reports whether err says OMDb is struggling or pushing back.
+func isOverload(err error) bool {
+	var ue *upstreamError
+	if !errors.As(err, &ue) {
+		return false
+	}
+	return ue.Code == "upstream_unavailable" || ue.Code == "upstream_limit_reached"
+}
+
+type adaptiveLimitStats struct {
+	Limit      int     `json:"limit"`
+	LimitExact float64 `json:"limit_exact"`
+	Max        int     `json:"max"`
+	InFlight   int     `json:"in_flight"`
+	LatencyMs  float64 `json:"latency_ms"`
+	BaselineMs float64 `json:"baseline_ms"`
+	Lookups    int64   `json:"lookups"`
+	Overloads  int64   `json:"overloads"`
+	Increases  int64   `json:"increases"`
+	Decreases  int64   `json:"decreases"`
+}
+
+func (l *adaptiveLimit) stats() adaptiveLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ceiling := maxConcurrency()
+	return adaptiveLimitStats{
+		Limit:      int(min(l.limit, ceiling)),
+		LimitExact: roundTo(min(l.limit, ceiling), 2),
+		Max:        int(ceiling),
+		InFlight:   l.inFlight,
+		LatencyMs:  roundTo(float64(l.latency)/float64(time.Millisecond), 1),
+		BaselineMs: roundTo(float64(l.baseline)/float64(time.Millisecond), 1),
+		Lookups:    l.lookups,
+		Overloads:  l.overloads,
+		Increases:  l.increases,
+		Decreases:  l.decreases,
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Config holds every setting that can change without a restart. It is
+// loaded from CONFIG_FILE (JSON) if set, with environment variables taking
+// precedence, and swapped atomically on reload.
+type Config struct {
+	Environment string          `json:"environment"`
+	Cache       CacheConfig     `json:"cache"`
+	LogLevel    string          `json:"log_level"`
+	Flags       map[string]Flag `json:"flags"`
+	Comments    CommentsConfig  `json:"comments"`
+	// HTMLPages enables the server-rendered /m, /l and /u pages.
+	HTMLPages bool `json:"html_pages"`
+	// ContractFixtures serves the lookup routes under /testing/ with OMDb
+	// answered from canned fixtures, for consumer contract tests.
+	ContractFixtures bool `json:"contract_fixtures"`
+	// PublicBaseURL is used for absolute links in shared pages.
+	PublicBaseURL string `json:"public_base_url"`
+	// Experiments maps an experiment name to its variant weights.
+	Experiments map[string]map[string]int `json:"experiments"`
+	// ChatHooks receive operational events in Slack or Discord.
+	ChatHooks []ChatHook   `json:"chat_hooks"`
+	Quotas    QuotasConfig `json:"quotas"`
+	// OMDbBaseURL is where upstream lookups go; point it at a mock or a
+	// caching proxy in tests and staging.
+	OMDbBaseURL string          `json:"omdb_base_url"`
+	Genre       GenreConfig     `json:"genre"`
+	Deepening   DeepeningConfig `json:"deepening"`
+	Ratings     RatingsConfig   `json:"ratings"`
+	// ContentFilter masks profanity in plots, summaries and comments.
+	ContentFilter ContentFilterConfig `json:"content_filter"`
+	// Continue weighs the suggestions of /api/users/me/continue.
+	Continue ContinueConfig `json:"continue"`
+	Tonight  TonightConfig  `json:"tonight"`
+	Trash    TrashConfig    `json:"trash"`
+	JWTKeys  JWTKeysConfig  `json:"jwt_keys"`
+	// RouteLimits caps how long a request may take and how many
+	// upstream calls it may make.
+	RouteLimits RouteLimitsConfig `json:"route_limits"`
+	// LoadShedding turns away expensive requests under pressure.
+	LoadShedding LoadSheddingConfig `json:"load_shedding"`
+	// ResponseHooks configures the built-in response hooks; which routes
+	// they apply to is fixed at startup.
+	ResponseHooks ResponseHooksConfig `json:"response_hooks"`
+	// Enrichments add computed fields to titles in responses.
+	Enrichments []Enrichment `json:"enrichments"`
+	// AccountDeletionGrace is how long a requested account deletion
+	// waits, and can be cancelled, before the data is erased.
+	AccountDeletionGrace Duration `json:"account_deletion_grace"`
+}
+
+// TonightConfig holds the moods /api/watchlist/tonight understands.
+type TonightConfig struct {
+	Moods map[string]Mood `json:"moods"`
+}
+
+type CacheConfig struct {
+	MaxEntries int      `json:"max_entries"`
+	MaxBytes   int64    `json:"max_bytes"`
+	MovieTTL   Duration `json:"movie_ttl"`
+	SearchTTL  Duration `json:"search_ttl"`
+	DiskPath   string   `json:"disk_path"`
+}
+
+type CommentsConfig struct {
+	PerUserLimit    int      `json:"per_user_limit"`
+	Window          Duration `json:"window"`
+	MaxLength       int      `json:"max_length"`
+	AutoHideReports int      `json:"auto_hide_reports"`
+}
+
+// Duration is a time.Duration that reads and writes as "1h30m" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+var (
+	currentConfig atomic.Pointer[Config]
+	logLevel      = new(slog.LevelVar)
+)
+
+func cfg() *Config {
+	return currentConfig.Load()
+}
+
+func defaultConfig() Config {
+	return Config{
+		Cache: CacheConfig{
+			MaxEntries: 10000,
+			MaxBytes:   64 << 20,
+			MovieTTL:   Duration(24 * time.Hour),
+			SearchTTL:  Duration(time.Hour),
+		},
+		Environment:          "production",
+		OMDbBaseURL:          "https://www.omdbapi.com/",
+		Genre:                defaultGenreConfig(),
+		Deepening:            defaultDeepeningConfig(),
+		Ratings:              defaultRatingsConfig(),
+		ContentFilter:        defaultContentFilterConfig(),
+		Continue:             defaultContinueConfig(),
+		Tonight:              TonightConfig{Moods: defaultMoods()},
+		Trash:                TrashConfig{Retention: Duration(30 * 24 * time.Hour)},
+		JWTKeys:              defaultJWTKeysConfig(),
+		RouteLimits:          defaultRouteLimitsConfig(),
+		LoadShedding:         defaultLoadSheddingConfig(),
+		AccountDeletionGrace: Duration(14 * 24 * time.Hour),
+		LogLevel:             "info",
+		Flags:                defaultFlags(),
+		Comments: CommentsConfig{
+			PerUserLimit:    10,
+			Window:          Duration(10 * time.Minute),
+			MaxLength:       4000,
+			AutoHideReports: 5,
+		},
+		Experiments: map[string]map[string]int{
+			"recommender": {"content": 1},
+		},
+	}
+}
+
+func loadConfig(path string) (*Config, error) {
+	c := defaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+
+	c.Cache.MaxEntries = envInt("CACHE_MAX_ENTRIES", c.Cache.MaxEntries)
+	c.Cache.MaxBytes = int64(envInt("CACHE_MAX_BYTES", int(c.Cache.MaxBytes)))
+	c.Cache.MovieTTL = Duration(envDuration("CACHE_TTL_MOVIE", time.Duration(c.Cache.MovieTTL)))
+	c.Cache.SearchTTL = Duration(envDuration("CACHE_TTL_SEARCH", time.Duration(c.Cache.SearchTTL)))
+	c.Cache.DiskPath = envString("CACHE_DISK_PATH", c.Cache.DiskPath)
+	c.LogLevel = envString("LOG_LEVEL", c.LogLevel)
+	c.Environment = envString("APP_ENV", c.Environment)
+	c.PublicBaseURL = envString("PUBLIC_BASE_URL", c.PublicBaseURL)
+	c.OMDbBaseURL = envString("OMDB_BASE_URL", c.OMDbBaseURL)
+	c.Genre.Strategy = envString("GENRE_SEED_STRATEGY", c.Genre.Strategy)
+	c.Genre.CacheTTL = Duration(envDuration("GENRE_CACHE_TTL", time.Duration(c.Genre.CacheTTL)))
+	c.AccountDeletionGrace = Duration(envDuration("ACCOUNT_DELETION_GRACE", time.Duration(c.AccountDeletionGrace)))
+	c.Trash.Retention = Duration(envDuration("TRASH_RETENTION", time.Duration(c.Trash.Retention)))
+	c.JWTKeys.RotationInterval = Duration(envDuration("JWT_ROTATION_INTERVAL", time.Duration(c.JWTKeys.RotationInterval)))
+	c.Deepening.GenreBudget = envInt("UPSTREAM_BUDGET_GENRE", c.Deepening.GenreBudget)
+	c.Deepening.RecommendationBudget = envInt("UPSTREAM_BUDGET_RECOMMENDATIONS", c.Deepening.RecommendationBudget)
+	c.LoadShedding.MaxInFlight = envInt("LOAD_SHED_MAX_IN_FLIGHT", c.LoadShedding.MaxInFlight)
+	c.LoadShedding.MaxUpstreamLatency = Duration(envDuration("LOAD_SHED_MAX_UPSTREAM_LATENCY", time.Duration(c.LoadShedding.MaxUpstreamLatency)))
+	c.Deepening.SearchEnrichBudget = envInt("UPSTREAM_BUDGET_SEARCH_ENRICH", c.Deepening.SearchEnrichBudget)
+	c.Deepening.MaxConcurrency = envInt("UPSTREAM_MAX_CONCURRENCY", c.Deepening.MaxConcurrency)
+	if v, err := strconv.ParseBool(os.Getenv("CONTENT_FILTER")); err == nil {
+		c.ContentFilter.Enabled = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv("CONTRACT_FIXTURES")); err == nil {
+		c.ContractFixtures = v
+	}
+	if url := envString("SLACK_WEBHOOK_URL", ""); url != "" {
+		c.ChatHooks = append(c.ChatHooks, ChatHook{Kind: "slack", URL: url})
+	}
+	if url := envString("DISCORD_WEBHOOK_URL", ""); url != "" {
+		c.ChatHooks = append(c.ChatHooks, ChatHook{Kind: "discord", URL: url})
+	}
+
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return nil, err
+	}
+	switch c.Genre.Strategy {
+	case seedsStopwords, seedsPopular, seedsMixed:
+	default:
+		return nil, fmt.Errorf("genre.strategy must be %s, %s or %s", seedsStopwords, seedsPopular, seedsMixed)
+	}
+	if err := compileEnrichments(c.Enrichments); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(c.OMDbBaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid omdb_base_url %q", c.OMDbBaseURL)
+	}
+	if u.Scheme == "http" {
+		slog.Warn("omdb_base_url is plain http; the API key is sent unencrypted", "url", c.OMDbBaseURL)
+	}
+	return &c, nil
+}
+
+// applyConfig swaps in c and pushes the reloadable parts into the running
+// components. The disk cache path only takes effect on restart.
+func applyConfig(c *Config) {
+	currentConfig.Store(c)
+	level, _ := parseLogLevel(c.LogLevel)
+	logLevel.Set(level)
+	if memoryCache != nil {
+		memoryCache.Resize(c.Cache.MaxEntries, c.Cache.MaxBytes)
+	}
+}
+
+func reloadConfig() (*Config, error) {
+	c, err := loadConfig(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	applyConfig(c)
+	slog.Info("configuration reloaded")
+	return c, nil
+}
+
+// watchConfig polls the config file and reloads it whenever its
+// modification time changes.
+func watchConfig(path string, every time.Duration) {
+	var last time.Time
+	if fi, err := os.Stat(path); err == nil {
+		last = fi.ModTime()
+	}
+	for range time.Tick(every) {
+		fi, err := os.Stat(path)
+		if err != nil || !fi.ModTime().After(last) {
+			continue
+		}
+		last = fi.ModTime()
+		if _, err := reloadConfig(); err != nil {
+			slog.Error("config reload failed; keeping previous config", "error", err)
+		}
+	}
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(s))); err != nil {
+		return 0, fmt.Errorf("invalid log_level %q", s)
+	}
+	return level, nil
+}
+
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(name string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContinueConfig weighs what to suggest next. A series scores
+// SeriesWeight, plus RecencyWeight halving every RecencyHalfLife since the
+// user last made progress, plus BacklogWeight scaled by how many aired
+// episodes are left (capped at ten). A watchlist title scores AgeWeight
+// scaled by how long it has waited (capped at a year) plus RatingWeight
+// scaled by its IMDb rating.
+type ContinueConfig struct {
+	// WatchlistAfter is how long a title must sit on the watchlist
+	// before it is suggested.
+	WatchlistAfter  Duration `json:"watchlist_after"`
+	SeriesWeight    float64  `json:"series_weight"`
+	RecencyWeight   float64  `json:"recency_weight"`
+	RecencyHalfLife Duration `json:"recency_half_life"`
+	BacklogWeight   float64  `json:"backlog_weight"`
+	AgeWeight       float64  `json:"age_weight"`
+	RatingWeight    float64  `json:"rating_weight"`
+}
+
+func defaultContinueConfig() ContinueConfig {
+	return ContinueConfig{
+		WatchlistAfter:  Duration(30 * 24 * time.Hour),
+		SeriesWeight:    1,
+		RecencyWeight:   2,
+		RecencyHalfLife: Duration(14 * 24 * time.Hour),
+		BacklogWeight:   0.5,
+		AgeWeight:       1,
+		RatingWeight:    1,
+	}
+}
+
+type continueItem struct {
+	Kind       string          `json:"kind"`
+	IMDBID     string          `json:"imdbID"`
+	Title      string          `json:"Title"`
+	Priority   float64         `json:"priority"`
+	Progress   *progressStatus `json:"progress,omitempty"`
+	LastActive *time.Time      `json:"last_active,omitempty"`
+	AddedAt    *time.Time      `json:"added_at,omitempty"`
+	IMDBRating string          `json:"imdbRating,omitempty"`
+}
+
+func seriesPriority(cc ContinueConfig, unwatched int, idle time.Duration) float64 {
+	score := cc.SeriesWeight + cc.BacklogWeight*float64(min(unwatched, 10))/10
+	if half := time.Duration(cc.RecencyHalfLife); half > 0 {
+		score += cc.RecencyWeight * math.Pow(0.5, idle.Hours()/half.Hours())
+	}
+	return roundTo(score, 3)
+}
+
+func watchlistPriority(cc ContinueConfig, waited time.Duration, rating float64) float64 {
+	age := min(waited.Hours()/(365*24), 1)
+	return roundTo(cc.AgeWeight*age+cc.RatingWeight*rating/10, 3)
+}
+
+// continueSeries returns the series the user has started, from recorded
+// progress or logged episode watches, that have aired episodes left.
+func continueSeries(c *gin.Context, userID string, cc ContinueConfig, now time.Time) ([]continueItem, error) {
+	lastActive := map[string]time.Time{}
+	for _, w := range userWatches(userID) {
+		if w.SeriesID != "" && w.WatchedAt.After(lastActive[w.SeriesID]) {
+			lastActive[w.SeriesID] = w.WatchedAt
+		}
+	}
+	store.ForEachPrefix(progressBucket, userID+"/", func(_ string, value []byte) error {
+		var p SeriesProgress
+		if json.Unmarshal(value, &p) == nil && p.UpdatedAt.After(lastActive[p.SeriesID]) {
+			lastActive[p.SeriesID] = p.UpdatedAt
+		}
+		return nil
+	})
+
+	items := []continueItem{}
+	for id, active := range lastActive {
+		series, seasons, err := lookupSeries(c, seriesQuery{ID: id})
+		if err != nil {
+			return nil, err
+		}
+		season, episode := watchedUpTo(userID, id)
+		p := SeriesProgress{SeriesID: id, Title: series.Title, Season: season, Episode: episode}
+		if stored, ok := loadProgress(userID, id); ok {
+			p.UpdatedAt = stored.UpdatedAt
+		}
+		st := progressOf(p, seriesEpisodes(seasons, now))
+		if st.UnwatchedAired == 0 {
+			continue
+		}
+		items = append(items, continueItem{
+			Kind:       "series",
+			IMDBID:     id,
+			Title:      series.Title,
+			Priority:   seriesPriority(cc, st.UnwatchedAired, now.Sub(active)),
+			Progress:   &st,
+			LastActive: &active,
+		})
+	}
+	return items, nil
+}
+
+// continueWatchlist returns watchlist titles added at least WatchlistAfter
+// ago that the user hasn't logged a watch of. Ratings come from the
+// catalog, so this costs no upstream calls.
+func continueWatchlist(userID string, cc ContinueConfig, now time.Time) ([]continueItem, error) {
+	l, err := userWatchlist(userID)
+	if err != nil {
+		return nil, err
+	}
+	watched := map[string]bool{}
+	for _, w := range userWatches(userID) {
+		watched[w.IMDBID] = true
+	}
+	items := []continueItem{}
+	for _, it := range l.Items {
+		waited := now.Sub(it.AddedAt)
+		if watched[it.IMDBID] || waited < time.Duration(cc.WatchlistAfter) {
+			continue
+		}
+		added := it.AddedAt
+		item := continueItem{Kind: "watchlist", IMDBID: it.IMDBID, Title: it.Title, AddedAt: &added}
+		rating := 0.0
+		if m, ok := catalog.Get(it.IMDBID); ok {
+			item.IMDBRating = m.IMDBRating
+			rating, _ = parseRating(m.IMDBRating)
+		}
+		item.Priority = watchlistPriority(cc, waited, rating)
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// getContinueWatching suggests what to watch next: started series with
+// aired episodes left, and titles that have been on the watchlist a
+// while, highest priority first.
+func getContinueWatching(c *gin.Context) {
+	var q pageQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	if q.Limit == 0 {
+		q.Limit = 20
+	}
+	u := currentUser(c)
+	cc := cfg().Continue
+	now := time.Now()
+
+	items, err := continueSeries(c, u.ID, cc, now)
+	if err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+	waiting, err := continueWatchlist(u.ID, cc, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load watchlist"})
+		return
+	}
+	seen := map[string]bool{}
+	for _, it := range items {
+		seen[it.IMDBID] = true
+	}
+	for _, it := range waiting {
+		if !seen[it.IMDBID] {
+			items = append(items, it)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Priority != items[j].Priority {
+			return items[i].Priority > items[j].Priority
+		}
+		return items[i].IMDBID < items[j].IMDBID
+	})
+	total := len(items)
+	if len(items) > q.Limit {
+		items = items[:q.Limit]
+	}
+	respondList(c, http.StatusOK, items, listMeta{Total: total}, gin.H{"items": items, "total": total})
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"movie-api/omdb"
+)
+
+const curatedListsBucket = "curated_lists"
+
+// CuratedList is a well-known list (IMDb Top 250, AFI 100, the Criterion
+// Collection, ...) imported by an admin. Unlike user lists it has a rank
+// per title and is public.
+type CuratedList struct {
+	Slug        string        `json:"slug"`
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Source      string        `json:"source,omitempty"`
+	Items       []CuratedItem `json:"items"`
+	ImportedAt  time.Time     `json:"imported_at"`
+}
+
+type CuratedItem struct {
+	Rank   int    `json:"rank"`
+	IMDBID string `json:"imdbID"`
+	Title  string `json:"Title"`
+	Year   string `json:"Year"`
+	Note   string `json:"note,omitempty"`
+}
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// curatedColumns maps the header names we recognise, including those of
+// IMDb's list export, onto CuratedItem fields.
+var curatedColumns = map[string]string{
+	"rank": "rank", "position": "rank", "#": "rank",
+	"imdbid": "imdbID", "imdb_id": "imdbID", "const": "imdbID", "tconst": "imdbID",
+	"title": "title", "name": "title",
+	"year": "year",
+	"note": "note", "description": "note",
+}
+
+// parseCuratedCSV reads a CSV with a header row. Only an IMDb ID or a
+// title column is required; rows without a rank are ranked by position.
+func parseCuratedCSV(data []byte) ([]CuratedItem, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		if field, ok := curatedColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			if _, dup := columns[field]; !dup {
+				columns[field] = i
+			}
+		}
+	}
+	_, hasID := columns["imdbID"]
+	_, hasTitle := columns["title"]
+	if !hasID && !hasTitle {
+		return nil, errors.New("CSV needs an imdbID (or Const) or a Title column")
+	}
+	cell := func(row []string, field string) string {
+		if i, ok := columns[field]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	var items []CuratedItem
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rank, _ := strconv.Atoi(cell(row, "rank"))
+		items = append(items, CuratedItem{Rank: rank, IMDBID: cell(row, "imdbID"), Title: cell(row, "title"), Year: cell(row, "year"), Note: cell(row, "note")})
+	}
+	return items, nil
+}
+
+// parseCuratedJSON accepts an array of items or an object with "items"
+// and optionally the list's name, description and source.
+func parseCuratedJSON(data []byte, into *CuratedList) ([]CuratedItem, error) {
+	var doc struct {
+		Name        string        `json:"name"`
+		Description string        `json:"description"`
+		Source      string        `json:"source"`
+		Items       []CuratedItem `json:"items"`
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err := json.Unmarshal(data, &doc.Items)
+		return doc.Items, err
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if into.Name == "" {
+		into.Name = doc.Name
+	}
+	if into.Description == "" {
+		into.Description = doc.Description
+	}
+	if into.Source == "" {
+		into.Source = doc.Source
+	}
+	return doc.Items, nil
+}
+
+// resolveCuratedItems validates the items, looks up the IMDb ID of rows
+// that only have a title, and ranks them. Rows that can't be used are
+// reported and left out.
+func resolveCuratedItems(c *gin.Context, items []CuratedItem) ([]CuratedItem, []string) {
+	var out []CuratedItem
+	var problems []string
+	seen := map[string]bool{}
+	for i, item := range items {
+		row := fmt.Sprintf("row %d", i+1)
+		if item.IMDBID == "" && item.Title != "" {
+			params := map[string]string{"t": item.Title}
+			if omdb.YearPattern.MatchString(item.Year) {
+				params["y"] = item.Year
+			}
+			m, err := fetchMovie(scopedParams(c, params))
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: could not find %q: %v", row, item.Title, err))
+				continue
+			}
+			item.IMDBID, item.Title, item.Year = m.IMDBID, m.Title, m.Year
+		}
+		if !omdb.IMDBIDPattern.MatchString(item.IMDBID) {
+			problems = append(problems, fmt.Sprintf("%s: %q is not an IMDb ID", row, item.IMDBID))
+			continue
+		}
+		if seen[item.IMDBID] {
+			problems = append(problems, fmt.Sprintf("%s: %s is listed twice", row, item.IMDBID))
+			continue
+		}
+		seen[item.IMDBID] = true
+		if item.Rank <= 0 {
+			item.Rank = len(out) + 1
+		}
+		out = append(out, item)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Rank < out[j].Rank })
+	return out, problems
+}
+
+// putCuratedList imports a list from the request body, replacing any
+// list with the same slug. The body is CSV (text/csv) or JSON; ?name=,
+// ?description= and ?source= override what a JSON body says.
+func putCuratedList(c *gin.Context) {
+	slug := c.Param("slug")
+	if !slugPattern.MatchString(slug) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "slug must be lowercase letters, digits and dashes"})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, 5<<20))
+	if err != nil || len(data) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "send the list as CSV or JSON in the request body"})
+		return
+	}
+	l := CuratedList{Slug: slug, Name: c.Query("name"), Description: c.Query("description"), Source: c.Query("source")}
+	var items []CuratedItem
+	if strings.Contains(c.ContentType(), "csv") {
+		items, err = parseCuratedCSV(data)
+	} else {
+		items, err = parseCuratedJSON(data, &l)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not parse list: " + err.Error()})
+		return
+	}
+	l.ImportedAt = time.Now().UTC()
+	var problems []string
+	l.Items, problems = resolveCuratedItems(c, items)
+	if len(l.Items) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "no usable titles in the list", "problems": problems})
+		return
+	}
+	if l.Name == "" {
+		l.Name = slug
+	}
+	if err := store.Put(curatedListsBucket, slug, l); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save list"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"slug": slug, "name": l.Name, "imported": len(l.Items), "skipped": len(problems), "problems": problems})
+}
+
+func deleteCuratedList(c *gin.Context) {
+	var l CuratedList
+	if found, _ := store.Get(curatedListsBucket, c.Param("slug"), &l); !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "curated list not found"})
+		return
+	}
+	if err := store.Delete(curatedListsBucket, c.Param("slug")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete list"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func getCuratedLists(c *gin.Context) {
+	lists := []gin.H{}
+	store.ForEach(curatedListsBucket, func(_ string, value []byte) error {
+		var l CuratedList
+		if json.Unmarshal(value, &l) == nil {
+			lists = append(lists, gin.H{
+				"slug": l.Slug, "name": l.Name, "description": l.Description,
+				"source": l.Source, "titles": len(l.Items), "imported_at": l.ImportedAt,
+			})
+		}
+		return nil
+	})
+	respondList(c, http.StatusOK, lists, listMeta{Total: len(lists)}, lists)
+}
+
+// getCuratedList serves a page of a curated list with each title's
+// details from the catalog, looking up the ones it doesn't have yet.
+func getCuratedList(c *gin.Context) {
+	var q numberedPageQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	var l CuratedList
+	if found, _ := store.Get(curatedListsBucket, c.Param("slug"), &l); !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "curated list not found"})
+		return
+	}
+	from := min((q.Page-1)*q.Limit, len(l.Items))
+	page := l.Items[from:min(from+q.Limit, len(l.Items))]
+
+	items := make([]gin.H, 0, len(page))
+	for _, item := range page {
+		entry := gin.H{"rank": item.Rank, "imdbID": item.IMDBID, "Title": item.Title, "Year": item.Year}
+		if item.Note != "" {
+			entry["note"] = item.Note
+		}
+		m, ok := catalog.Get(item.IMDBID)
+		if !ok {
+			fetched, err := fetchMovie(scopedParams(c, map[string]string{"i": item.IMDBID}))
+			if err != nil && !errors.Is(err, errRequestLimit) {
+				slog.Debug("curated list lookup failed", "list", l.Slug, "imdbID", item.IMDBID, "error", err)
+			}
+			m, ok = fetched, err == nil
+		}
+		if ok {
+			entry["Title"], entry["Year"] = m.Title, m.Year
+			entry["Genre"], entry["Director"], entry["Runtime"] = m.Genre, m.Director, m.Runtime
+			entry["Poster"], entry["imdbRating"], entry["imdbVotes"] = m.Poster, m.IMDBRating, m.IMDBVotes
+		}
+		items = append(items, entry)
+	}
+	respondList(c, http.StatusOK, items, listMeta{Total: len(l.Items), Page: q.Page}, gin.H{
+		"slug":        l.Slug,
+		"name":        l.Name,
+		"description": l.Description,
+		"source":      l.Source,
+		"imported_at": l.ImportedAt,
+		"total":       len(l.Items),
+		"page":        q.Page,
+		"items":       items,
+	})
+}
+
+// importCuratedDir imports every <slug>.csv and <slug>.json in dir at
+// startup, so a deployment can ship its curated lists as files. Titles
+// must have IMDb IDs here; there is no request to look them up under.
+func importCuratedDir(dir string) {
+	if dir == "" {
+		return
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, path := range paths {
+		ext := filepath.Ext(path)
+		slug := strings.TrimSuffix(filepath.Base(path), ext)
+		if (ext != ".csv" && ext != ".json") || !slugPattern.MatchString(slug) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("could not read curated list", "path", path, "error", err)
+			continue
+		}
+		l := CuratedList{Slug: slug, ImportedAt: time.Now().UTC()}
+		var items []CuratedItem
+		if ext == ".csv" {
+			items, err = parseCuratedCSV(data)
+		} else {
+			items, err = parseCuratedJSON(data, &l)
+		}
+		if err != nil {
+			slog.Warn("could not parse curated list", "path", path, "error", err)
+			continue
+		}
+		for i, item := range items {
+			if !omdb.IMDBIDPattern.MatchString(item.IMDBID) {
+				continue
+			}
+			if item.Rank <= 0 {
+				item.Rank = i + 1
+			}
+			l.Items = append(l.Items, item)
+		}
+		sort.SliceStable(l.Items, func(i, j int) bool { return l.Items[i].Rank < l.Items[j].Rank })
+		if l.Name == "" {
+			l.Name = slug
+		}
+		if err := store.Put(curatedListsBucket, slug, l); err != nil {
+			slog.Warn("could not save curated list", "slug", slug, "error", err)
+			continue
+		}
+		slog.Info("imported curated list", "slug", slug, "titles", len(l.Items), "skipped", len(items)-len(l.Items))
+	}
+}
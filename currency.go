@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const exchangeRatesBucket = "exchange_rates"
+
+var errNoRate = errors.New("no exchange rate")
+
+// RateProvider returns how many units of each currency one unit of base
+// buys.
+type RateProvider interface {
+	Rates(ctx context.Context, base string) (map[string]float64, error)
+}
+
+// httpRates reads {"rates": {"EUR": 0.92, ...}} from a URL with the base
+// currency substituted for {base}, which fits frankfurter.app,
+// open.er-api.com and most self-hosted rate services.
+type httpRates struct {
+	url    string
+	client *http.Client
+}
+
+func (h *httpRates) Rates(ctx context.Context, base string) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(h.url, "{base}", base), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("exchange rate provider returned %s", resp.Status)
+	}
+	var out struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if len(out.Rates) == 0 {
+		return nil, fmt.Errorf("exchange rate provider returned no rates")
+	}
+	return out.Rates, nil
+}
+
+// newRateProviderFromEnv returns nil unless EXCHANGE_RATES_URL is set,
+// which disables currency conversion.
+func newRateProviderFromEnv() RateProvider {
+	url := envString("EXCHANGE_RATES_URL", "")
+	if url == "" {
+		return nil
+	}
+	return &httpRates{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type rateTable struct {
+	Base      string             `json:"base"`
+	Rates     map[string]float64 `json:"rates"`
+	FetchedAt time.Time          `json:"fetched_at"`
+}
+
+// exchangeRates caches a provider's tables for a day, in memory and in
+// the store so a restart doesn't refetch them.
+type exchangeRates struct {
+	provider RateProvider
+	mu       sync.Mutex
+	tables   map[string]rateTable
+}
+
+var rates *exchangeRates
+
+func newExchangeRates(provider RateProvider) *exchangeRates {
+	return &exchangeRates{provider: provider, tables: map[string]rateTable{}}
+}
+
+func (er *exchangeRates) table(ctx context.Context, base string) (rateTable, error) {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	t, ok := er.tables[base]
+	if !ok {
+		if found, _ := store.Get(exchangeRatesBucket, base, &t); found {
+			er.tables[base] = t
+		}
+	}
+	if t.Rates != nil && time.Since(t.FetchedAt) < 24*time.Hour {
+		return t, nil
+	}
+	fetched, err := er.provider.Rates(ctx, base)
+	if err != nil {
+		if t.Rates != nil {
+			return t, nil // a stale table beats no conversion
+		}
+		return rateTable{}, err
+	}
+	t = rateTable{Base: base, Rates: fetched, FetchedAt: time.Now().UTC()}
+	er.tables[base] = t
+	store.Put(exchangeRatesBucket, base, t)
+	return t, nil
+}
+
+// parseBoxOffice parses OMDb's "$292,587,330", which is always US dollars.
+func parseBoxOffice(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "$") {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(strings.ReplaceAll(s[1:], ",", ""), 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+type money struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+type convertedMoney struct {
+	money
+	Rate      float64   `json:"rate"`
+	RatesAsOf time.Time `json:"rates_as_of"`
+}
+
+// convertBoxOffice converts a parsed box office figure to currency.
+func convertBoxOffice(ctx context.Context, usd float64, currency string) (*convertedMoney, error) {
+	currency = strings.ToUpper(currency)
+	if currency == "USD" {
+		return &convertedMoney{money: money{usd, "USD"}, Rate: 1, RatesAsOf: time.Now().UTC()}, nil
+	}
+	t, err := rates.table(ctx, "USD")
+	if err != nil {
+		return nil, err
+	}
+	rate, ok := t.Rates[currency]
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", errNoRate, currency)
+	}
+	return &convertedMoney{
+		money:     money{math.Round(usd*rate*100) / 100, currency},
+		Rate:      rate,
+		RatesAsOf: t.FetchedAt,
+	}, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Cursors are opaque to clients: base64url-encoded JSON of whatever
+// position a list endpoint needs to resume from. Keyset positions (the
+// last item's sort key) rather than offsets keep pages stable when items
+// are added or removed mid-scroll.
+
+var errBadCursor = errors.New("invalid cursor")
+
+func encodeCursor(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(data, v) != nil {
+		return errBadCursor
+	}
+	return nil
+}
+
+// searchCursor resumes an OMDb search. OMDb only pages by number, so the
+// last ID seen is kept too: if results shifted forward since, everything
+// up to it is skipped instead of being shown twice.
+type searchCursor struct {
+	Q     string `json:"q"`
+	Type  string `json:"t"`
+	Year  string `json:"y,omitempty"`
+	Tag   string `json:"g,omitempty"`
+	Page  int    `json:"p"`
+	Pages int    `json:"n,omitempty"`
+	After string `json:"a,omitempty"`
+}
+
+// ratingCursor resumes a list sorted by ratingKey.
+type ratingCursor struct {
+	Rating  float64 `json:"r"`
+	Unrated bool    `json:"u,omitempty"`
+	Votes   int     `json:"v,omitempty"`
+	ID      string  `json:"id"`
+}
+
+// timeCursor resumes a list sorted by time ascending, then ID.
+type timeCursor struct {
+	At time.Time `json:"at"`
+	ID string    `json:"id"`
+}
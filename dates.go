@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// releasedLayouts are the date formats OMDb uses: "14 Oct 1994" on
+// titles, "1994-10-14" on season pages.
+var releasedLayouts = []string{"02 Jan 2006", "2 Jan 2006", "2006-01-02"}
+
+// parseReleased parses an OMDb release date. ok is false for "N/A" and
+// anything else that isn't a date.
+func parseReleased(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range releasedLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// addReleaseDates adds released_iso (a plain 2006-01-02 date) to out and,
+// when loc is given, released_at (midnight of the release day in loc, as
+// RFC 3339) and released_local (a readable date with the zone).
+func addReleaseDates(out gin.H, released string, loc *time.Location) {
+	t, ok := parseReleased(released)
+	if !ok {
+		return
+	}
+	out["released_iso"] = t.Format("2006-01-02")
+	if loc != nil {
+		local := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		out["released_at"] = local.Format(time.RFC3339)
+		out["released_local"] = local.Format("Monday, 2 January 2006 MST")
+	}
+}
+
+// location loads the ?tz= zone; validation has already checked it.
+func location(tz string) *time.Location {
+	if tz == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RouteLimit bounds one request: how long it may run and how many
+// upstream calls (cache misses) it may make. Zero means no limit.
+type RouteLimit struct {
+	Timeout        Duration `json:"timeout"`
+	UpstreamBudget int      `json:"upstream_budget"`
+}
+
+// RouteLimitsConfig sets limits per route pattern as registered, e.g.
+// "/api/lists/:id"; routes not listed get Default.
+type RouteLimitsConfig struct {
+	Default RouteLimit            `json:"default"`
+	Routes  map[string]RouteLimit `json:"routes"`
+}
+
+func defaultRouteLimitsConfig() RouteLimitsConfig {
+	return RouteLimitsConfig{
+		Routes: map[string]RouteLimit{
+			"/api/movie":                  {Timeout: Duration(2 * time.Second)},
+			"/api/episode":                {Timeout: Duration(2 * time.Second)},
+			"/api/search":                 {Timeout: Duration(3 * time.Second)},
+			"/api/search/all":             {Timeout: Duration(5 * time.Second)},
+			"/api/movies/genre":           {Timeout: Duration(30 * time.Second)},
+			"/api/movies/recommendations": {Timeout: Duration(30 * time.Second)},
+			"/api/watchlist/tonight":      {Timeout: Duration(10 * time.Second), UpstreamBudget: 50},
+		},
+	}
+}
+
+// errRequestLimit is returned for lookups the request no longer has time
+// or budget for. List endpoints stop there and answer with what they
+// have, marked truncated.
+var errRequestLimit = &upstreamError{http.StatusGatewayTimeout, "request_limit_reached", "the request ran out of time or upstream budget"}
+
+// limitRoute applies the route's limits to the request's cost tracker,
+// and its deadline to the request context for work that isn't an OMDb
+// lookup.
+func limitRoute(c *gin.Context) {
+	limits := cfg().RouteLimits
+	limit, ok := limits.Routes[c.FullPath()]
+	if !ok {
+		limit = limits.Default
+	}
+	cost := costOf(c)
+	if cost == nil {
+		c.Next()
+		return
+	}
+	cost.budget = limit.UpstreamBudget
+	if limit.Timeout > 0 {
+		cost.deadline = time.Now().Add(time.Duration(limit.Timeout))
+		ctx, cancel := context.WithDeadline(c.Request.Context(), cost.deadline)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+	}
+	c.Next()
+}
+
+// admit reports whether the request may make another upstream call, and
+// marks it truncated if not.
+func (rc *requestCost) admit() error {
+	if rc == nil {
+		return nil
+	}
+	if (rc.budget > 0 && rc.upstreamCalls.Load() >= int64(rc.budget)) ||
+		(!rc.deadline.IsZero() && time.Now().After(rc.deadline)) {
+		rc.truncated.Store(true)
+		return errRequestLimit
+	}
+	return nil
+}
+
+// lookup calls the provider, giving up when the request's deadline
+// passes. An abandoned lookup still finishes and fills the cache, so
+// asking again is likely to be quick.
+func (rc *requestCost) lookup(key string, params map[string]string) ([]byte, error) {
+	if rc == nil || rc.deadline.IsZero() {
+		return provider.Lookup(params)
+	}
+	type result struct {
+		body []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		body, err := provider.Lookup(params)
+		done <- result{body, err}
+	}()
+	timer := time.NewTimer(time.Until(rc.deadline))
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.body, r.err
+	case <-timer.C:
+		rc.truncated.Store(true)
+		go func() {
+			r := <-done
+			var found struct{ Response string }
+			if r.err == nil && json.Unmarshal(r.body, &found) == nil && found.Response == "True" {
+				upstreamCache.Set(key, r.body, cacheTTL(params))
+			}
+		}()
+		return nil, errRequestLimit
+	}
+}
+
+func (rc *requestCost) isTruncated() bool {
+	return rc != nil && rc.truncated.Load()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// debugTrace collects what went into a response when an admin asks for
+// ?debug=true: the upstream calls, the cache's answers, the filters that
+// dropped titles and where the time went.
+type debugTrace struct {
+	mu      sync.Mutex
+	calls   []upstreamRecord
+	cache   []cacheDecision
+	filters []*filterTrace
+	phases  []phaseTiming
+}
+
+type cacheDecision struct {
+	Key string `json:"key"`
+	// Decision is "hit", "miss", "dry_run", "fixture" or "refused" (over
+	// the request's budget or deadline), or how a computed list was served.
+	Decision   string `json:"decision"`
+	AgeSeconds int    `json:"age_seconds,omitempty"`
+}
+
+type filterTrace struct {
+	Name    string      `json:"name"`
+	Params  interface{} `json:"params,omitempty"`
+	Checked int         `json:"checked"`
+	Removed int         `json:"removed"`
+}
+
+type phaseTiming struct {
+	Name string  `json:"name"`
+	Ms   float64 `json:"ms"`
+}
+
+// trace is the request's debug trace, or nil when it isn't debugged.
+func (rc *requestCost) trace() *debugTrace {
+	if rc == nil {
+		return nil
+	}
+	return rc.debug
+}
+
+func (t *debugTrace) upstream(r upstreamRecord) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls = append(t.calls, r)
+}
+
+func (t *debugTrace) cached(key, decision string, age time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cache = append(t.cache, cacheDecision{Key: key, Decision: decision, AgeSeconds: int(age.Seconds())})
+}
+
+// filtered counts a title a filter checked, and whether it kept it.
+func (t *debugTrace) filtered(name string, params interface{}, kept bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var f *filterTrace
+	for _, existing := range t.filters {
+		if existing.Name == name {
+			f = existing
+			break
+		}
+	}
+	if f == nil {
+		f = &filterTrace{Name: name, Params: params}
+		t.filters = append(t.filters, f)
+	}
+	f.Checked++
+	if !kept {
+		f.Removed++
+	}
+}
+
+// narrowed records a step that cut a list from before to after items.
+func (t *debugTrace) narrowed(name string, params interface{}, before, after int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.filters = append(t.filters, &filterTrace{Name: name, Params: params, Checked: before, Removed: before - after})
+}
+
+func (t *debugTrace) phase(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.phases = append(t.phases, phaseTiming{Name: name, Ms: roundTo(float64(d)/float64(time.Millisecond), 2)})
+}
+
+// timed returns a func that records the time since as phase name.
+func (t *debugTrace) timed(name string) func() {
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { t.phase(name, time.Since(start)) }
+}
+
+func (t *debugTrace) report() gin.H {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var upstreamMs int64
+	for _, r := range t.calls {
+		upstreamMs += r.LatencyMs
+	}
+	return gin.H{
+		"upstream_calls": append([]upstreamRecord{}, t.calls...),
+		"upstream_ms":    upstreamMs,
+		"cache":          append([]cacheDecision{}, t.cache...),
+		"filters":        append([]*filterTrace{}, t.filters...),
+		"timing":         append([]phaseTiming{}, t.phases...),
+	}
+}
+
+// debugEcho answers ?debug=true from admins with the request's trace in
+// the response: under meta in an envelope, at the top level of other
+// objects, and alongside the data of bare lists. It runs last, so the
+// time up to it is what the middleware took.
+func debugEcho(c *gin.Context) {
+	if c.Query("debug") != "true" {
+		c.Next()
+		return
+	}
+	if currentPrincipal(c).Role != roleAdmin {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "debug=true is for admins"})
+		return
+	}
+	cost := costOf(c)
+	if cost == nil {
+		c.Next()
+		return
+	}
+	trace := &debugTrace{}
+	cost.debug = trace
+	handlerStart := time.Now()
+	trace.phase("middleware", handlerStart.Sub(cost.start))
+
+	w := &heldJSONWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+	trace.phase("handler", time.Since(handlerStart))
+	trace.phase("total", time.Since(cost.start))
+	if w.buf.Len() == 0 {
+		return
+	}
+	payload, ok := w.payload()
+	if !ok {
+		w.ResponseWriter.Write(w.buf.Bytes())
+		return
+	}
+	report := trace.report()
+	switch p := payload.(type) {
+	case map[string]interface{}:
+		if meta, ok := p["meta"].(map[string]interface{}); ok {
+			meta["debug"] = report
+		} else {
+			p["debug"] = report
+		}
+	default:
+		payload = gin.H{"data": p, "debug": report}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		body = w.buf.Bytes()
+	}
+	w.ResponseWriter.Write(body)
+}
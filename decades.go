@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+var decadePattern = regexp.MustCompile(`^\d{3}0s$`)
+
+// decadeTitle is one entry of a decade list.
+type decadeTitle struct {
+	Title      string `json:"Title"`
+	Year       string `json:"Year"`
+	Type       string `json:"Type"`
+	Genre      string `json:"Genre"`
+	IMDBRating string `json:"imdbRating"`
+	IMDBVotes  string `json:"imdbVotes"`
+	// WeightedRating is the Bayesian estimate lists sort by by default.
+	WeightedRating float64 `json:"weighted_rating"`
+	IMDBID         string  `json:"imdbID"`
+	criticFields
+}
+
+func (t decadeTitle) key(by string) ratingKey {
+	return sortKeyOf(by, t.IMDBRating, t.IMDBVotes, t.IMDBID)
+}
+
+// startYear reads the first year of OMDb's "1999" or "2010–2015".
+func startYear(year string) int {
+	if len(year) < 4 {
+		return 0
+	}
+	n, _ := strconv.Atoi(year[:4])
+	return n
+}
+
+// getMoviesByDecade lists the best rated titles of a decade from the title
+// catalog, so browsing costs no upstream calls. Unrated titles are left
+// out. ?sort=rating orders by the raw IMDb rating instead of the weighted
+// one.
+func getMoviesByDecade(c *gin.Context) {
+	var q decadeQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	from, _ := strconv.Atoi(q.Decade[:4])
+
+	found := catalog.All(func(m *MovieResponse) bool {
+		if y := startYear(m.Year); y < from || y >= from+10 {
+			return false
+		}
+		if _, rated := parseRating(m.IMDBRating); !rated {
+			return false
+		}
+		return m.Type == q.Type && (q.Genre == "" || containsFold(m.Genre, q.Genre)) && q.titleFilter.matches(m)
+	})
+	titles := make([]decadeTitle, 0, len(found))
+	for _, m := range found {
+		r, _ := parseRating(m.IMDBRating)
+		titles = append(titles, decadeTitle{
+			Title:          m.Title,
+			Year:           m.Year,
+			Type:           m.Type,
+			Genre:          m.Genre,
+			IMDBRating:     m.IMDBRating,
+			IMDBVotes:      m.IMDBVotes,
+			WeightedRating: weightedRating(r, parseVotes(m.IMDBVotes)),
+			IMDBID:         m.IMDBID,
+			criticFields:   criticFieldsOf(m),
+		})
+	}
+	sort.Slice(titles, func(i, j int) bool { return titles[i].key(q.Sort).before(titles[j].key(q.Sort)) })
+	costOf(c).fromDataset()
+
+	total := len(titles)
+	if q.Cursor != "" {
+		var cur ratingCursor
+		if err := decodeCursor(q.Cursor, &cur); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		after := ratingKey{Rating: cur.Rating, Rated: !cur.Unrated, Votes: cur.Votes, ID: cur.ID}
+		i := sort.Search(len(titles), func(i int) bool { return after.before(titles[i].key(q.Sort)) })
+		titles = titles[i:]
+	}
+	meta := listMeta{Total: total}
+	resp := gin.H{"decade": q.Decade, "items": titles}
+	if len(titles) > q.Limit {
+		k := titles[q.Limit-1].key(q.Sort)
+		titles = titles[:q.Limit]
+		meta.NextCursor = encodeCursor(ratingCursor{Rating: k.Rating, Votes: k.Votes, ID: k.ID})
+		resp["items"], resp["next_cursor"] = titles, meta.NextCursor
+	}
+	respondList(c, http.StatusOK, titles, meta, resp)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeepeningConfig bounds how far list endpoints widen their search when
+// the first pass finds too few titles.
+type DeepeningConfig struct {
+	// MinResults is how many titles a list tries to reach.
+	MinResults int `json:"min_results"`
+	// MaxPages is the deepest search page a widened search goes to.
+	MaxPages int `json:"max_pages"`
+	// The budgets cap upstream calls (cache misses) per computed list;
+	// 0 means no cap.
+	GenreBudget          int `json:"genre_budget"`
+	RecommendationBudget int `json:"recommendation_budget"`
+	// SearchEnrichBudget caps the detail lookups of /api/search?enrich=true.
+	SearchEnrichBudget int `json:"search_enrich_budget"`
+	// MaxConcurrency caps the title lookups a genre or recommendation list
+	// runs at once; below it the number adapts to how OMDb copes.
+	MaxConcurrency int `json:"max_concurrency"`
+}
+
+func defaultDeepeningConfig() DeepeningConfig {
+	return DeepeningConfig{
+		MinResults:           15,
+		MaxPages:             10,
+		GenreBudget:          2000,
+		RecommendationBudget: 300,
+		SearchEnrichBudget:   5,
+		MaxConcurrency:       16,
+	}
+}
+
+var errBudgetSpent = errors.New("upstream call budget spent")
+
+// deepening runs a list search in stages and accounts the upstream calls
+// each stage made against a budget. It is reported back to the client as
+// is.
+type deepening struct {
+	Budget          int              `json:"budget"`
+	Spent           int              `json:"spent"`
+	BudgetExhausted bool             `json:"budget_exhausted"`
+	Stages          []deepeningStage `json:"stages"`
+	// Warnings are the lookups that failed and were skipped; they go in
+	// the envelope rather than here.
+	Warnings []lookupWarning `json:"-"`
+
+	c *gin.Context
+}
+
+// lookupWarning describes a failed upstream lookup a list went on
+// without.
+type lookupWarning struct {
+	Lookup string `json:"lookup"`
+	Code   string `json:"code"`
+	Error  string `json:"error"`
+}
+
+// maxWarnings caps the warnings kept per list; the list is marked
+// partial either way.
+const maxWarnings = 20
+
+func (d *deepening) warn(params map[string]string, err error) {
+	if len(d.Warnings) >= maxWarnings {
+		return
+	}
+	d.Warnings = append(d.Warnings, newLookupWarning(params, err))
+}
+
+func newLookupWarning(params map[string]string, err error) lookupWarning {
+	lookup := "title " + params["i"]
+	if s, ok := params["s"]; ok {
+		lookup = fmt.Sprintf("search %q page %s", s, params["page"])
+	}
+	code := "upstream_error"
+	var ue *upstreamError
+	if errors.As(err, &ue) {
+		code = ue.Code
+	}
+	return lookupWarning{Lookup: lookup, Code: code, Error: err.Error()}
+}
+
+type deepeningStage struct {
+	Stage         string `json:"stage"`
+	UpstreamCalls int    `json:"upstream_calls"`
+	Found         int    `json:"found"`
+}
+
+func newDeepening(c *gin.Context, budget int) *deepening {
+	return &deepening{Budget: budget, Stages: []deepeningStage{}, c: c}
+}
+
+// stage starts a new stage; calls and finds are charged to it.
+func (d *deepening) stage(name string) {
+	d.Stages = append(d.Stages, deepeningStage{Stage: name})
+}
+
+// matches applies the list's filter to m, counting it in the request's
+// debug trace.
+func (d *deepening) matches(filter titleFilter, m *MovieResponse) bool {
+	ok := filter.matches(m)
+	if d.c != nil {
+		costOf(d.c).trace().filtered("title_filter", filter.params(), ok)
+	}
+	return ok
+}
+
+func (d *deepening) found() {
+	d.Stages[len(d.Stages)-1].Found++
+}
+
+func (d *deepening) exhausted() bool {
+	return d.Budget > 0 && d.Spent >= d.Budget
+}
+
+// charge runs fetch and records the upstream calls it made. Cached
+// lookups are free.
+func (d *deepening) charge(fetch func(params map[string]string) error, params map[string]string) error {
+	if d.exhausted() {
+		d.BudgetExhausted = true
+		return errBudgetSpent
+	}
+	params = scopedParams(d.c, params)
+	cost := costFor(params)
+	var before int64
+	if cost != nil {
+		before = cost.upstreamCalls.Load()
+	}
+	err := fetch(params)
+	calls := 1
+	if cost != nil {
+		calls = int(cost.upstreamCalls.Load() - before)
+	}
+	d.Spent += calls
+	d.Stages[len(d.Stages)-1].UpstreamCalls += calls
+	if errors.Is(err, errRequestLimit) {
+		d.BudgetExhausted = true
+		return errBudgetSpent
+	}
+	if err != nil && !isNotFound(err) {
+		d.warn(params, err)
+	}
+	return err
+}
+
+func (d *deepening) search(query string, page int) (*SearchResults, error) {
+	var results *SearchResults
+	err := d.charge(func(params map[string]string) (err error) {
+		results, err = fetchSearch(params)
+		return err
+	}, searchParams(query, page))
+	return results, err
+}
+
+func (d *deepening) movie(id string) (*MovieResponse, error) {
+	var movie *MovieResponse
+	err := d.charge(func(params map[string]string) (err error) {
+		movie, err = fetchMovie(params)
+		return err
+	}, map[string]string{"i": id})
+	return movie, err
+}
+
+// movies looks up ids together, as many at a time as pool allows, and
+// returns each title or its error in order. Cached titles are free and
+// don't wait for the pool; the others are charged to the budget, and
+// those past it fail with errBudgetSpent without being looked up.
+func (d *deepening) movies(pool *adaptiveLimit, ids []string) ([]*MovieResponse, []error) {
+	movies := make([]*MovieResponse, len(ids))
+	errs := make([]error, len(ids))
+	params := make([]map[string]string, len(ids))
+	cost := costOf(d.c)
+	var before int64
+	if cost != nil {
+		before = cost.upstreamCalls.Load()
+	}
+	uncached := 0
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		params[i] = scopedParams(d.c, map[string]string{"i": id})
+		_, cached := upstreamCache.Peek(cacheKey(params[i]))
+		if !cached {
+			if d.Budget > 0 && d.Spent+uncached >= d.Budget {
+				d.BudgetExhausted = true
+				errs[i] = errBudgetSpent
+				continue
+			}
+			uncached++
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !cached {
+				pool.Acquire()
+				start := time.Now()
+				defer func() { pool.Release(time.Since(start), errs[i]) }()
+			}
+			movies[i], errs[i] = fetchMovie(params[i])
+		}()
+	}
+	wg.Wait()
+
+	calls := uncached
+	if cost != nil {
+		calls = int(cost.upstreamCalls.Load() - before)
+	}
+	d.Spent += calls
+	d.Stages[len(d.Stages)-1].UpstreamCalls += calls
+	for i, err := range errs {
+		switch {
+		case errors.Is(err, errRequestLimit):
+			d.BudgetExhausted = true
+			errs[i] = errBudgetSpent
+		case err != nil && !isNotFound(err) && !errors.Is(err, errBudgetSpent):
+			d.warn(params[i], err)
+		}
+	}
+	return movies, errs
+}
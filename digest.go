@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const digestInterval = 7 * 24 * time.Hour
+
+type digestPreference struct {
+	Enabled      bool       `json:"enabled"`
+	LastSentAt   *time.Time `json:"last_sent_at,omitempty"`
+	Subscribable bool       `json:"email_configured"`
+}
+
+// digestContent is what goes into one weekly email.
+type digestContent struct {
+	Recommendations []*MovieResponse
+	Upcoming        []upcomingRelease
+}
+
+type upcomingRelease struct {
+	IMDBID   string
+	Title    string
+	Released time.Time
+}
+
+// buildDigest picks recommendations from the local catalog (no upstream
+// cost) in the user's favorite genres, and watchlist titles releasing in
+// the next four weeks.
+func buildDigest(u *User, now time.Time) digestContent {
+	var d digestContent
+	watches := userWatches(u.ID)
+	seen := map[string]bool{}
+	for _, w := range watches {
+		seen[w.IMDBID] = true
+	}
+
+	if watchlist, err := userWatchlist(u.ID); err == nil {
+		for _, item := range watchlist.Items {
+			seen[item.IMDBID] = true
+			m, ok := catalog.Get(item.IMDBID)
+			if !ok {
+				continue
+			}
+			released, ok := parseReleased(m.Released)
+			if ok && released.After(now) && released.Before(now.Add(28*24*time.Hour)) {
+				d.Upcoming = append(d.Upcoming, upcomingRelease{IMDBID: m.IMDBID, Title: m.Title, Released: released})
+			}
+		}
+	}
+	sort.Slice(d.Upcoming, func(i, j int) bool { return d.Upcoming[i].Released.Before(d.Upcoming[j].Released) })
+
+	profile := buildTasteProfile(watches, 3)
+	if len(profile.Genres) == 0 {
+		return d
+	}
+	candidates := catalog.All(func(m *MovieResponse) bool {
+		if seen[m.IMDBID] || m.Type == "episode" {
+			return false
+		}
+		for _, g := range profile.Genres {
+			if containsFold(m.Genre, g.Name) {
+				return true
+			}
+		}
+		return false
+	})
+	sort.Slice(candidates, func(i, j int) bool {
+		ki := ratingKeyOf(candidates[i].IMDBRating, candidates[i].IMDBVotes, candidates[i].IMDBID)
+		kj := ratingKeyOf(candidates[j].IMDBRating, candidates[j].IMDBVotes, candidates[j].IMDBID)
+		return ki.before(kj)
+	})
+	if len(candidates) > 5 {
+		candidates = candidates[:5]
+	}
+	d.Recommendations = candidates
+	return d
+}
+
+func (d digestContent) empty() bool {
+	return len(d.Recommendations) == 0 && len(d.Upcoming) == 0
+}
+
+func (d digestContent) text(unsubscribeURL string) string {
+	var b strings.Builder
+	if len(d.Recommendations) > 0 {
+		b.WriteString("Picked for you this week:\n")
+		for _, m := range d.Recommendations {
+			fmt.Fprintf(&b, "  - %s (%s), IMDb %s — %s\n", m.Title, m.Year, m.IMDBRating, m.Genre)
+		}
+		b.WriteString("\n")
+	}
+	if len(d.Upcoming) > 0 {
+		b.WriteString("Coming soon from your watchlist:\n")
+		for _, r := range d.Upcoming {
+			fmt.Fprintf(&b, "  - %s, out %s\n", r.Title, r.Released.Format("Mon 2 Jan"))
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "Unsubscribe: %s\n", unsubscribeURL)
+	return b.String()
+}
+
+// unsubscribeToken lets an emailed link unsubscribe without logging in.
+func unsubscribeToken(userID string) string {
+	return unsubscribeTokenWith(jwtSigningKey(), userID)
+}
+
+func unsubscribeTokenWith(key []byte, userID string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("digest-unsubscribe:" + userID))
+	return userID + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifyUnsubscribeToken(token string) (string, bool) {
+	userID, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", false
+	}
+	for _, key := range jwtVerificationKeys() {
+		if hmac.Equal([]byte(token), []byte(unsubscribeTokenWith(key, userID))) {
+			return userID, true
+		}
+	}
+	return userID, false
+}
+
+func sendDigests(every time.Duration) {
+	for range time.Tick(every) {
+		leaderOnly("digests", func() { sendDueDigests(time.Now().UTC()) })
+	}
+}
+
+func sendDueDigests(now time.Time) {
+	if mailer == nil {
+		return
+	}
+	var due []*User
+	store.ForEach(usersBucket, func(_ string, value []byte) error {
+		var u User
+		if json.Unmarshal(value, &u) == nil && u.DigestEnabled && now.Sub(u.DigestSentAt) >= digestInterval {
+			due = append(due, &u)
+		}
+		return nil
+	})
+
+	base := strings.TrimRight(cfg().PublicBaseURL, "/")
+	for _, u := range due {
+		d := buildDigest(u, now)
+		if !d.empty() {
+			link := base + "/api/digest/unsubscribe?token=" + url.QueryEscape(unsubscribeToken(u.ID))
+			err := mailer.Send(u.Email, "Your weekly movie digest", d.text(link), map[string]string{
+				"List-Unsubscribe": "<" + link + ">",
+			})
+			if err != nil {
+				slog.Warn("digest email failed", "user", u.ID, "error", err)
+				continue
+			}
+		}
+		var fresh User
+		store.Modify(usersBucket, u.ID, &fresh, func() error {
+			fresh.DigestSentAt = now
+			return nil
+		})
+	}
+}
+
+func digestPreferenceOf(u *User) digestPreference {
+	p := digestPreference{Enabled: u.DigestEnabled, Subscribable: mailer != nil}
+	if !u.DigestSentAt.IsZero() {
+		p.LastSentAt = &u.DigestSentAt
+	}
+	return p
+}
+
+func getDigestPreference(c *gin.Context) {
+	c.JSON(http.StatusOK, digestPreferenceOf(currentUser(c)))
+}
+
+func putDigestPreference(c *gin.Context) {
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide {"enabled": true|false}`})
+		return
+	}
+	u := currentUser(c)
+	u.DigestEnabled = req.Enabled
+	if err := store.Put(usersBucket, u.ID, u); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save preference"})
+		return
+	}
+	c.JSON(http.StatusOK, digestPreferenceOf(u))
+}
+
+// getDigestUnsubscribe is linked from every digest, so it's a GET that
+// works without authentication.
+func getDigestUnsubscribe(c *gin.Context) {
+	userID, ok := verifyUnsubscribeToken(c.Query("token"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid unsubscribe link"})
+		return
+	}
+	var u User
+	found, err := store.Modify(usersBucket, userID, &u, func() error {
+		u.DigestEnabled = false
+		return nil
+	})
+	if err != nil || !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unsubscribed": true})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var diskCacheBucket = []byte("responses")
+
+// diskCache persists upstream responses in a bbolt file so a restart doesn't
+// throw away everything we've already paid quota for. Each value is stored
+// as an 8-byte expiry (unix nanos) followed by the raw body.
+type diskCache struct {
+	db *bolt.DB
+}
+
+func openDiskCache(path string) (*diskCache, error) {
+	db, err := openBoltFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(diskCacheBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &diskCache{db: db}, nil
+}
+
+func (d *diskCache) Get(key string) ([]byte, bool) {
+	var body []byte
+	expired := false
+	d.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(diskCacheBucket).Get([]byte(key))
+		if len(v) < 8 {
+			return nil
+		}
+		expires := time.Unix(0, int64(binary.BigEndian.Uint64(v[:8])))
+		if time.Now().After(expires) {
+			expired = true
+			return nil
+		}
+		body = append([]byte(nil), v[8:]...)
+		return nil
+	})
+	if expired {
+		d.Delete(key)
+	}
+	return body, body != nil
+}
+
+func (d *diskCache) Peek(key string) ([]byte, bool) {
+	var body []byte
+	d.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(diskCacheBucket).Get([]byte(key))
+		if len(v) < 8 || time.Now().After(time.Unix(0, int64(binary.BigEndian.Uint64(v[:8])))) {
+			return nil
+		}
+		body = append([]byte(nil), v[8:]...)
+		return nil
+	})
+	return body, body != nil
+}
+
+// TTL returns how long the entry for key has left to live, or zero if the
+// key is missing or already expired.
+func (d *diskCache) TTL(key string) time.Duration {
+	var ttl time.Duration
+	d.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(diskCacheBucket).Get([]byte(key))
+		if len(v) >= 8 {
+			ttl = time.Until(time.Unix(0, int64(binary.BigEndian.Uint64(v[:8]))))
+		}
+		return nil
+	})
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+func (d *diskCache) Set(key string, value []byte, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	buf := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(buf[:8], uint64(time.Now().Add(ttl).UnixNano()))
+	copy(buf[8:], value)
+	d.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(diskCacheBucket).Put([]byte(key), buf)
+	})
+}
+
+func (d *diskCache) Delete(key string) {
+	d.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(diskCacheBucket).Delete([]byte(key))
+	})
+}
+
+func (d *diskCache) Purge() {
+	d.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(diskCacheBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(diskCacheBucket)
+		return err
+	})
+}
+
+// Compact drops expired entries; run periodically so the file doesn't grow
+// without bound.
+func (d *diskCache) Compact() int {
+	removed := 0
+	now := time.Now()
+	d.db.Update(func(tx *bolt.Tx) error {
+		// Deleting under a cursor skips the key after each one deleted, so
+		// collect the expired keys first.
+		b := tx.Bucket(diskCacheBucket)
+		var expired [][]byte
+		b.ForEach(func(k, v []byte) error {
+			if len(v) < 8 || now.After(time.Unix(0, int64(binary.BigEndian.Uint64(v[:8])))) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed
+}
+
+func compactDiskCache(d *diskCache, every time.Duration) {
+	for range time.Tick(every) {
+		d.Compact()
+	}
+}
+
+func (d *diskCache) Close() error {
+	return d.db.Close()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDiskCacheCompact(t *testing.T) {
+	d, err := openDiskCache(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for i := range 100 {
+		ttl := time.Millisecond
+		if i%10 == 0 {
+			ttl = time.Hour
+		}
+		d.Set("k"+strconv.Itoa(i), []byte("body"), ttl)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if n := d.Compact(); n != 90 {
+		t.Errorf("Compact removed %d entries, want the 90 expired ones", n)
+	}
+	for i := range 100 {
+		if _, ok := d.Peek("k" + strconv.Itoa(i)); ok != (i%10 == 0) {
+			t.Errorf("k%d: kept %v after Compact", i, ok)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const embeddingsBucket = "embeddings"
+
+// Embedder turns texts into vectors. Implementations must return one vector
+// per input, in order.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// openAIEmbedder talks to the OpenAI embeddings API or anything that speaks
+// the same protocol (Ollama, llama.cpp server, LocalAI, ...).
+type openAIEmbedder struct {
+	url    string
+	apiKey string
+	model  string
+	client *http.Client
+}
+
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body, _ := json.Marshal(map[string]interface{}{"model": e.model, "input": texts})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embeddings provider returned %s", resp.Status)
+	}
+
+	var out struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if len(out.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings provider returned %d vectors for %d inputs", len(out.Data), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, errors.New("embeddings provider returned an out-of-range index")
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+// newEmbedderFromEnv returns nil when no provider is configured, which
+// disables the similarity endpoint.
+func newEmbedderFromEnv() Embedder {
+	switch envString("EMBEDDINGS_PROVIDER", "") {
+	case "openai":
+		return &openAIEmbedder{
+			url:    envString("EMBEDDINGS_URL", "https://api.openai.com/v1/embeddings"),
+			apiKey: envString("EMBEDDINGS_API_KEY", envString("OPENAI_API_KEY", "")),
+			model:  envString("EMBEDDINGS_MODEL", "text-embedding-3-small"),
+			client: &http.Client{Timeout: 30 * time.Second},
+		}
+	case "local":
+		return &openAIEmbedder{
+			url:    envString("EMBEDDINGS_URL", "http://localhost:11434/v1/embeddings"),
+			model:  envString("EMBEDDINGS_MODEL", "nomic-embed-text"),
+			client: &http.Client{Timeout: 30 * time.Second},
+		}
+	}
+	return nil
+}
+
+type plotEmbedding struct {
+	Title  string    `json:"title"`
+	Year   string    `json:"year"`
+	Vector []float32 `json:"vector"`
+}
+
+// plotIndex keeps plot embeddings of every title we've fetched, in memory
+// for scoring and in the store so they survive restarts. Titles are
+// queued as they're fetched and embedded in batches in the background.
+type plotIndex struct {
+	embedder Embedder
+	mu       sync.RWMutex
+	vectors  map[string]plotEmbedding
+	queue    chan *MovieResponse
+}
+
+var plots *plotIndex
+
+func newPlotIndex(embedder Embedder) *plotIndex {
+	idx := &plotIndex{
+		embedder: embedder,
+		vectors:  map[string]plotEmbedding{},
+		queue:    make(chan *MovieResponse, 1024),
+	}
+	store.ForEach(embeddingsBucket, func(key string, value []byte) error {
+		var e plotEmbedding
+		if json.Unmarshal(value, &e) == nil {
+			idx.vectors[key] = e
+		}
+		return nil
+	})
+	go idx.run(32, 2*time.Second)
+	return idx
+}
+
+// Enqueue schedules movie for embedding if we don't have it yet. It never
+// blocks the caller; if the queue is full the title is picked up the next
+// time it's fetched.
+func (idx *plotIndex) Enqueue(movie *MovieResponse) {
+	if idx == nil || movie.Plot == "" || movie.Plot == "N/A" || idx.has(movie.IMDBID) {
+		return
+	}
+	select {
+	case idx.queue <- movie:
+	default:
+	}
+}
+
+func (idx *plotIndex) has(id string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	_, ok := idx.vectors[id]
+	return ok
+}
+
+func (idx *plotIndex) run(batchSize int, flushEvery time.Duration) {
+	batch := []*MovieResponse{}
+	ticker := time.NewTicker(flushEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case m := <-idx.queue:
+			batch = append(batch, m)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := idx.embed(context.Background(), batch); err != nil {
+			slog.Warn("plot embedding failed", "titles", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+}
+
+func (idx *plotIndex) embed(ctx context.Context, movies []*MovieResponse) error {
+	texts := make([]string, len(movies))
+	for i, m := range movies {
+		texts[i] = m.Plot
+	}
+	vectors, err := idx.embedder.Embed(ctx, texts)
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for i, m := range movies {
+		e := plotEmbedding{Title: m.Title, Year: m.Year, Vector: vectors[i]}
+		idx.vectors[m.IMDBID] = e
+		if err := store.Put(embeddingsBucket, m.IMDBID, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type similarTitle struct {
+	IMDBID     string  `json:"imdbID"`
+	Title      string  `json:"Title"`
+	Year       string  `json:"Year"`
+	Similarity float64 `json:"similarity"`
+}
+
+func (idx *plotIndex) Similar(id string, limit int) []similarTitle {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	target, ok := idx.vectors[id]
+	if !ok {
+		return nil
+	}
+	results := []similarTitle{}
+	for otherID, e := range idx.vectors {
+		if otherID == id {
+			continue
+		}
+		results = append(results, similarTitle{
+			IMDBID:     otherID,
+			Title:      e.Title,
+			Year:       e.Year,
+			Similarity: cosine(target.Vector, e.Vector),
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Similarity > results[j].Similarity
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+func getSimilarMovies(c *gin.Context) {
+	if plots == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "plot similarity is disabled; set EMBEDDINGS_PROVIDER"})
+		return
+	}
+	var q idQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	id, limit := q.ID, q.Limit
+
+	if !plots.has(id) {
+		movie, err := fetchMovie(scopedParams(c, map[string]string{"i": id}))
+		if err != nil {
+			respondUpstreamError(c, err)
+			return
+		}
+		if err := plots.embed(c.Request.Context(), []*MovieResponse{movie}); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "embedding failed: " + err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"imdbID":  id,
+		"indexed": plots.Len(),
+		"similar": plots.Similar(id, limit),
+	})
+}
+
+func (idx *plotIndex) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.vectors)
+}
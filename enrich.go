@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/gin-gonic/gin"
+)
+
+// Enrichment adds a computed field to every title in a response. Expr is
+// an expr-lang expression over enrichmentEnv, for example
+//
+//	{"field": "is_oscar_winner", "expr": "Awards matches '^Won \\d+ Oscars?'"}
+//	{"field": "date_night_score", "expr": "Rating * 10 - (Runtime > 120 ? 15 : 0)"}
+//
+// Expressions are compiled when the config loads, so a typo rejects the
+// config instead of failing requests.
+type Enrichment struct {
+	Field string `json:"field"`
+	Expr  string `json:"expr"`
+	// Routes limits the enrichment to these route patterns; none means
+	// every route.
+	Routes []string `json:"routes,omitempty"`
+
+	program *vm.Program
+}
+
+// enrichmentEnv is what an expression sees: the title's OMDb fields with
+// numbers parsed. Missing numbers are 0.
+type enrichmentEnv struct {
+	IMDBID         string   `expr:"imdbID"`
+	Title          string   `expr:"Title"`
+	Type           string   `expr:"Type"`
+	Year           int      `expr:"Year"`
+	Rated          string   `expr:"Rated"`
+	Plot           string   `expr:"Plot"`
+	Awards         string   `expr:"Awards"`
+	Director       string   `expr:"Director"`
+	Actors         string   `expr:"Actors"`
+	Country        string   `expr:"Country"`
+	Language       string   `expr:"Language"`
+	Genres         []string `expr:"Genres"`
+	Runtime        int      `expr:"Runtime"`
+	Rating         float64  `expr:"Rating"`
+	Votes          int      `expr:"Votes"`
+	Metascore      int      `expr:"Metascore"`
+	RottenTomatoes int      `expr:"RottenTomatoes"`
+	BoxOffice      float64  `expr:"BoxOffice"`
+}
+
+func newEnrichmentEnv(m *MovieResponse) enrichmentEnv {
+	env := enrichmentEnv{
+		IMDBID: m.IMDBID, Title: m.Title, Type: m.Type, Rated: m.Rated, Plot: m.Plot,
+		Awards: m.Awards, Director: m.Director, Actors: m.Actors, Country: m.Country, Language: m.Language,
+		Runtime: runtimeMinutes(m.Runtime), Votes: parseVotes(m.IMDBVotes), Genres: []string{},
+	}
+	if len(m.Year) >= 4 {
+		env.Year, _ = strconv.Atoi(m.Year[:4])
+	}
+	for _, g := range strings.Split(m.Genre, ",") {
+		if g = strings.TrimSpace(g); g != "" && g != "N/A" {
+			env.Genres = append(env.Genres, g)
+		}
+	}
+	env.Rating, _ = parseRating(m.IMDBRating)
+	env.Metascore, _ = metascore(m)
+	env.RottenTomatoes, _ = rottenTomatoes(m)
+	env.BoxOffice, _ = parseBoxOffice(m.BoxOffice)
+	return env
+}
+
+// compileEnrichments checks and compiles the configured enrichments.
+func compileEnrichments(enrichments []Enrichment) error {
+	seen := map[string]bool{}
+	for i := range enrichments {
+		e := &enrichments[i]
+		if e.Field == "" || e.Expr == "" {
+			return fmt.Errorf("enrichments[%d] needs a field and an expr", i)
+		}
+		if seen[e.Field] {
+			return fmt.Errorf("enrichment field %q is defined twice", e.Field)
+		}
+		seen[e.Field] = true
+		program, err := expr.Compile(e.Expr, expr.Env(enrichmentEnv{}))
+		if err != nil {
+			return fmt.Errorf("enrichment %q: %w", e.Field, err)
+		}
+		e.program = program
+	}
+	return nil
+}
+
+// enrichmentHook is the response hook that applies the enrichments. It
+// adds fields next to the title's "imdbID" wherever one appears, and
+// never overwrites a field the response already has.
+type enrichmentHook struct {
+	routes []string
+}
+
+func (h enrichmentHook) Routes() []string { return h.routes }
+
+func (h enrichmentHook) Transform(c *gin.Context, payload interface{}) (interface{}, error) {
+	var enrichments []Enrichment
+	for _, e := range cfg().Enrichments {
+		if len(e.Routes) == 0 || slices.Contains(e.Routes, c.FullPath()) {
+			enrichments = append(enrichments, e)
+		}
+	}
+	if len(enrichments) > 0 {
+		enrichTitles(payload, enrichments)
+	}
+	return payload, nil
+}
+
+func enrichTitles(v interface{}, enrichments []Enrichment) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if id, ok := v["imdbID"].(string); ok {
+			if m, ok := catalog.Get(id); ok {
+				env := newEnrichmentEnv(m)
+				for _, e := range enrichments {
+					if _, exists := v[e.Field]; exists {
+						continue
+					}
+					out, err := expr.Run(e.program, env)
+					if err != nil {
+						slog.Debug("enrichment failed", "field", e.Field, "imdbID", id, "error", err)
+						continue
+					}
+					v[e.Field] = out
+				}
+			}
+		}
+		for _, child := range v {
+			enrichTitles(child, enrichments)
+		}
+	case []interface{}:
+		for _, child := range v {
+			enrichTitles(child, enrichments)
+		}
+	}
+}
+
+// initEnrichments registers the enrichment hook if any are configured.
+// The routes it runs on are fixed at startup, the expressions reload.
+func initEnrichments() {
+	enrichments := cfg().Enrichments
+	if len(enrichments) == 0 {
+		return
+	}
+	var routes []string
+	for _, e := range enrichments {
+		if len(e.Routes) == 0 {
+			routes = nil
+			break
+		}
+		routes = append(routes, e.Routes...)
+	}
+	registerResponseHook("enrichments", enrichmentHook{routes: routes})
+}
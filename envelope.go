@@ -0,0 +1,282 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// costParam ties OMDb params to the request that asked for them so cache
+// hits and upstream calls can be reported back. Unlike tenantParam it is
+// not part of the cache key.
+const costParam = "_cost"
+
+// requestCost counts what serving one request cost.
+type requestCost struct {
+	start time.Time
+	// route is the request's method and route, for the upstream
+	// inspector.
+	route         string
+	upstreamCalls atomic.Int64
+	cacheHits     atomic.Int64
+	cacheMisses   atomic.Int64
+	dataset       atomic.Bool
+	// dryRun skips lookups the cache can't answer, counting them as
+	// upstream calls; unforeseen records that one of them would have led
+	// to more.
+	dryRun     atomic.Bool
+	unforeseen atomic.Bool
+
+	// Limits set by limitRoute; truncated records that one cut the
+	// request short.
+	deadline  time.Time
+	budget    int
+	truncated atomic.Bool
+
+	// debug is set when an admin asked for ?debug=true.
+	debug *debugTrace
+	// fixtures is set for requests under /testing/, whose lookups are
+	// answered from the contract fixtures.
+	fixtures bool
+	// apiKey is the caller's, for flags checked below the handler.
+	apiKey string
+
+	mu     sync.Mutex
+	oldest time.Duration
+	// providers are the ones other than OMDb that answered lookups.
+	providers []string
+}
+
+var requestCosts sync.Map // request ID -> *requestCost
+
+// trackCost registers a cost counter for the request for the lifetime of
+// the handler. Its ID is the request's X-Request-ID.
+func trackCost(c *gin.Context) {
+	id := randomHex(8)
+	cost := &requestCost{start: time.Now(), route: c.Request.Method + " " + c.FullPath(), fixtures: servingFixtures(c.Request), apiKey: apiKey(c)}
+	requestCosts.Store(id, cost)
+	c.Header("X-Request-ID", id)
+	c.Set("cost.id", id)
+	c.Set("cost", cost)
+	c.Writer = &upstreamCallsWriter{ResponseWriter: c.Writer, cost: cost}
+	defer requestCosts.Delete(id)
+	c.Next()
+}
+
+// upstreamCallsWriter stamps X-Upstream-Calls on the response as it
+// starts, with the upstream calls the request made up to then.
+type upstreamCallsWriter struct {
+	gin.ResponseWriter
+	cost *requestCost
+}
+
+func (w *upstreamCallsWriter) stamp() {
+	if !w.Written() {
+		w.Header().Set("X-Upstream-Calls", strconv.FormatInt(w.cost.upstreamCalls.Load(), 10))
+	}
+}
+
+func (w *upstreamCallsWriter) WriteHeaderNow() {
+	w.stamp()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *upstreamCallsWriter) Write(b []byte) (int, error) {
+	w.stamp()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *upstreamCallsWriter) WriteString(s string) (int, error) {
+	w.stamp()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// costOf returns the request's cost tracker; nil is safe to use.
+func costOf(c *gin.Context) *requestCost {
+	if v, ok := c.Get("cost"); ok {
+		return v.(*requestCost)
+	}
+	return nil
+}
+
+func costFor(params map[string]string) *requestCost {
+	if v, ok := requestCosts.Load(params[costParam]); ok {
+		return v.(*requestCost)
+	}
+	return nil
+}
+
+// hit records a cache hit on data that was fetched age ago.
+func (rc *requestCost) hit(age time.Duration) {
+	if rc == nil {
+		return
+	}
+	rc.cacheHits.Add(1)
+	rc.mu.Lock()
+	rc.oldest = max(rc.oldest, age)
+	rc.mu.Unlock()
+}
+
+// servedBy records which provider answered a lookup.
+func (rc *requestCost) servedBy(name string) {
+	if rc == nil || name == "omdb" {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if !slices.Contains(rc.providers, name) {
+		rc.providers = append(rc.providers, name)
+	}
+}
+
+// provider names who answered the request's lookups: "omdb", or the
+// fallbacks that stood in for it.
+func (rc *requestCost) provider() string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.providers) == 0 {
+		return "omdb"
+	}
+	return strings.Join(rc.providers, ",")
+}
+
+// fromDataset records that the response was built from the local title
+// catalog rather than a lookup.
+func (rc *requestCost) fromDataset() {
+	if rc != nil {
+		rc.dataset.Store(true)
+	}
+}
+
+// source is where the response's data came from: "omdb" if anything was
+// fetched live, else "cache" or "dataset". Age is that of the oldest
+// cached piece.
+func (rc *requestCost) source() (string, time.Duration) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	switch {
+	case rc.cacheMisses.Load() > 0:
+		return "omdb", rc.oldest
+	case rc.cacheHits.Load() > 0:
+		return "cache", rc.oldest
+	case rc.dataset.Load():
+		return "dataset", 0
+	}
+	return "", 0
+}
+
+// cachedAge estimates how long ago a cached body was fetched from the TTL
+// it was stored with and what it has left.
+func cachedAge(key string, params map[string]string) time.Duration {
+	tc, ok := upstreamCache.(interface{ TTL(string) time.Duration })
+	if !ok {
+		return 0
+	}
+	return max(cacheTTL(params)-tc.TTL(key), 0)
+}
+
+// setProvenance sets X-Data-Source and X-Data-Age (seconds) for the
+// lookups made so far in this request.
+func setProvenance(c *gin.Context) {
+	cost := costOf(c)
+	if cost == nil {
+		return
+	}
+	if cost.isTruncated() {
+		c.Header("X-Truncated", "true")
+	}
+	source, age := cost.source()
+	if source == "" {
+		return
+	}
+	if source != "dataset" {
+		c.Header("X-Data-Provider", cost.provider())
+	}
+	c.Header("X-Data-Source", source)
+	c.Header("X-Data-Age", strconv.Itoa(int(age.Seconds())))
+}
+
+func (rc *requestCost) miss() {
+	if rc != nil {
+		rc.cacheMisses.Add(1)
+		rc.upstreamCalls.Add(1)
+	}
+}
+
+// listMeta is the meta block of an enveloped list response. Handlers fill
+// in what they know about the list; respondList adds the rest.
+type listMeta struct {
+	Total      int    `json:"total"`
+	Page       int    `json:"page,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	// ComputedAt is set for lists served from a precomputed result.
+	ComputedAt time.Time `json:"computed_at,omitzero"`
+	// Deepening reports how a list that widened its search spent its
+	// upstream budget.
+	Deepening *deepening `json:"deepening,omitempty"`
+	// Merge reports how a multi-page search was combined.
+	Merge *searchMerge `json:"merge,omitempty"`
+	// Enrichment reports the detail lookups of an enriched search.
+	Enrichment *searchEnrichment `json:"enrichment,omitempty"`
+	// Warnings list the lookups that failed; a list with any is partial.
+	Warnings  []lookupWarning `json:"warnings,omitempty"`
+	Partial   bool            `json:"partial,omitempty"`
+	Truncated bool            `json:"truncated,omitempty"`
+
+	TookMS        *int64 `json:"took_ms,omitempty"`
+	UpstreamCalls *int64 `json:"upstream_calls,omitempty"`
+	// Cache is "hit", "miss" or "none" for what the request's lookups
+	// found in the cache.
+	Cache      string `json:"cache,omitempty"`
+	Source     string `json:"source,omitempty"`
+	AgeSeconds *int   `json:"age_seconds,omitempty"`
+	Provider   string `json:"provider,omitempty"`
+}
+
+// respondList writes a list. Schema 3 and later wrap it as
+// {"data": items, "meta": listMeta}; older schemas get legacy as before.
+func respondList(c *gin.Context, status int, items interface{}, meta listMeta, legacy interface{}) {
+	schema, ok := responseSchema(c)
+	if !ok {
+		return
+	}
+	setProvenance(c)
+	truncated := costOf(c).isTruncated()
+	if len(meta.Warnings) > 0 {
+		c.Header("X-Partial-Results", "true")
+	}
+	if schema < schemaV3 {
+		if h, ok := legacy.(gin.H); ok && truncated {
+			h["truncated"] = true
+		}
+		c.JSON(status, legacy)
+		return
+	}
+	meta.Truncated = truncated
+	meta.Partial = len(meta.Warnings) > 0
+	if cost := costOf(c); cost != nil {
+		took, calls := time.Since(cost.start).Milliseconds(), cost.upstreamCalls.Load()
+		meta.TookMS, meta.UpstreamCalls = &took, &calls
+		switch {
+		case cost.cacheMisses.Load() > 0:
+			meta.Cache = "miss"
+		case cost.cacheHits.Load() > 0:
+			meta.Cache = "hit"
+		default:
+			meta.Cache = "none"
+		}
+		if source, age := cost.source(); source != "" {
+			ageSeconds := int(age.Seconds())
+			meta.Source, meta.AgeSeconds = source, &ageSeconds
+			if source != "dataset" {
+				meta.Provider = cost.provider()
+			}
+		}
+	}
+	c.JSON(status, gin.H{"data": items, "meta": meta})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestListEnvelopeMeta(t *testing.T) {
+	upstreamCache.Purge()
+	headers := map[string]string{"X-API-Version": "3"}
+	tests := []struct {
+		name      string
+		want      map[string]string
+		wantNoKey []string
+	}{
+		{
+			name:      "first search",
+			want:      map[string]string{"total": "1", "cache": "miss", "upstream_calls": "1"},
+			wantNoKey: []string{"computed_at", "partial", "truncated", "warnings"},
+		},
+		{
+			name:      "cached search",
+			want:      map[string]string{"total": "1", "cache": "hit", "upstream_calls": "0"},
+			wantNoKey: []string{"computed_at", "partial", "truncated", "warnings"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(http.MethodGet, "/api/search?q=matrix", headers, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d; body %s", w.Code, w.Body)
+			}
+			meta, ok := decodeObject(t, w)["meta"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("no meta; body %s", w.Body)
+			}
+			for k, want := range tt.want {
+				if got := fmt.Sprint(meta[k]); got != want {
+					t.Errorf("meta.%s = %s, want %s", k, got, want)
+				}
+			}
+			for _, k := range tt.wantNoKey {
+				if v, ok := meta[k]; ok {
+					t.Errorf("meta.%s = %v, want it left out", k, v)
+				}
+			}
+			if _, ok := meta["took_ms"]; !ok {
+				t.Error("meta has no took_ms")
+			}
+		})
+	}
+}
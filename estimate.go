@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errDryRun is what a lookup the cache can't answer returns while a
+// request is being estimated rather than run.
+var errDryRun = &upstreamError{http.StatusAccepted, "dry_run", "not looked up: dry run"}
+
+// estimableRoutes are the routes /api/estimate can cost.
+var estimableRoutes = map[string]gin.HandlerFunc{
+	"/api/movie":                  getMovie,
+	"/api/search":                 getSearch,
+	"/api/search/all":             getSearchAll,
+	"/api/movies/genre":           getMoviesByGenre,
+	"/api/movies/by-decade":       getMoviesByDecade,
+	"/api/movies/recommendations": getRecommendations,
+	"/api/movies/similar":         getSimilarMovies,
+}
+
+// isDryRun reports whether the request is only being estimated.
+func (rc *requestCost) isDryRun() bool {
+	return rc != nil && rc.dryRun.Load()
+}
+
+// dryRunMiss records a lookup a dry run skipped. Searches and lookups by
+// title feed further lookups the dry run can't foresee.
+func (rc *requestCost) dryRunMiss(params map[string]string) {
+	if _, ok := params["s"]; ok {
+		rc.unforeseen.Store(true)
+	}
+	if _, ok := params["t"]; ok {
+		rc.unforeseen.Store(true)
+	}
+}
+
+// estimating answers ?dry_run=true on handler's route with an estimate
+// instead of running it, and reports whether it did.
+func estimating(c *gin.Context, handler gin.HandlerFunc) bool {
+	if c.Query("dry_run") != "true" || costOf(c).isDryRun() {
+		return false
+	}
+	respondEstimate(c, c.Request, handler)
+	return true
+}
+
+// respondEstimate runs handler on req with every lookup the cache can't answer
+// skipped and its response thrown away, and reports how many upstream
+// calls it would have made. When a skipped search or title lookup would
+// have led to more lookups, the count is a lower bound and exact is
+// false; max_upstream_calls is the route's budget, if it has one.
+func respondEstimate(c *gin.Context, req *http.Request, handler gin.HandlerFunc) {
+	cost := costOf(c)
+	if cost == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not estimate the request"})
+		return
+	}
+	cost.dryRun.Store(true)
+	// The handler runs on a copy so the request it sees, and what it
+	// records for analytics, stay its own.
+	run := c.Copy()
+	run.Request = req
+	discard := &discardWriter{ResponseWriter: c.Writer, header: http.Header{}}
+	run.Writer = discard
+	handler(run)
+	// A request rejected before it looked anything up, for bad parameters
+	// or a cached "not found", is answered as it would have been.
+	if discard.status >= 400 && cost.upstreamCalls.Load() == 0 {
+		c.Data(discard.status, discard.header.Get("Content-Type"), discard.body.Bytes())
+		return
+	}
+
+	resp := gin.H{
+		"dry_run":        true,
+		"upstream_calls": cost.upstreamCalls.Load(),
+		"cached_lookups": cost.cacheHits.Load(),
+		"exact":          !cost.unforeseen.Load(),
+	}
+	if cost.budget > 0 {
+		resp["max_upstream_calls"] = cost.budget
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// getEstimate costs another route given as ?path=, e.g.
+// /api/estimate?path=/api/movies/genre%3Fgenre%3DHorror. The route's own
+// upstream budget applies as it would if it were called.
+func getEstimate(c *gin.Context) {
+	target, err := url.Parse(c.Query("path"))
+	if err != nil || target.Path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide ?path=/api/... with the request to estimate"})
+		return
+	}
+	handler, ok := estimableRoutes[strings.TrimSuffix(target.Path, "/")]
+	if !ok {
+		routes := make([]string, 0, len(estimableRoutes))
+		for route := range estimableRoutes {
+			routes = append(routes, route)
+		}
+		sort.Strings(routes)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "that route can't be estimated", "routes": routes})
+		return
+	}
+	if limit, ok := cfg().RouteLimits.Routes[target.Path]; ok {
+		if cost := costOf(c); cost != nil {
+			cost.budget = limit.UpstreamBudget
+		}
+	}
+	req := c.Request.Clone(c.Request.Context())
+	req.URL.RawQuery = target.RawQuery
+	respondEstimate(c, req, handler)
+}
+
+// discardWriter keeps the response of a handler run for an estimate
+// from reaching the client.
+type discardWriter struct {
+	gin.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *discardWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *discardWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+func (w *discardWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *discardWriter) WriteHeaderNow()     {}
+func (w *discardWriter) Status() int         { return w.status }
+func (w *discardWriter) Written() bool       { return w.status != 0 }
+func (w *discardWriter) Size() int           { return w.body.Len() }
+func (w *discardWriter) Header() http.Header { return w.header }
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// assignVariant picks a variant of experiment for the caller. Assignment is
+// sticky: the same user account, else API key, else client IP always
+// hashes to the same bucket as long as the configured weights don't
+// change. Admins can pass ?variant= to see a known variant; overridden
+// reports that it was forced, so it can be kept out of the results.
+func assignVariant(c *gin.Context, experiment string) (variant string, overridden bool) {
+	weights := cfg().Experiments[experiment]
+	p := currentPrincipal(c)
+	if override := c.Query("variant"); override != "" && p.Role == roleAdmin {
+		if _, ok := weights[override]; ok {
+			return override, true
+		}
+	}
+
+	names := make([]string, 0, len(weights))
+	total := 0
+	for name, w := range weights {
+		if w > 0 {
+			names = append(names, name)
+			total += w
+		}
+	}
+	if total == 0 {
+		return "", false
+	}
+	sort.Strings(names)
+
+	unit := apiKey(c)
+	switch {
+	case p.Kind == "user":
+		unit = "user:" + p.ID
+	case unit == "":
+		unit = c.ClientIP()
+	}
+	h := fnv.New32a()
+	h.Write([]byte(experiment + ":" + unit))
+	bucket := int(h.Sum32() % uint32(total))
+	for _, name := range names {
+		bucket -= weights[name]
+		if bucket < 0 {
+			return name, false
+		}
+	}
+	return names[len(names)-1], false
+}
+
+type variantStats struct {
+	Impressions int64   `json:"impressions"`
+	Positive    int64   `json:"positive"`
+	Negative    int64   `json:"negative"`
+	LikeRate    float64 `json:"like_rate"`
+}
+
+const (
+	experimentStatsBucket = "experiment_stats"
+	experimentLikesBucket = "experiment_likes"
+)
+
+// experimentLog aggregates impressions and feedback per variant, and keeps
+// which titles were liked for each source title so the collaborative
+// recommender has something to work from. What was recorded since the
+// last flush is held in memory and then added to the store, so replicas
+// sharing it report the same results.
+type experimentLog struct {
+	mu sync.Mutex
+	// stats is keyed by "experiment|variant", likes by "source|item".
+	stats   map[string]*variantStats
+	likes   map[string]int
+	flushMu sync.Mutex
+}
+
+var experiments = &experimentLog{
+	stats: map[string]*variantStats{},
+	likes: map[string]int{},
+}
+
+// pending must be called with mu held.
+func (l *experimentLog) pending(experiment, variant string) *variantStats {
+	key := experiment + "|" + variant
+	s := l.stats[key]
+	if s == nil {
+		s = &variantStats{}
+		l.stats[key] = s
+	}
+	return s
+}
+
+func (l *experimentLog) Impression(experiment, variant string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending(experiment, variant).Impressions++
+}
+
+func (l *experimentLog) Feedback(experiment, variant, sourceID, itemID string, liked bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.pending(experiment, variant)
+	if liked {
+		s.Positive++
+		l.likes[sourceID+"|"+itemID]++
+	} else {
+		s.Negative++
+	}
+}
+
+// Liked returns the titles liked alongside sourceID, most liked first.
+func (l *experimentLog) Liked(sourceID string) []string {
+	prefix := sourceID + "|"
+	counts := map[string]int{}
+	store.ForEachPrefix(experimentLikesBucket, prefix, func(key string, value []byte) error {
+		var n int
+		if json.Unmarshal(value, &n) == nil {
+			counts[strings.TrimPrefix(key, prefix)] += n
+		}
+		return nil
+	})
+	l.mu.Lock()
+	for key, n := range l.likes {
+		if id, ok := strings.CutPrefix(key, prefix); ok {
+			counts[id] += n
+		}
+	}
+	l.mu.Unlock()
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if counts[ids[i]] != counts[ids[j]] {
+			return counts[ids[i]] > counts[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+func (l *experimentLog) Report(experiment string) map[string]variantStats {
+	prefix := experiment + "|"
+	out := map[string]variantStats{}
+	add := func(variant string, s variantStats) {
+		r := out[variant]
+		r.Impressions += s.Impressions
+		r.Positive += s.Positive
+		r.Negative += s.Negative
+		out[variant] = r
+	}
+	store.ForEachPrefix(experimentStatsBucket, prefix, func(key string, value []byte) error {
+		var s variantStats
+		if json.Unmarshal(value, &s) == nil {
+			add(strings.TrimPrefix(key, prefix), s)
+		}
+		return nil
+	})
+	l.mu.Lock()
+	for key, s := range l.stats {
+		if variant, ok := strings.CutPrefix(key, prefix); ok {
+			add(variant, *s)
+		}
+	}
+	l.mu.Unlock()
+	for name, r := range out {
+		if total := r.Positive + r.Negative; total > 0 {
+			r.LikeRate = float64(r.Positive) / float64(total)
+		}
+		out[name] = r
+	}
+	return out
+}
+
+// Flush adds what was recorded since the last flush to the store.
+func (l *experimentLog) Flush() {
+	l.flushMu.Lock()
+	defer l.flushMu.Unlock()
+	l.mu.Lock()
+	stats, likes := l.stats, l.likes
+	l.stats, l.likes = map[string]*variantStats{}, map[string]int{}
+	l.mu.Unlock()
+
+	for key, s := range stats {
+		var stored variantStats
+		found, err := store.Modify(experimentStatsBucket, key, &stored, func() error {
+			stored.Impressions += s.Impressions
+			stored.Positive += s.Positive
+			stored.Negative += s.Negative
+			return nil
+		})
+		if err == nil && !found {
+			err = store.Put(experimentStatsBucket, key, s)
+		}
+		if err != nil {
+			slog.Warn("experiment flush failed", "variant", key, "error", err)
+		}
+	}
+	for key, n := range likes {
+		var stored int
+		found, err := store.Modify(experimentLikesBucket, key, &stored, func() error {
+			stored += n
+			return nil
+		})
+		if err == nil && !found {
+			err = store.Put(experimentLikesBucket, key, n)
+		}
+		if err != nil {
+			slog.Warn("experiment flush failed", "like", key, "error", err)
+		}
+	}
+}
+
+func flushExperiments(every time.Duration) {
+	for range time.Tick(every) {
+		experiments.Flush()
+	}
+}
+
+func getExperiment(c *gin.Context) {
+	name := c.Param("name")
+	c.JSON(http.StatusOK, gin.H{
+		"experiment": name,
+		"weights":    cfg().Experiments[name],
+		"variants":   experiments.Report(name),
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVariantOverride(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.Experiments = map[string]map[string]int{recommenderExperiment: {"content": 1, "blended": 0}}
+	})
+	user, _ := newUser(t)
+	blendedImpressions := func() int64 { return experiments.Report(recommenderExperiment)["blended"].Impressions }
+	before := blendedImpressions()
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "users can't pick a variant",
+			path:        "/api/movies/recommendations?favorite_movie=Heat&variant=blended",
+			headers:     user,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"variant": "content"},
+			wantLookups: -1,
+		},
+		{
+			name:        "admins can",
+			path:        "/api/movies/recommendations?favorite_movie=Heat&variant=blended",
+			headers:     adminHeaders,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"variant": "blended"},
+			wantLookups: -1,
+		},
+	})
+	if n := blendedImpressions(); n != before {
+		t.Errorf("an overridden request counted as %d impressions", n-before)
+	}
+}
+
+func TestVariantFollowsUser(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.Experiments = map[string]map[string]int{recommenderExperiment: {"content": 1, "blended": 1}}
+	})
+	assign := func(p *principal, ip int) string {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.RemoteAddr = "192.0.2." + strconv.Itoa(ip) + ":1234"
+		c.Set("principal", p)
+		variant, _ := assignVariant(c, recommenderExperiment)
+		return variant
+	}
+	user := &principal{Kind: "user", ID: "u-sticky", Role: roleUser}
+	anonymous := map[string]bool{}
+	for ip := 1; ip <= 50; ip++ {
+		if got, want := assign(user, ip), assign(user, 1); got != want {
+			t.Fatalf("from address %d the user got %s, and %s from address 1", ip, got, want)
+		}
+		anonymous[assign(&principal{}, ip)] = true
+	}
+	if len(anonymous) != 2 {
+		t.Errorf("anonymous callers from 50 addresses got variants %v, want both", anonymous)
+	}
+}
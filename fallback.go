@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fallbacks are tried in order when OMDb fails a lookup (an error, a
+// timeout, an exhausted or rejected key), but not when it answers that
+// there is nothing to find. Each returns an OMDb-shaped body with
+// "provider" set, so responses can say where the data came from.
+var fallbacks []fallbackProvider
+
+type fallbackProvider interface {
+	Provider
+	Name() string
+}
+
+var errUnsupportedLookup = errors.New("lookup not supported by this provider")
+
+// fallbackCacheTTL bounds how long a fallback answer is cached, so OMDb
+// gets asked again soon after it recovers.
+var fallbackCacheTTL = 10 * time.Minute
+
+// lookupFailure returns why OMDb failed the lookup, or nil if it
+// answered, found or not.
+func lookupFailure(body []byte, err error) error {
+	if err != nil {
+		var ue *upstreamError
+		if errors.As(err, &ue) && ue.Code == "invalid_parameter" {
+			return nil
+		}
+		return err
+	}
+	var r struct{ Response, Error string }
+	if json.Unmarshal(body, &r) != nil {
+		return errors.New("unreadable OMDb response")
+	}
+	if r.Response != "False" {
+		return nil
+	}
+	failure := omdbError(r.Error)
+	var ue *upstreamError
+	if errors.As(failure, &ue) && (ue.Code == "not_found" || ue.Code == "query_too_broad") {
+		return nil
+	}
+	return failure
+}
+
+// lookupFallback asks each fallback in turn and returns the first answer.
+func lookupFallback(params map[string]string, failure error) []byte {
+	for _, fb := range fallbacks {
+		if fb.Name() == "tmdb" && !flagEnabledFor(params, flagTMDbFallback) {
+			continue
+		}
+		body, err := fb.Lookup(params)
+		if err == nil {
+			slog.Debug("served lookup from fallback", "provider", fb.Name(), "omdb_error", failure)
+			return body
+		}
+		if !errors.Is(err, errUnsupportedLookup) && !isNotFound(err) {
+			slog.Warn("fallback lookup failed", "provider", fb.Name(), "error", err)
+		}
+	}
+	return nil
+}
+
+// providerOf returns which provider answered a decoded lookup.
+func providerOf(out interface{}) string {
+	switch v := out.(type) {
+	case *MovieResponse:
+		if v.Provider != "" {
+			return v.Provider
+		}
+	case *SearchResults:
+		if v.Provider != "" {
+			return v.Provider
+		}
+	}
+	return "omdb"
+}
+
+// datasetProvider answers from the local title catalog: everything ever
+// fetched, however old.
+type datasetProvider struct{}
+
+func (datasetProvider) Name() string { return "dataset" }
+
+func (datasetProvider) Lookup(params map[string]string) ([]byte, error) {
+	if params["Season"] != "" || params["Episode"] != "" {
+		return nil, errUnsupportedLookup
+	}
+	if id := params["i"]; id != "" {
+		m, ok := catalog.Get(id)
+		if !ok {
+			return nil, omdbError("Incorrect IMDb ID.")
+		}
+		return datasetBody(m)
+	}
+	if t := params["t"]; t != "" {
+		matches := catalog.All(func(m *MovieResponse) bool {
+			return normalizeTitle(m.Title) == normalizeTitle(t) && datasetFilter(m, params)
+		})
+		if len(matches) == 0 {
+			return nil, omdbError("Movie not found!")
+		}
+		sort.Slice(matches, func(i, j int) bool { return parseVotes(matches[i].IMDBVotes) > parseVotes(matches[j].IMDBVotes) })
+		return datasetBody(matches[0])
+	}
+
+	query := strings.ToLower(params["s"])
+	matches := catalog.All(func(m *MovieResponse) bool {
+		return strings.Contains(strings.ToLower(m.Title), query) && datasetFilter(m, params)
+	})
+	if len(matches) == 0 {
+		return nil, omdbError("Movie not found!")
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].IMDBID < matches[j].IMDBID })
+	page, _ := strconv.Atoi(params["page"])
+	page = max(page, 1)
+	results := SearchResults{TotalResults: strconv.Itoa(len(matches)), Response: "True", Provider: "dataset"}
+	for _, m := range matches[min((page-1)*10, len(matches)):min(page*10, len(matches))] {
+		results.Search = append(results.Search, searchItem{Title: m.Title, Year: m.Year, IMDBID: m.IMDBID, Type: m.Type})
+	}
+	return json.Marshal(results)
+}
+
+func datasetFilter(m *MovieResponse, params map[string]string) bool {
+	if t := params["type"]; t != "" && m.Type != t {
+		return false
+	}
+	return params["y"] == "" || strings.HasPrefix(m.Year, params["y"])
+}
+
+func datasetBody(m *MovieResponse) ([]byte, error) {
+	out := *m
+	out.Provider, out.Response = "dataset", "True"
+	return json.Marshal(out)
+}
+
+// tmdbProvider looks titles up on TMDb and maps them onto OMDb's shape.
+// It handles lookups by IMDb ID or title; searches and episodes are left
+// to the next fallback, since TMDb results don't carry IMDb IDs.
+type tmdbProvider struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func (t *tmdbProvider) Name() string { return "tmdb" }
+
+type tmdbTitle struct {
+	ID             int    `json:"id"`
+	Title          string `json:"title"`
+	Name           string `json:"name"`
+	Overview       string `json:"overview"`
+	ReleaseDate    string `json:"release_date"`
+	FirstAirDate   string `json:"first_air_date"`
+	Runtime        int    `json:"runtime"`
+	EpisodeRunTime []int  `json:"episode_run_time"`
+	PosterPath     string `json:"poster_path"`
+	Genres         []struct {
+		Name string `json:"name"`
+	} `json:"genres"`
+	SpokenLanguages []struct {
+		EnglishName string `json:"english_name"`
+	} `json:"spoken_languages"`
+	ProductionCountries []struct {
+		Name string `json:"name"`
+	} `json:"production_countries"`
+	NumberOfSeasons int `json:"number_of_seasons"`
+	Credits         struct {
+		Cast []struct {
+			Name string `json:"name"`
+		} `json:"cast"`
+		Crew []struct {
+			Name string `json:"name"`
+			Job  string `json:"job"`
+		} `json:"crew"`
+	} `json:"credits"`
+	ExternalIDs struct {
+		IMDBID string `json:"imdb_id"`
+	} `json:"external_ids"`
+}
+
+func (t *tmdbProvider) get(path string, query url.Values, out interface{}) error {
+	query.Set("api_key", t.apiKey)
+	resp, err := t.client.Get(strings.TrimSuffix(t.baseURL, "/") + path + "?" + query.Encode())
+	if err != nil {
+		return upstreamUnavailable(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return omdbError("Movie not found!")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tmdb returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (t *tmdbProvider) Lookup(params map[string]string) ([]byte, error) {
+	if params["i"] == "" && params["t"] == "" {
+		return nil, errUnsupportedLookup
+	}
+	if params["Season"] != "" || params["Episode"] != "" || params["type"] == "episode" {
+		return nil, errUnsupportedLookup
+	}
+	kind, id := "movie", 0
+	if params["type"] == "series" {
+		kind = "tv"
+	}
+	if imdbID := params["i"]; imdbID != "" {
+		var found struct {
+			MovieResults []tmdbTitle `json:"movie_results"`
+			TVResults    []tmdbTitle `json:"tv_results"`
+		}
+		if err := t.get("/find/"+url.PathEscape(imdbID), url.Values{"external_source": {"imdb_id"}}, &found); err != nil {
+			return nil, err
+		}
+		switch {
+		case len(found.MovieResults) > 0:
+			kind, id = "movie", found.MovieResults[0].ID
+		case len(found.TVResults) > 0:
+			kind, id = "tv", found.TVResults[0].ID
+		default:
+			return nil, omdbError("Incorrect IMDb ID.")
+		}
+	} else {
+		q := url.Values{"query": {params["t"]}}
+		if y := params["y"]; y != "" {
+			if kind == "tv" {
+				q.Set("first_air_date_year", y)
+			} else {
+				q.Set("year", y)
+			}
+		}
+		var found struct {
+			Results []tmdbTitle `json:"results"`
+		}
+		if err := t.get("/search/"+kind, q, &found); err != nil {
+			return nil, err
+		}
+		if len(found.Results) == 0 {
+			return nil, omdbError("Movie not found!")
+		}
+		id = found.Results[0].ID
+	}
+
+	var title tmdbTitle
+	if err := t.get("/"+kind+"/"+strconv.Itoa(id), url.Values{"append_to_response": {"credits,external_ids"}}, &title); err != nil {
+		return nil, err
+	}
+	return json.Marshal(title.omdb(kind))
+}
+
+// omdb maps a TMDb title onto OMDb's fields, with "N/A" where TMDb has
+// nothing comparable, such as the IMDb rating.
+func (t tmdbTitle) omdb(kind string) MovieResponse {
+	orNA := func(values []string) string {
+		if len(values) == 0 {
+			return "N/A"
+		}
+		return strings.Join(values, ", ")
+	}
+	m := MovieResponse{
+		Title: t.Title, Plot: t.Overview, IMDBID: t.ExternalIDs.IMDBID, Type: "movie",
+		Rated: "N/A", Awards: "N/A", IMDBRating: "N/A", IMDBVotes: "N/A", Poster: "N/A",
+		Response: "True", Provider: "tmdb",
+	}
+	released, runtime := t.ReleaseDate, t.Runtime
+	if kind == "tv" {
+		m.Title, m.Type, released = t.Name, "series", t.FirstAirDate
+		m.TotalSeasons = strconv.Itoa(t.NumberOfSeasons)
+		if len(t.EpisodeRunTime) > 0 {
+			runtime = t.EpisodeRunTime[0]
+		}
+	}
+	m.Released, m.Year = "N/A", "N/A"
+	if d, err := time.Parse("2006-01-02", released); err == nil {
+		m.Released, m.Year = d.Format("02 Jan 2006"), strconv.Itoa(d.Year())
+	}
+	m.Runtime = "N/A"
+	if runtime > 0 {
+		m.Runtime = strconv.Itoa(runtime) + " min"
+	}
+	if t.PosterPath != "" {
+		m.Poster = "https://image.tmdb.org/t/p/w500" + t.PosterPath
+	}
+	var genres, languages, countries, directors, actors []string
+	for _, g := range t.Genres {
+		genres = append(genres, g.Name)
+	}
+	for _, l := range t.SpokenLanguages {
+		languages = append(languages, l.EnglishName)
+	}
+	for _, c := range t.ProductionCountries {
+		countries = append(countries, c.Name)
+	}
+	for _, c := range t.Credits.Crew {
+		if c.Job == "Director" {
+			directors = append(directors, c.Name)
+		}
+	}
+	for i, c := range t.Credits.Cast {
+		if i == 4 {
+			break
+		}
+		actors = append(actors, c.Name)
+	}
+	m.Genre, m.Language, m.Country = orNA(genres), orNA(languages), orNA(countries)
+	m.Director, m.Actors = orNA(directors), orNA(actors)
+	return m
+}
+
+// newFallbacksFromEnv reads FALLBACK_PROVIDERS, a comma-separated list of
+// tmdb and dataset in the order to try them. Unset, OMDb failures are
+// reported as they are.
+func newFallbacksFromEnv() []fallbackProvider {
+	fallbackCacheTTL = envDuration("FALLBACK_CACHE_TTL", fallbackCacheTTL)
+	var out []fallbackProvider
+	for _, name := range strings.Split(envString("FALLBACK_PROVIDERS", ""), ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "dataset":
+			out = append(out, datasetProvider{})
+		case "tmdb":
+			key := envString("TMDB_API_KEY", "")
+			if key == "" {
+				slog.Warn("FALLBACK_PROVIDERS includes tmdb but TMDB_API_KEY is not set; skipping it")
+				continue
+			}
+			out = append(out, &tmdbProvider{
+				baseURL: envString("TMDB_BASE_URL", "https://api.themoviedb.org/3"),
+				apiKey:  key,
+				client:  &http.Client{Timeout: 10 * time.Second},
+			})
+		default:
+			slog.Warn("unknown fallback provider; skipping it", "provider", name)
+		}
+	}
+	return out
+}
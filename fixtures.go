@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"movie-api/omdb"
+)
+
+// With contract_fixtures on, the lookup routes are also served under
+// /testing/, e.g. /testing/api/movie?id=tt0133093, with OMDb answered from
+// the canned titles below instead of the network. Responses come from the
+// real handlers, so their shape is exactly that of the live routes, and
+// downstream teams can write consumer contract tests against them. GET
+// /testing lists the fixture titles and the routes.
+//
+// Fixture answers are cached apart from live ones, under
+// fixturesCachePrefix. Only fixtureRoutes are served there: routes for
+// accounts, lists, admin and the like would read and write the
+// deployment's own store, so under /testing/ they are not found.
+
+const (
+	fixturesPrefix      = "/testing"
+	fixturesCachePrefix = "fixtures|"
+)
+
+// fixtureRoutes are the routes served under /testing/. They only read
+// upstream data, and every lookup they make goes through scopedParams.
+var fixtureRoutes = map[string]bool{
+	"GET /api/movie":            true,
+	"GET /api/search":           true,
+	"GET /api/search/all":       true,
+	"GET /api/search/nl":        true,
+	"GET /api/movie/related":    true,
+	"GET /api/episode":          true,
+	"GET /api/series/trend":     true,
+	"GET /api/series/skippable": true,
+	"GET /api/movies/genre":     true,
+	"GET /api/movies/by-decade": true,
+	"GET /api/movies/similar":   true,
+	"GET /api/genres/related":   true,
+	"GET /api/i18n/labels":      true,
+	"GET /m/:imdbID":            true,
+}
+
+type fixturesKey struct{}
+
+// withFixtures strips /testing from requests under it and marks them to
+// be answered from fixtures, before the router sees them.
+func withFixtures(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, fixturesPrefix+"/")
+		if !ok || !cfg().ContractFixtures {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), fixturesKey{}, true))
+		r.URL.Path = "/" + rest
+		r.URL.RawPath = ""
+		w.Header().Set("X-Fixtures", "true")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func servingFixtures(r *http.Request) bool {
+	on, _ := r.Context().Value(fixturesKey{}).(bool)
+	return on
+}
+
+// onlyFixtureRoutes answers 404 for routes under /testing/ that aren't
+// fixtureRoutes, before any other middleware sees the request.
+func onlyFixtureRoutes(c *gin.Context) {
+	if servingFixtures(c.Request) && !fixtureRoutes[c.Request.Method+" "+c.FullPath()] {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "not served under " + fixturesPrefix + "/; GET " + fixturesPrefix + " lists the routes that are"})
+		return
+	}
+	c.Next()
+}
+
+func (rc *requestCost) usesFixtures() bool {
+	return rc != nil && rc.fixtures
+}
+
+// fixtureTitles are the titles OMDb knows in fixture mode: two films and a
+// series with one season of two episodes.
+const fixtureTitles = `[
+  {"Title": "The Matrix", "Year": "1999", "Rated": "R", "Released": "31 Mar 1999", "Runtime": "136 min",
+   "Genre": "Action, Sci-Fi", "Director": "Lana Wachowski, Lilly Wachowski",
+   "Actors": "Keanu Reeves, Laurence Fishburne, Carrie-Anne Moss",
+   "Plot": "When a beautiful stranger leads computer hacker Neo to a forbidding underworld, he discovers the shocking truth.",
+   "Language": "English", "Country": "United States, Australia", "Awards": "Won 4 Oscars. 42 wins & 52 nominations total",
+   "Poster": "https://m.media-amazon.com/images/M/MV5BNzQzOTk3OTAtNDQ0Zi00ZTVkLWI0MTEtMDllZjNkYzNjNTc4L2ltYWdlXkEyXkFqcGdeQXVyNjU0OTQ0OTY@._V1_SX300.jpg",
+   "Ratings": [{"Source": "Internet Movie Database", "Value": "8.7/10"}, {"Source": "Rotten Tomatoes", "Value": "83%"}, {"Source": "Metacritic", "Value": "73/100"}],
+   "Metascore": "73", "imdbRating": "8.7", "imdbVotes": "2,100,000", "imdbID": "tt0133093", "Type": "movie",
+   "BoxOffice": "$172,076,928", "Response": "True"},
+  {"Title": "The Godfather", "Year": "1972", "Rated": "R", "Released": "24 Mar 1972", "Runtime": "175 min",
+   "Genre": "Crime, Drama", "Director": "Francis Ford Coppola",
+   "Actors": "Marlon Brando, Al Pacino, James Caan",
+   "Plot": "The aging patriarch of an organized crime dynasty transfers control of his clandestine empire to his reluctant son.",
+   "Language": "English, Italian, Latin", "Country": "United States", "Awards": "Won 3 Oscars. 31 wins & 31 nominations total",
+   "Poster": "https://m.media-amazon.com/images/M/MV5BM2MyNjYxNmUtYTAwNi00MTYxLWJmNWYtYzZlODY3ZTk3OTFlXkEyXkFqcGdeQXVyNzkwMjQ5NzM@._V1_SX300.jpg",
+   "Ratings": [{"Source": "Internet Movie Database", "Value": "9.2/10"}, {"Source": "Rotten Tomatoes", "Value": "97%"}, {"Source": "Metacritic", "Value": "100/100"}],
+   "Metascore": "100", "imdbRating": "9.2", "imdbVotes": "2,000,000", "imdbID": "tt0068646", "Type": "movie",
+   "BoxOffice": "$136,381,073", "Response": "True"},
+  {"Title": "Breaking Bad", "Year": "2008–2013", "Rated": "TV-MA", "Released": "20 Jan 2008", "Runtime": "49 min",
+   "Genre": "Crime, Drama, Thriller", "Director": "N/A",
+   "Actors": "Bryan Cranston, Aaron Paul, Anna Gunn",
+   "Plot": "A chemistry teacher diagnosed with inoperable lung cancer turns to manufacturing and selling methamphetamine.",
+   "Language": "English, Spanish", "Country": "United States", "Awards": "Won 16 Primetime Emmys. 161 wins & 262 nominations total",
+   "Poster": "https://m.media-amazon.com/images/M/MV5BYmQ4YWMxYjUtNjZmYi00MDQ1LWFjMjMtNjA5ZDdiYjdiODU5XkEyXkFqcGdeQXVyMTMzNDExODE5._V1_SX300.jpg",
+   "Ratings": [{"Source": "Internet Movie Database", "Value": "9.5/10"}],
+   "Metascore": "N/A", "imdbRating": "9.5", "imdbVotes": "2,000,000", "imdbID": "tt0903747", "Type": "series",
+   "totalSeasons": "1", "Response": "True"},
+  {"Title": "Pilot", "Year": "2008", "Rated": "TV-14", "Released": "20 Jan 2008", "Runtime": "58 min",
+   "Genre": "Crime, Drama, Thriller", "Director": "Vince Gilligan",
+   "Actors": "Bryan Cranston, Anna Gunn, Aaron Paul",
+   "Plot": "Diagnosed with terminal lung cancer, a chemistry teacher teams up with a former student to secure his family's future.",
+   "Language": "English, Spanish", "Country": "United States", "Awards": "N/A", "Poster": "N/A",
+   "Ratings": [{"Source": "Internet Movie Database", "Value": "9.0/10"}],
+   "Metascore": "N/A", "imdbRating": "9.0", "imdbVotes": "45,000", "imdbID": "tt0959621", "Type": "episode",
+   "Season": "1", "Episode": "1", "seriesID": "tt0903747", "Response": "True"},
+  {"Title": "Cat's in the Bag...", "Year": "2008", "Rated": "TV-14", "Released": "27 Jan 2008", "Runtime": "48 min",
+   "Genre": "Crime, Drama, Thriller", "Director": "Adam Bernstein",
+   "Actors": "Bryan Cranston, Anna Gunn, Aaron Paul",
+   "Plot": "Walt and Jesse attempt to tie up loose ends.",
+   "Language": "English, Spanish", "Country": "United States", "Awards": "N/A", "Poster": "N/A",
+   "Ratings": [{"Source": "Internet Movie Database", "Value": "8.6/10"}],
+   "Metascore": "N/A", "imdbRating": "8.6", "imdbVotes": "33,000", "imdbID": "tt1054724", "Type": "episode",
+   "Season": "1", "Episode": "2", "seriesID": "tt0903747", "Response": "True"}
+]`
+
+// fixtures is what fixture mode answers OMDb lookups with.
+var fixtures = mustLoadFixtures()
+
+type fixtureProvider struct {
+	titles []*MovieResponse
+	raw    map[string]json.RawMessage
+}
+
+func mustLoadFixtures() *fixtureProvider {
+	var raw []json.RawMessage
+	if err := json.Unmarshal([]byte(fixtureTitles), &raw); err != nil {
+		panic("parse fixture titles: " + err.Error())
+	}
+	f := &fixtureProvider{raw: map[string]json.RawMessage{}}
+	for _, body := range raw {
+		var m MovieResponse
+		if err := json.Unmarshal(body, &m); err != nil {
+			panic("parse fixture titles: " + err.Error())
+		}
+		f.titles = append(f.titles, &m)
+		f.raw[m.IMDBID] = body
+	}
+	return f
+}
+
+// Lookup answers as OMDb would for the fixture titles, "not found"
+// included.
+func (f *fixtureProvider) Lookup(params map[string]string) ([]byte, error) {
+	if _, err := omdb.Query(params, ""); err != nil {
+		return nil, invalidParameter(err)
+	}
+	switch {
+	case params["s"] != "":
+		return f.search(params)
+	case params["Season"] != "":
+		return f.season(params)
+	}
+	for _, m := range f.titles {
+		if f.matches(m, params) {
+			return f.raw[m.IMDBID], nil
+		}
+	}
+	if params["i"] != "" {
+		return omdbFalse("Incorrect IMDb ID."), nil
+	}
+	return omdbFalse("Movie not found!"), nil
+}
+
+func (f *fixtureProvider) matches(m *MovieResponse, params map[string]string) bool {
+	if id := params["i"]; id != "" && m.IMDBID != id {
+		return false
+	}
+	if t := params["t"]; t != "" && !strings.EqualFold(m.Title, strings.TrimSpace(t)) {
+		return false
+	}
+	if typ := params["type"]; typ != "" && m.Type != typ {
+		return false
+	}
+	if y := params["y"]; y != "" && !strings.HasPrefix(m.Year, y) {
+		return false
+	}
+	return true
+}
+
+func (f *fixtureProvider) search(params map[string]string) ([]byte, error) {
+	term := strings.ToLower(strings.TrimSpace(params["s"]))
+	var hits []searchItem
+	for _, m := range f.titles {
+		if m.Type == "episode" || !strings.Contains(strings.ToLower(m.Title), term) {
+			continue
+		}
+		if typ := params["type"]; typ != "" && m.Type != typ {
+			continue
+		}
+		if y := params["y"]; y != "" && !strings.HasPrefix(m.Year, y) {
+			continue
+		}
+		hits = append(hits, searchItem{Title: m.Title, Year: m.Year, IMDBID: m.IMDBID, Type: m.Type})
+	}
+	page, _ := strconv.Atoi(params["page"])
+	start := max(page-1, 0) * 10
+	if start >= len(hits) {
+		return omdbFalse("Movie not found!"), nil
+	}
+	return json.Marshal(SearchResults{
+		Search:       hits[start:min(start+10, len(hits))],
+		TotalResults: strconv.Itoa(len(hits)),
+		Response:     "True",
+	})
+}
+
+// season answers a season lookup, or an episode's with Episode set.
+func (f *fixtureProvider) season(params map[string]string) ([]byte, error) {
+	var series *MovieResponse
+	for _, m := range f.titles {
+		if m.Type == "series" && f.matches(m, map[string]string{"i": params["i"], "t": params["t"]}) {
+			series = m
+			break
+		}
+	}
+	if series == nil {
+		return omdbFalse("Series or season not found!"), nil
+	}
+	var episodes []*MovieResponse
+	for _, m := range f.titles {
+		if m.Type == "episode" && m.SeriesID == series.IMDBID && m.Season == params["Season"] {
+			if m.Episode == params["Episode"] {
+				return f.raw[m.IMDBID], nil
+			}
+			episodes = append(episodes, m)
+		}
+	}
+	if params["Episode"] != "" || len(episodes) == 0 {
+		return omdbFalse("Series or season not found!"), nil
+	}
+	season := SeasonResponse{Title: series.Title, Season: params["Season"], TotalSeasons: series.TotalSeasons, Response: "True"}
+	for _, e := range episodes {
+		season.Episodes = append(season.Episodes, struct {
+			Title      string `json:"Title"`
+			Released   string `json:"Released"`
+			Episode    string `json:"Episode"`
+			IMDBRating string `json:"imdbRating"`
+			IMDBID     string `json:"imdbID"`
+		}{e.Title, e.Released, e.Episode, e.IMDBRating, e.IMDBID})
+	}
+	return json.Marshal(season)
+}
+
+func omdbFalse(message string) []byte {
+	body, _ := json.Marshal(gin.H{"Response": "False", "Error": message})
+	return body
+}
+
+// getFixtureIndex lists the fixture titles and the routes served under
+// /testing/.
+func getFixtureIndex() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !cfg().ContractFixtures {
+			c.JSON(http.StatusNotFound, gin.H{"error": "contract fixtures are off"})
+			return
+		}
+		titles := make([]searchItem, 0, len(fixtures.titles))
+		for _, m := range fixtures.titles {
+			titles = append(titles, searchItem{Title: m.Title, Year: m.Year, IMDBID: m.IMDBID, Type: m.Type})
+		}
+		routes := []string{}
+		for route := range fixtureRoutes {
+			method, path, _ := strings.Cut(route, " ")
+			routes = append(routes, method+" "+fixturesPrefix+path)
+		}
+		sort.Strings(routes)
+		c.JSON(http.StatusOK, gin.H{"titles": titles, "routes": routes})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Flag gates an experimental feature. A flag is on when Enabled is set, or
+// when the running environment or the caller's API key is listed.
+type Flag struct {
+	Enabled      bool     `json:"enabled"`
+	Environments []string `json:"environments,omitempty"`
+	APIKeys      []string `json:"api_keys,omitempty"`
+	Description  string   `json:"description,omitempty"`
+}
+
+func apiKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	return c.Query("api_key")
+}
+
+// Flags gating the experimental features. Each is on by default, so a
+// deployment limits one by configuring it with Enabled unset.
+const (
+	flagNLSearch            = "nl_search"
+	flagSimilarTitles       = "similar_titles"
+	flagRecommenderVariants = "recommender_variants"
+	flagTMDbFallback        = "tmdb_fallback"
+)
+
+func defaultFlags() map[string]Flag {
+	return map[string]Flag{
+		flagNLSearch:            {Enabled: true, Description: "natural language search and chat"},
+		flagSimilarTitles:       {Enabled: true, Description: "embedding-based similar titles"},
+		flagRecommenderVariants: {Enabled: true, Description: "recommender experiment variants beyond content"},
+		flagTMDbFallback:        {Enabled: true, Description: "TMDb answers lookups OMDb can't"},
+	}
+}
+
+func flagEnabled(c *gin.Context, name string) bool {
+	key := ""
+	if c != nil {
+		key = apiKey(c)
+	}
+	return flagOn(name, key)
+}
+
+// flagEnabledFor is flagEnabled for a lookup, made on behalf of the
+// request its params were scoped to.
+func flagEnabledFor(params map[string]string, name string) bool {
+	key := ""
+	if cost := costFor(params); cost != nil {
+		key = cost.apiKey
+	}
+	return flagOn(name, key)
+}
+
+func flagOn(name, key string) bool {
+	config := cfg()
+	flag, ok := config.Flags[name]
+	if !ok {
+		return false
+	}
+	if flag.Enabled || slices.Contains(flag.Environments, config.Environment) {
+		return true
+	}
+	return key != "" && slices.Contains(flag.APIKeys, key)
+}
+
+// requireFlag hides a route behind a flag; callers without the flag see a
+// plain 404 as if the endpoint didn't exist.
+func requireFlag(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !flagEnabled(c, name) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func getFlags(c *gin.Context) {
+	config := cfg()
+	flags := gin.H{}
+	for name, flag := range config.Flags {
+		flags[name] = gin.H{
+			"flag":   flag,
+			"active": flag.Enabled || slices.Contains(flag.Environments, config.Environment),
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"environment": config.Environment, "flags": flags})
+}
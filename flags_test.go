@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFlagsGateRoutes(t *testing.T) {
+	key := newAPIKey(t, roleUser, "")
+	withConfig(t, func(c *Config) {
+		c.Flags = map[string]Flag{
+			flagNLSearch:      {APIKeys: []string{key}},
+			flagSimilarTitles: {Environments: []string{"nowhere"}},
+		}
+	})
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "flag off",
+			path:        "/api/search/nl?q=matrix&assist=false",
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "flag off for this key",
+			path:        "/api/search/nl?q=matrix&assist=false",
+			headers:     map[string]string{"X-API-Key": newAPIKey(t, roleUser, "")},
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "allow-listed key",
+			path:        "/api/search/nl?q=matrix&assist=false",
+			headers:     map[string]string{"X-API-Key": key},
+			wantStatus:  http.StatusOK,
+			wantLookups: -1,
+		},
+		{
+			name:        "other environment",
+			path:        "/api/movies/similar?id=tt0133093",
+			headers:     map[string]string{"X-API-Key": key},
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "chat shares the NL search flag",
+			method:      http.MethodPost,
+			path:        "/api/chat",
+			body:        `{"message":"something like heat"}`,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const followsBucket = "follows"
+
+// Follow tracks the latest released episode we've told a user about for a
+// series. New follows start at the current latest episode so the user
+// isn't flooded with the back catalogue.
+type Follow struct {
+	UserID        string    `json:"-"`
+	TenantID      string    `json:"tenant_id,omitempty"`
+	SeriesID      string    `json:"series_id"`
+	Title         string    `json:"title"`
+	LastSeason    int       `json:"last_season"`
+	LastEpisode   int       `json:"last_episode"`
+	LastTitle     string    `json:"last_episode_title,omitempty"`
+	LastReleased  string    `json:"last_released,omitempty"`
+	LastCheckedAt time.Time `json:"last_checked_at"`
+	LastError     string    `json:"last_error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type releasedEpisode struct {
+	SeriesID string `json:"series_id"`
+	Series   string `json:"series"`
+	Season   int    `json:"season"`
+	Episode  int    `json:"episode"`
+	Title    string `json:"title"`
+	Released string `json:"released"`
+	IMDBID   string `json:"imdbID"`
+}
+
+func followKey(userID, seriesID string) string {
+	return userID + "/" + seriesID
+}
+
+// releasedEpisodesAfter returns episodes of the series released on or
+// before now that come after (season, episode), in airing order. It reads
+// the latest two seasons only, which covers everything a periodic check
+// can miss. Season pages are evicted from the cache first so the check
+// sees fresh data. scope carries the tenant and request of the lookups, as
+// from scopedParams.
+func releasedEpisodesAfter(scope map[string]string, series *MovieResponse, season, episode int, now time.Time) ([]releasedEpisode, error) {
+	total, err := strconv.Atoi(series.TotalSeasons)
+	if err != nil {
+		return nil, nil
+	}
+	var out []releasedEpisode
+	for s := max(season, total-1, 1); s <= total; s++ {
+		params := map[string]string{"i": series.IMDBID, "Season": strconv.Itoa(s)}
+		maps.Copy(params, scope)
+		upstreamCache.Delete(cacheKey(params))
+		resp, err := fetchSeason(params)
+		if err != nil {
+			return nil, err
+		}
+		for _, ep := range resp.Episodes {
+			n, _ := strconv.Atoi(ep.Episode)
+			released, err := time.Parse("2006-01-02", ep.Released)
+			if err != nil || released.After(now) {
+				continue
+			}
+			if s < season || (s == season && n <= episode) {
+				continue
+			}
+			out = append(out, releasedEpisode{
+				SeriesID: series.IMDBID,
+				Series:   series.Title,
+				Season:   s,
+				Episode:  n,
+				Title:    ep.Title,
+				Released: ep.Released,
+				IMDBID:   ep.IMDBID,
+			})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Season != out[j].Season {
+			return out[i].Season < out[j].Season
+		}
+		return out[i].Episode < out[j].Episode
+	})
+	return out, nil
+}
+
+func postFollow(c *gin.Context) {
+	var req struct {
+		SeriesID string `json:"series_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.SeriesID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide {"series_id": "tt..."}`})
+		return
+	}
+	series, err := fetchMovie(scopedParams(c, map[string]string{"i": req.SeriesID}))
+	if err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+	if series.Type != "series" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": series.Title + " is not a series"})
+		return
+	}
+
+	now := time.Now().UTC()
+	u := currentUser(c)
+	f := Follow{UserID: u.ID, TenantID: u.TenantID, SeriesID: series.IMDBID, Title: series.Title, CreatedAt: now, LastCheckedAt: now}
+	episodes, err := releasedEpisodesAfter(scopedParams(c, map[string]string{}), series, 0, 0, now)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "could not read episodes: " + err.Error()})
+		return
+	}
+	if len(episodes) > 0 {
+		latest := episodes[len(episodes)-1]
+		f.LastSeason, f.LastEpisode, f.LastTitle, f.LastReleased = latest.Season, latest.Episode, latest.Title, latest.Released
+	}
+	if err := store.Put(followsBucket, followKey(f.UserID, f.SeriesID), f); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save follow"})
+		return
+	}
+	c.JSON(http.StatusCreated, f)
+}
+
+func getFollows(c *gin.Context) {
+	follows := []Follow{}
+	store.ForEachPrefix(followsBucket, currentUser(c).ID+"/", func(_ string, value []byte) error {
+		var f Follow
+		if json.Unmarshal(value, &f) == nil {
+			follows = append(follows, f)
+		}
+		return nil
+	})
+	c.JSON(http.StatusOK, follows)
+}
+
+func deleteFollow(c *gin.Context) {
+	if err := store.Delete(followsBucket, followKey(currentUser(c).ID, c.Param("id"))); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete follow"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// checkFollows runs checkAllFollows on a fixed schedule.
+func checkFollows(every time.Duration) {
+	for range time.Tick(every) {
+		leaderOnly("follows", checkAllFollows)
+	}
+}
+
+// checkAllFollows looks for newly released episodes of every followed
+// series. Each series is only fetched once per run and tenant however many
+// users follow it.
+func checkAllFollows() {
+	follows := map[string][]Follow{}
+	store.ForEach(followsBucket, func(key string, value []byte) error {
+		var f Follow
+		if json.Unmarshal(value, &f) == nil {
+			f.UserID, _, _ = strings.Cut(key, "/")
+			k := tenantKey(f.TenantID, f.SeriesID)
+			follows[k] = append(follows[k], f)
+		}
+		return nil
+	})
+
+	now := time.Now().UTC()
+	announced := map[string]bool{}
+	for _, followers := range follows {
+		seriesID, scope := followers[0].SeriesID, map[string]string{}
+		if tenant := followers[0].TenantID; tenant != "" {
+			scope[tenantParam] = tenant
+		}
+		params := map[string]string{"i": seriesID}
+		maps.Copy(params, scope)
+		upstreamCache.Delete(cacheKey(params))
+		series, seriesErr := fetchMovie(params)
+		for _, f := range followers {
+			f.LastCheckedAt = now
+			f.LastError = ""
+			episodes, err := []releasedEpisode(nil), seriesErr
+			if err == nil {
+				episodes, err = releasedEpisodesAfter(scope, series, f.LastSeason, f.LastEpisode, now)
+			}
+			if err != nil {
+				f.LastError = err.Error()
+				slog.Warn("follow check failed", "series", seriesID, "error", err)
+			}
+			for _, ep := range episodes {
+				if !announced[ep.IMDBID] {
+					announced[ep.IMDBID] = true
+					announce(eventSeriesNewEpisode, fmt.Sprintf("New episode of %s: S%02dE%02d %q (%s)", ep.Series, ep.Season, ep.Episode, ep.Title, ep.Released))
+				}
+				notify(f.UserID, eventSeriesNewEpisode, ep)
+				f.LastSeason, f.LastEpisode, f.LastTitle, f.LastReleased = ep.Season, ep.Episode, ep.Title, ep.Released
+			}
+			store.Put(followsBucket, followKey(f.UserID, f.SeriesID), f)
+		}
+	}
+}
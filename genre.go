@@ -0,0 +1,541 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Genre seed strategies. OMDb can't list titles by genre, so the genre
+// endpoint searches for seed words and filters the hits.
+const (
+	// seedsStopwords searches for common title words.
+	seedsStopwords = "stopwords"
+	// seedsPopular searches for words from the most-voted titles of the
+	// genre we already know about, which finds titles in any language the
+	// catalog has seen.
+	seedsPopular = "popular"
+	// seedsMixed uses popular seeds first, then stopwords.
+	seedsMixed = "mixed"
+)
+
+type GenreConfig struct {
+	Strategy string `json:"strategy"`
+	// Seeds are the stopword seeds; PerGenre replaces them for a genre
+	// (keys are matched case-insensitively).
+	Seeds        []string            `json:"seeds"`
+	PerGenre     map[string][]string `json:"per_genre"`
+	PagesPerSeed int                 `json:"pages_per_seed"`
+	// PopularSeeds caps how many seeds the popular strategy derives.
+	PopularSeeds int `json:"popular_seeds"`
+	// CacheTTL is how long a computed genre list is served before it is
+	// rebuilt.
+	CacheTTL Duration `json:"cache_ttl"`
+}
+
+var defaultGenreSeeds = []string{
+	"the", "a", "love", "man", "girl", "night", "day", "war", "life", "death",
+	"hero", "king", "queen", "dark", "light", "red", "black", "white", "green",
+	"star", "moon", "sun", "fire", "water", "blood", "heart", "soul", "time",
+	"world", "house", "home", "city", "road", "story", "game", "fight", "power",
+}
+
+func defaultGenreConfig() GenreConfig {
+	return GenreConfig{
+		Strategy:     seedsStopwords,
+		Seeds:        defaultGenreSeeds,
+		PerGenre:     map[string][]string{},
+		PagesPerSeed: 5,
+		PopularSeeds: 20,
+		CacheTTL:     Duration(6 * time.Hour),
+	}
+}
+
+// genreSeeds returns the search seeds for genre under the configured
+// strategy, without duplicates.
+func genreSeeds(genre string) []string {
+	gc := cfg().Genre
+	stopwords := stopwordSeeds(genre)
+	switch gc.Strategy {
+	case seedsPopular:
+		if seeds := popularSeeds(genre, gc.PopularSeeds); len(seeds) > 0 {
+			return dedupeSeeds(seeds, nil)
+		}
+		return dedupeSeeds(stopwords, nil)
+	case seedsMixed:
+		return dedupeSeeds(append(popularSeeds(genre, gc.PopularSeeds), stopwords...), nil)
+	}
+	return dedupeSeeds(stopwords, nil)
+}
+
+// moreGenreSeeds returns the seeds of every strategy that are not in used,
+// for widening a search that found too little.
+func moreGenreSeeds(genre string, used []string) []string {
+	return dedupeSeeds(append(popularSeeds(genre, cfg().Genre.PopularSeeds), stopwordSeeds(genre)...), used)
+}
+
+func stopwordSeeds(genre string) []string {
+	gc := cfg().Genre
+	for name, seeds := range gc.PerGenre {
+		if strings.EqualFold(name, genre) {
+			return seeds
+		}
+	}
+	return gc.Seeds
+}
+
+// dedupeSeeds drops repeated seeds and those in skip, ignoring case.
+func dedupeSeeds(seeds, skip []string) []string {
+	seen := map[string]bool{}
+	for _, s := range skip {
+		seen[strings.ToLower(s)] = true
+	}
+	out := make([]string, 0, len(seeds))
+	for _, s := range seeds {
+		key := strings.ToLower(s)
+		if !seen[key] {
+			seen[key] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// popularSeeds takes the distinctive words from the titles of the most
+// voted catalog titles in genre.
+func popularSeeds(genre string, limit int) []string {
+	titles := catalog.All(func(m *MovieResponse) bool {
+		return m.Type != "episode" && containsFold(m.Genre, genre)
+	})
+	sort.Slice(titles, func(i, j int) bool {
+		vi, vj := parseVotes(titles[i].IMDBVotes), parseVotes(titles[j].IMDBVotes)
+		if vi != vj {
+			return vi > vj
+		}
+		return titles[i].IMDBID < titles[j].IMDBID
+	})
+
+	stop := map[string]bool{}
+	for _, w := range defaultGenreSeeds {
+		stop[w] = true
+	}
+	var seeds []string
+	seen := map[string]bool{}
+	for _, m := range titles {
+		for _, word := range strings.FieldsFunc(m.Title, func(r rune) bool {
+			return r == ' ' || r == ':' || r == '-' || r == ',' || r == '.' || r == '!' || r == '?'
+		}) {
+			w := strings.ToLower(word)
+			if len([]rune(w)) < 3 || stop[w] || seen[w] {
+				continue
+			}
+			seen[w] = true
+			seeds = append(seeds, w)
+			if len(seeds) >= limit {
+				return seeds
+			}
+		}
+	}
+	return seeds
+}
+
+// genreTitle is one entry of a genre list.
+type genreTitle struct {
+	Title      string `json:"Title"`
+	Year       string `json:"Year"`
+	Genre      string `json:"Genre"`
+	IMDBRating string `json:"imdbRating"`
+	IMDBVotes  string `json:"imdbVotes"`
+	// WeightedRating is filled in when the list is served, as the prior
+	// can change.
+	WeightedRating float64 `json:"weighted_rating,omitempty"`
+	IMDBID         string  `json:"imdbID"`
+	criticFields
+	Country  string `json:"Country"`
+	Language string `json:"Language"`
+	// BroadenedVia is the related genre a title was let in for when a
+	// sparse list was broadened.
+	BroadenedVia string `json:"broadened_via,omitempty"`
+}
+
+func (t genreTitle) key(by string) ratingKey {
+	return sortKeyOf(by, t.IMDBRating, t.IMDBVotes, t.IMDBID)
+}
+
+// genreResult is a computed genre list. A list built while some lookups
+// failed is incomplete: it is only served until a complete one replaces it,
+// and never replaces a complete one.
+type genreResult struct {
+	Titles     []genreTitle
+	ComputedAt time.Time
+	Complete   bool
+	// Target is how many titles the computation tried to reach.
+	Target    int
+	Deepening *deepening
+}
+
+// partialGenreTTL is how long an incomplete list is kept when there is no
+// complete one to fall back on.
+const partialGenreTTL = time.Minute
+
+// maxGenreLists bounds how many computed lists are kept. The key takes
+// the filters as the client sent them, so without a bound every distinct
+// combination would stay in memory for good.
+const maxGenreLists = 500
+
+var genreResults = struct {
+	sync.Mutex
+	lists map[string]*genreResult
+	// locks holds a lock for each list being computed or waited for, and
+	// is emptied as they finish.
+	locks map[string]*genreLock
+}{lists: map[string]*genreResult{}, locks: map[string]*genreLock{}}
+
+type genreLock struct {
+	sync.Mutex
+	users int
+}
+
+// storeGenreList keeps r under key, first dropping expired lists and then,
+// if still full, the oldest. genreResults must be locked.
+func storeGenreList(key string, r *genreResult) {
+	if _, ok := genreResults.lists[key]; !ok && len(genreResults.lists) >= maxGenreLists {
+		for k, l := range genreResults.lists {
+			if time.Since(l.ComputedAt) >= genreTTL(l) {
+				delete(genreResults.lists, k)
+			}
+		}
+		for len(genreResults.lists) >= maxGenreLists {
+			oldest := ""
+			for k, l := range genreResults.lists {
+				if oldest == "" || l.ComputedAt.Before(genreResults.lists[oldest].ComputedAt) {
+					oldest = k
+				}
+			}
+			delete(genreResults.lists, oldest)
+		}
+	}
+	genreResults.lists[key] = r
+}
+
+// genreList returns the list for genre, rebuilding it if it has expired or
+// was built for a smaller target and came up short. Concurrent requests for
+// the same list wait for a single rebuild.
+func genreList(c *gin.Context, q genreQuery, target int) *genreResult {
+	key := tenantKey(currentPrincipal(c).Tenant, strings.ToLower(strings.Join(
+		[]string{q.Genre, q.Country, q.Language, strconv.Itoa(q.MinMetascore), strconv.Itoa(q.MinRT),
+			strconv.FormatBool(q.Broaden), cfg().Genre.Strategy}, "|")))
+	if costOf(c).usesFixtures() {
+		key = fixturesCachePrefix + key
+	}
+
+	genreResults.Lock()
+	lock, ok := genreResults.locks[key]
+	if !ok {
+		lock = &genreLock{}
+		genreResults.locks[key] = lock
+	}
+	lock.users++
+	genreResults.Unlock()
+
+	lock.Lock()
+	defer func() {
+		lock.Unlock()
+		genreResults.Lock()
+		if lock.users--; lock.users == 0 {
+			delete(genreResults.locks, key)
+		}
+		genreResults.Unlock()
+	}()
+
+	genreResults.Lock()
+	prev := genreResults.lists[key]
+	genreResults.Unlock()
+	trace := costOf(c).trace()
+	if prev != nil && time.Since(prev.ComputedAt) < genreTTL(prev) &&
+		(prev.Target >= target || len(prev.Titles) >= target) {
+		trace.cached("genre_list:"+key, "hit", time.Since(prev.ComputedAt))
+		return prev
+	}
+
+	done := trace.timed("compute_genre_list")
+	next := computeGenre(c, q, target)
+	done()
+	if costOf(c).isDryRun() {
+		return next
+	}
+	if !next.Complete && prev != nil && prev.Complete {
+		trace.cached("genre_list:"+key, "kept_complete_over_partial", time.Since(prev.ComputedAt))
+		return prev
+	}
+	trace.cached("genre_list:"+key, "computed", 0)
+	genreResults.Lock()
+	storeGenreList(key, next)
+	genreResults.Unlock()
+	return next
+}
+
+func genreTTL(r *genreResult) time.Duration {
+	if !r.Complete {
+		return partialGenreTTL
+	}
+	return time.Duration(cfg().Genre.CacheTTL)
+}
+
+// computeGenre searches the seeds and keeps the titles tagged with the
+// genre that pass the list filters, best rated first, ties broken by
+// votes and imdbID so the order is stable. If that finds fewer than target
+// titles it searches deeper pages, then more seeds, then lets unrated
+// titles in, as far as the upstream budget goes. With q.Broaden set it
+// finally lets in titles of the genres that most often go with this one.
+func computeGenre(c *gin.Context, q genreQuery, target int) *genreResult {
+	genre, filter := q.Genre, q.titleFilter
+	result := &genreResult{Titles: []genreTitle{}, Complete: true, Target: target}
+	d := newDeepening(c, cfg().Deepening.GenreBudget)
+	result.Deepening = d
+	seen := make(map[string]bool)
+	unrated := []genreTitle{}
+	broadened := []genreTitle{}
+	var related []relatedGenre
+	if q.Broaden {
+		related = relatedGenres(genre, 2)
+	}
+
+	// scan searches seeds over pages from..to. Unless full is set it stops
+	// as soon as target is reached.
+	scan := func(seeds []string, from, to int, full bool) {
+		for _, seed := range seeds {
+			for page := from; page <= to; page++ {
+				if !full && len(result.Titles) >= target {
+					return
+				}
+				results, err := d.search(seed, page)
+				if errors.Is(err, errBudgetSpent) {
+					return
+				}
+				if isNotFound(err) {
+					break // past the last page
+				}
+				if err != nil {
+					result.Complete = false
+					continue
+				}
+
+				var ids []string
+				for _, item := range results.Search {
+					if !seen[item.IMDBID] {
+						seen[item.IMDBID] = true
+						ids = append(ids, item.IMDBID)
+					}
+				}
+				movies, errs := d.movies(genreLookups, ids)
+				for i := range ids {
+					movie, err := movies[i], errs[i]
+					if errors.Is(err, errBudgetSpent) {
+						return
+					}
+					if err != nil {
+						result.Complete = false
+						continue
+					}
+					if !d.matches(filter, movie) {
+						continue
+					}
+					via := ""
+					if !containsFold(movie.Genre, genre) {
+						for _, g := range related {
+							if containsFold(movie.Genre, g.Genre) {
+								via = g.Genre
+								break
+							}
+						}
+						costOf(c).trace().filtered("genre", genre, via != "")
+						if via == "" {
+							continue
+						}
+					}
+					t := genreTitle{
+						Title:        movie.Title,
+						Year:         movie.Year,
+						Genre:        movie.Genre,
+						IMDBRating:   movie.IMDBRating,
+						IMDBVotes:    movie.IMDBVotes,
+						IMDBID:       movie.IMDBID,
+						Country:      movie.Country,
+						Language:     movie.Language,
+						criticFields: criticFieldsOf(movie),
+					}
+					if via != "" {
+						if _, rated := parseRating(movie.IMDBRating); rated {
+							t.BroadenedVia = via
+							broadened = append(broadened, t)
+						}
+						continue
+					}
+					if _, rated := parseRating(movie.IMDBRating); !rated {
+						unrated = append(unrated, t)
+						continue
+					}
+					result.Titles = append(result.Titles, t)
+					d.found()
+				}
+			}
+		}
+	}
+
+	seeds := genreSeeds(genre)
+	pages := cfg().Genre.PagesPerSeed
+	d.stage("seeds")
+	scan(seeds, 1, pages, true)
+	if maxPages := cfg().Deepening.MaxPages; len(result.Titles) < target && maxPages > pages && !d.exhausted() {
+		d.stage("more_pages")
+		scan(seeds, pages+1, maxPages, false)
+	}
+	if more := moreGenreSeeds(genre, seeds); len(result.Titles) < target && len(more) > 0 && !d.exhausted() {
+		d.stage("more_seeds")
+		scan(more, 1, pages, false)
+	}
+	if len(result.Titles) < target && len(unrated) > 0 {
+		d.stage("unrated")
+		for _, t := range unrated {
+			result.Titles = append(result.Titles, t)
+			d.found()
+		}
+	}
+	if len(result.Titles) < target && len(broadened) > 0 {
+		d.stage("related_genres")
+		for _, t := range broadened {
+			result.Titles = append(result.Titles, t)
+			d.found()
+		}
+	}
+
+	sort.Slice(result.Titles, func(i, j int) bool {
+		return result.Titles[i].key(sortRating).before(result.Titles[j].key(sortRating))
+	})
+	result.ComputedAt = time.Now().UTC()
+	if costOf(c).isTruncated() {
+		result.Complete = false
+	}
+	d.c = nil // the list outlives the request
+	return result
+}
+
+func getMoviesByGenre(c *gin.Context) {
+	if estimating(c, getMoviesByGenre) {
+		return
+	}
+	var q genreQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	list := genreList(c, q, max(q.Limit, cfg().Deepening.MinResults))
+	// The cached list is shared; sort a copy the way this request wants.
+	titles := slices.Clone(list.Titles)
+	for i, t := range titles {
+		if r, ok := parseRating(t.IMDBRating); ok {
+			titles[i].WeightedRating = weightedRating(r, parseVotes(t.IMDBVotes))
+		}
+	}
+	sort.SliceStable(titles, func(i, j int) bool { return titles[i].key(q.Sort).before(titles[j].key(q.Sort)) })
+	c.Header("X-Computed-At", list.ComputedAt.Format(time.RFC3339))
+	c.Header("X-Upstream-Budget", fmt.Sprintf("%d/%d", list.Deepening.Spent, list.Deepening.Budget))
+
+	total := len(titles)
+	meta := listMeta{Total: total, ComputedAt: list.ComputedAt, Deepening: list.Deepening, Warnings: list.Deepening.Warnings}
+	if q.Limit == 0 && q.Cursor == "" {
+		costOf(c).trace().narrowed("limit", 15, len(titles), min(len(titles), 15))
+		if len(titles) > 15 {
+			titles = titles[:15]
+		}
+		respondList(c, http.StatusOK, titles, meta, titles)
+		return
+	}
+
+	// Cursor pagination: resume after the last title returned.
+	if q.Limit == 0 {
+		q.Limit = 15
+	}
+	if q.Cursor != "" {
+		var cur ratingCursor
+		if err := decodeCursor(q.Cursor, &cur); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		after := ratingKey{Rating: cur.Rating, Rated: !cur.Unrated, Votes: cur.Votes, ID: cur.ID}
+		i := sort.Search(len(titles), func(i int) bool { return after.before(titles[i].key(q.Sort)) })
+		costOf(c).trace().narrowed("cursor", cur, len(titles), len(titles)-i)
+		titles = titles[i:]
+	}
+	resp := gin.H{"items": titles}
+	costOf(c).trace().narrowed("limit", q.Limit, len(titles), min(len(titles), q.Limit))
+	if len(titles) > q.Limit {
+		last := titles[q.Limit-1]
+		titles = titles[:q.Limit]
+		k := last.key(q.Sort)
+		meta.NextCursor = encodeCursor(ratingCursor{Rating: k.Rating, Unrated: !k.Rated, Votes: k.Votes, ID: k.ID})
+		resp["items"], resp["next_cursor"] = titles, meta.NextCursor
+	}
+	respondList(c, http.StatusOK, titles, meta, resp)
+}
+
+// relatedGenre is how often another genre is tagged alongside a genre in
+// the catalog. Share is the fraction of the genre's titles that have it.
+type relatedGenre struct {
+	Genre string  `json:"genre"`
+	Count int     `json:"count"`
+	Share float64 `json:"share"`
+}
+
+// relatedGenres returns the genres that co-occur most with genre in the
+// title catalog, most frequent first.
+func relatedGenres(genre string, limit int) []relatedGenre {
+	counts := map[string]int{}
+	total := 0
+	for _, m := range catalog.All(func(m *MovieResponse) bool {
+		return m.Type != "episode" && containsFold(m.Genre, genre)
+	}) {
+		total++
+		for _, g := range strings.Split(m.Genre, ",") {
+			if g = strings.TrimSpace(g); g != "" && g != "N/A" && !strings.EqualFold(g, genre) {
+				counts[g]++
+			}
+		}
+	}
+
+	related := make([]relatedGenre, 0, len(counts))
+	for g, n := range counts {
+		related = append(related, relatedGenre{Genre: g, Count: n, Share: roundTo(float64(n)/float64(total), 2)})
+	}
+	sort.Slice(related, func(i, j int) bool {
+		if related[i].Count != related[j].Count {
+			return related[i].Count > related[j].Count
+		}
+		return related[i].Genre < related[j].Genre
+	})
+	if len(related) > limit {
+		related = related[:limit]
+	}
+	return related
+}
+
+func getRelatedGenres(c *gin.Context) {
+	var q relatedGenresQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	costOf(c).fromDataset()
+	setProvenance(c)
+	c.JSON(http.StatusOK, gin.H{
+		"genre":   q.Genre,
+		"related": relatedGenres(q.Genre, q.Limit),
+	})
+}
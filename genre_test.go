@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGenreListsAreBounded(t *testing.T) {
+	genreResults.Lock()
+	defer genreResults.Unlock()
+	saved := genreResults.lists
+	genreResults.lists = map[string]*genreResult{}
+	defer func() { genreResults.lists = saved }()
+
+	now := time.Now()
+	for i := range maxGenreLists + 10 {
+		storeGenreList("list"+strconv.Itoa(i), &genreResult{Complete: true, ComputedAt: now.Add(time.Duration(i) * time.Second)})
+	}
+	if n := len(genreResults.lists); n != maxGenreLists {
+		t.Fatalf("kept %d lists, want %d", n, maxGenreLists)
+	}
+	if _, ok := genreResults.lists["list0"]; ok {
+		t.Error("the oldest list was kept")
+	}
+	if _, ok := genreResults.lists["list"+strconv.Itoa(maxGenreLists+9)]; !ok {
+		t.Error("the newest list was dropped")
+	}
+
+	genreResults.lists["list20"].Complete = false
+	genreResults.lists["list20"].ComputedAt = now.Add(-time.Hour)
+	storeGenreList("another", &genreResult{Complete: true, ComputedAt: now.Add(time.Hour)})
+	if _, ok := genreResults.lists["list20"]; ok {
+		t.Error("an expired list was kept over the oldest live one")
+	}
+	if _, ok := genreResults.lists["list10"]; !ok {
+		t.Error("a live list was dropped while an expired one could go")
+	}
+}
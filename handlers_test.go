@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type handlerTest struct {
+	name    string
+	method  string
+	path    string
+	headers map[string]string
+	body    string
+
+	wantStatus int
+	// wantJSON holds top-level fields of the response and their expected
+	// values, compared as printed by fmt.
+	wantJSON map[string]string
+	// wantKeys and wantNoKeys are top-level fields that must be present
+	// or absent.
+	wantKeys   []string
+	wantNoKeys []string
+	// wantHeaders holds response headers and their expected values.
+	wantHeaders map[string]string
+	// wantLookups is how many lookups should reach the provider; -1 skips
+	// the check.
+	wantLookups int
+}
+
+// request sends one request to the in-process server.
+func request(method, path string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	if method == "" {
+		method = http.MethodGet
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	testServer.ServeHTTP(w, req)
+	return w
+}
+
+// runHandlerTests runs the cases in order on an empty cache; later cases
+// may rely on what earlier ones cached.
+func runHandlerTests(t *testing.T, tests []handlerTest) {
+	t.Helper()
+	upstreamCache.Purge()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := fake.lookups()
+			w := request(tt.method, tt.path, tt.headers, tt.body)
+			lookups := fake.lookups() - before
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("%s %s: status %d, want %d; body %s", tt.method, tt.path, w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantLookups >= 0 && lookups != tt.wantLookups {
+				t.Errorf("%s: %d provider lookups, want %d", tt.path, lookups, tt.wantLookups)
+			}
+			if h := w.Header().Get("X-Upstream-Calls"); h != "" && h != strconv.Itoa(lookups) {
+				t.Errorf("%s: X-Upstream-Calls is %s, but the provider saw %d lookups", tt.path, h, lookups)
+			}
+			for k, want := range tt.wantHeaders {
+				if got := w.Header().Get(k); got != want {
+					t.Errorf("%s: %s is %q, want %q", tt.path, k, got, want)
+				}
+			}
+			if len(tt.wantJSON)+len(tt.wantKeys)+len(tt.wantNoKeys) == 0 {
+				return
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("%s: response is not a JSON object: %v; body %s", tt.path, err, w.Body)
+			}
+			for k, want := range tt.wantJSON {
+				if v, ok := got[k]; !ok || fmt.Sprint(v) != want {
+					t.Errorf("%s: %s = %v, want %s", tt.path, k, v, want)
+				}
+			}
+			for _, k := range tt.wantKeys {
+				if _, ok := got[k]; !ok {
+					t.Errorf("%s: response has no %s; body %s", tt.path, k, w.Body)
+				}
+			}
+			for _, k := range tt.wantNoKeys {
+				if _, ok := got[k]; ok {
+					t.Errorf("%s: response has %s, want it left out; body %s", tt.path, k, w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestMovieHandler(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "by id",
+			path:        "/api/movie?id=tt0133093",
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"Title": "The Matrix", "Year": "1999"},
+			wantNoKeys:  []string{"Type", "imdbID"},
+			wantLookups: 1,
+		},
+		{
+			name:        "cached",
+			path:        "/api/movie?id=tt0133093",
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"Title": "The Matrix"},
+			wantLookups: 0,
+		},
+		{
+			name:        "schema 2 adds the title card fields",
+			path:        "/api/movie?id=tt0133093",
+			headers:     map[string]string{"X-API-Version": "2"},
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"Type": "movie", "imdbID": "tt0133093", "Genre": "Action, Sci-Fi"},
+			wantLookups: 0,
+		},
+		{
+			name:        "by title",
+			path:        "/api/movie?title=The+Godfather",
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"Title": "The Godfather", "Director": "Francis Ford Coppola"},
+			wantLookups: 1,
+		},
+		{
+			name:        "unknown id",
+			path:        "/api/movie?id=tt0000404",
+			wantStatus:  http.StatusNotFound,
+			wantJSON:    map[string]string{"code": "not_found"},
+			wantLookups: 1,
+		},
+		{
+			name:        "OMDb unavailable",
+			path:        "/api/movie?id=tt0000502",
+			wantStatus:  http.StatusBadGateway,
+			wantJSON:    map[string]string{"code": "upstream_unavailable"},
+			wantLookups: 1,
+		},
+		{
+			name:        "no title or id",
+			path:        "/api/movie",
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+		{
+			name:        "malformed id",
+			path:        "/api/movie?id=0133093",
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+		{
+			name:        "unknown schema",
+			path:        "/api/movie?id=tt0133093",
+			headers:     map[string]string{"X-API-Version": "9"},
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+	})
+}
+
+func TestSearchHandler(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "v1 shape",
+			path:        "/api/search?q=matrix",
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"totalResults": "1"},
+			wantKeys:    []string{"Search"},
+			wantNoKeys:  []string{"data"},
+			wantLookups: 1,
+		},
+		{
+			name:        "schema 3 envelope",
+			path:        "/api/search?q=matrix",
+			headers:     map[string]string{"X-API-Version": "3"},
+			wantStatus:  http.StatusOK,
+			wantKeys:    []string{"data", "meta"},
+			wantNoKeys:  []string{"Search"},
+			wantLookups: 0,
+		},
+		{
+			name:        "nothing found",
+			path:        "/api/search?q=zzzz",
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 1,
+		},
+		{
+			name:        "no query",
+			path:        "/api/search",
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+	})
+}
+
+func TestAuthentication(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "unknown API key",
+			path:        "/api/movie?id=tt0133093",
+			headers:     map[string]string{"X-API-Key": "mk_nope"},
+			wantStatus:  http.StatusUnauthorized,
+			wantLookups: 0,
+		},
+		{
+			name:        "bad bearer token",
+			path:        "/api/movie?id=tt0133093",
+			headers:     map[string]string{"Authorization": "Bearer nope"},
+			wantStatus:  http.StatusUnauthorized,
+			wantLookups: 0,
+		},
+		{
+			name:        "admin route without credentials",
+			path:        "/admin/load",
+			wantStatus:  http.StatusUnauthorized,
+			wantLookups: 0,
+		},
+		{
+			name:        "admin route with the admin token",
+			path:        "/admin/load",
+			headers:     map[string]string{"Authorization": "Bearer " + testAdminToken},
+			wantStatus:  http.StatusOK,
+			wantLookups: 0,
+		},
+	})
+}
+
+// Routes that fan out to many lookups must still count each one in
+// X-Upstream-Calls.
+func TestUpstreamCallsHeader(t *testing.T) {
+	for _, path := range []string{
+		"/api/search/nl?q=heat&assist=false",
+		"/api/search/nl?q=godfather+crime&assist=false",
+	} {
+		before := fake.lookups()
+		w := request(http.MethodGet, path, nil, "")
+		lookups := fake.lookups() - before
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d; body %s", path, w.Code, w.Body)
+		}
+		if lookups == 0 {
+			t.Fatalf("%s: made no provider lookups, so there is nothing to count", path)
+		}
+		if h := w.Header().Get("X-Upstream-Calls"); h != strconv.Itoa(lookups) {
+			t.Errorf("%s: X-Upstream-Calls is %q, but the provider saw %d lookups", path, h, lookups)
+		}
+	}
+}
+
+func TestFixtureRoutes(t *testing.T) {
+	admin := map[string]string{"Authorization": "Bearer " + testAdminToken}
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "index",
+			path:        "/testing",
+			wantStatus:  http.StatusOK,
+			wantKeys:    []string{"titles", "routes"},
+			wantLookups: 0,
+		},
+		{
+			name:        "fixture title",
+			path:        "/testing/api/movie?id=tt0903747",
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"Title": "Breaking Bad"},
+			wantLookups: 0,
+		},
+		{
+			name:        "title only the live provider has",
+			path:        "/testing/api/movie?id=tt0113277",
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "natural language search",
+			path:        "/testing/api/search/nl?q=godfather&assist=false",
+			wantStatus:  http.StatusOK,
+			wantLookups: 0,
+		},
+		{
+			name:        "admin route",
+			path:        "/testing/admin/load",
+			headers:     admin,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "write route",
+			method:      http.MethodPost,
+			path:        "/testing/api/shorten",
+			body:        `{"imdb_id":"tt0133093"}`,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+	})
+	if memoryCache.TTL(cacheKey(map[string]string{"i": "tt0903747"})) != 0 {
+		t.Error("a fixture answer was cached as a live one")
+	}
+	if memoryCache.TTL(fixturesCachePrefix+cacheKey(map[string]string{"i": "tt0903747"})) == 0 {
+		t.Error("the fixture answer wasn't cached under " + fixturesCachePrefix)
+	}
+}
+
+func TestRecommendationsHandler(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "favorite found",
+			path:        "/api/movies/recommendations?favorite_movie=The+Matrix",
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"favorite_movie_id": "tt0133093"},
+			wantKeys:    []string{"recommendations", "variant"},
+			wantLookups: -1,
+		},
+		{
+			name:        "favorite unknown",
+			path:        "/api/movies/recommendations?favorite_movie=Nothing+Like+It",
+			wantStatus:  http.StatusNotFound,
+			wantJSON:    map[string]string{"code": "not_found"},
+			wantLookups: 1,
+		},
+		{
+			name:        "OMDb unavailable",
+			path:        "/api/movies/recommendations?favorite_movie=Unreachable",
+			wantStatus:  http.StatusBadGateway,
+			wantJSON:    map[string]string{"code": "upstream_unavailable"},
+			wantLookups: 1,
+		},
+		{
+			name:        "no favorite",
+			path:        "/api/movies/recommendations",
+			wantStatus:  http.StatusBadRequest,
+			wantJSON:    map[string]string{"fields": "[map[field:favorite_movie message:is required]]"},
+			wantLookups: 0,
+		},
+		{
+			name:        "invalid filter",
+			path:        "/api/movies/recommendations?favorite_movie=Heat&min_rt=101",
+			wantStatus:  http.StatusBadRequest,
+			wantJSON:    map[string]string{"fields": "[map[field:min_rt message:must be at most 100]]"},
+			wantLookups: 0,
+		},
+	})
+}
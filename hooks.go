@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"plugin"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResponseHook rewrites successful JSON responses after the handler has
+// built them, so a fork can add or drop fields without patching every
+// handler. Register one from an init function in a file of its own, or
+// build it as a Go plugin (see loadHookPlugins).
+type ResponseHook interface {
+	// Routes lists the route patterns the hook applies to, as registered,
+	// e.g. "/api/movie" or "/api/lists/:id". None means every route.
+	Routes() []string
+	// Transform gets the decoded body (maps, slices, strings, bools and
+	// json.Numbers) and returns what to send instead; it may change
+	// payload in place and return it. An error fails the request rather
+	// than sending a body the hook didn't vet.
+	Transform(c *gin.Context, payload interface{}) (interface{}, error)
+}
+
+type namedHook struct {
+	name string
+	hook ResponseHook
+}
+
+// responseHooks run in registration order. They are registered during
+// startup only, so reading them needs no lock.
+var responseHooks []namedHook
+
+func registerResponseHook(name string, hook ResponseHook) {
+	responseHooks = append(responseHooks, namedHook{name, hook})
+}
+
+func hooksFor(route string) []namedHook {
+	var out []namedHook
+	for _, h := range responseHooks {
+		if routes := h.hook.Routes(); len(routes) == 0 || slices.Contains(routes, route) {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// transformResponses runs the response hooks for the route over its
+// successful JSON responses.
+func transformResponses(c *gin.Context) {
+	hooks := hooksFor(c.FullPath())
+	if len(hooks) == 0 {
+		c.Next()
+		return
+	}
+	w := &heldJSONWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+	if w.buf.Len() == 0 {
+		return
+	}
+	payload, ok := w.payload()
+	if !ok {
+		w.ResponseWriter.Write(w.buf.Bytes())
+		return
+	}
+	for _, h := range hooks {
+		var err error
+		if payload, err = h.hook.Transform(c, payload); err != nil {
+			slog.Error("response hook failed", "hook", h.name, "route", c.FullPath(), "error", err)
+			w.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+			w.ResponseWriter.Write([]byte(`{"error":"could not prepare the response"}`))
+			return
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("could not encode hooked response", "route", c.FullPath(), "error", err)
+		body = w.buf.Bytes()
+	}
+	w.ResponseWriter.Write(body)
+}
+
+// heldJSONWriter holds back successful JSON bodies so middleware can
+// rewrite them once the handler is done; everything else goes straight
+// through.
+type heldJSONWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *heldJSONWriter) holding() bool {
+	return w.Status() < http.StatusBadRequest &&
+		strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+}
+
+func (w *heldJSONWriter) Write(b []byte) (int, error) {
+	if w.holding() {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *heldJSONWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// payload decodes the held body, keeping numbers as written.
+func (w *heldJSONWriter) payload() (interface{}, bool) {
+	dec := json.NewDecoder(bytes.NewReader(w.buf.Bytes()))
+	dec.UseNumber()
+	var payload interface{}
+	return payload, dec.Decode(&payload) == nil
+}
+
+// ResponseHooksConfig configures the built-in hooks.
+type ResponseHooksConfig struct {
+	// StripFields maps a route pattern to top-level fields to drop from
+	// its responses, and from each item of an enveloped "data" list.
+	StripFields map[string][]string `json:"strip_fields"`
+}
+
+// stripFieldsHook drops the configured fields. It reads the config on
+// every response, so a reload applies to the routes it was registered
+// for at startup.
+type stripFieldsHook struct {
+	routes []string
+}
+
+func (h stripFieldsHook) Routes() []string { return h.routes }
+
+func (h stripFieldsHook) Transform(c *gin.Context, payload interface{}) (interface{}, error) {
+	fields := cfg().ResponseHooks.StripFields[c.FullPath()]
+	strip := func(v interface{}) {
+		if m, ok := v.(map[string]interface{}); ok {
+			for _, f := range fields {
+				delete(m, f)
+			}
+		}
+	}
+	strip(payload)
+	if m, ok := payload.(map[string]interface{}); ok {
+		if items, ok := m["data"].([]interface{}); ok {
+			for _, item := range items {
+				strip(item)
+			}
+		} else {
+			strip(m["data"])
+		}
+	}
+	return payload, nil
+}
+
+// pluginHook adapts a Go plugin exporting
+//
+//	func TransformResponse(route string, payload interface{}) (interface{}, error)
+//
+// and optionally "var HookRoutes []string". Plugins can't import this
+// package, so the contract is spelled in builtin types only.
+type pluginHook struct {
+	routes    []string
+	transform func(string, interface{}) (interface{}, error)
+}
+
+func (h pluginHook) Routes() []string { return h.routes }
+
+func (h pluginHook) Transform(c *gin.Context, payload interface{}) (interface{}, error) {
+	return h.transform(c.FullPath(), payload)
+}
+
+// loadHookPlugins opens the comma-separated RESPONSE_HOOK_PLUGINS (.so
+// files built with go build -buildmode=plugin against this module).
+func loadHookPlugins(paths string) error {
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		p, err := plugin.Open(path)
+		if err != nil {
+			return fmt.Errorf("open hook plugin %s: %w", path, err)
+		}
+		sym, err := p.Lookup("TransformResponse")
+		if err != nil {
+			return fmt.Errorf("hook plugin %s: %w", path, err)
+		}
+		transform, ok := sym.(func(string, interface{}) (interface{}, error))
+		if !ok {
+			return fmt.Errorf("hook plugin %s: TransformResponse has the wrong signature", path)
+		}
+		h := pluginHook{transform: transform}
+		if sym, err := p.Lookup("HookRoutes"); err == nil {
+			if routes, ok := sym.(*[]string); ok {
+				h.routes = *routes
+			}
+		}
+		registerResponseHook(path, h)
+	}
+	return nil
+}
+
+// initResponseHooks registers the configured built-in hooks and the
+// plugins, after any hooks registered from init functions.
+func initResponseHooks() error {
+	if strip := cfg().ResponseHooks.StripFields; len(strip) > 0 {
+		routes := make([]string, 0, len(strip))
+		for route := range strip {
+			routes = append(routes, route)
+		}
+		registerResponseHook("strip_fields", stripFieldsHook{routes: routes})
+	}
+	initEnrichments()
+	return loadHookPlugins(envString("RESPONSE_HOOK_PLUGINS", ""))
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultLanguage = "en"
+
+// translationBundle holds one language. Messages are keyed by the English
+// text; a key may contain one {} placeholder, which matches any text and
+// is carried over into the translation. Labels name response fields for
+// clients that generate their UI.
+type translationBundle struct {
+	Messages map[string]string `json:"messages"`
+	Labels   map[string]string `json:"labels"`
+
+	patterns []string // Messages keys with a placeholder, longest first
+}
+
+var translations = map[string]*translationBundle{}
+
+// loadTranslations reads the bundles shipped in web/i18n, then any
+// <lang>.json in dir on top, entry by entry. Files in dir may add
+// languages as well as override strings.
+func loadTranslations(dir string) {
+	builtin, _ := fs.Sub(webFiles, "web/i18n")
+	readBundles(builtin)
+	if dir != "" {
+		readBundles(os.DirFS(dir))
+	}
+	for _, b := range translations {
+		b.patterns = b.patterns[:0]
+		for key := range b.Messages {
+			if strings.Count(key, "{}") == 1 {
+				b.patterns = append(b.patterns, key)
+			}
+		}
+		sort.Slice(b.patterns, func(i, j int) bool {
+			if len(b.patterns[i]) != len(b.patterns[j]) {
+				return len(b.patterns[i]) > len(b.patterns[j])
+			}
+			return b.patterns[i] < b.patterns[j]
+		})
+	}
+}
+
+func readBundles(fsys fs.FS) {
+	files, _ := fs.Glob(fsys, "*.json")
+	for _, name := range files {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			slog.Warn("could not read translations", "file", name, "error", err)
+			continue
+		}
+		var b translationBundle
+		if err := json.Unmarshal(data, &b); err != nil {
+			slog.Warn("could not parse translations", "file", name, "error", err)
+			continue
+		}
+		lang := strings.ToLower(strings.TrimSuffix(filepath.Base(name), ".json"))
+		into, ok := translations[lang]
+		if !ok {
+			into = &translationBundle{Messages: map[string]string{}, Labels: map[string]string{}}
+			translations[lang] = into
+		}
+		for k, v := range b.Messages {
+			into.Messages[k] = v
+		}
+		for k, v := range b.Labels {
+			into.Labels[k] = v
+		}
+	}
+}
+
+// translate returns msg in lang, or msg itself if there's no translation.
+func translate(lang, msg string) string {
+	b, ok := translations[lang]
+	if !ok {
+		return msg
+	}
+	if t, ok := b.Messages[msg]; ok {
+		return t
+	}
+	for _, key := range b.patterns {
+		prefix, suffix, _ := strings.Cut(key, "{}")
+		if len(msg) >= len(prefix)+len(suffix) && strings.HasPrefix(msg, prefix) && strings.HasSuffix(msg, suffix) {
+			arg := msg[len(prefix) : len(msg)-len(suffix)]
+			return strings.Replace(b.Messages[key], "{}", arg, 1)
+		}
+	}
+	return msg
+}
+
+// labels returns the labels of lang for fields, falling back to English
+// and then to the field name itself.
+func labels(lang string, fields []string) map[string]string {
+	out := make(map[string]string, len(fields))
+	for _, f := range fields {
+		out[f] = f
+		for _, l := range []string{defaultLanguage, lang} {
+			if b, ok := translations[l]; ok {
+				if label, ok := b.Labels[f]; ok {
+					out[f] = label
+				}
+			}
+		}
+	}
+	return out
+}
+
+// negotiateLanguage picks the response language from ?lang=, then
+// Accept-Language, among those there are bundles for.
+func negotiateLanguage(c *gin.Context) string {
+	if lang := strings.ToLower(c.Query("lang")); lang != "" {
+		if _, ok := translations[lang]; ok {
+			return lang
+		}
+		base, _, _ := strings.Cut(lang, "-")
+		if _, ok := translations[base]; ok {
+			return base
+		}
+	}
+
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var accepted []weighted
+	for _, part := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		accepted = append(accepted, weighted{base, q})
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+	for _, a := range accepted {
+		if _, ok := translations[a.lang]; ok && a.q > 0 {
+			return a.lang
+		}
+	}
+	return defaultLanguage
+}
+
+// localize negotiates the request language and translates the "error"
+// (and per-field "message") of JSON error responses into it, so handlers
+// keep writing English.
+func localize(c *gin.Context) {
+	lang := negotiateLanguage(c)
+	c.Set("lang", lang)
+	c.Header("Content-Language", lang)
+	if lang == defaultLanguage {
+		c.Next()
+		return
+	}
+
+	w := &translatingWriter{ResponseWriter: c.Writer, lang: lang}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+	w.flush()
+}
+
+func requestLanguage(c *gin.Context) string {
+	if lang := c.GetString("lang"); lang != "" {
+		return lang
+	}
+	return defaultLanguage
+}
+
+// translatingWriter holds back JSON error bodies so they can be
+// translated once the handler is done; everything else goes straight
+// through.
+type translatingWriter struct {
+	gin.ResponseWriter
+	lang string
+	buf  bytes.Buffer
+}
+
+func (w *translatingWriter) holding() bool {
+	return w.Status() >= http.StatusBadRequest &&
+		strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+}
+
+func (w *translatingWriter) Write(b []byte) (int, error) {
+	if w.holding() {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *translatingWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *translatingWriter) flush() {
+	if w.buf.Len() == 0 {
+		return
+	}
+	body := w.buf.Bytes()
+	var payload map[string]interface{}
+	if json.Unmarshal(body, &payload) == nil {
+		if msg, ok := payload["error"].(string); ok {
+			payload["error"] = translate(w.lang, msg)
+		}
+		if fields, ok := payload["fields"].([]interface{}); ok {
+			for _, f := range fields {
+				if field, ok := f.(map[string]interface{}); ok {
+					if msg, ok := field["message"].(string); ok {
+						field["message"] = translate(w.lang, msg)
+					}
+				}
+			}
+		}
+		if out, err := json.Marshal(payload); err == nil {
+			body = out
+		}
+	}
+	w.ResponseWriter.Write(body)
+}
+
+// getLabels returns field labels in the request language for building
+// UIs, with the languages there are bundles for.
+func getLabels(c *gin.Context) {
+	lang := requestLanguage(c)
+	fields := []string{}
+	for _, l := range []string{defaultLanguage, lang} {
+		if b, ok := translations[l]; ok {
+			for f := range b.Labels {
+				fields = append(fields, f)
+			}
+		}
+	}
+	available := make([]string, 0, len(translations))
+	for l := range translations {
+		available = append(available, l)
+	}
+	sort.Strings(available)
+	c.JSON(http.StatusOK, gin.H{
+		"lang":      lang,
+		"available": available,
+		"labels":    labels(lang, fields),
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// upstreamRecord is one request made to OMDb, kept for debugging where
+// the quota goes.
+type upstreamRecord struct {
+	At        time.Time `json:"at"`
+	URL       string    `json:"url"`
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMs int64     `json:"latency_ms"`
+	// Route is the route of the request that made the call, or
+	// "background" for refreshes, warming and other jobs.
+	Route     string `json:"route"`
+	RequestID string `json:"request_id,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+}
+
+// upstreamLog is a ring buffer of the last upstream requests.
+type upstreamLog struct {
+	mu      sync.Mutex
+	records []upstreamRecord
+	next    int
+	full    bool
+}
+
+var recentUpstream = newUpstreamLog(200)
+
+func newUpstreamLog(size int) *upstreamLog {
+	return &upstreamLog{records: make([]upstreamRecord, max(size, 1))}
+}
+
+func (l *upstreamLog) Add(r upstreamRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records[l.next] = r
+	l.next = (l.next + 1) % len(l.records)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Recent returns the records, newest first.
+func (l *upstreamLog) Recent() []upstreamRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := l.next
+	if l.full {
+		n = len(l.records)
+	}
+	out := make([]upstreamRecord, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, l.records[(l.next-i+len(l.records))%len(l.records)])
+	}
+	return out
+}
+
+// recordUpstream logs a call made for params. The API key is left out
+// of the URL.
+func recordUpstream(params map[string]string, query url.Values, start time.Time, status int, err error) {
+	q := url.Values{}
+	for k, v := range query {
+		if k != "apikey" {
+			q[k] = v
+		}
+	}
+	r := upstreamRecord{
+		At:        start.UTC(),
+		URL:       cfg().OMDbBaseURL + "?" + q.Encode(),
+		Status:    status,
+		LatencyMs: time.Since(start).Milliseconds(),
+		Route:     "background",
+		RequestID: params[costParam],
+		Tenant:    params[tenantParam],
+	}
+	if err != nil {
+		// A transport error's URL carries the API key too.
+		r.Error = withoutURL(err).Error()
+	}
+	if cost := costFor(params); cost != nil && cost.route != "" {
+		r.Route = cost.route
+	}
+	recentUpstream.Add(r)
+	costFor(params).trace().upstream(r)
+}
+
+type upstreamRecentQuery struct {
+	Limit int `form:"limit,default=50" binding:"min=1,max=1000"`
+	// Route keeps the calls made by routes containing it.
+	Route     string `form:"route"`
+	RequestID string `form:"request_id"`
+	// Failed keeps only calls that errored or got a non-2xx status.
+	Failed bool `form:"failed"`
+}
+
+// getRecentUpstream lists the latest upstream requests, newest first,
+// with counts per route over all that are kept.
+func getRecentUpstream(c *gin.Context) {
+	var q upstreamRecentQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	records := recentUpstream.Recent()
+	byRoute := map[string]int64{}
+	out := []upstreamRecord{}
+	for _, r := range records {
+		byRoute[r.Route]++
+		if q.Route != "" && !strings.Contains(r.Route, q.Route) {
+			continue
+		}
+		if q.RequestID != "" && r.RequestID != q.RequestID {
+			continue
+		}
+		if q.Failed && r.Error == "" && r.Status >= 200 && r.Status < 300 {
+			continue
+		}
+		if len(out) < q.Limit {
+			out = append(out, r)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"requests": out,
+		"kept":     len(records),
+		"by_route": topN(byRoute, len(byRoute)),
+	})
+}
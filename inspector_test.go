@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRecentUpstreamLeavesOutTheKey(t *testing.T) {
+	withConfig(t, func(c *Config) { c.OMDbBaseURL = "http://127.0.0.1:1/" })
+	if _, err := (omdbProvider{}).Lookup(map[string]string{"i": "tt0068646"}); err == nil {
+		t.Fatal("lookup against a closed port succeeded")
+	}
+	w := request(http.MethodGet, "/admin/upstream/recent?failed=true", map[string]string{"Authorization": "Bearer " + testAdminToken}, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d; body %s", w.Code, w.Body)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "connection refused") {
+		t.Fatalf("the failed call isn't listed: %s", body)
+	}
+	if strings.Contains(body, "apikey") {
+		t.Errorf("recent upstream calls carry the API key: %s", body)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const invalidationChannel = "movie-api:cache-invalidate"
+
+// instanceID identifies this process on the invalidation channel so that we
+// don't re-apply our own messages.
+var instanceID = randomHex(8)
+
+type invalidation struct {
+	Origin string `json:"origin"`
+	Op     string `json:"op"` // "delete", "purge" or "reload_overrides"
+	Key    string `json:"key,omitempty"`
+	// Keys are more keys to delete, such as the same lookup's entries
+	// for each tenant.
+	Keys []string `json:"keys,omitempty"`
+}
+
+// invalidationBus fans cache invalidations out to every replica. The local
+// cache is always updated first; Publish only tells the others.
+type invalidationBus interface {
+	Publish(ctx context.Context, msg invalidation) error
+	Close() error
+}
+
+type localBus struct{}
+
+func (localBus) Publish(context.Context, invalidation) error { return nil }
+func (localBus) Close() error                                { return nil }
+
+type redisBus struct {
+	client *redis.Client
+	sub    *redis.PubSub
+}
+
+func newRedisBus(client *redis.Client, cache Cache) *redisBus {
+	sub := client.Subscribe(context.Background(), invalidationChannel)
+	go func() {
+		for m := range sub.Channel() {
+			var msg invalidation
+			if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+				slog.Warn("cache invalidation: bad message", "error", err)
+				continue
+			}
+			if msg.Origin == instanceID {
+				continue
+			}
+			applyInvalidation(cache, msg)
+		}
+	}()
+	return &redisBus{client: client, sub: sub}
+}
+
+func (b *redisBus) Publish(ctx context.Context, msg invalidation) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, invalidationChannel, payload).Err()
+}
+
+func (b *redisBus) Close() error {
+	return b.sub.Close()
+}
+
+func applyInvalidation(cache Cache, msg invalidation) {
+	switch msg.Op {
+	case "purge":
+		cache.Purge()
+	case "delete":
+		if msg.Key != "" {
+			cache.Delete(msg.Key)
+		}
+		for _, key := range msg.Keys {
+			cache.Delete(key)
+		}
+	case "reload_overrides":
+		loadCatalogOverrides()
+	}
+}
+
+// invalidate applies msg locally and broadcasts it to the other replicas.
+func invalidate(ctx context.Context, msg invalidation) error {
+	msg.Origin = instanceID
+	applyInvalidation(upstreamCache, msg)
+	return cacheBus.Publish(ctx, msg)
+}
+
+// randomHex returns n random bytes hex-encoded, for IDs and tokens.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const jobsBucket = "jobs"
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	// jobDead is a job that failed every attempt. It stays until an admin
+	// re-runs it or it ages out.
+	jobDead = "dead"
+)
+
+// Job is a unit of background work kept in the store, so it survives
+// restarts and any replica can pick it up.
+type Job struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	UserID      string          `json:"user_id,omitempty"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	// Worker and LeaseUntil say who is running the job and until when; a
+	// running job whose lease ran out, because its worker died, is queued
+	// again.
+	Worker     string     `json:"worker,omitempty"`
+	LeaseUntil *time.Time `json:"lease_until,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// jobKind is how to run one kind of job and how to retry it.
+type jobKind struct {
+	run         func(j *Job) error
+	maxAttempts int
+	// backoff is the wait after the given failed attempt.
+	backoff func(attempt int) time.Duration
+}
+
+var jobKinds = map[string]jobKind{}
+
+func registerJobKind(name string, k jobKind) {
+	if k.maxAttempts <= 0 {
+		k.maxAttempts = 5
+	}
+	if k.backoff == nil {
+		k.backoff = exponentialBackoff(10*time.Second, time.Hour)
+	}
+	jobKinds[name] = k
+}
+
+// exponentialBackoff doubles the wait after each failed attempt, from base
+// up to limit.
+func exponentialBackoff(base, limit time.Duration) func(int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < limit; i++ {
+			d *= 2
+		}
+		return min(d, limit)
+	}
+}
+
+const (
+	// jobLease is how long a worker may run a job before others assume it
+	// died and run it again.
+	jobLease = 5 * time.Minute
+	// jobHeartbeat is how often a running job's lease is renewed.
+	jobHeartbeat = jobLease / 3
+	// jobWorkers is how many jobs a process runs at once.
+	jobWorkers = 4
+	// jobRetention is how long finished jobs are kept.
+	jobRetention = 7 * 24 * time.Hour
+)
+
+// jobsWake nudges the workers when a job is queued, so it doesn't wait for
+// the next poll.
+var jobsWake = make(chan struct{}, 1)
+
+// enqueueJob stores a job of kind to run as soon as a worker is free.
+func enqueueJob(kind, userID string, payload interface{}) (*Job, error) {
+	k, ok := jobKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown job kind %q", kind)
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	j := &Job{
+		ID:          randomHex(8),
+		Kind:        kind,
+		Payload:     raw,
+		UserID:      userID,
+		Status:      jobQueued,
+		MaxAttempts: k.maxAttempts,
+		RunAt:       now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := store.Put(jobsBucket, j.ID, j); err != nil {
+		return nil, err
+	}
+	select {
+	case jobsWake <- struct{}{}:
+	default:
+	}
+	return j, nil
+}
+
+func loadJobs(keep func(*Job) bool) []*Job {
+	jobs := []*Job{}
+	store.ForEach(jobsBucket, func(_ string, value []byte) error {
+		var j Job
+		if json.Unmarshal(value, &j) == nil && keep(&j) {
+			jobs = append(jobs, &j)
+		}
+		return nil
+	})
+	return jobs
+}
+
+// claimJob marks a due job as running on this instance. It fails when
+// another worker got there first.
+func claimJob(id string, now time.Time) (*Job, bool) {
+	var j Job
+	claimed := false
+	found, err := store.Modify(jobsBucket, id, &j, func() error {
+		claimed = false
+		due := j.Status == jobQueued && !j.RunAt.After(now)
+		abandoned := j.Status == jobRunning && j.LeaseUntil != nil && now.After(*j.LeaseUntil)
+		if !due && !abandoned {
+			return errStopIteration
+		}
+		j.Status = jobRunning
+		j.Attempts++
+		j.Worker = instanceID
+		lease := now.Add(jobLease)
+		j.LeaseUntil = &lease
+		j.UpdatedAt = now
+		claimed = true
+		return nil
+	})
+	if err != nil && err != errStopIteration {
+		slog.Warn("could not claim job", "job", id, "error", err)
+	}
+	return &j, found && claimed && err == nil
+}
+
+// ownsJob reports whether the stored job is still the attempt this
+// instance claimed, and not one another worker took over once the lease
+// ran out.
+func ownsJob(stored, claimed *Job) bool {
+	return stored.Status == jobRunning && stored.Worker == instanceID && stored.Attempts == claimed.Attempts
+}
+
+// heartbeatJob extends the job's lease every jobHeartbeat until stop is
+// closed, so a job that runs longer than jobLease isn't taken as
+// abandoned.
+func heartbeatJob(j *Job, stop <-chan struct{}) {
+	ticker := time.NewTicker(jobHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		var current Job
+		now := time.Now().UTC()
+		_, err := store.Modify(jobsBucket, j.ID, &current, func() error {
+			if !ownsJob(&current, j) {
+				return errStopIteration
+			}
+			lease := now.Add(jobLease)
+			current.LeaseUntil, current.UpdatedAt = &lease, now
+			return nil
+		})
+		if err == errStopIteration {
+			slog.Warn("lost the lease on a running job", "job", j.ID, "kind", j.Kind)
+			return
+		}
+		if err != nil {
+			slog.Warn("could not renew job lease", "job", j.ID, "error", err)
+		}
+	}
+}
+
+// runJob runs a claimed job and records how it went: done, queued again
+// after its backoff, or dead once it is out of attempts. The outcome is
+// dropped if another worker has taken the job over meanwhile.
+func runJob(j *Job) {
+	k, ok := jobKinds[j.Kind]
+	var err error
+	if ok {
+		stop := make(chan struct{})
+		go heartbeatJob(j, stop)
+		err = k.run(j)
+		close(stop)
+	} else {
+		err = fmt.Errorf("unknown job kind %q", j.Kind)
+	}
+	now := time.Now().UTC()
+	var done Job
+	_, serr := store.Modify(jobsBucket, j.ID, &done, func() error {
+		if !ownsJob(&done, j) {
+			return errStopIteration
+		}
+		done.Worker, done.LeaseUntil, done.UpdatedAt = "", nil, now
+		switch {
+		case err == nil:
+			done.Status, done.LastError, done.FinishedAt = jobSucceeded, "", &now
+		case done.Attempts < done.MaxAttempts && ok:
+			done.Status, done.LastError = jobQueued, err.Error()
+			done.RunAt = now.Add(k.backoff(done.Attempts))
+		default:
+			done.Status, done.LastError, done.FinishedAt = jobDead, err.Error(), &now
+		}
+		return nil
+	})
+	if serr == errStopIteration {
+		slog.Warn("job was taken over by another worker; dropping this attempt's outcome", "job", j.ID, "kind", j.Kind, "attempt", j.Attempts, "error", err)
+		return
+	}
+	if serr != nil {
+		slog.Error("could not record job outcome", "job", j.ID, "kind", j.Kind, "error", serr)
+		return
+	}
+	switch done.Status {
+	case jobSucceeded:
+		if done.UserID != "" {
+			publishEvent(done.UserID, eventJobCompleted, &done)
+		}
+	case jobQueued:
+		slog.Warn("job failed; will retry", "job", j.ID, "kind", j.Kind, "attempt", done.Attempts, "retry_at", done.RunAt, "error", err)
+	case jobDead:
+		slog.Error("job failed for good", "job", j.ID, "kind", j.Kind, "attempts", done.Attempts, "error", err)
+	}
+}
+
+// runJobs polls the queue and runs due jobs, up to jobWorkers at a time.
+// Every replica runs it; claiming through the store keeps a job from
+// running twice.
+func runJobs(every time.Duration) {
+	slots := make(chan struct{}, jobWorkers)
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		now := time.Now().UTC()
+		due := loadJobs(func(j *Job) bool {
+			return (j.Status == jobQueued && !j.RunAt.After(now)) || (j.Status == jobRunning && j.LeaseUntil != nil && now.After(*j.LeaseUntil))
+		})
+		sort.Slice(due, func(i, k int) bool { return due[i].RunAt.Before(due[k].RunAt) })
+		for _, candidate := range due {
+			if draining.Load() {
+				break
+			}
+			slots <- struct{}{}
+			j, ok := claimJob(candidate.ID, time.Now().UTC())
+			if !ok {
+				<-slots
+				continue
+			}
+			go func() {
+				defer func() { <-slots }()
+				runJob(j)
+			}()
+		}
+		select {
+		case <-ticker.C:
+		case <-jobsWake:
+		}
+	}
+}
+
+// purgeJobs drops finished jobs past jobRetention.
+func purgeJobs() {
+	cutoff := time.Now().Add(-jobRetention)
+	for _, j := range loadJobs(func(j *Job) bool { return j.FinishedAt != nil && j.FinishedAt.Before(cutoff) }) {
+		store.Delete(jobsBucket, j.ID)
+	}
+}
+
+func purgeFinishedJobs(every time.Duration) {
+	for range time.Tick(every) {
+		leaderOnly("job_purge", purgeJobs)
+	}
+}
+
+type jobsQuery struct {
+	Status string `form:"status" binding:"omitempty,oneof=queued running succeeded dead"`
+	Kind   string `form:"kind"`
+	Limit  int    `form:"limit,default=100" binding:"min=1,max=1000"`
+}
+
+// getJobs lists jobs, newest first, with counts per status.
+func getJobs(c *gin.Context) {
+	var q jobsQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	counts := map[string]int{}
+	jobs := loadJobs(func(j *Job) bool {
+		counts[j.Status]++
+		return (q.Status == "" || j.Status == q.Status) && (q.Kind == "" || j.Kind == q.Kind)
+	})
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].CreatedAt.After(jobs[k].CreatedAt) })
+	total := len(jobs)
+	if len(jobs) > q.Limit {
+		jobs = jobs[:q.Limit]
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs, "total": total, "counts": counts})
+}
+
+func getJob(c *gin.Context) {
+	var j Job
+	if found, _ := store.Get(jobsBucket, c.Param("id"), &j); !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, j)
+}
+
+// postJobRetry queues a dead job to run again with a fresh set of
+// attempts.
+func postJobRetry(c *gin.Context) {
+	var j Job
+	now := time.Now().UTC()
+	found, err := store.Modify(jobsBucket, c.Param("id"), &j, func() error {
+		if j.Status != jobDead {
+			return fmt.Errorf("only dead jobs can be re-run; this one is %s", j.Status)
+		}
+		j.Status, j.Attempts, j.RunAt, j.UpdatedAt, j.FinishedAt = jobQueued, 0, now, now, nil
+		return nil
+	})
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	select {
+	case jobsWake <- struct{}{}:
+	default:
+	}
+	slog.Info("re-running dead job", "job", j.ID, "kind", j.Kind)
+	c.JSON(http.StatusAccepted, j)
+}
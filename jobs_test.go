@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyJobFails makes test_flaky jobs fail while set.
+var flakyJobFails atomic.Bool
+
+func init() {
+	registerJobKind("test_flaky", jobKind{
+		run: func(*Job) error {
+			if flakyJobFails.Load() {
+				return errors.New("flaked")
+			}
+			return nil
+		},
+		maxAttempts: 2,
+	})
+}
+
+// newFutureJob stores a test_flaky job that only becomes due at runAt,
+// out of reach of the background workers until then.
+func newFutureJob(t *testing.T, runAt time.Time) *Job {
+	t.Helper()
+	j := &Job{ID: randomHex(8), Kind: "test_flaky", Status: jobQueued, MaxAttempts: 2, RunAt: runAt, CreatedAt: runAt, UpdatedAt: runAt}
+	if err := store.Put(jobsBucket, j.ID, j); err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := exponentialBackoff(10*time.Second, time.Minute)
+	for _, tt := range []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, time.Minute},
+		{10, time.Minute},
+	} {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestJobLeases(t *testing.T) {
+	flakyJobFails.Store(true)
+	t.Cleanup(func() { flakyJobFails.Store(false) })
+	start := time.Now().UTC().Add(time.Hour)
+	j := newFutureJob(t, start)
+
+	if _, ok := claimJob(j.ID, start.Add(-time.Minute)); ok {
+		t.Fatal("claimed a job before it was due")
+	}
+	first, ok := claimJob(j.ID, start)
+	if !ok || first.Attempts != 1 || first.Worker != instanceID {
+		t.Fatalf("first claim = %+v, %v; want attempt 1 on this instance", first, ok)
+	}
+	if _, ok := claimJob(j.ID, start.Add(jobLease-time.Minute)); ok {
+		t.Fatal("claimed a job while its lease held")
+	}
+	second, ok := claimJob(j.ID, start.Add(jobLease+time.Minute))
+	if !ok || second.Attempts != 2 {
+		t.Fatalf("claim after the lease ran out = %+v, %v; want attempt 2", second, ok)
+	}
+
+	// The first attempt's outcome is dropped: the job is someone else's now.
+	runJob(first)
+	var stored Job
+	store.Get(jobsBucket, j.ID, &stored)
+	if stored.Status != jobRunning || stored.Attempts != 2 {
+		t.Fatalf("after the stale attempt, job = %+v; want attempt 2 still running", stored)
+	}
+
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "a running job can't be retried",
+			method:      http.MethodPost,
+			path:        "/admin/jobs/" + j.ID + "/retry",
+			headers:     adminHeaders,
+			wantStatus:  http.StatusConflict,
+			wantLookups: 0,
+		},
+	})
+
+	runJob(second)
+	stored = Job{}
+	store.Get(jobsBucket, j.ID, &stored)
+	if stored.Status != jobDead || stored.LastError != "flaked" || stored.LeaseUntil != nil {
+		t.Fatalf("after the last attempt failed, job = %+v; want dead", stored)
+	}
+
+	flakyJobFails.Store(false)
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "retry an unknown job",
+			method:      http.MethodPost,
+			path:        "/admin/jobs/nowhere/retry",
+			headers:     adminHeaders,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "retry the dead job",
+			method:      http.MethodPost,
+			path:        "/admin/jobs/" + j.ID + "/retry",
+			headers:     adminHeaders,
+			wantStatus:  http.StatusAccepted,
+			wantJSON:    map[string]string{"status": jobQueued, "attempts": "0"},
+			wantLookups: 0,
+		},
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for store.Get(jobsBucket, j.ID, &stored); stored.Status != jobSucceeded; store.Get(jobsBucket, j.ID, &stored) {
+		if time.Now().After(deadline) {
+			t.Fatalf("retried job = %+v; want it run to success", stored)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestJobHandlers(t *testing.T) {
+	user, _ := newUser(t)
+	j := newFutureJob(t, time.Now().UTC().Add(time.Hour))
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "list by kind",
+			path:        "/admin/jobs?kind=test_flaky&status=queued",
+			headers:     adminHeaders,
+			wantStatus:  http.StatusOK,
+			wantKeys:    []string{"jobs", "total", "counts"},
+			wantLookups: 0,
+		},
+		{
+			name:        "unknown status",
+			path:        "/admin/jobs?status=lost",
+			headers:     adminHeaders,
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+		{
+			name:        "limit out of range",
+			path:        "/admin/jobs?limit=0",
+			headers:     adminHeaders,
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+		{
+			name:        "one job",
+			path:        "/admin/jobs/" + j.ID,
+			headers:     adminHeaders,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"id": j.ID, "kind": "test_flaky", "status": jobQueued},
+			wantLookups: 0,
+		},
+		{
+			name:        "unknown job",
+			path:        "/admin/jobs/nowhere",
+			headers:     adminHeaders,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "users can't see jobs",
+			path:        "/admin/jobs/" + j.ID,
+			headers:     user,
+			wantStatus:  http.StatusForbidden,
+			wantLookups: 0,
+		},
+	})
+}
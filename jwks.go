@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const jwtKeysBucket = "jwt_keys"
+
+// JWTKeysConfig sets how often the token signing key is replaced. A new
+// key is published in the JWKS Prepublish ahead of signing anything, so
+// services that cache our JWKS know it before they see a token using it.
+type JWTKeysConfig struct {
+	RotationInterval Duration `json:"rotation_interval"`
+	Prepublish       Duration `json:"prepublish"`
+}
+
+func defaultJWTKeysConfig() JWTKeysConfig {
+	return JWTKeysConfig{
+		RotationInterval: Duration(30 * 24 * time.Hour),
+		Prepublish:       Duration(24 * time.Hour),
+	}
+}
+
+// signingKey is an ES256 key tokens are signed with. The newest key that
+// has activated signs; every key stays valid for verification until
+// tokenTTL after a newer one took over.
+type signingKey struct {
+	KID         string    `json:"kid"`
+	PrivateKey  []byte    `json:"private_key"`
+	CreatedAt   time.Time `json:"created_at"`
+	ActivatesAt time.Time `json:"activates_at"`
+
+	key *ecdsa.PrivateKey
+}
+
+func (k *signingKey) public() gin.H {
+	return gin.H{"kid": k.KID, "alg": "ES256", "created_at": k.CreatedAt, "activates_at": k.ActivatesAt}
+}
+
+// signingKeys caches the store's signing keys, oldest first.
+var signingKeys struct {
+	sync.RWMutex
+	keys []*signingKey
+}
+
+func loadSigningKeys() error {
+	var keys []*signingKey
+	err := store.ForEach(jwtKeysBucket, func(_ string, value []byte) error {
+		var k signingKey
+		if err := json.Unmarshal(value, &k); err != nil {
+			return err
+		}
+		key, err := x509.ParseECPrivateKey(k.PrivateKey)
+		if err != nil {
+			return err
+		}
+		k.key = key
+		keys = append(keys, &k)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ActivatesAt.Before(keys[j].ActivatesAt) })
+	signingKeys.Lock()
+	signingKeys.keys = keys
+	signingKeys.Unlock()
+	return nil
+}
+
+func newSigningKey(activatesAt time.Time) (*signingKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	k := &signingKey{KID: randomHex(8), PrivateKey: der, CreatedAt: time.Now().UTC(), ActivatesAt: activatesAt.UTC(), key: key}
+	if err := store.Put(jwtKeysBucket, k.KID, k); err != nil {
+		return nil, err
+	}
+	return k, loadSigningKeys()
+}
+
+// activeSigningKey returns the key new tokens are signed with.
+func activeSigningKey() *signingKey {
+	signingKeys.RLock()
+	defer signingKeys.RUnlock()
+	now := time.Now()
+	for i := len(signingKeys.keys) - 1; i >= 0; i-- {
+		if k := signingKeys.keys[i]; !k.ActivatesAt.After(now) {
+			return k
+		}
+	}
+	return nil
+}
+
+// verifyingKeys returns the keys a token may still be signed with,
+// including ones published ahead of activation.
+func verifyingKeys() []*signingKey {
+	signingKeys.RLock()
+	defer signingKeys.RUnlock()
+	now := time.Now()
+	var keys []*signingKey
+	for i, k := range signingKeys.keys {
+		if i+1 < len(signingKeys.keys) {
+			if next := signingKeys.keys[i+1]; now.After(next.ActivatesAt.Add(tokenTTL)) {
+				continue
+			}
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func verifyingKey(kid string) *ecdsa.PublicKey {
+	for _, k := range verifyingKeys() {
+		if k.KID == kid {
+			return &k.key.PublicKey
+		}
+	}
+	return nil
+}
+
+// initSigningKeys loads the signing keys, creating the first one on a
+// fresh store.
+func initSigningKeys() error {
+	if err := loadSigningKeys(); err != nil {
+		return err
+	}
+	if activeSigningKey() != nil {
+		return nil
+	}
+	_, err := newSigningKey(time.Now())
+	return err
+}
+
+// rotateSigningKeys prepublishes the next key when the active one is due
+// to be replaced, and deletes keys no token can still be signed with.
+func rotateSigningKeys(every time.Duration) {
+	for range time.Tick(every) {
+		c := cfg().JWTKeys
+		signingKeys.RLock()
+		newest := signingKeys.keys[len(signingKeys.keys)-1]
+		signingKeys.RUnlock()
+		due := newest.ActivatesAt.Add(time.Duration(c.RotationInterval))
+		if time.Now().After(due.Add(-time.Duration(c.Prepublish))) {
+			if k, err := newSigningKey(due); err != nil {
+				slog.Error("could not create the next signing key", "error", err)
+			} else {
+				slog.Info("published next signing key", "kid", k.KID, "activates_at", k.ActivatesAt)
+			}
+		}
+		pruneSigningKeys()
+	}
+}
+
+func pruneSigningKeys() {
+	keep := map[string]bool{}
+	for _, k := range verifyingKeys() {
+		keep[k.KID] = true
+	}
+	signingKeys.RLock()
+	keys := signingKeys.keys
+	signingKeys.RUnlock()
+	pruned := false
+	for _, k := range keys {
+		if !keep[k.KID] {
+			store.Delete(jwtKeysBucket, k.KID)
+			pruned = true
+		}
+	}
+	if pruned {
+		loadSigningKeys()
+	}
+}
+
+// getJWKS publishes the public halves of the verifying keys so other
+// services can check our tokens.
+func getJWKS(c *gin.Context) {
+	keys := []gin.H{}
+	for _, k := range verifyingKeys() {
+		pub := k.key.PublicKey
+		keys = append(keys, gin.H{
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+			"kid": k.KID,
+			"alg": "ES256",
+			"use": "sig",
+		})
+	}
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+func getSigningKeys(c *gin.Context) {
+	active := activeSigningKey()
+	keys := []gin.H{}
+	for _, k := range verifyingKeys() {
+		h := k.public()
+		h["active"] = k == active
+		keys = append(keys, h)
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// postSigningKeyRotate replaces the active key right away, e.g. when it
+// may have leaked. Tokens signed with the old key keep working unless it
+// is deleted too.
+func postSigningKeyRotate(c *gin.Context) {
+	k, err := newSigningKey(time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create signing key"})
+		return
+	}
+	c.JSON(http.StatusCreated, k.public())
+}
+
+// deleteSigningKey revokes a key: every token signed with it stops
+// verifying. The active key can't be deleted; rotate first.
+func deleteSigningKey(c *gin.Context) {
+	kid := c.Param("kid")
+	if active := activeSigningKey(); active != nil && active.KID == kid {
+		c.JSON(http.StatusConflict, gin.H{"error": "this is the active signing key; rotate before deleting it"})
+		return
+	}
+	if verifyingKey(kid) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "signing key not found"})
+		return
+	}
+	if err := store.Delete(jwtKeysBucket, kid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete signing key"})
+		return
+	}
+	loadSigningKeys()
+	c.Status(http.StatusNoContent)
+}
+
+var errUnknownKeyID = errors.New("token signed with an unknown key")
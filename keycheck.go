@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"movie-api/omdb"
+)
+
+// Outcomes of the OMDb key check.
+const (
+	keyUnchecked   = "unchecked"
+	keyValid       = "valid"
+	keyInvalid     = "invalid"
+	keyExhausted   = "limit_reached"
+	keyUnreachable = "unreachable"
+)
+
+// keyStatus is the result of the most recent OMDb key check.
+type keyStatus struct {
+	mu        sync.RWMutex
+	State     string
+	Detail    string
+	CheckedAt time.Time
+}
+
+var omdbKey = &keyStatus{State: keyUnchecked}
+
+func (k *keyStatus) set(state, detail string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.State, k.Detail, k.CheckedAt = state, detail, time.Now().UTC()
+}
+
+func (k *keyStatus) snapshot() gin.H {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	h := gin.H{"state": k.State}
+	if k.Detail != "" {
+		h["detail"] = k.Detail
+	}
+	if !k.CheckedAt.IsZero() {
+		h["checked_at"] = k.CheckedAt
+	}
+	return h
+}
+
+func (k *keyStatus) ok() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.State == keyValid
+}
+
+// checkOMDbKey makes one uncached lookup of a well-known title and
+// classifies the answer.
+func checkOMDbKey() (string, string) {
+	query, _ := omdb.Query(map[string]string{"i": "tt0133093"}, omdbAPIKey())
+	resp, err := omdbClient.Get(cfg().OMDbBaseURL + "?" + query.Encode())
+	if err != nil {
+		return keyUnreachable, withoutURL(err).Error()
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return keyUnreachable, err.Error()
+	}
+	var m MovieResponse
+	if err := json.Unmarshal(body, &m); err != nil {
+		return keyUnreachable, "unexpected response (" + resp.Status + ")"
+	}
+	if m.Response == "True" {
+		return keyValid, ""
+	}
+	var ue *upstreamError
+	if errors.As(omdbError(m.Error), &ue) && ue.Status == http.StatusTooManyRequests {
+		return keyExhausted, m.Error
+	}
+	return keyInvalid, m.Error
+}
+
+// keySuspect asks validateOMDbKey for an early check of a key it last
+// found valid.
+var keySuspect = make(chan struct{}, 1)
+
+// suspectOMDbKey is called when OMDb rejects the key on a lookup, since it
+// may have been revoked since it was last checked.
+func suspectOMDbKey() {
+	select {
+	case keySuspect <- struct{}{}:
+	default:
+	}
+}
+
+// validateOMDbKey checks the key now and then again on every retry tick
+// until it passes, so a key that was fixed or refilled is picked up without
+// a restart. A valid key is checked again every recheck, or after retry if
+// a lookup is rejected meanwhile, so one revoked later takes the instance
+// out of /readyz. Failing to reach OMDb doesn't demote a valid key.
+func validateOMDbKey(retry, recheck time.Duration) {
+	valid := false
+	for {
+		state, detail := checkOMDbKey()
+		switch state {
+		case keyValid:
+			if !valid {
+				slog.Info("OMDb API key is valid")
+			}
+		case keyInvalid:
+			slog.Error("OMDb rejected OMDB_API_KEY; check that the key is correct and activated (OMDb emails an activation link)", "omdb_error", detail)
+		case keyExhausted:
+			slog.Error("OMDb API key has hit its daily request limit; lookups will fail until it resets", "omdb_error", detail)
+		case keyUnreachable:
+			slog.Warn("could not reach OMDb to validate the API key", "error", detail)
+		}
+		if state != keyUnreachable || !valid {
+			omdbKey.set(state, detail)
+			valid = state == keyValid
+		}
+
+		if !valid {
+			time.Sleep(retry)
+			continue
+		}
+		// Drop a suspicion raised before this check, and don't let a run of
+		// rejected lookups check more often than retry.
+		checked := time.Now()
+		select {
+		case <-keySuspect:
+		default:
+		}
+		select {
+		case <-keySuspect:
+			time.Sleep(time.Until(checked.Add(retry)))
+		case <-time.After(recheck):
+		}
+	}
+}
+
+// getReady reports whether this instance can serve traffic: the store is
+// usable and OMDb accepts our key.
+func getReady(c *gin.Context) {
+	storeOK := store.ForEach(settingsBucket, func(string, []byte) error { return errStopIteration }) == nil
+	ready := storeOK && omdbKey.ok()
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{
+		"ready": ready,
+		"checks": gin.H{
+			"store":        gin.H{"ok": storeOK},
+			"omdb_api_key": omdbKey.snapshot(),
+		},
+	})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// leaderKey is the Redis lock whose holder runs the background jobs that
+// must run once per deployment rather than once per replica: follow and
+// saved-search checks, digests and purges. Cache refreshes are per replica,
+// since each has its own in-memory cache.
+const leaderKey = "movie-api:leader:jobs"
+
+// renewLease extends the lock only if this instance still holds it.
+var renewLease = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseLease deletes the lock only if this instance still holds it.
+var releaseLease = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+// leaderElection holds a lease on leaderKey, renewed every third of its
+// TTL. If the leader dies the lease runs out and another replica takes
+// it. Without Redis there is only this process, which always leads.
+type leaderElection struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	leader    bool
+	holder    string
+	since     time.Time
+	renewedAt time.Time
+	lastError string
+	jobs      map[string]*jobStatus
+}
+
+type jobStatus struct {
+	Runs    int64     `json:"runs"`
+	Skipped int64     `json:"skipped"`
+	LastRun time.Time `json:"last_run,omitempty"`
+}
+
+var leader = &leaderElection{jobs: map[string]*jobStatus{}}
+
+func newLeaderElection(ttl time.Duration) *leaderElection {
+	return &leaderElection{ttl: ttl, jobs: map[string]*jobStatus{}}
+}
+
+// IsLeader reports whether this instance should run the jobs. A leader
+// that can't renew its lease steps down once the lease would have run
+// out, since another replica may hold it by then.
+func (l *leaderElection) IsLeader() bool {
+	if sharedRedis == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.leader && time.Since(l.renewedAt) > l.ttl {
+		l.step(false, "", time.Now())
+	}
+	return l.leader
+}
+
+// step must be called with mu held.
+func (l *leaderElection) step(leading bool, holder string, now time.Time) {
+	if leading != l.leader {
+		l.since = now
+		if leading {
+			slog.Info("became leader for background jobs", "instance", instanceID)
+		} else {
+			slog.Info("no longer leader for background jobs", "instance", instanceID, "leader", holder)
+		}
+	}
+	l.leader, l.holder = leading, holder
+	if leading {
+		l.renewedAt = now
+	}
+}
+
+// campaign takes the lease if it is free or renews it if it is ours.
+func (l *leaderElection) campaign() {
+	ctx := context.Background()
+	now := time.Now()
+	acquired, err := sharedRedis.SetNX(ctx, leaderKey, instanceID, l.ttl).Result()
+	if err == nil && !acquired {
+		var renewed int64
+		renewed, err = renewLease.Run(ctx, sharedRedis, []string{leaderKey}, instanceID, l.ttl.Milliseconds()).Int64()
+		acquired = renewed == 1
+	}
+	var holder string
+	if err == nil && !acquired {
+		holder, err = sharedRedis.Get(ctx, leaderKey).Result()
+		if errors.Is(err, redis.Nil) {
+			err = nil
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		l.lastError = err.Error()
+		slog.Warn("leader election failed", "error", err)
+		return
+	}
+	l.lastError = ""
+	if acquired {
+		holder = instanceID
+	}
+	l.step(acquired, holder, now)
+}
+
+// Resign gives the lease up so another replica can take over without
+// waiting for it to run out.
+func (l *leaderElection) Resign() {
+	if sharedRedis == nil {
+		return
+	}
+	releaseLease.Run(context.Background(), sharedRedis, []string{leaderKey}, instanceID)
+	l.mu.Lock()
+	l.step(false, "", time.Now())
+	l.mu.Unlock()
+}
+
+func campaignForLeader(l *leaderElection) {
+	if sharedRedis == nil {
+		return
+	}
+	l.campaign()
+	for range time.Tick(l.ttl / 3) {
+		if draining.Load() {
+			return
+		}
+		l.campaign()
+	}
+}
+
+// leaderOnly runs a job's iteration if this instance leads, and counts it
+// either way for /admin/jobs/leader.
+func leaderOnly(job string, run func()) {
+	if draining.Load() {
+		return
+	}
+	leading := leader.IsLeader()
+	leader.mu.Lock()
+	s := leader.jobs[job]
+	if s == nil {
+		s = &jobStatus{}
+		leader.jobs[job] = s
+	}
+	if leading {
+		s.Runs++
+		s.LastRun = time.Now().UTC()
+	} else {
+		s.Skipped++
+	}
+	leader.mu.Unlock()
+	if leading {
+		run()
+	}
+}
+
+func getJobLeader(c *gin.Context) {
+	leading := leader.IsLeader()
+	hostname, _ := os.Hostname()
+	resp := gin.H{
+		"instance": instanceID,
+		"hostname": hostname,
+		"leader":   leading,
+	}
+	leader.mu.Lock()
+	jobs := make(map[string]jobStatus, len(leader.jobs))
+	for name, s := range leader.jobs {
+		jobs[name] = *s
+	}
+	resp["jobs"] = jobs
+	if sharedRedis == nil {
+		resp["election"] = "none: single instance without Redis"
+	} else {
+		resp["election"] = "redis"
+		resp["lease_ttl_seconds"] = int(leader.ttl.Seconds())
+		resp["holder"] = leader.holder
+		if !leader.since.IsZero() {
+			resp["since"] = leader.since.UTC()
+		}
+		if leading {
+			resp["renewed_at"] = leader.renewedAt.UTC()
+		}
+		if leader.lastError != "" {
+			resp["error"] = leader.lastError
+		}
+	}
+	leader.mu.Unlock()
+	c.JSON(http.StatusOK, resp)
+}
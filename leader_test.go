@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// withUnreachableRedis points sharedRedis at a server that isn't there,
+// for the tests' duration. Tests using it must not send requests, which
+// would reach Redis through rate limiting.
+func withUnreachableRedis(t *testing.T) {
+	t.Helper()
+	old := sharedRedis
+	sharedRedis = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond, MaxRetries: -1})
+	t.Cleanup(func() {
+		sharedRedis.Close()
+		sharedRedis = old
+	})
+}
+
+func TestSingleInstanceLeads(t *testing.T) {
+	job, ran := "test_"+randomHex(4), false
+	leaderOnly(job, func() { ran = true })
+	if !ran {
+		t.Error("leaderOnly skipped the job without Redis")
+	}
+	leader.mu.Lock()
+	status := *leader.jobs[job]
+	leader.mu.Unlock()
+	if status.Runs != 1 || status.Skipped != 0 || status.LastRun.IsZero() {
+		t.Errorf("job status = %+v, want one run", status)
+	}
+
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "leader status",
+			path:        "/admin/jobs/leader",
+			headers:     adminHeaders,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"leader": "true", "election": "none: single instance without Redis", "instance": instanceID},
+			wantNoKeys:  []string{"holder", "lease_ttl_seconds"},
+			wantLookups: 0,
+		},
+		{
+			name:        "users can't see it",
+			path:        "/admin/jobs/leader",
+			headers:     map[string]string{"X-API-Key": newAPIKey(t, roleUser, "")},
+			wantStatus:  http.StatusForbidden,
+			wantLookups: 0,
+		},
+	})
+}
+
+func TestLeaderStepsDown(t *testing.T) {
+	withUnreachableRedis(t)
+	const ttl = time.Minute
+	l := newLeaderElection(ttl)
+
+	l.campaign()
+	if l.IsLeader() || l.lastError == "" {
+		t.Fatalf("after a failed campaign: leader %v, error %q; want a follower with the error", l.IsLeader(), l.lastError)
+	}
+
+	l.mu.Lock()
+	l.step(true, instanceID, time.Now().Add(-ttl/2))
+	l.mu.Unlock()
+	if !l.IsLeader() {
+		t.Fatal("stepped down while the lease still held")
+	}
+	l.campaign()
+	if !l.IsLeader() {
+		t.Fatal("a failed renewal stepped down before the lease ran out")
+	}
+
+	l.mu.Lock()
+	l.step(true, instanceID, time.Now().Add(-ttl-time.Second))
+	l.mu.Unlock()
+	if l.IsLeader() {
+		t.Fatal("still leading after the lease ran out")
+	}
+	if l.holder != "" {
+		t.Errorf("holder = %q after stepping down, want none", l.holder)
+	}
+}
+
+func TestFollowersSkipJobs(t *testing.T) {
+	withUnreachableRedis(t)
+	leader.mu.Lock()
+	wasLeader := leader.leader
+	leader.leader = false
+	leader.mu.Unlock()
+	t.Cleanup(func() {
+		leader.mu.Lock()
+		leader.leader = wasLeader
+		leader.mu.Unlock()
+	})
+
+	job := "test_" + randomHex(4)
+	leaderOnly(job, func() { t.Error("a follower ran the job") })
+	leader.mu.Lock()
+	status := *leader.jobs[job]
+	leader.mu.Unlock()
+	if status.Runs != 0 || status.Skipped != 1 {
+		t.Errorf("job status = %+v, want one skip", status)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBulkOperations caps one bulk request. Adds may each cost an
+// upstream lookup.
+const maxBulkOperations = 200
+
+// bulkOperation is one step of a bulk request: "add" takes the fields of
+// a single add, "remove" an imdbID, and "move" an imdbID and the 0-based
+// position it should end up at.
+type bulkOperation struct {
+	Op string `json:"op"`
+	listItemRequest
+	Position *int `json:"position"`
+}
+
+type bulkResult struct {
+	Index  int       `json:"index"`
+	Op     string    `json:"op"`
+	IMDBID string    `json:"imdbID,omitempty"`
+	OK     bool      `json:"ok"`
+	Status string    `json:"status,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Item   *ListItem `json:"item,omitempty"`
+}
+
+func postListBulk(c *gin.Context) {
+	if l := ownedList(c); l != nil {
+		applyBulk(c, l)
+	}
+}
+
+func postWatchlistBulk(c *gin.Context) {
+	if l := watchlistFor(c); l != nil {
+		applyBulk(c, l)
+	}
+}
+
+// applyBulk runs the operations in order against the list and saves it
+// once, in one transaction through editList. Operations that fail are
+// reported and skipped; the rest still apply. Once OMDb says the daily
+// limit is reached, the remaining adds fail without trying.
+func applyBulk(c *gin.Context, l *List) {
+	var req struct {
+		Operations []bulkOperation `json:"operations"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Operations) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide {"operations": [{"op": "add|remove|move", "imdbID": "tt...", ...}]}`})
+		return
+	}
+	if len(req.Operations) > maxBulkOperations {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at most " + strconv.Itoa(maxBulkOperations) + " operations per request"})
+		return
+	}
+
+	var results []bulkResult
+	failed := 0
+	l, err := editList(c, l, func(l *List, lookup titleLookup) error {
+		results, failed = runBulk(l, req.Operations, lookup)
+		if failed == len(results) {
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopIteration) {
+		respondListError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"results":   results,
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+		"items":     len(l.Items),
+	})
+}
+
+// runBulk applies the operations to l in memory and reports on each.
+func runBulk(l *List, ops []bulkOperation, lookup titleLookup) ([]bulkResult, int) {
+	results := make([]bulkResult, len(ops))
+	failed := 0
+	var limitErr error
+	for i, op := range ops {
+		r := bulkResult{Index: i, Op: op.Op, IMDBID: op.IMDBID}
+		var err error
+		switch op.Op {
+		case "add":
+			if limitErr != nil {
+				err = limitErr
+				break
+			}
+			var added listAdd
+			added, err = addToList(l, op.listItemRequest, lookup)
+			if err == nil {
+				r.IMDBID, r.Item = added.Item.IMDBID, &added.Item
+				switch {
+				case added.Merged:
+					r.Status = "merged"
+				case added.Duplicate != "":
+					err = errors.New("title is already in the list (matched by " + added.Duplicate + ")")
+				default:
+					r.Status = "added"
+				}
+			}
+			var ue *upstreamError
+			if errors.As(err, &ue) && ue.Status == http.StatusTooManyRequests {
+				limitErr = err
+			}
+		case "remove":
+			err = removeFromList(l, op.IMDBID)
+			r.Status = "removed"
+		case "move":
+			err = moveInList(l, op.IMDBID, op.Position)
+			r.Status = "moved"
+		default:
+			err = errors.New("op must be add, remove or move")
+		}
+		if err != nil {
+			r.Status, r.Error = "", err.Error()
+			failed++
+		} else {
+			r.OK = true
+		}
+		results[i] = r
+	}
+	return results, failed
+}
+
+var errNotInList = errors.New("title is not in the list")
+
+func removeFromList(l *List, imdbID string) error {
+	for i, item := range l.Items {
+		if item.IMDBID == imdbID {
+			l.Items = append(l.Items[:i], l.Items[i+1:]...)
+			return nil
+		}
+	}
+	return errNotInList
+}
+
+func moveInList(l *List, imdbID string, position *int) error {
+	from := -1
+	for i, item := range l.Items {
+		if item.IMDBID == imdbID {
+			from = i
+			break
+		}
+	}
+	if from < 0 {
+		return errNotInList
+	}
+	if position == nil || *position < 0 || *position >= len(l.Items) {
+		return errors.New("position must be between 0 and " + strconv.Itoa(len(l.Items)-1))
+	}
+	item := l.Items[from]
+	l.Items = append(l.Items[:from], l.Items[from+1:]...)
+	p := *position
+	l.Items = append(l.Items[:p], append([]ListItem{item}, l.Items[p:]...)...)
+	return nil
+}
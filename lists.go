@@ -0,0 +1,491 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const listsBucket = "lists"
+
+// List visibility: private lists are only visible to their owner, unlisted
+// ones to anyone with the link, public ones also appear on the owner's
+// public profile.
+const (
+	visibilityPrivate  = "private"
+	visibilityUnlisted = "unlisted"
+	visibilityPublic   = "public"
+)
+
+type ListItem struct {
+	IMDBID  string    `json:"imdbID"`
+	Title   string    `json:"Title"`
+	Year    string    `json:"Year"`
+	Note    string    `json:"note,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+type List struct {
+	ID          string     `json:"id"`
+	OwnerID     string     `json:"owner_id"`
+	Kind        string     `json:"kind,omitempty"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Visibility  string     `json:"visibility"`
+	Items       []ListItem `json:"items"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+func loadList(id string) (*List, bool) {
+	var l List
+	found, err := store.Get(listsBucket, id, &l)
+	if err != nil || !found {
+		return nil, false
+	}
+	return &l, true
+}
+
+// saveList writes a new list. Changes to an existing one go through
+// modifyList.
+func saveList(l *List) error {
+	l.UpdatedAt = time.Now().UTC()
+	return store.Put(listsBucket, l.ID, l)
+}
+
+var (
+	errListNotFound = errors.New("list not found")
+	errListSave     = errors.New("could not save list")
+	errListChanged  = errors.New("the list changed while the title was looked up; try again")
+)
+
+// modifyList applies fn to the stored list in one transaction, so
+// concurrent edits don't overwrite each other, and returns the list as it
+// is now. fn may return errStopIteration to leave the list as it is.
+func modifyList(id string, fn func(l *List) error) (*List, error) {
+	var l List
+	var fnErr error
+	found, err := store.Modify(listsBucket, id, &l, func() error {
+		if fnErr = fn(&l); fnErr != nil {
+			return fnErr
+		}
+		l.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+	switch {
+	case fnErr != nil:
+		return &l, fnErr
+	case err != nil:
+		return &l, errListSave
+	case !found:
+		return &l, errListNotFound
+	}
+	return &l, nil
+}
+
+// titleLookup resolves the title a list edit adds.
+type titleLookup func(params map[string]string) (*MovieResponse, error)
+
+// editList runs edit on l, a copy of the list, looking titles up as it
+// goes, and then on the stored list inside modifyList with the answers it
+// got. That keeps upstream calls out of the transaction. An edit that
+// needs a title the first run didn't look up fails with errListChanged.
+func editList(c *gin.Context, l *List, edit func(l *List, lookup titleLookup) error) (*List, error) {
+	type answer struct {
+		movie *MovieResponse
+		err   error
+	}
+	answers := map[string]answer{}
+	replay := false
+	lookup := func(params map[string]string) (*MovieResponse, error) {
+		key := cacheKey(params)
+		if a, ok := answers[key]; ok {
+			return a.movie, a.err
+		}
+		if replay {
+			return nil, errListChanged
+		}
+		movie, err := fetchMovie(scopedParams(c, params))
+		answers[key] = answer{movie, err}
+		return movie, err
+	}
+	if err := edit(l, lookup); err != nil {
+		return l, err
+	}
+	replay = true
+	return modifyList(l.ID, func(l *List) error { return edit(l, lookup) })
+}
+
+func userLists(userID string, keep func(*List) bool) []*List {
+	lists := []*List{}
+	store.ForEach(listsBucket, func(_ string, value []byte) error {
+		var l List
+		if json.Unmarshal(value, &l) == nil && l.OwnerID == userID && (keep == nil || keep(&l)) {
+			lists = append(lists, &l)
+		}
+		return nil
+	})
+	sort.Slice(lists, func(i, j int) bool { return lists[i].CreatedAt.Before(lists[j].CreatedAt) })
+	return lists
+}
+
+func validVisibility(v string) bool {
+	return v == visibilityPrivate || v == visibilityUnlisted || v == visibilityPublic
+}
+
+// ownedList loads the :id list and checks it belongs to the caller. It
+// writes the error response itself and returns nil on failure.
+func ownedList(c *gin.Context) *List {
+	l, ok := loadList(c.Param("id"))
+	if !ok || l.OwnerID != currentUser(c).ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "list not found"})
+		return nil
+	}
+	return l
+}
+
+type listRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Visibility  *string `json:"visibility"`
+}
+
+func postList(c *gin.Context) {
+	var req listRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == nil || strings.TrimSpace(*req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide {"name": "...", "visibility": "private|unlisted|public"}`})
+		return
+	}
+	now := time.Now().UTC()
+	l := &List{
+		ID:         randomHex(8),
+		OwnerID:    currentUser(c).ID,
+		Visibility: visibilityPrivate,
+		Items:      []ListItem{},
+		CreatedAt:  now,
+	}
+	if err := applyListRequest(l, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := saveList(l); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save list"})
+		return
+	}
+	c.JSON(http.StatusCreated, l)
+}
+
+func patchList(c *gin.Context) {
+	l := ownedList(c)
+	if l == nil {
+		return
+	}
+	var req listRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := applyListRequest(l, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	l, err := modifyList(l.ID, func(l *List) error { return applyListRequest(l, req) })
+	if err != nil {
+		respondListError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, l)
+}
+
+var errVisibility = errors.New("visibility must be private, unlisted or public")
+
+func applyListRequest(l *List, req listRequest) error {
+	if req.Visibility != nil {
+		if !validVisibility(*req.Visibility) {
+			return errVisibility
+		}
+		l.Visibility = *req.Visibility
+	}
+	if req.Name != nil {
+		l.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		l.Description = *req.Description
+	}
+	return nil
+}
+
+// respondListError writes the error of a list edit that went through
+// modifyList or editList.
+func respondListError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errListNotFound), errors.Is(err, errNotInList):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errListChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, errListSave):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		respondUpstreamError(c, err)
+	}
+}
+
+func getLists(c *gin.Context) {
+	lists := userLists(currentUser(c).ID, nil)
+	respondList(c, http.StatusOK, lists, listMeta{Total: len(lists)}, lists)
+}
+
+func getList(c *gin.Context) {
+	if l := ownedList(c); l != nil {
+		c.JSON(http.StatusOK, l)
+	}
+}
+
+// deleteList moves a list to the trash. The watchlist is made on first
+// use and can only be emptied.
+func deleteList(c *gin.Context) {
+	l := ownedList(c)
+	if l == nil {
+		return
+	}
+	if l.Kind == listKindWatchlist {
+		c.JSON(http.StatusConflict, gin.H{"error": "the watchlist can't be deleted; remove its titles instead"})
+		return
+	}
+	if err := moveListToTrash(l); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete list"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type listItemRequest struct {
+	IMDBID string `json:"imdbID"`
+	// Title and Year add by name instead; OMDb resolves the imdbID.
+	Title string `json:"title"`
+	Year  string `json:"year"`
+	Note  string `json:"note"`
+	// OnDuplicate is "conflict" (the default) to refuse a title that is
+	// already in the list, or "merge" to fold the note into the existing
+	// entry.
+	OnDuplicate string `json:"on_duplicate"`
+}
+
+const (
+	onDuplicateConflict = "conflict"
+	onDuplicateMerge    = "merge"
+)
+
+func postListItem(c *gin.Context) {
+	if l := ownedList(c); l != nil {
+		addListItem(c, l)
+	}
+}
+
+var (
+	titlePunctuation = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+	trailingArticle  = regexp.MustCompile(`,\s*(the|a|an)$`)
+)
+
+// normalizeTitle folds the ways one title gets written: case,
+// punctuation, "&" for "and", and a leading or trailing article ("The
+// Matrix", "Matrix, The").
+func normalizeTitle(title string) string {
+	t := strings.ToLower(strings.TrimSpace(title))
+	if m := trailingArticle.FindStringSubmatch(t); m != nil {
+		t = m[1] + " " + strings.TrimSpace(t[:len(t)-len(m[0])])
+	}
+	t = strings.ReplaceAll(t, "&", " and ")
+	words := strings.Fields(titlePunctuation.ReplaceAllString(t, " "))
+	if len(words) > 1 && (words[0] == "the" || words[0] == "a" || words[0] == "an") {
+		words = words[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// duplicateItem finds an entry of l that is the same title as imdbID or,
+// failing that, has the same normalized title and starting year. It
+// returns how it matched.
+func duplicateItem(l *List, imdbID, title, year string) (int, string) {
+	for i, item := range l.Items {
+		if imdbID != "" && item.IMDBID == imdbID {
+			return i, "imdbID"
+		}
+	}
+	if title == "" || len(year) < 4 {
+		return -1, ""
+	}
+	norm := normalizeTitle(title)
+	for i, item := range l.Items {
+		if len(item.Year) >= 4 && item.Year[:4] == year[:4] && normalizeTitle(item.Title) == norm {
+			return i, "title_year"
+		}
+	}
+	return -1, ""
+}
+
+var (
+	errListItemRequest = errors.New(`Please provide {"imdbID": "tt..."} or {"title": "...", "year": optional}`)
+	errOnDuplicate     = errors.New("on_duplicate must be conflict or merge")
+)
+
+// listAdd is what adding a title to a list came to.
+type listAdd struct {
+	Item ListItem
+	// Duplicate is set when the title was already in the list, to how
+	// it matched; Merged when the request was folded into that entry.
+	Duplicate string
+	Merged    bool
+}
+
+// addToList adds a title by imdbID, or by title (and optionally year), to
+// l in memory; the caller saves. lookup resolves the title. Duplicates are checked before the
+// upstream lookup where the request allows it, and again against what
+// OMDb resolved.
+func addToList(l *List, req listItemRequest, lookup titleLookup) (listAdd, error) {
+	if req.IMDBID == "" && strings.TrimSpace(req.Title) == "" {
+		return listAdd{}, errListItemRequest
+	}
+	switch req.OnDuplicate {
+	case "", onDuplicateConflict, onDuplicateMerge:
+	default:
+		return listAdd{}, errOnDuplicate
+	}
+
+	if i, match := duplicateItem(l, req.IMDBID, req.Title, req.Year); i >= 0 {
+		return mergeDuplicate(l, i, match, req), nil
+	}
+	params := map[string]string{"i": req.IMDBID}
+	if req.IMDBID == "" {
+		params = map[string]string{"t": strings.TrimSpace(req.Title)}
+		if req.Year != "" {
+			params["y"] = req.Year
+		}
+	}
+	movie, err := lookup(params)
+	if err != nil {
+		return listAdd{}, err
+	}
+	if i, match := duplicateItem(l, movie.IMDBID, movie.Title, movie.Year); i >= 0 {
+		return mergeDuplicate(l, i, match, req), nil
+	}
+	item := ListItem{IMDBID: movie.IMDBID, Title: movie.Title, Year: movie.Year, Note: req.Note, AddedAt: time.Now().UTC()}
+	l.Items = append(l.Items, item)
+	return listAdd{Item: item}, nil
+}
+
+func mergeDuplicate(l *List, i int, match string, req listItemRequest) listAdd {
+	item := &l.Items[i]
+	if req.OnDuplicate != onDuplicateMerge {
+		return listAdd{Item: *item, Duplicate: match}
+	}
+	if note := strings.TrimSpace(req.Note); note != "" && !strings.Contains(item.Note, note) {
+		if item.Note != "" {
+			item.Note += "\n"
+		}
+		item.Note += note
+	}
+	return listAdd{Item: *item, Duplicate: match, Merged: true}
+}
+
+func addListItem(c *gin.Context, l *List) {
+	var req listItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errListItemRequest.Error()})
+		return
+	}
+	var added listAdd
+	_, err := editList(c, l, func(l *List, lookup titleLookup) error {
+		var err error
+		if added, err = addToList(l, req, lookup); err == nil && added.Duplicate != "" && !added.Merged {
+			return errStopIteration
+		}
+		return err
+	})
+	switch {
+	case errors.Is(err, errListItemRequest), errors.Is(err, errOnDuplicate):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errStopIteration):
+		c.JSON(http.StatusConflict, gin.H{"error": "title is already in the list", "item": added.Item, "match": added.Duplicate})
+		return
+	case err != nil:
+		respondListError(c, err)
+		return
+	}
+	if added.Merged {
+		c.JSON(http.StatusOK, gin.H{"item": added.Item, "merged": true, "match": added.Duplicate})
+		return
+	}
+	c.JSON(http.StatusCreated, added.Item)
+}
+
+func deleteListItem(c *gin.Context) {
+	if l := ownedList(c); l != nil {
+		removeListItem(c, l)
+	}
+}
+
+func removeListItem(c *gin.Context, l *List) {
+	_, err := modifyList(l.ID, func(l *List) error { return removeFromList(l, c.Param("imdbID")) })
+	if err != nil {
+		respondListError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+const listKindWatchlist = "watchlist"
+
+// userWatchlist returns the user's watchlist, a private list with a fixed
+// ID that is created on first use.
+func userWatchlist(userID string) (*List, error) {
+	id := "wl-" + userID
+	if l, ok := loadList(id); ok {
+		return l, nil
+	}
+	now := time.Now().UTC()
+	l := &List{
+		ID:         id,
+		OwnerID:    userID,
+		Kind:       listKindWatchlist,
+		Name:       "Watchlist",
+		Visibility: visibilityPrivate,
+		Items:      []ListItem{},
+		CreatedAt:  now,
+	}
+	return l, saveList(l)
+}
+
+func watchlistFor(c *gin.Context) *List {
+	l, err := userWatchlist(currentUser(c).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not load watchlist"})
+		return nil
+	}
+	return l
+}
+
+func getWatchlist(c *gin.Context) {
+	if l := watchlistFor(c); l != nil {
+		c.JSON(http.StatusOK, l)
+	}
+}
+
+func postWatchlistItem(c *gin.Context) {
+	if l := watchlistFor(c); l != nil {
+		addListItem(c, l)
+	}
+}
+
+func deleteWatchlistItem(c *gin.Context) {
+	if l := watchlistFor(c); l != nil {
+		removeListItem(c, l)
+	}
+}
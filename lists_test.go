@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// newList creates a list for user and returns its ID.
+func newList(t *testing.T, user map[string]string) string {
+	t.Helper()
+	w := request(http.MethodPost, "/api/lists", user, `{"name":"Crime"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create list: status %d; body %s", w.Code, w.Body)
+	}
+	return decodeObject(t, w)["id"].(string)
+}
+
+func TestListHandlers(t *testing.T) {
+	user, userID := newUser(t)
+	other, _ := newUser(t)
+	list := "/api/lists/" + newList(t, user)
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "add by imdbID",
+			method:      http.MethodPost,
+			path:        list + "/items",
+			headers:     user,
+			body:        `{"imdbID":"tt0068646","note":"the wedding"}`,
+			wantStatus:  http.StatusCreated,
+			wantJSON:    map[string]string{"imdbID": "tt0068646", "Title": "The Godfather"},
+			wantLookups: 1,
+		},
+		{
+			name:        "add by title",
+			method:      http.MethodPost,
+			path:        list + "/items",
+			headers:     user,
+			body:        `{"title":"heat"}`,
+			wantStatus:  http.StatusCreated,
+			wantJSON:    map[string]string{"imdbID": "tt0113277"},
+			wantLookups: 1,
+		},
+		{
+			name:        "duplicate",
+			method:      http.MethodPost,
+			path:        list + "/items",
+			headers:     user,
+			body:        `{"imdbID":"tt0068646"}`,
+			wantStatus:  http.StatusConflict,
+			wantJSON:    map[string]string{"match": "imdbID"},
+			wantLookups: 0,
+		},
+		{
+			name:        "duplicate merged",
+			method:      http.MethodPost,
+			path:        list + "/items",
+			headers:     user,
+			body:        `{"title":"Godfather, The","year":"1972","note":"the ending","on_duplicate":"merge"}`,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"merged": "true", "match": "title_year"},
+			wantLookups: 0,
+		},
+		{
+			name:        "unknown title",
+			method:      http.MethodPost,
+			path:        list + "/items",
+			headers:     user,
+			body:        `{"imdbID":"tt0000404"}`,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 1,
+		},
+		{
+			name:        "bad visibility",
+			method:      http.MethodPatch,
+			path:        list,
+			headers:     user,
+			body:        `{"visibility":"everyone"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+		{
+			name:        "rename",
+			method:      http.MethodPatch,
+			path:        list,
+			headers:     user,
+			body:        `{"name":"Heists","visibility":"public"}`,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"name": "Heists", "visibility": visibilityPublic},
+			wantLookups: 0,
+		},
+		{
+			name:        "someone else's list",
+			method:      http.MethodPost,
+			path:        list + "/items",
+			headers:     other,
+			body:        `{"imdbID":"tt0133093"}`,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "remove",
+			method:      http.MethodDelete,
+			path:        list + "/items/tt0113277",
+			headers:     user,
+			wantStatus:  http.StatusNoContent,
+			wantLookups: 0,
+		},
+		{
+			name:        "remove a title not in the list",
+			method:      http.MethodDelete,
+			path:        list + "/items/tt0113277",
+			headers:     user,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "add to the watchlist",
+			method:      http.MethodPost,
+			path:        "/api/watchlist",
+			headers:     user,
+			body:        `{"imdbID":"tt0133093"}`,
+			wantStatus:  http.StatusCreated,
+			wantLookups: 1,
+		},
+		{
+			name:        "the watchlist can't be deleted",
+			method:      http.MethodDelete,
+			path:        "/api/lists/wl-" + userID,
+			headers:     user,
+			wantStatus:  http.StatusConflict,
+			wantLookups: 0,
+		},
+		{
+			name:        "delete a list",
+			method:      http.MethodDelete,
+			path:        list,
+			headers:     user,
+			wantStatus:  http.StatusNoContent,
+			wantLookups: 0,
+		},
+	})
+	if l, _ := loadList("wl-" + userID); l == nil || len(l.Items) != 1 {
+		t.Errorf("watchlist is %+v, want it kept with its title", l)
+	}
+}
+
+func TestListBulk(t *testing.T) {
+	user, _ := newUser(t)
+	list := "/api/lists/" + newList(t, user)
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "no operations",
+			method:      http.MethodPost,
+			path:        list + "/bulk",
+			headers:     user,
+			body:        `{"operations":[]}`,
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+		{
+			name:    "mixed operations",
+			method:  http.MethodPost,
+			path:    list + "/bulk",
+			headers: user,
+			body: `{"operations":[
+				{"op":"add","imdbID":"tt0133093"},
+				{"op":"add","imdbID":"tt0068646"},
+				{"op":"add","imdbID":"tt0133093"},
+				{"op":"move","imdbID":"tt0068646","position":0},
+				{"op":"remove","imdbID":"tt0113277"},
+				{"op":"rename"}]}`,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"succeeded": "3", "failed": "3", "items": "2"},
+			wantLookups: 2,
+		},
+		{
+			name:        "all failing",
+			method:      http.MethodPost,
+			path:        list + "/bulk",
+			headers:     user,
+			body:        `{"operations":[{"op":"remove","imdbID":"tt0113277"}]}`,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"succeeded": "0", "failed": "1", "items": "2"},
+			wantLookups: 0,
+		},
+		{
+			name:        "watchlist",
+			method:      http.MethodPost,
+			path:        "/api/watchlist/bulk",
+			headers:     user,
+			body:        `{"operations":[{"op":"add","title":"Heat"}]}`,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"succeeded": "1", "items": "1"},
+			wantLookups: 1,
+		},
+	})
+	w := request(http.MethodGet, list, user, "")
+	items := decodeObject(t, w)["items"].([]interface{})
+	if first := items[0].(map[string]interface{})["imdbID"]; first != "tt0068646" {
+		t.Errorf("first title is %v, want the one moved to the top", first)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// LLM is a minimal text-completion provider used by the optional
+// language-model features. It is nil unless LLM_PROVIDER is configured.
+type LLM interface {
+	Complete(ctx context.Context, system, prompt string) (string, error)
+}
+
+var llm LLM
+
+// openAIChat speaks the OpenAI chat completions protocol, which most hosted
+// and local model servers also implement.
+type openAIChat struct {
+	url    string
+	apiKey string
+	model  string
+	client *http.Client
+}
+
+func (o *openAIChat) Complete(ctx context.Context, system, prompt string) (string, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"model": o.model,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": prompt},
+		},
+		"temperature": 0.2,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("llm provider returned %s", resp.Status)
+	}
+
+	var out struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if len(out.Choices) == 0 {
+		return "", errors.New("llm provider returned no choices")
+	}
+	return out.Choices[0].Message.Content, nil
+}
+
+func newLLMFromEnv() LLM {
+	switch envString("LLM_PROVIDER", "") {
+	case "openai":
+		return &openAIChat{
+			url:    envString("LLM_URL", "https://api.openai.com/v1/chat/completions"),
+			apiKey: envString("LLM_API_KEY", envString("OPENAI_API_KEY", "")),
+			model:  envString("LLM_MODEL", "gpt-4o-mini"),
+			client: &http.Client{Timeout: 60 * time.Second},
+		}
+	case "local":
+		return &openAIChat{
+			url:    envString("LLM_URL", "http://localhost:11434/v1/chat/completions"),
+			model:  envString("LLM_MODEL", "llama3.1"),
+			client: &http.Client{Timeout: 120 * time.Second},
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoadSheddingConfig sets when the server is under pressure and what it
+// turns away then: the expensive routes go first, while cheap lookups,
+// mostly served from the cache, keep working.
+type LoadSheddingConfig struct {
+	// MaxInFlight is how many requests may be in progress at once before
+	// expensive routes are shed; 0 disables the check.
+	MaxInFlight int `json:"max_in_flight"`
+	// MaxUpstreamLatency is the average OMDb latency above which expensive
+	// routes are shed; 0 disables the check.
+	MaxUpstreamLatency Duration `json:"max_upstream_latency"`
+	// RetryAfter is what shed requests are told to wait.
+	RetryAfter Duration `json:"retry_after"`
+	// Expensive are the route patterns shed first, as registered.
+	Expensive []string `json:"expensive"`
+}
+
+func defaultLoadSheddingConfig() LoadSheddingConfig {
+	return LoadSheddingConfig{
+		MaxInFlight:        200,
+		MaxUpstreamLatency: Duration(2 * time.Second),
+		RetryAfter:         Duration(10 * time.Second),
+		Expensive: []string{
+			"/api/movies/genre",
+			"/api/movies/by-decade",
+			"/api/movies/recommendations",
+			"/api/movies/similar",
+			"/api/search/all",
+			"/api/search/nl",
+			"/api/chat",
+			"/api/watchlist/tonight",
+			"/api/users/me/year-in-review",
+			"/api/users/me/export",
+		},
+	}
+}
+
+// loadMonitor tracks the two pressure signals: requests in flight and
+// a moving average of OMDb's latency.
+type loadMonitor struct {
+	inFlight atomic.Int64
+
+	mu       sync.Mutex
+	latency  time.Duration
+	observed time.Time
+	shed     map[string]int64
+}
+
+var load = &loadMonitor{shed: map[string]int64{}}
+
+// latencyWeight is how much each new upstream call moves the average.
+const latencyWeight = 0.1
+
+// latencyStale is how long the average counts for without new calls, so
+// a slow spell doesn't keep routes shed once traffic is served from the
+// cache.
+const latencyStale = time.Minute
+
+func (m *loadMonitor) observeUpstream(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.latency == 0 || time.Since(m.observed) > latencyStale {
+		m.latency = d
+	} else {
+		m.latency = time.Duration(latencyWeight*float64(d) + (1-latencyWeight)*float64(m.latency))
+	}
+	m.observed = time.Now()
+}
+
+func (m *loadMonitor) upstreamLatency() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if time.Since(m.observed) > latencyStale {
+		return 0
+	}
+	return m.latency
+}
+
+// pressure names the first threshold exceeded, or "" when there is none.
+func (m *loadMonitor) pressure(conf LoadSheddingConfig) string {
+	if conf.MaxInFlight > 0 && m.inFlight.Load() > int64(conf.MaxInFlight) {
+		return "in_flight"
+	}
+	if conf.MaxUpstreamLatency > 0 && m.upstreamLatency() > time.Duration(conf.MaxUpstreamLatency) {
+		return "upstream_latency"
+	}
+	return ""
+}
+
+// shedLoad counts the requests in flight and, under pressure, turns away
+// the expensive routes with 503 and Retry-After.
+func shedLoad(c *gin.Context) {
+	load.inFlight.Add(1)
+	defer load.inFlight.Add(-1)
+	conf := cfg().LoadShedding
+	route := c.FullPath()
+	if slices.Contains(conf.Expensive, route) {
+		if reason := load.pressure(conf); reason != "" {
+			load.mu.Lock()
+			load.shed[route]++
+			load.mu.Unlock()
+			c.Header("Retry-After", strconv.Itoa(int(time.Duration(conf.RetryAfter).Seconds())))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error":  "the server is busy; try again shortly",
+				"code":   "overloaded",
+				"reason": reason,
+			})
+			return
+		}
+	}
+	c.Next()
+}
+
+func getLoad(c *gin.Context) {
+	conf := cfg().LoadShedding
+	load.mu.Lock()
+	shed := make(map[string]int64, len(load.shed))
+	for route, n := range load.shed {
+		shed[route] = n
+	}
+	load.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{
+		"in_flight":           load.inFlight.Load(),
+		"upstream_latency_ms": load.upstreamLatency().Milliseconds(),
+		"pressure":            load.pressure(conf),
+		"shed":                shed,
+		"thresholds":          conf,
+		"upstream_concurrency": gin.H{
+			"genre":           genreLookups.stats(),
+			"recommendations": recommendationLookups.stats(),
+		},
+	})
+}
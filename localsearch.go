@@ -0,0 +1,474 @@
+package main
+
+import (
+	"html"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// localIndex is a full-text index over the title catalog: titles,
+// directors, actors and plots of everything fetched or imported. It lives
+// in memory, is built from the catalog at startup and follows it as
+// titles are added. Ranking is BM25 with per-field boosts; query words
+// also match index words a typo or two away, and the last word matches
+// as a prefix so the index can back search-as-you-type.
+type localIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]*indexedTitle
+	postings map[string]map[string]*posting
+	// totalLen is the summed token count per field, for average lengths.
+	totalLen [numSearchFields]int
+	pending  chan *MovieResponse
+}
+
+// The indexed fields, in the order of searchFieldNames and
+// searchFieldBoosts.
+const (
+	fieldTitle = iota
+	fieldDirector
+	fieldActors
+	fieldPlot
+	numSearchFields
+)
+
+var searchFieldNames = [numSearchFields]string{"Title", "Director", "Actors", "Plot"}
+
+// searchFieldBoosts make a title match outrank a director or actor match
+// outrank a mention in a plot.
+var searchFieldBoosts = [numSearchFields]float64{4, 2, 2, 1}
+
+type indexedTitle struct {
+	IMDBID string
+	Title  string
+	Year   string
+	Type   string
+	text   [numSearchFields]string
+	length [numSearchFields]int
+	terms  []string
+}
+
+type posting struct {
+	tf [numSearchFields]int
+}
+
+var searchIndex *localIndex
+
+func newLocalIndex() *localIndex {
+	return &localIndex{
+		docs:     map[string]*indexedTitle{},
+		postings: map[string]map[string]*posting{},
+		pending:  make(chan *MovieResponse, 1024),
+	}
+}
+
+// stopwords are too common to rank by; queries made only of them still
+// match, as "it" or "up" are real titles.
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "the": true, "of": true, "in": true,
+	"on": true, "to": true, "is": true, "his": true, "her": true, "their": true,
+	"with": true, "for": true, "by": true, "from": true, "at": true, "as": true,
+}
+
+// tokenSpan is a word of the source text and where it sits in it.
+type tokenSpan struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lowercased words with their byte offsets.
+// Words are folded to a crude stem (a plural "s" dropped) so "heist"
+// finds "heists".
+func tokenize(text string) []tokenSpan {
+	var out []tokenSpan
+	start := -1
+	for i, r := range text + " " {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\''
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			if term := normalizeTerm(text[start:i]); term != "" {
+				out = append(out, tokenSpan{term, start, i})
+			}
+			start = -1
+		}
+	}
+	return out
+}
+
+func normalizeTerm(word string) string {
+	term := strings.ToLower(strings.Trim(word, "'"))
+	term = strings.TrimSuffix(term, "'s")
+	if len(term) > 3 && strings.HasSuffix(term, "s") && !strings.HasSuffix(term, "ss") {
+		term = term[:len(term)-1]
+	}
+	return term
+}
+
+func newIndexedTitle(m *MovieResponse) *indexedTitle {
+	d := &indexedTitle{IMDBID: m.IMDBID, Title: m.Title, Year: m.Year, Type: m.Type}
+	d.text = [numSearchFields]string{m.Title, m.Director, m.Actors, m.Plot}
+	for f := range d.text {
+		if d.text[f] == "N/A" {
+			d.text[f] = ""
+		}
+	}
+	return d
+}
+
+// Add indexes a title, replacing what was indexed for it before.
+func (li *localIndex) Add(m *MovieResponse) {
+	if m.IMDBID == "" {
+		return
+	}
+	d := newIndexedTitle(m)
+	tf := map[string]*posting{}
+	for f, text := range d.text {
+		for _, t := range tokenize(text) {
+			if tf[t.term] == nil {
+				tf[t.term] = &posting{}
+			}
+			tf[t.term].tf[f]++
+			d.length[f]++
+		}
+	}
+	for term := range tf {
+		d.terms = append(d.terms, term)
+	}
+
+	li.mu.Lock()
+	defer li.mu.Unlock()
+	li.remove(m.IMDBID)
+	li.docs[m.IMDBID] = d
+	for term, p := range tf {
+		if li.postings[term] == nil {
+			li.postings[term] = map[string]*posting{}
+		}
+		li.postings[term][m.IMDBID] = p
+	}
+	for f := range d.length {
+		li.totalLen[f] += d.length[f]
+	}
+}
+
+func (li *localIndex) remove(id string) {
+	old, ok := li.docs[id]
+	if !ok {
+		return
+	}
+	for _, term := range old.terms {
+		delete(li.postings[term], id)
+		if len(li.postings[term]) == 0 {
+			delete(li.postings, term)
+		}
+	}
+	for f := range old.length {
+		li.totalLen[f] -= old.length[f]
+	}
+	delete(li.docs, id)
+}
+
+// Enqueue schedules a title for indexing without holding up the lookup
+// that fetched it. If the queue is full the title waits for the next
+// restart.
+func (li *localIndex) Enqueue(m *MovieResponse) {
+	if li == nil {
+		return
+	}
+	select {
+	case li.pending <- m:
+	default:
+	}
+}
+
+func (li *localIndex) run() {
+	for m := range li.pending {
+		li.Add(m)
+	}
+}
+
+// initSearchIndex indexes the catalog in the background, so startup
+// isn't held up by a large one, then follows catalog additions.
+func initSearchIndex() {
+	li := newLocalIndex()
+	searchIndex = li
+	go func() {
+		for _, m := range catalog.All(nil) {
+			li.Add(m)
+		}
+		li.run()
+	}()
+}
+
+// expansion is an index term a query word matched, and how much the
+// match counts: 1 for the word itself, less for a prefix or a typo.
+type expansion struct {
+	term   string
+	weight float64
+}
+
+// expand finds the index terms a query word matches. Typos are allowed
+// from four letters on, two of them from eight.
+func (li *localIndex) expand(word string, prefix, fuzzy bool) []expansion {
+	var out []expansion
+	if _, ok := li.postings[word]; ok {
+		out = append(out, expansion{word, 1})
+	}
+	n := utf8.RuneCountInString(word)
+	maxEdits := 0
+	if fuzzy && n >= 8 {
+		maxEdits = 2
+	} else if fuzzy && n >= 4 {
+		maxEdits = 1
+	}
+	if !prefix && maxEdits == 0 {
+		return out
+	}
+	for term := range li.postings {
+		if term == word {
+			continue
+		}
+		if prefix && n >= 2 && strings.HasPrefix(term, word) {
+			out = append(out, expansion{term, 0.8})
+			continue
+		}
+		if maxEdits > 0 && abs(utf8.RuneCountInString(term)-n) <= maxEdits {
+			if d := editDistance(word, term, maxEdits); d <= maxEdits {
+				out = append(out, expansion{term, 0.6 / float64(d)})
+			}
+		}
+	}
+	return out
+}
+
+// editDistance is the Damerau-Levenshtein distance (adjacent swaps count
+// as one edit), giving up with limit+1 once it exceeds limit.
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+// BM25 parameters, the usual ones.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+type localSearchOptions struct {
+	Type  string
+	Year  string
+	Fuzzy bool
+}
+
+type scoredTitle struct {
+	doc     *indexedTitle
+	score   float64
+	matched map[string]bool
+}
+
+// Search ranks the titles matching query. Each query word contributes
+// its best-scoring match, and titles matching fewer of the words are
+// scaled down, so "dark knight" puts the title with both first.
+func (li *localIndex) Search(query string, opts localSearchOptions) []scoredTitle {
+	li.mu.RLock()
+	defer li.mu.RUnlock()
+
+	words := tokenize(query)
+	content := words[:0:0]
+	for _, w := range words {
+		if !stopwords[w.term] {
+			content = append(content, w)
+		}
+	}
+	if len(content) > 0 {
+		words = content
+	}
+	if len(words) == 0 || len(li.docs) == 0 {
+		return nil
+	}
+
+	var avgLen [numSearchFields]float64
+	for f := range avgLen {
+		avgLen[f] = max(float64(li.totalLen[f])/float64(len(li.docs)), 1)
+	}
+	n := float64(len(li.docs))
+
+	hits := map[string]*scoredTitle{}
+	for i, w := range words {
+		best := map[string]float64{}
+		bestTerm := map[string]string{}
+		for _, e := range li.expand(w.term, i == len(words)-1, opts.Fuzzy) {
+			postings := li.postings[e.term]
+			idf := math.Log(1 + (n-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+			for id, p := range postings {
+				doc := li.docs[id]
+				s := 0.0
+				for f, tf := range p.tf {
+					if tf == 0 {
+						continue
+					}
+					norm := 1 - bm25B + bm25B*float64(doc.length[f])/avgLen[f]
+					s += searchFieldBoosts[f] * idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*norm)
+				}
+				if s *= e.weight; s > best[id] {
+					best[id], bestTerm[id] = s, e.term
+				}
+			}
+		}
+		for id, s := range best {
+			h := hits[id]
+			if h == nil {
+				h = &scoredTitle{doc: li.docs[id], matched: map[string]bool{}}
+				hits[id] = h
+			}
+			h.score += s
+			h.matched[bestTerm[id]] = true
+		}
+	}
+
+	out := make([]scoredTitle, 0, len(hits))
+	for _, h := range hits {
+		if opts.Type != "" && h.doc.Type != opts.Type {
+			continue
+		}
+		if opts.Year != "" && !strings.HasPrefix(h.doc.Year, opts.Year) {
+			continue
+		}
+		coverage := float64(len(h.matched)) / float64(len(words))
+		h.score *= coverage * coverage
+		out = append(out, *h)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].score != out[j].score {
+			return out[i].score > out[j].score
+		}
+		return out[i].doc.IMDBID < out[j].doc.IMDBID
+	})
+	return out
+}
+
+// highlights returns, per field that matched, the text with the matched
+// words wrapped in <mark> and everything else HTML-escaped. Long fields
+// are cut to a fragment around the first match.
+func (h scoredTitle) highlights() map[string]string {
+	out := map[string]string{}
+	for f, text := range h.doc.text {
+		var spans []tokenSpan
+		for _, t := range tokenize(text) {
+			if h.matched[t.term] {
+				spans = append(spans, t)
+			}
+		}
+		if len(spans) == 0 {
+			continue
+		}
+		from, to := 0, len(text)
+		const window = 160
+		if to-from > window {
+			from = max(spans[0].start-window/3, 0)
+			for from > 0 && !utf8.RuneStart(text[from]) {
+				from--
+			}
+			to = min(from+window, len(text))
+			for to < len(text) && !utf8.RuneStart(text[to]) {
+				to++
+			}
+		}
+		var b strings.Builder
+		if from > 0 {
+			b.WriteString("…")
+		}
+		at := from
+		for _, s := range spans {
+			if s.start < from || s.end > to {
+				continue
+			}
+			b.WriteString(html.EscapeString(text[at:s.start]))
+			b.WriteString("<mark>" + html.EscapeString(text[s.start:s.end]) + "</mark>")
+			at = s.end
+		}
+		b.WriteString(html.EscapeString(text[at:to]))
+		if to < len(text) {
+			b.WriteString("…")
+		}
+		out[searchFieldNames[f]] = b.String()
+	}
+	return out
+}
+
+type localSearchQuery struct {
+	Q    string `form:"q" binding:"required,max=200"`
+	Type string `form:"type" binding:"omitempty,oneof=movie series episode game"`
+	Year string `form:"year" binding:"omitempty,year"`
+	// Fuzzy allows typos in the query.
+	Fuzzy bool `form:"fuzzy,default=true"`
+	Page  int  `form:"page,default=1" binding:"min=1,max=100"`
+	Limit int  `form:"limit,default=20" binding:"min=1,max=50"`
+}
+
+type localHit struct {
+	IMDBID string  `json:"imdbID"`
+	Title  string  `json:"Title"`
+	Year   string  `json:"Year"`
+	Type   string  `json:"Type"`
+	Score  float64 `json:"score"`
+	// Highlights has each matching field with the matches in <mark>.
+	Highlights map[string]string `json:"highlights"`
+}
+
+// getLocalSearch searches the local index, best match first. It never
+// calls OMDb, so it only finds titles someone has looked up or an admin
+// has imported.
+func getLocalSearch(c *gin.Context) {
+	var q localSearchQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	found := searchIndex.Search(q.Q, localSearchOptions{Type: q.Type, Year: q.Year, Fuzzy: q.Fuzzy})
+	from := min((q.Page-1)*q.Limit, len(found))
+	hits := make([]localHit, 0, q.Limit)
+	for _, h := range found[from:min(from+q.Limit, len(found))] {
+		hits = append(hits, localHit{
+			IMDBID:     h.doc.IMDBID,
+			Title:      h.doc.Title,
+			Year:       h.doc.Year,
+			Type:       h.doc.Type,
+			Score:      roundTo(h.score, 3),
+			Highlights: h.highlights(),
+		})
+	}
+	costOf(c).fromDataset()
+	respondList(c, http.StatusOK, hits, listMeta{Total: len(found), Page: q.Page}, gin.H{"q": q.Q, "total": len(found), "page": q.Page, "results": hits})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer sends plain-text email. It is nil unless SMTP_HOST is set, in
+// which case email features are silently unavailable.
+type Mailer interface {
+	Send(to, subject, body string, headers map[string]string) error
+}
+
+var mailer Mailer
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func newMailerFromEnv() Mailer {
+	host := envString("SMTP_HOST", "")
+	if host == "" {
+		return nil
+	}
+	m := &smtpMailer{
+		addr: net.JoinHostPort(host, envString("SMTP_PORT", "587")),
+		from: envString("SMTP_FROM", "movie-api@"+host),
+	}
+	if user := envString("SMTP_USERNAME", ""); user != "" {
+		m.auth = smtp.PlainAuth("", user, envString("SMTP_PASSWORD", ""), host)
+	}
+	return m
+}
+
+func (m *smtpMailer) Send(to, subject, body string, headers map[string]string) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	for k, v := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", k, v)
+	}
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg.String()))
+}
@@ -2,100 +2,259 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-var OMDB_API_KEY string
+// omdbAPIKey is the instance's OMDb key. It is looked up on each use so
+// a rotated key takes effect right away.
+func omdbAPIKey() string {
+	return secret("OMDB_API_KEY")
+}
+
+var (
+	memoryCache   *responseCache
+	upstreamCache Cache
+	cacheBus      invalidationBus = localBus{}
+	store         Store
+)
 
 type MovieResponse struct {
-	Title      string `json:"Title"`
-	Year       string `json:"Year"`
-	Plot       string `json:"Plot"`
-	Director   string `json:"Director"`
-	Genre      string `json:"Genre"`
-	Actors     string `json:"Actors"`
-	Country    string `json:"Country"`
-	Awards     string `json:"Awards"`
-	Season     string `json:"Season,omitempty"`
-	Episode    string `json:"Episode,omitempty"`
+	Title    string `json:"Title"`
+	Year     string `json:"Year"`
+	Plot     string `json:"Plot"`
+	Director string `json:"Director"`
+	Genre    string `json:"Genre"`
+	Actors   string `json:"Actors"`
+	Country  string `json:"Country"`
+	Language string `json:"Language"`
+	Rated    string `json:"Rated"`
+	Awards   string `json:"Awards"`
+	Runtime  string `json:"Runtime"`
+	Poster   string `json:"Poster"`
+	Type     string `json:"Type"`
+	Season   string `json:"Season,omitempty"`
+	Episode  string `json:"Episode,omitempty"`
+	// SeriesID is only set for episodes.
+	SeriesID   string `json:"seriesID,omitempty"`
 	Released   string `json:"Released,omitempty"`
 	IMDBID     string `json:"imdbID"`
 	IMDBRating string `json:"imdbRating"`
-	Ratings    []struct {
+	IMDBVotes  string `json:"imdbVotes"`
+	Metascore  string `json:"Metascore,omitempty"`
+	BoxOffice  string `json:"BoxOffice,omitempty"`
+	// TotalSeasons is only set for series.
+	TotalSeasons string `json:"totalSeasons,omitempty"`
+	Ratings      []struct {
 		Source string `json:"Source"`
 		Value  string `json:"Value"`
 	} `json:"Ratings"`
+	// Availability and Tags come from locally imported catalog data.
+	Availability string   `json:"availability,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	// Provider is set when a fallback answered instead of OMDb.
+	Provider string `json:"provider,omitempty"`
 	Response string `json:"Response"`
 	Error    string `json:"Error,omitempty"`
 }
 
+type searchItem struct {
+	Title  string `json:"Title"`
+	Year   string `json:"Year"`
+	IMDBID string `json:"imdbID"`
+	Type   string `json:"Type"`
+}
+
 type SearchResults struct {
-	Search []struct {
-		Title  string `json:"Title"`
-		Year   string `json:"Year"`
-		IMDBID string `json:"imdbID"`
-		Type   string `json:"Type"`
-	} `json:"Search"`
+	Search       []searchItem `json:"Search"`
+	TotalResults string       `json:"totalResults"`
+	// RequestedYear echoes the ?year= filter the results were narrowed to.
+	RequestedYear string `json:"requested_year,omitempty"`
+	NextCursor    string `json:"next_cursor,omitempty"`
+	// Merge is set when ?pages= combined several pages.
+	Merge *searchMerge `json:"merge,omitempty"`
+	// Provider is set when a fallback answered instead of OMDb.
+	Provider string `json:"provider,omitempty"`
 	Response string `json:"Response"`
 	Error    string `json:"Error,omitempty"`
 }
 
-func fetchFromOMDb(params map[string]string, out interface{}) error {
-	baseURL := "http://www.omdbapi.com/"
-	query := ""
-	for k, v := range params {
-		query += fmt.Sprintf("&%s=%s", k, v)
+type SeasonResponse struct {
+	Title        string `json:"Title"`
+	Season       string `json:"Season"`
+	TotalSeasons string `json:"totalSeasons"`
+	Episodes     []struct {
+		Title      string `json:"Title"`
+		Released   string `json:"Released"`
+		Episode    string `json:"Episode"`
+		IMDBRating string `json:"imdbRating"`
+		IMDBID     string `json:"imdbID"`
+	} `json:"Episodes"`
+	Response string `json:"Response"`
+	Error    string `json:"Error,omitempty"`
+}
+
+// cachedFetcher is the Fetcher the server runs with. It answers from the
+// cache where it can and otherwise asks the Provider, within the request's
+// limits, falling back to other providers when OMDb can't answer.
+type cachedFetcher struct{}
+
+func (cachedFetcher) Fetch(params map[string]string, out interface{}) error {
+	key := cacheKey(params)
+	cost := costFor(params)
+	if cost.usesFixtures() {
+		// cacheKey keeps fixture answers apart from live ones.
+		cost.trace().cached(key, "fixture", 0)
+		if body, ok := upstreamCache.Get(key); ok {
+			return decodeOMDb(body, out)
+		}
+		body, err := fixtures.Lookup(params)
+		if err != nil {
+			return err
+		}
+		if err := decodeOMDb(body, out); err != nil {
+			return err
+		}
+		upstreamCache.Set(key, body, cacheTTL(params))
+		return nil
+	}
+	if body, ok := upstreamCache.Get(key); ok {
+		age := cachedAge(key, params)
+		cost.hit(age)
+		cost.trace().cached(key, "hit", age)
+		err := decodeOMDb(body, out)
+		cost.servedBy(providerOf(out))
+		return err
+	}
+	if err := cost.admit(); err != nil {
+		cost.trace().cached(key, "refused", 0)
+		return err
+	}
+	cost.miss()
+	if cost.isDryRun() {
+		cost.trace().cached(key, "dry_run", 0)
+		cost.dryRunMiss(params)
+		return errDryRun
 	}
-	url := fmt.Sprintf("%s?apikey=%s%s", baseURL, OMDB_API_KEY, query)
+	cost.trace().cached(key, "miss", 0)
 
-	resp, err := http.Get(url)
+	body, err := cost.lookup(key, params)
+	ttl := cacheTTL(params)
+	if failure := lookupFailure(body, err); failure != nil && len(fallbacks) > 0 {
+		if strings.Contains(failure.Error(), "limit reached") {
+			omdbHealth.quotaExhausted(failure.Error())
+		}
+		if isAuthFailure(failure) {
+			suspectOMDbKey()
+		}
+		if fb := lookupFallback(params, failure); fb != nil {
+			body, err, ttl = fb, nil, min(ttl, fallbackCacheTTL)
+		}
+	}
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-
-	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+	if err := decodeOMDb(body, out); err != nil {
+		if strings.Contains(err.Error(), "limit reached") {
+			omdbHealth.quotaExhausted(err.Error())
+		}
+		if isAuthFailure(err) {
+			suspectOMDbKey()
+		}
 		return err
 	}
+	cost.servedBy(providerOf(out))
+
+	upstreamCache.Set(key, body, ttl)
+	return nil
+}
+
+func decodeOMDb(body []byte, out interface{}) error {
+	if err := json.Unmarshal(body, out); err != nil {
+		return decodeFailed(err)
+	}
 
 	switch v := out.(type) {
 	case *MovieResponse:
 		if v.Response == "False" {
-			return fmt.Errorf(v.Error)
+			return omdbError(v.Error)
 		}
 	case *SearchResults:
 		if v.Response == "False" {
-			return fmt.Errorf(v.Error)
+			return omdbError(v.Error)
+		}
+	case *SeasonResponse:
+		if v.Response == "False" {
+			return omdbError(v.Error)
 		}
 	}
 	return nil
 }
 
+// cacheKey builds a stable key from the request params so that map
+// iteration order doesn't produce duplicate entries. Lookups for requests
+// under /testing/ get keys of their own.
+func cacheKey(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k != costParam {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(params[k])
+		b.WriteByte('&')
+	}
+	if costFor(params).usesFixtures() {
+		return fixturesCachePrefix + b.String()
+	}
+	return b.String()
+}
+
+func cacheTTL(params map[string]string) time.Duration {
+	if _, ok := params["s"]; ok {
+		return time.Duration(cfg().Cache.SearchTTL)
+	}
+	return time.Duration(cfg().Cache.MovieTTL)
+}
+
 func fetchMovie(params map[string]string) (*MovieResponse, error) {
 	var movie MovieResponse
 	if err := fetchFromOMDb(params, &movie); err != nil {
 		return nil, err
 	}
+	if costFor(params).usesFixtures() {
+		return &movie, nil
+	}
+	applyOverride(&movie)
+	catalog.Add(&movie)
+	plots.Enqueue(&movie)
 	return &movie, nil
 }
 
-func fetchSearchResults(query string) (*SearchResults, error) {
-	return fetchSearchPage(query, 1)
-}
-
-func fetchSearchPage(query string, page int) (*SearchResults, error) {
-	params := map[string]string{
+func searchParams(query string, page int) map[string]string {
+	return map[string]string{
 		"s":    query,
 		"type": "movie",
 		"page": strconv.Itoa(page),
 	}
+}
+
+func fetchSearch(params map[string]string) (*SearchResults, error) {
 	var results SearchResults
 	if err := fetchFromOMDb(params, &results); err != nil {
 		return nil, err
@@ -103,67 +262,141 @@ func fetchSearchPage(query string, page int) (*SearchResults, error) {
 	return &results, nil
 }
 
+func fetchSeason(params map[string]string) (*SeasonResponse, error) {
+	var s SeasonResponse
+	if err := fetchFromOMDb(params, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
 
 func getMovie(c *gin.Context) {
-	title := c.Query("title")
-	id := c.Query("id")
-
-	if title == "" && id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide ?title=MovieName or ?id=IMDBid"})
+	var q movieQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	schema, ok := responseSchema(c)
+	if !ok {
 		return
 	}
 
 	params := map[string]string{}
-	if title != "" {
-		params["t"] = title
+	if q.Title != "" {
+		params["t"] = q.Title
+	}
+	if q.ID != "" {
+		params["i"] = q.ID
+	}
+	if q.Year != "" {
+		params["y"] = q.Year
 	}
-	if id != "" {
-		params["i"] = id
+	if q.Type != "" {
+		params["type"] = q.Type
 	}
 
-	movie, err := fetchMovie(params)
+	movie, err := fetchMovie(scopedParams(c, params))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		respondUpstreamError(c, err)
 		return
 	}
+	trackTitle(c, movie.IMDBID)
 
-	c.JSON(http.StatusOK, gin.H{
-		"Title":    movie.Title,
-		"Year":     movie.Year,
-		"Plot":     movie.Plot,
-		"Country":  movie.Country,  
-		"Awards":   movie.Awards,  
-		"Director": movie.Director,
-		"Ratings":  movie.Ratings,
-	})
+	setProvenance(c)
+	resp := movieProjection(movie, schema)
+	if q.Year != "" {
+		resp["requested_year"] = q.Year
+	}
+	if q.TZ != "" {
+		addReleaseDates(resp, movie.Released, location(q.TZ))
+	}
+	if q.Currency != "" {
+		if rates == nil {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "currency conversion is disabled; set EXCHANGE_RATES_URL"})
+			return
+		}
+		if usd, ok := parseBoxOffice(movie.BoxOffice); ok {
+			converted, err := convertBoxOffice(c.Request.Context(), usd, q.Currency)
+			if errors.Is(err, errNoRate) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusBadGateway, gin.H{"error": "currency conversion failed: " + err.Error()})
+				return
+			}
+			resp["box_office"] = money{usd, "USD"}
+			resp["box_office_converted"] = converted
+		}
+	}
+	if translator != nil && q.Lang != "" && !strings.EqualFold(q.Lang, "en") && movie.Plot != "" && movie.Plot != "N/A" {
+		plot, err := translatePlot(c.Request.Context(), movie.IMDBID, movie.Plot, q.Lang)
+		if err != nil {
+			slog.Warn("plot translation failed", "imdbID", movie.IMDBID, "lang", q.Lang, "error", err)
+		} else {
+			resp["Plot"] = plot
+			resp["plot_language"] = q.Lang
+		}
+	}
+	if q.SpoilerFree {
+		applySpoilerFree(c, resp, movie)
+	}
+	if q.Labels {
+		fields := make([]string, 0, len(resp))
+		for f := range resp {
+			fields = append(fields, f)
+		}
+		resp["labels"] = labels(requestLanguage(c), fields)
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
+// getEpisode looks an episode up by its own ?id=, or by season and
+// episode number within a series given as ?series_id= or ?series_title=.
 func getEpisode(c *gin.Context) {
-	seriesTitle := c.Query("series_title")
-	season := c.Query("season")
-	episode := c.Query("episode_number")
+	var q episodeQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	seriesTitle := q.SeriesTitle
 
-	if seriesTitle == "" || season == "" || episode == "" {
+	params := map[string]string{}
+	switch {
+	case q.ID != "":
+		params["i"] = q.ID
+	case q.SeriesID != "" || q.SeriesTitle != "":
+		params["Season"] = strconv.Itoa(q.Season)
+		params["Episode"] = strconv.Itoa(q.Episode)
+		if q.SeriesID != "" {
+			params["i"] = q.SeriesID
+		} else {
+			params["t"] = q.SeriesTitle
+		}
+	default:
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Please provide ?series_title=...&season=1&episode_number=1",
+			"error": "Please provide ?id=tt..., or ?series_id=tt... (or series_title=...) with &season=1&episode_number=1",
 		})
 		return
 	}
 
-	params := map[string]string{
-		"t":       seriesTitle,
-		"Season":  season,
-		"Episode": episode,
-	}
-
-	ep, err := fetchMovie(params)
+	ep, err := fetchMovie(scopedParams(c, params))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		respondUpstreamError(c, err)
 		return
 	}
+	if ep.Type != "episode" {
+		c.JSON(http.StatusNotFound, gin.H{"error": ep.IMDBID + " is a " + ep.Type + ", not an episode"})
+		return
+	}
+	if seriesTitle == "" && ep.SeriesID != "" {
+		if series, err := fetchMovie(scopedParams(c, map[string]string{"i": ep.SeriesID})); err == nil {
+			seriesTitle = series.Title
+		}
+	}
 
-	c.JSON(http.StatusOK, gin.H{
+	setProvenance(c)
+	resp := gin.H{
 		"Series":     seriesTitle,
+		"seriesID":   ep.SeriesID,
 		"Episode":    ep.Episode,
 		"Season":     ep.Season,
 		"Title":      ep.Title,
@@ -171,168 +404,321 @@ func getEpisode(c *gin.Context) {
 		"Plot":       ep.Plot,
 		"imdbID":     ep.IMDBID,
 		"imdbRating": ep.IMDBRating,
-	})
+	}
+	addReleaseDates(resp, ep.Released, location(q.TZ))
+	if q.SpoilerFree {
+		applySpoilerFree(c, resp, ep)
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
-
-func getMoviesByGenre(c *gin.Context) {
-	genre := c.Query("genre")
-	if genre == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide a genre using ?genre=GenreName"})
-		return
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:]))
 	}
+	run(func(h http.Handler) error { return serve(h, ":8080") })
+}
 
-	matchingMovies := []map[string]interface{}{}
-	seen := make(map[string]bool)
-
-	
-	searchSeeds := []string{
-		"the", "a", "love", "man", "girl", "night", "day", "war", "life", "death",
-		"hero", "king", "queen", "dark", "light", "red", "black", "white", "green",
-		"star", "moon", "sun", "fire", "water", "blood", "heart", "soul", "time",
-		"world", "house", "home", "city", "road", "story", "game", "fight", "power",
+// run starts everything up, hands the router to serveWith and cleans up
+// once that returns.
+func run(serveWith func(http.Handler) error) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+	if err := initSecrets(); err != nil {
+		slog.Error("could not load secrets", "error", err)
+		os.Exit(1)
 	}
-
-	
-	for _, seed := range searchSeeds {
-		for page := 1; page <= 5; page++ {
-			results, err := fetchSearchPage(seed, page)
-			if err != nil {
-				continue
-			}
-
-			for _, item := range results.Search {
-				if seen[item.IMDBID] {
-					continue
-				}
-				seen[item.IMDBID] = true
-
-				movie, err := fetchMovie(map[string]string{"i": item.IMDBID})
-				if err != nil || movie.IMDBRating == "N/A" {
-					continue
-				}
-
-				genres := strings.Split(movie.Genre, ",")
-				for _, g := range genres {
-					if strings.EqualFold(strings.TrimSpace(g), genre) {
-						matchingMovies = append(matchingMovies, map[string]interface{}{"Title": movie.Title, "Year": movie.Year, "Genre": movie.Genre, "imdbRating": movie.IMDBRating, "imdbID": movie.IMDBID,})
-						break
-					}
-				}
-			}
-		}
+	go refreshSecrets(envDuration("SECRETS_REFRESH_INTERVAL", 5*time.Minute))
+	if omdbAPIKey() == "" {
+		slog.Error("OMDB_API_KEY is not set; get a free key at https://www.omdbapi.com/apikey.aspx")
+		os.Exit(1)
 	}
-
-	
-	sort.Slice(matchingMovies, func(i, j int) bool {
-		r1, _ := strconv.ParseFloat(matchingMovies[i]["imdbRating"].(string), 64)
-		r2, _ := strconv.ParseFloat(matchingMovies[j]["imdbRating"].(string), 64)
-		return r1 > r2
-	})
-
-	
-	if len(matchingMovies) > 15 {
-		matchingMovies = matchingMovies[:15]
+	client, err := newUpstreamClient()
+	if err != nil {
+		slog.Error("invalid upstream client settings", "error", err)
+		os.Exit(1)
 	}
+	omdbClient = client
+	recentUpstream = newUpstreamLog(envInt("UPSTREAM_LOG_SIZE", 200))
 
-	c.JSON(http.StatusOK, matchingMovies)
-}
-
-
-func getRecommendations(c *gin.Context) {
-	fav := c.Query("favorite_movie")
-	if fav == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide ?favorite_movie=MovieTitle"})
-		return
+	configPath := os.Getenv("CONFIG_FILE")
+	config, err := loadConfig(configPath)
+	if err != nil {
+		panic(fmt.Sprintf("load config: %v", err))
+	}
+	applyConfig(config)
+	go validateOMDbKey(15*time.Minute, time.Hour)
+	if configPath != "" {
+		go watchConfig(configPath, envDuration("CONFIG_WATCH_INTERVAL", 5*time.Second))
 	}
 
-	favMovie, err := fetchMovie(map[string]string{"t": fav})
+	memoryCache = newResponseCache(config.Cache.MaxEntries, config.Cache.MaxBytes)
+	upstreamCache = memoryCache
+	if config.Cache.DiskPath != "" {
+		disk, err := openDiskCache(config.Cache.DiskPath)
+		if err != nil {
+			panic(fmt.Sprintf("open disk cache %s: %v", config.Cache.DiskPath, err))
+		}
+		defer disk.Close()
+		go compactDiskCache(disk, time.Hour)
+		upstreamCache = &tieredCache{mem: memoryCache, disk: disk}
+	}
+	storeLocation := secret("STORE_URL")
+	if storeLocation == "" {
+		storeLocation = envString("STORE_PATH", "movie-api.db")
+	}
+	store, err = openStore(storeLocation)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Favorite movie not found"})
-		return
+		panic(fmt.Sprintf("open store: %v", err))
+	}
+	defer store.Close()
+	indexAPIKeys()
+	catalog.Load()
+	loadCatalogOverrides()
+	initSearchIndex()
+	importCuratedDir(os.Getenv("CURATED_LISTS_DIR"))
+	go flushCatalog(30 * time.Second)
+	popularity = newPopularityTracker(envDuration("POPULARITY_HALF_LIFE", 3*24*time.Hour))
+	popularity.Load()
+	memoryCache.SetPriority(cachePriority)
+	go flushPopularity(30 * time.Second)
+
+	if embedder := newEmbedderFromEnv(); embedder != nil {
+		plots = newPlotIndex(embedder)
 	}
 
-	genres := strings.Split(favMovie.Genre, ",")
-	directors := strings.Split(favMovie.Director, ",")
-	actors := strings.Split(favMovie.Actors, ",")
-
-	seen := map[string]bool{favMovie.IMDBID: true}
-
-	collect := func(level string, keywords []string, limit int) []gin.H {
-		results := []gin.H{}
-		for _, kw := range keywords {
-			kw = strings.TrimSpace(kw)
-			if kw == "" || kw == "N/A" {
-				continue
-			}
+	loadTranslations(os.Getenv("I18N_DIR"))
+	loadProfanity(os.Getenv("PROFANITY_DIR"))
+	llm = newLLMFromEnv()
+	translator = newTranslatorFromEnv()
+	fallbacks = newFallbacksFromEnv()
+	if err := initResponseHooks(); err != nil {
+		panic(fmt.Sprintf("load response hooks: %v", err))
+	}
+	if provider := newRateProviderFromEnv(); provider != nil {
+		rates = newExchangeRates(provider)
+	}
+	initJWTSecret()
+	if err := initSigningKeys(); err != nil {
+		panic(fmt.Sprintf("load signing keys: %v", err))
+	}
+	go rotateSigningKeys(time.Hour)
+	initOAuth()
 
-			for page := 1; page <= 3 && len(results) < limit; page++ {
-				search, err := fetchSearchPage(kw, page)
-				if err != nil || search == nil {
-					continue
-				}
-
-				for _, s := range search.Search {
-					if seen[s.IMDBID] {
-						continue
-					}
-					movie, err := fetchMovie(map[string]string{"i": s.IMDBID})
-					if err != nil || movie.IMDBRating == "N/A" {
-						continue
-					}
-					seen[s.IMDBID] = true
-					results = append(results, gin.H{
-						"Title":      movie.Title,
-						"Year":       movie.Year,
-						"Genre":      movie.Genre,
-						"imdbRating": movie.IMDBRating,
-						"imdbID":     movie.IMDBID,
-						"Why":        level,
-					})
-					if len(results) >= limit {
-						break
-					}
-				}
-			}
-			if len(results) >= limit {
-				break
-			}
+	if redisURL := secret("REDIS_URL"); redisURL != "" {
+		client, err := newRedisClient(redisURL, "REDIS_URL")
+		if err != nil {
+			panic(fmt.Sprintf("connect to redis: %v", err))
 		}
-		sort.Slice(results, func(i, j int) bool {
-			ri, _ := strconv.ParseFloat(results[i]["imdbRating"].(string), 64)
-			rj, _ := strconv.ParseFloat(results[j]["imdbRating"].(string), 64)
-			return ri > rj
-		})
-		return results
-	}
-
-	
-	genreRecs := collect("Genre", genres, 20)
-	directorRecs := collect("Director", directors, 20)
-	actorRecs := collect("Actor", actors, 20)
-
-	c.JSON(http.StatusOK, gin.H{
-		"favorite_movie": favMovie.Title,
-		"recommendations": gin.H{
-			"by_genre":    genreRecs,
-			"by_director": directorRecs,
-			"by_actor":    actorRecs,
-		},
-	})
-}
-
-func main() {
-	OMDB_API_KEY = os.Getenv("OMDB_API_KEY")
-	if OMDB_API_KEY == "" {
-		panic("set OMDB_API_KEY in your environment")
+		defer client.Close()
+		sharedRedis = client
+		bus := newRedisBus(client, upstreamCache)
+		defer bus.Close()
+		cacheBus = bus
+		go relayNotifications(client)
 	}
+	if err := checkBackingServices(); err != nil {
+		panic(err.Error())
+	}
+	leader = newLeaderElection(envDuration("LEADER_LEASE_TTL", 15*time.Second))
+	go campaignForLeader(leader)
 
-	router := gin.Default()
+	go checkFollows(envDuration("FOLLOWS_CHECK_INTERVAL", 6*time.Hour))
+	mailer = newMailerFromEnv()
+	if errorReporter, err = newErrorReporterFromEnv(); err != nil {
+		panic(fmt.Sprintf("error reporting: %v", err))
+	}
+	if trafficLog, err = newTrafficRecorderFromEnv(); err != nil {
+		panic(fmt.Sprintf("record traffic: %v", err))
+	}
+	defer trafficLog.Close()
+	go sendDigests(time.Hour)
+	go checkSavedSearches(envDuration("SAVED_SEARCHES_CHECK_INTERVAL", time.Hour))
+	go flushQuotas(time.Minute)
+	go flushExperiments(time.Minute)
+	go purgeTrash(time.Hour)
+	go purgeAccounts(time.Hour)
+	go runJobs(envDuration("JOBS_POLL_INTERVAL", 2*time.Second))
+	go purgeFinishedJobs(time.Hour)
+	go flushUsage(time.Minute, envDuration("ANALYTICS_RETENTION", 90*24*time.Hour))
+	go warmCache(warmList(envInt("CACHE_WARM_TITLES", 50)))
+	refresher = newCacheRefresher()
+	go refreshHotEntries(envDuration("CACHE_REFRESH_INTERVAL", time.Minute))
+
+	loadMaintenance()
+	go watchMaintenance(10 * time.Second)
+
+	router := gin.New()
+	router.Use(gin.Logger(), onlyFixtureRoutes, recordTraffic, recoverPanics, localize, filterContent, transformResponses, maintenanceGate, authenticate(os.Getenv("ADMIN_TOKEN")), recordUsage, trackCost, shedLoad, limitRoute, denyReadOnly, enforceQuota, debugEcho)
+	router.GET("/healthz", getHealth)
+	router.GET("/readyz", getReady)
+	router.GET("/.well-known/jwks.json", getJWKS)
+	router.GET("/testing", getFixtureIndex())
+
+	registerUI(router)
 
 	router.GET("/api/movie", getMovie)
+	router.GET("/api/search", getSearch)
+	router.GET("/api/search/all", getSearchAll)
+	router.GET("/api/search/local", getLocalSearch)
+	router.GET("/api/trending", getTrending)
+	router.GET("/api/estimate", getEstimate)
+	router.GET("/api/usage", getUsage)
+	router.GET("/api/movie/summary", getMovieSummary)
+	router.GET("/api/movie/related", getRelatedTitles)
 	router.GET("/api/episode", getEpisode)
+	router.GET("/api/series/trend", getSeriesTrend)
+	router.GET("/api/series/skippable", getSkippableEpisodes)
 	router.GET("/api/movies/genre", getMoviesByGenre)
+	router.GET("/api/movies/by-decade", getMoviesByDecade)
+	router.GET("/api/genres/related", getRelatedGenres)
+	router.GET("/api/i18n/labels", getLabels)
 	router.GET("/api/movies/recommendations", getRecommendations)
-
-	router.Run(":8080")
+	router.POST("/api/movies/recommendations/feedback", postRecommendationFeedback)
+	router.GET("/api/movies/similar", requireFlag(flagSimilarTitles), getSimilarMovies)
+	router.GET("/api/search/nl", requireFlag(flagNLSearch), getNaturalSearch)
+	router.POST("/api/chat", requireFlag(flagNLSearch), postChat)
+	router.GET("/api/quiz", getQuiz)
+
+	router.POST("/api/auth/register", postRegister)
+	router.POST("/api/auth/login", postLogin)
+	router.POST("/api/auth/session", postSession)
+	router.DELETE("/api/auth/session", deleteSession)
+	router.GET("/api/auth/oauth/:provider/login", getOAuthLogin)
+	router.GET("/api/auth/oauth/:provider/callback", getOAuthCallback)
+
+	me := router.Group("/api/users/me", requireUser)
+	me.GET("", getMe)
+	me.PATCH("", patchMe)
+	me.DELETE("", deleteMe)
+	me.DELETE("/deletion", cancelAccountDeletion)
+	me.GET("/export", getExport)
+	me.GET("/watches", getWatches)
+	me.POST("/watches", postWatch)
+	me.DELETE("/watches/:id", deleteWatch)
+	me.GET("/continue", getContinueWatching)
+	me.GET("/trash", getTrash)
+	me.POST("/trash/:kind/:id/restore", postTrashRestore)
+	me.DELETE("/trash/:kind/:id", deleteTrashEntry)
+	me.GET("/progress", getProgress)
+	me.GET("/progress/:seriesID", getSeriesProgress)
+	me.GET("/progress/:seriesID/next", getNextEpisode)
+	me.PUT("/progress/:seriesID", putSeriesProgress)
+	me.DELETE("/progress/:seriesID", deleteSeriesProgress)
+	me.GET("/year-in-review", getYearInReview)
+	me.GET("/profile", getTasteProfile)
+	me.GET("/notifications/ws", getNotificationSocket)
+	me.GET("/tags", getMyTags)
+	me.GET("/saved-searches", getSavedSearches)
+	me.POST("/saved-searches", postSavedSearch)
+	me.DELETE("/saved-searches/:id", deleteSavedSearch)
+	me.GET("/saved-searches/:id/results", getSavedSearchResults)
+	me.GET("/digest", getDigestPreference)
+	me.PUT("/digest", putDigestPreference)
+	router.GET("/api/digest/unsubscribe", getDigestUnsubscribe)
+
+	watchlist := router.Group("/api/watchlist", requireUser)
+	watchlist.GET("", getWatchlist)
+	watchlist.GET("/tonight", getTonight)
+	watchlist.POST("", postWatchlistItem)
+	watchlist.POST("/bulk", postWatchlistBulk)
+	watchlist.DELETE("/:imdbID", deleteWatchlistItem)
+
+	follows := router.Group("/api/follows", requireUser)
+	follows.GET("", getFollows)
+	follows.POST("", postFollow)
+	follows.DELETE("/:id", deleteFollow)
+
+	router.GET("/api/lists/curated", getCuratedLists)
+	router.GET("/api/lists/curated/:slug", getCuratedList)
+	lists := router.Group("/api/lists", requireUser)
+	lists.GET("", getLists)
+	lists.POST("", postList)
+	lists.GET("/:id", getList)
+	lists.PATCH("/:id", patchList)
+	lists.DELETE("/:id", deleteList)
+	lists.POST("/:id/items", postListItem)
+	lists.POST("/:id/bulk", postListBulk)
+	lists.DELETE("/:id/items/:imdbID", deleteListItem)
+
+	webhooks := router.Group("/api/webhooks", requireUser)
+	webhooks.GET("", getWebhooks)
+	webhooks.POST("", postWebhook)
+	webhooks.DELETE("/:id", deleteWebhook)
+	webhooks.POST("/:id/test", postWebhookTest)
+	webhooks.GET("/:id/deliveries", getWebhookDeliveries)
+
+	router.GET("/api/tags", getTagSuggestions)
+	router.GET("/api/tags/:tag", getTaggedTitles)
+	router.GET("/api/movies/:id/tags", getTitleTags)
+	tags := router.Group("/api/movies/:id/tags", requireUser)
+	tags.POST("", postUserTag)
+	tags.DELETE("/:tag", deleteUserTag)
+
+	router.GET("/api/movies/:id/comments", getComments)
+	comments := router.Group("/api/movies/:id/comments", requireUser)
+	comments.POST("", postComment)
+	comments.DELETE("/:commentID", deleteOwnComment)
+	comments.POST("/:commentID/report", postCommentReport)
+
+	router.GET("/u/:handle", getPublicProfile)
+	router.GET("/l/:id", getPublicList)
+	router.GET("/m/:imdbID", getMoviePage)
+	router.POST("/api/shorten", postShorten)
+	router.GET("/api/shorten/:code", getShortlinkStats)
+	router.GET("/s/:code", getShortlinkRedirect)
+	router.GET("/api/qr/:file", getMovieQR)
+
+	moderation := router.Group("/admin/moderation", requireRole(roleAdmin, roleModerator))
+	moderation.GET("/queue", getModerationQueue)
+	moderation.POST("/comments/:id/:commentID/hide", moderateComment(commentHidden))
+	moderation.POST("/comments/:id/:commentID/restore", moderateComment(commentVisible))
+	moderation.POST("/comments/:id/:commentID/dismiss", dismissReports)
+	moderation.DELETE("/comments/:id/:commentID", moderateComment(commentDeleted))
+
+	admin := router.Group("/admin", requireRole(roleAdmin))
+	admin.GET("/cache/stats", getCacheStats)
+	admin.GET("/maintenance", getMaintenance)
+	admin.PUT("/maintenance", putMaintenance)
+	admin.GET("/analytics", getAnalytics)
+	admin.POST("/cache/purge", purgeCache)
+	admin.GET("/cache/warm-list", getWarmList)
+	admin.POST("/cache/warm", postCacheWarm)
+	admin.GET("/cache/refresh", getCacheRefreshStats)
+	admin.GET("/upstream/recent", getRecentUpstream)
+	admin.GET("/load", getLoad)
+	admin.GET("/jobs/leader", getJobLeader)
+	admin.GET("/jobs", getJobs)
+	admin.GET("/jobs/:id", getJob)
+	admin.POST("/jobs/:id/retry", postJobRetry)
+	admin.GET("/config", getConfig)
+	admin.POST("/config/reload", postConfigReload)
+	admin.GET("/flags", getFlags)
+	admin.GET("/experiments/:name", getExperiment)
+	admin.GET("/users", getUsers)
+	admin.GET("/audit", getAuditLog)
+	admin.GET("/tenants", getTenants)
+	admin.POST("/tenants", postTenant)
+	admin.PATCH("/tenants/:id", patchTenant)
+	admin.DELETE("/tenants/:id", deleteTenant)
+	admin.PUT("/users/:id/role", putUserRole)
+	admin.GET("/api-keys", getAPIKeys)
+	admin.POST("/api-keys", postAPIKey)
+	admin.PATCH("/api-keys/:id", patchAPIKey)
+	admin.DELETE("/api-keys/:id", deleteAPIKey)
+	admin.PUT("/api-keys/:id/signing-secret", putSigningSecret)
+	admin.DELETE("/api-keys/:id/signing-secret", deleteSigningSecret)
+	admin.GET("/catalog/overrides", getCatalogOverrides)
+	admin.POST("/catalog/import", postCatalogImport)
+	admin.POST("/movies/:id/tags", postGlobalTag)
+	admin.DELETE("/movies/:id/tags/:tag", deleteGlobalTag)
+	admin.PUT("/curated-lists/:slug", putCuratedList)
+	admin.DELETE("/curated-lists/:slug", deleteCuratedList)
+	admin.GET("/jwt-keys", getSigningKeys)
+	admin.POST("/jwt-keys/rotate", postSigningKeyRotate)
+	admin.DELETE("/jwt-keys/:kid", deleteSigningKey)
+
+	if err := serveWith(withFixtures(router)); err != nil {
+		slog.Error("server failed", "error", err)
+	}
+	flushState()
 }
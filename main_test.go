@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"movie-api/omdb"
+)
+
+// The handler tests run the whole server in-process, as main does but
+// without listening, on a throwaway store and with fakeProvider standing
+// in for OMDb.
+
+var (
+	testServer http.Handler
+	fake       = newFakeProvider()
+)
+
+const testAdminToken = "test-admin"
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "movie-api-test")
+	if err != nil {
+		panic(err)
+	}
+	// Only the key check reaches the network; everything else goes
+	// through the fake provider.
+	keyCheck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"Title":"The Matrix","imdbID":"tt0133093","Response":"True"}`)
+	}))
+
+	isolate()
+	os.Setenv("STORE_PATH", filepath.Join(dir, "test.db"))
+	os.Setenv("OMDB_API_KEY", "test")
+	os.Setenv("OMDB_BASE_URL", keyCheck.URL+"/")
+	os.Setenv("ADMIN_TOKEN", testAdminToken)
+	os.Setenv("CACHE_WARM_TITLES", "0")
+	os.Setenv("CONTRACT_FIXTURES", "true")
+	os.Setenv("LOG_LEVEL", "error")
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	provider = fake
+
+	code := 0
+	run(func(h http.Handler) error {
+		testServer = h
+		code = m.Run()
+		return nil
+	})
+	keyCheck.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fakeProvider answers lookups for a handful of titles as OMDb would and
+// counts the lookups it is asked for.
+type fakeProvider struct {
+	mu     sync.Mutex
+	titles []MovieResponse
+	// failing makes lookups of these IMDb IDs or titles fail as if OMDb
+	// were down.
+	failing map[string]bool
+	calls   int
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{
+		titles: []MovieResponse{
+			{Title: "The Matrix", Year: "1999", Genre: "Action, Sci-Fi", Director: "Lana Wachowski, Lilly Wachowski", Type: "movie", IMDBID: "tt0133093", IMDBRating: "8.7", IMDBVotes: "2,000,000"},
+			{Title: "The Godfather", Year: "1972", Genre: "Crime, Drama", Director: "Francis Ford Coppola", Type: "movie", IMDBID: "tt0068646", IMDBRating: "9.2", IMDBVotes: "2,000,000"},
+			{Title: "Heat", Year: "1995", Genre: "Action, Crime, Drama", Director: "Michael Mann", Type: "movie", IMDBID: "tt0113277", IMDBRating: "8.3", IMDBVotes: "700,000"},
+		},
+		failing: map[string]bool{"tt0000502": true, "Unreachable": true},
+	}
+}
+
+func (f *fakeProvider) Lookup(params map[string]string) ([]byte, error) {
+	if _, err := omdb.Query(params, ""); err != nil {
+		return nil, invalidParameter(err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.failing[params["i"]] || f.failing[params["t"]] {
+		return nil, upstreamUnavailable(errors.New("connection refused"))
+	}
+	if q := strings.ToLower(params["s"]); q != "" {
+		results := SearchResults{Response: "True"}
+		for _, m := range f.titles {
+			if strings.Contains(strings.ToLower(m.Title), q) {
+				results.Search = append(results.Search, searchItem{Title: m.Title, Year: m.Year, IMDBID: m.IMDBID, Type: m.Type})
+			}
+		}
+		if len(results.Search) == 0 {
+			return []byte(`{"Response":"False","Error":"Movie not found!"}`), nil
+		}
+		results.TotalResults = strconv.Itoa(len(results.Search))
+		return json.Marshal(results)
+	}
+	for _, m := range f.titles {
+		if m.IMDBID == params["i"] || (params["t"] != "" && strings.EqualFold(m.Title, params["t"])) {
+			m.Response = "True"
+			return json.Marshal(m)
+		}
+	}
+	return []byte(`{"Response":"False","Error":"Incorrect IMDb ID."}`), nil
+}
+
+func (f *fakeProvider) lookups() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// withConfig runs the rest of the test with the config changed by edit,
+// and puts the old one back after.
+func withConfig(t *testing.T, edit func(c *Config)) {
+	t.Helper()
+	old := cfg()
+	c := *old
+	edit(&c)
+	currentConfig.Store(&c)
+	t.Cleanup(func() { currentConfig.Store(old) })
+}
+
+// chatHookRecorder is a Slack hook that hands over the text of each post.
+func chatHookRecorder(t *testing.T) (ChatHook, <-chan string) {
+	t.Helper()
+	posts := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct{ Text string }
+		json.NewDecoder(r.Body).Decode(&payload)
+		posts <- payload.Text
+	}))
+	t.Cleanup(srv.Close)
+	return ChatHook{Kind: "slack", URL: srv.URL}, posts
+}
+
+// decodeObject decodes a JSON object response, failing the test if it
+// isn't one.
+func decodeObject(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var got map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("response is not a JSON object: %v; body %s", err, w.Body)
+	}
+	return got
+}
+
+var adminHeaders = map[string]string{"Authorization": "Bearer " + testAdminToken}
+
+// newAPIKey creates an API key with role, for tenant if not empty, and
+// returns its plaintext.
+func newAPIKey(t *testing.T, role, tenant string) string {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"name": t.Name(), "role": role, "tenant_id": tenant})
+	w := request(http.MethodPost, "/admin/api-keys", adminHeaders, string(body))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create API key: status %d; body %s", w.Code, w.Body)
+	}
+	return decodeObject(t, w)["key"].(string)
+}
+
+// newUser registers a user and returns headers that sign in as them, and
+// their ID.
+func newUser(t *testing.T) (map[string]string, string) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"email": randomHex(6) + "@example.com", "password": "correct horse", "name": "Test"})
+	w := request(http.MethodPost, "/api/auth/register", nil, string(body))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register: status %d; body %s", w.Code, w.Body)
+	}
+	got := decodeObject(t, w)
+	user := got["user"].(map[string]interface{})
+	return map[string]string{"Authorization": "Bearer " + got["token"].(string)}, user["id"].(string)
+}
+
+// newTenant creates a tenant and returns its ID.
+func newTenant(t *testing.T) string {
+	t.Helper()
+	id := "t-" + randomHex(4)
+	w := request(http.MethodPost, "/admin/tenants", adminHeaders, `{"id":"`+id+`","name":"`+t.Name()+`"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create tenant: status %d; body %s", w.Code, w.Body)
+	}
+	return id
+}
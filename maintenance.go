@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	settingsBucket = "settings"
+	maintenanceKey = "maintenance"
+)
+
+// maintenanceState is kept in the store so every instance sharing it
+// picks up the switch, and so it survives restarts mid-migration.
+type maintenanceState struct {
+	Enabled    bool       `json:"enabled"`
+	Message    string     `json:"message,omitempty"`
+	RetryAfter int        `json:"retry_after_seconds"`
+	Since      *time.Time `json:"since,omitempty"`
+}
+
+var maintenance atomic.Pointer[maintenanceState]
+
+func loadMaintenance() {
+	var m maintenanceState
+	store.Get(settingsBucket, maintenanceKey, &m)
+	maintenance.Store(&m)
+}
+
+// watchMaintenance re-reads the switch so instances that didn't receive
+// the admin request follow along.
+func watchMaintenance(every time.Duration) {
+	for range time.Tick(every) {
+		loadMaintenance()
+	}
+}
+
+// maintenanceGate answers 503 while maintenance mode is on, except for
+// health checks and the admin API (so it can be switched off again).
+func maintenanceGate(c *gin.Context) {
+	m := maintenance.Load()
+	path := c.Request.URL.Path
+	if m == nil || !m.Enabled || path == "/healthz" || path == "/readyz" || strings.HasPrefix(path, "/admin/") {
+		c.Next()
+		return
+	}
+	c.Header("Retry-After", strconv.Itoa(m.RetryAfter))
+	message := m.Message
+	if message == "" {
+		message = "The API is down for maintenance"
+	}
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": message, "maintenance": true})
+}
+
+func getHealth(c *gin.Context) {
+	m := maintenance.Load()
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "maintenance": m != nil && m.Enabled})
+}
+
+func getMaintenance(c *gin.Context) {
+	c.JSON(http.StatusOK, maintenance.Load())
+}
+
+func putMaintenance(c *gin.Context) {
+	var req struct {
+		Enabled    *bool  `json:"enabled"`
+		Message    string `json:"message"`
+		RetryAfter int    `json:"retry_after_seconds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil || req.RetryAfter < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide {"enabled": true|false, "message": "...", "retry_after_seconds": N}`})
+		return
+	}
+	m := &maintenanceState{Enabled: *req.Enabled, Message: req.Message, RetryAfter: req.RetryAfter}
+	if m.RetryAfter == 0 {
+		m.RetryAfter = 300
+	}
+	if m.Enabled {
+		now := time.Now().UTC()
+		m.Since = &now
+		if prev := maintenance.Load(); prev != nil && prev.Enabled {
+			m.Since = prev.Since
+		}
+	}
+	if err := store.Put(settingsBucket, maintenanceKey, m); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save maintenance state"})
+		return
+	}
+	maintenance.Store(m)
+	c.JSON(http.StatusOK, m)
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// searchFilters is the structured form of a natural-language query.
+type searchFilters struct {
+	Keywords   []string `json:"keywords,omitempty"`
+	Genres     []string `json:"genres,omitempty"`
+	People     []string `json:"people,omitempty"`
+	YearFrom   int      `json:"year_from,omitempty"`
+	YearTo     int      `json:"year_to,omitempty"`
+	MinRuntime int      `json:"min_runtime,omitempty"`
+	MaxRuntime int      `json:"max_runtime,omitempty"`
+	MinRating  float64  `json:"min_rating,omitempty"`
+	Type       string   `json:"type,omitempty"`
+	Country    string   `json:"country,omitempty"`
+	Language   string   `json:"language,omitempty"`
+	// The critic minimums only come from ?min_metascore= and ?min_rt=.
+	MinMetascore int `json:"min_metascore,omitempty"`
+	MinRT        int `json:"min_rt,omitempty"`
+}
+
+// genreWords maps words people use to the OMDb genre they imply.
+var genreWords = map[string]string{
+	"action": "Action", "adventure": "Adventure", "animated": "Animation", "animation": "Animation",
+	"biopic": "Biography", "biography": "Biography", "comedy": "Comedy", "comedies": "Comedy",
+	"funny": "Comedy", "crime": "Crime", "heist": "Crime", "gangster": "Crime", "mob": "Crime",
+	"documentary": "Documentary", "documentaries": "Documentary", "drama": "Drama", "dramas": "Drama",
+	"family": "Family", "fantasy": "Fantasy", "noir": "Film-Noir", "history": "History",
+	"historical": "History", "horror": "Horror", "scary": "Horror", "musical": "Musical",
+	"musicals": "Musical", "mystery": "Mystery", "whodunit": "Mystery", "romance": "Romance",
+	"romantic": "Romance", "sci-fi": "Sci-Fi", "scifi": "Sci-Fi", "space": "Sci-Fi",
+	"sport": "Sport", "sports": "Sport", "thriller": "Thriller", "thrillers": "Thriller",
+	"war": "War", "western": "Western", "westerns": "Western",
+}
+
+var (
+	nlDecade     = regexp.MustCompile(`(?i)\b(?:(19|20)?(\d)0)'?s\b`)
+	nlYearRange  = regexp.MustCompile(`(?i)\b(?:from|between)\s+(\d{4})\s+(?:to|and|-)\s+(\d{4})\b`)
+	nlAfter      = regexp.MustCompile(`(?i)\b(?:after|since|newer than)\s+(\d{4})\b`)
+	nlBefore     = regexp.MustCompile(`(?i)\b(?:before|older than)\s+(\d{4})\b`)
+	nlInYear     = regexp.MustCompile(`(?i)\b(?:in|from)\s+(\d{4})\b`)
+	nlRuntime    = regexp.MustCompile(`(?i)\b(under|less than|over|more than|at least|longer than|shorter than)\s+(\d+(?:\.\d+)?)\s*(hours?|hrs?|h|minutes?|mins?|m)\b`)
+	nlRating     = regexp.MustCompile(`(?i)\b(?:rated|rating)\s+(?:above|over|at least)?\s*(\d(?:\.\d)?)\+?`)
+	nlPerson     = regexp.MustCompile(`\b(?:with|starring|featuring|directed by|by)\s+((?:[A-Z][\w'.-]*\s?){1,3})`)
+	nlType       = regexp.MustCompile(`(?i)\b(movies?|films?|series|shows?|tv shows?)\b`)
+	nlStopwords  = map[string]bool{"a": true, "an": true, "the": true, "and": true, "or": true, "of": true, "some": true, "good": true, "best": true, "top": true, "great": true}
+	nlWordSplits = regexp.MustCompile(`[^\w'-]+`)
+	// nlTitleWords imply a genre but are also worth searching titles for.
+	nlTitleWords = map[string]bool{"heist": true, "space": true, "gangster": true, "mob": true, "western": true}
+)
+
+// parseNaturalQuery extracts filters from q with simple patterns. Whatever
+// isn't consumed by a pattern becomes a title keyword.
+func parseNaturalQuery(q string) searchFilters {
+	var f searchFilters
+	rest := q
+
+	consume := func(re *regexp.Regexp, fn func(m []string)) {
+		for _, m := range re.FindAllStringSubmatch(rest, -1) {
+			fn(m)
+		}
+		rest = re.ReplaceAllString(rest, " ")
+	}
+
+	consume(nlPerson, func(m []string) {
+		f.People = append(f.People, strings.TrimSpace(m[1]))
+	})
+	consume(nlYearRange, func(m []string) {
+		f.YearFrom, _ = strconv.Atoi(m[1])
+		f.YearTo, _ = strconv.Atoi(m[2])
+	})
+	consume(nlAfter, func(m []string) { f.YearFrom, _ = strconv.Atoi(m[1]) })
+	consume(nlBefore, func(m []string) { f.YearTo, _ = strconv.Atoi(m[1]) })
+	consume(nlInYear, func(m []string) {
+		y, _ := strconv.Atoi(m[1])
+		f.YearFrom, f.YearTo = y, y
+	})
+	consume(nlDecade, func(m []string) {
+		century := m[1]
+		if century == "" {
+			century = "19"
+			if m[2] <= "2" {
+				century = "20"
+			}
+		}
+		start, _ := strconv.Atoi(century + m[2] + "0")
+		f.YearFrom, f.YearTo = start, start+9
+	})
+	consume(nlRuntime, func(m []string) {
+		n, _ := strconv.ParseFloat(m[2], 64)
+		minutes := int(n)
+		if strings.HasPrefix(strings.ToLower(m[3]), "h") {
+			minutes = int(n * 60)
+		}
+		switch strings.ToLower(m[1]) {
+		case "under", "less than", "shorter than":
+			f.MaxRuntime = minutes
+		default:
+			f.MinRuntime = minutes
+		}
+	})
+	consume(nlRating, func(m []string) { f.MinRating, _ = strconv.ParseFloat(m[1], 64) })
+	consume(nlType, func(m []string) {
+		if strings.HasPrefix(strings.ToLower(m[1]), "movie") || strings.HasPrefix(strings.ToLower(m[1]), "film") {
+			f.Type = "movie"
+		} else {
+			f.Type = "series"
+		}
+	})
+
+	for _, w := range nlWordSplits.Split(strings.ToLower(rest), -1) {
+		if w == "" || nlStopwords[w] {
+			continue
+		}
+		if g, ok := genreWords[w]; ok {
+			if !slices.Contains(f.Genres, g) {
+				f.Genres = append(f.Genres, g)
+			}
+			if !nlTitleWords[w] {
+				continue
+			}
+		}
+		f.Keywords = append(f.Keywords, w)
+	}
+	return f
+}
+
+const nlAssistPrompt = `You convert movie search requests into JSON filters.
+Reply with only a JSON object using these optional fields:
+keywords (array of title words), genres (array of IMDb genre names), people (array of actor/director names),
+year_from, year_to, min_runtime, max_runtime (minutes), min_rating (IMDb, 0-10), type ("movie" or "series"),
+country (production country), language (spoken language).`
+
+// assistWithLLM asks the configured model to interpret q and merges any
+// fields it fills in over the rule-based result.
+func assistWithLLM(ctx context.Context, q string, f searchFilters) (searchFilters, error) {
+	reply, err := llm.Complete(ctx, nlAssistPrompt, q)
+	if err != nil {
+		return f, err
+	}
+	reply = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(reply), "```json"), "```"))
+	var assisted searchFilters
+	if err := json.Unmarshal([]byte(reply), &assisted); err != nil {
+		return f, err
+	}
+	if len(assisted.Keywords) > 0 {
+		f.Keywords = assisted.Keywords
+	}
+	if len(assisted.Genres) > 0 {
+		f.Genres = assisted.Genres
+	}
+	if len(assisted.People) > 0 {
+		f.People = assisted.People
+	}
+	if assisted.YearFrom > 0 {
+		f.YearFrom = assisted.YearFrom
+	}
+	if assisted.YearTo > 0 {
+		f.YearTo = assisted.YearTo
+	}
+	if assisted.MinRuntime > 0 {
+		f.MinRuntime = assisted.MinRuntime
+	}
+	if assisted.MaxRuntime > 0 {
+		f.MaxRuntime = assisted.MaxRuntime
+	}
+	if assisted.MinRating > 0 {
+		f.MinRating = assisted.MinRating
+	}
+	if assisted.Type != "" {
+		f.Type = assisted.Type
+	}
+	if assisted.Country != "" {
+		f.Country = assisted.Country
+	}
+	if assisted.Language != "" {
+		f.Language = assisted.Language
+	}
+	return f, nil
+}
+
+func (f searchFilters) matches(m *MovieResponse) bool {
+	year, _ := strconv.Atoi(strings.TrimRight(m.Year, "–- "))
+	if f.YearFrom > 0 && year < f.YearFrom {
+		return false
+	}
+	if f.YearTo > 0 && (year == 0 || year > f.YearTo) {
+		return false
+	}
+	runtime := runtimeMinutes(m.Runtime)
+	if f.MinRuntime > 0 && runtime < f.MinRuntime {
+		return false
+	}
+	if f.MaxRuntime > 0 && (runtime == 0 || runtime > f.MaxRuntime) {
+		return false
+	}
+	if f.MinRating > 0 {
+		rating, ok := parseRating(m.IMDBRating)
+		if !ok || rating < f.MinRating {
+			return false
+		}
+	}
+	if !(titleFilter{
+		regionQuery{Country: f.Country, Language: f.Language},
+		criticsQuery{MinMetascore: f.MinMetascore, MinRT: f.MinRT},
+	}).matches(m) {
+		return false
+	}
+	for _, g := range f.Genres {
+		if !containsFold(m.Genre, g) {
+			return false
+		}
+	}
+	for _, p := range f.People {
+		if !strings.Contains(strings.ToLower(m.Actors+", "+m.Director), strings.ToLower(p)) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsFold reports whether the comma-separated OMDb list contains want.
+func containsFold(list, want string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), want) {
+			return true
+		}
+	}
+	return false
+}
+
+// seeds picks the title searches to run. OMDb only searches titles, so
+// keywords go first and genre names are the fallback.
+func (f searchFilters) seeds() []string {
+	seeds := []string{}
+	if len(f.Keywords) > 1 {
+		seeds = append(seeds, strings.Join(f.Keywords, " "))
+	}
+	seeds = append(seeds, f.Keywords...)
+	if len(seeds) == 0 {
+		for _, g := range f.Genres {
+			seeds = append(seeds, strings.ToLower(g))
+		}
+	}
+	return seeds
+}
+
+func getNaturalSearch(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide ?q=, e.g. ?q=90s heist movies with De Niro under 2 hours`})
+		return
+	}
+
+	filters := parseNaturalQuery(q)
+	parser := "rules"
+	var warning string
+	if llm != nil && c.DefaultQuery("assist", "true") == "true" {
+		assisted, err := assistWithLLM(c.Request.Context(), q, filters)
+		if err != nil {
+			warning = "llm assist failed, using rule-based interpretation: " + err.Error()
+		} else {
+			filters = assisted
+			parser = "rules+llm"
+		}
+	}
+
+	// Explicit ?country= and ?language= win over what was read from q.
+	var explicit titleFilter
+	if !bindQuery(c, &explicit) {
+		return
+	}
+	if explicit.Country != "" {
+		filters.Country = explicit.Country
+	}
+	if explicit.Language != "" {
+		filters.Language = explicit.Language
+	}
+	filters.MinMetascore, filters.MinRT = explicit.MinMetascore, explicit.MinRT
+
+	seeds := filters.seeds()
+	if len(seeds) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":       "could not find anything to search for in the query",
+			"interpreted": filters,
+		})
+		return
+	}
+
+	searchType := filters.Type
+	if searchType == "" {
+		searchType = "movie"
+	}
+
+	const detailBudget = 60
+	lookups := 0
+	seen := map[string]bool{}
+	results := []gin.H{}
+	// A lookup refused for the route's deadline or budget ends the scan;
+	// the response has what was found by then, marked truncated.
+scan:
+	for _, seed := range seeds {
+		for page := 1; page <= 2 && lookups < detailBudget; page++ {
+			var search SearchResults
+			err := fetchFromOMDb(scopedParams(c, map[string]string{"s": seed, "type": searchType, "page": strconv.Itoa(page)}), &search)
+			if errors.Is(err, errRequestLimit) {
+				break scan
+			}
+			if err != nil {
+				break
+			}
+			for _, item := range search.Search {
+				if seen[item.IMDBID] || lookups >= detailBudget {
+					continue
+				}
+				seen[item.IMDBID] = true
+				lookups++
+				movie, err := fetchMovie(scopedParams(c, map[string]string{"i": item.IMDBID}))
+				if errors.Is(err, errRequestLimit) {
+					break scan
+				}
+				if err != nil || !filters.matches(movie) {
+					continue
+				}
+				results = append(results, gin.H{
+					"Title":      movie.Title,
+					"Year":       movie.Year,
+					"Genre":      movie.Genre,
+					"Runtime":    movie.Runtime,
+					"Director":   movie.Director,
+					"imdbRating": movie.IMDBRating,
+					"imdbID":     movie.IMDBID,
+				})
+			}
+		}
+	}
+	sortByRating(results)
+	if len(results) > 20 {
+		results = results[:20]
+	}
+
+	resp := gin.H{
+		"query":       q,
+		"interpreted": filters,
+		"parser":      parser,
+		"results":     results,
+		"truncated":   costOf(c).isTruncated(),
+	}
+	if warning != "" {
+		resp["warning"] = warning
+	}
+	c.JSON(http.StatusOK, resp)
+}
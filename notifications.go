@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// notify tells a user about an event on every channel they can receive it
+// on: their webhooks and any open notification sockets.
+func notify(userID, event string, data interface{}) {
+	publishEvent(userID, event, data)
+	publishNotification(userID, event, data)
+}
+
+// socketHub tracks the notification websockets open per user.
+type socketHub struct {
+	mu    sync.Mutex
+	conns map[string]map[chan []byte]bool
+	// closing is closed when the server shuts down; open sockets are then
+	// asked to reconnect, to whichever process serves next.
+	closing   chan struct{}
+	closeOnce sync.Once
+	open      sync.WaitGroup
+}
+
+var notificationHub = &socketHub{conns: map[string]map[chan []byte]bool{}, closing: make(chan struct{})}
+
+func (h *socketHub) CloseAll() {
+	h.closeOnce.Do(func() { close(h.closing) })
+}
+
+// Wait waits for the sockets to close, or for ctx to end.
+func (h *socketHub) Wait(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		h.open.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+func (h *socketHub) subscribe(userID string) chan []byte {
+	ch := make(chan []byte, 16)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conns[userID] == nil {
+		h.conns[userID] = map[chan []byte]bool{}
+	}
+	h.conns[userID][ch] = true
+	return ch
+}
+
+func (h *socketHub) unsubscribe(userID string, ch chan []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns[userID], ch)
+	if len(h.conns[userID]) == 0 {
+		delete(h.conns, userID)
+	}
+}
+
+// Send drops the message for a socket whose buffer is full rather than
+// blocking the caller on a slow client.
+func (h *socketHub) Send(userID, event string, data interface{}) {
+	msg, err := json.Marshal(gin.H{"event": event, "data": data, "created_at": time.Now().UTC()})
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.conns[userID] {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+var upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 4096}
+
+func getNotificationSocket(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	notificationHub.open.Add(1)
+	defer notificationHub.open.Done()
+
+	userID := currentUser(c).ID
+	ch := notificationHub.subscribe(userID)
+	defer notificationHub.unsubscribe(userID, ch)
+
+	// The client never sends anything meaningful; reading just lets us
+	// notice when it goes away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case msg := <-ch:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-notificationHub.closing:
+			msg := websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server restarting; reconnect")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(5*time.Second))
+			return
+		}
+	}
+}
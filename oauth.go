@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+const (
+	oauthIdentitiesBucket = "oauth_identities"
+	// oauthStatesBucket remembers, per login in progress, which tenant it
+	// signs in to; the callback comes from a browser without the tenant's
+	// credentials.
+	oauthStatesBucket = "oauth_states"
+	oauthStateTTL     = 10 * time.Minute
+)
+
+type oauthState struct {
+	Tenant    string    `json:"tenant,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// oauthProfile is the subset of a provider's user info we rely on.
+type oauthProfile struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type oauthProvider struct {
+	config  *oauth2.Config
+	profile func(ctx context.Context, client *http.Client) (*oauthProfile, error)
+}
+
+var oauthProviders = map[string]*oauthProvider{}
+
+// initOAuth registers every provider that has client credentials in the
+// environment. Callback URLs are built from OAUTH_REDIRECT_BASE_URL.
+func initOAuth() {
+	base := strings.TrimRight(envString("OAUTH_REDIRECT_BASE_URL", "http://localhost:8080"), "/")
+	if id := envString("OAUTH_GOOGLE_CLIENT_ID", ""); id != "" {
+		oauthProviders["google"] = &oauthProvider{
+			config: &oauth2.Config{
+				ClientID:     id,
+				ClientSecret: envString("OAUTH_GOOGLE_CLIENT_SECRET", ""),
+				Endpoint:     endpoints.Google,
+				RedirectURL:  base + "/api/auth/oauth/google/callback",
+				Scopes:       []string{"openid", "email", "profile"},
+			},
+			profile: googleProfile,
+		}
+	}
+	if id := envString("OAUTH_GITHUB_CLIENT_ID", ""); id != "" {
+		oauthProviders["github"] = &oauthProvider{
+			config: &oauth2.Config{
+				ClientID:     id,
+				ClientSecret: envString("OAUTH_GITHUB_CLIENT_SECRET", ""),
+				Endpoint:     endpoints.GitHub,
+				RedirectURL:  base + "/api/auth/oauth/github/callback",
+				Scopes:       []string{"read:user", "user:email"},
+			},
+			profile: githubProfile,
+		}
+	}
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func googleProfile(ctx context.Context, client *http.Client) (*oauthProfile, error) {
+	var info struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := getJSON(ctx, client, "https://openidconnect.googleapis.com/v1/userinfo", &info); err != nil {
+		return nil, err
+	}
+	return &oauthProfile{Subject: info.Sub, Email: info.Email, EmailVerified: info.EmailVerified, Name: info.Name}, nil
+}
+
+func githubProfile(ctx context.Context, client *http.Client) (*oauthProfile, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user", &user); err != nil {
+		return nil, err
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user/emails", &emails); err != nil {
+		return nil, err
+	}
+	p := &oauthProfile{Subject: fmt.Sprint(user.ID), Name: user.Name}
+	if p.Name == "" {
+		p.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary {
+			p.Email, p.EmailVerified = e.Email, e.Verified
+		}
+	}
+	return p, nil
+}
+
+func getOAuthLogin(c *gin.Context) {
+	provider, ok := oauthProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown or unconfigured provider"})
+		return
+	}
+	purgeExpiredOAuthStates()
+	state := randomHex(16)
+	if err := store.Put(oauthStatesBucket, state, oauthState{Tenant: currentPrincipal(c).Tenant, ExpiresAt: time.Now().Add(oauthStateTTL)}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not start login"})
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie("oauth_state", state, int(oauthStateTTL.Seconds()), "/api/auth/oauth", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, provider.config.AuthCodeURL(state))
+}
+
+// purgeExpiredOAuthStates drops the states of logins that were abandoned.
+func purgeExpiredOAuthStates() {
+	now := time.Now()
+	var expired []string
+	store.ForEach(oauthStatesBucket, func(key string, value []byte) error {
+		var st oauthState
+		if json.Unmarshal(value, &st) != nil || now.After(st.ExpiresAt) {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	for _, key := range expired {
+		store.Delete(oauthStatesBucket, key)
+	}
+}
+
+func getOAuthCallback(c *gin.Context) {
+	name := c.Param("provider")
+	provider, ok := oauthProviders[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown or unconfigured provider"})
+		return
+	}
+	state, err := c.Cookie("oauth_state")
+	if err != nil || state == "" || state != c.Query("state") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid oauth state"})
+		return
+	}
+	c.SetCookie("oauth_state", "", -1, "/api/auth/oauth", "", c.Request.TLS != nil, true)
+	var login oauthState
+	found, _ := store.Get(oauthStatesBucket, state, &login)
+	store.Delete(oauthStatesBucket, state)
+	if !found || time.Now().After(login.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid oauth state"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+	defer cancel()
+	token, err := provider.config.Exchange(ctx, c.Query("code"))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "code exchange failed"})
+		return
+	}
+	profile, err := provider.profile(ctx, provider.config.Client(ctx, token))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "could not fetch profile: " + err.Error()})
+		return
+	}
+
+	u, err := userForOAuth(login.Tenant, name, profile)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Browser flows usually want to land back in the app with the token;
+	// API clients get the usual JSON.
+	if redirect := envString("OAUTH_SUCCESS_REDIRECT", ""); redirect != "" {
+		jwt, expires, err := issueToken(u)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not issue token"})
+			return
+		}
+		fragment := url.Values{"token": {jwt}, "expires_at": {expires.Format(time.RFC3339)}}
+		c.Redirect(http.StatusFound, redirect+"#"+fragment.Encode())
+		return
+	}
+	respondWithToken(c, http.StatusOK, u)
+}
+
+// userForOAuth finds tenant's user linked to this provider identity,
+// linking by verified email or creating a new passwordless account if
+// needed. The same identity can have an account in each tenant.
+func userForOAuth(tenant, provider string, p *oauthProfile) (*User, error) {
+	identity := tenantKey(tenant, provider+":"+p.Subject)
+	var id string
+	if found, _ := store.Get(oauthIdentitiesBucket, identity, &id); found {
+		if u, ok := loadUser(id); ok && u.TenantID == tenant {
+			return u, nil
+		}
+	}
+
+	email := strings.ToLower(p.Email)
+	if email == "" {
+		return nil, errors.New("provider did not share an email address")
+	}
+	var existing string
+	if found, _ := store.Get(usersByEmailBucket, tenantKey(tenant, email), &existing); found {
+		if !p.EmailVerified {
+			return nil, errors.New("an account with this email exists; verify the email with the provider to link it")
+		}
+		u, ok := loadUser(existing)
+		if !ok || u.TenantID != tenant {
+			return nil, errors.New("linked account no longer exists")
+		}
+		return u, store.Put(oauthIdentitiesBucket, identity, u.ID)
+	}
+
+	u := &User{ID: randomHex(12), Email: email, Name: p.Name, TenantID: tenant, CreatedAt: time.Now().UTC()}
+	if err := store.Put(usersBucket, u.ID, u); err != nil {
+		return nil, err
+	}
+	store.Put(usersByEmailBucket, tenantKey(tenant, email), u.ID)
+	return u, store.Put(oauthIdentitiesBucket, identity, u.ID)
+}
@@ -0,0 +1,55 @@
+package omdb
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Client sends lookups to OMDb. It is cheap to build, so callers can make
+// one per lookup with the current settings.
+type Client struct {
+	HTTP    *http.Client
+	BaseURL string
+	APIKey  string
+	// Observe, when set, is told about every request sent.
+	Observe func(Call)
+}
+
+// Call describes one request sent to OMDb. Query includes the API key.
+type Call struct {
+	Query  url.Values
+	Start  time.Time
+	Status int
+	Err    error
+}
+
+// Lookup sends params to OMDb and returns the body of its answer, which
+// may still be a Response=False error for the caller to decode. Params
+// OMDb wouldn't understand fail with a *ParamError before anything is
+// sent; 5xx answers are returned as errors.
+func (c *Client) Lookup(params map[string]string) ([]byte, error) {
+	query, err := Query(params, c.APIKey)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := c.HTTP.Get(c.BaseURL + "?" + query.Encode())
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	if c.Observe != nil {
+		c.Observe(Call{Query: query, Start: start, Status: status, Err: err})
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("omdb returned %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
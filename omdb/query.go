@@ -0,0 +1,111 @@
+// Package omdb builds and sends OMDb API requests. It knows nothing of
+// caching, tenants or request limits; the server puts those around it.
+package omdb
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MediaTypes are the values OMDb accepts for type.
+var MediaTypes = []string{"movie", "series", "episode", "game"}
+
+var (
+	IMDBIDPattern = regexp.MustCompile(`^tt\d{7,}$`)
+	YearPattern   = regexp.MustCompile(`^\d{4}$`)
+)
+
+// paramRules validates each parameter OMDb understands. Anything not
+// listed here is rejected rather than passed through.
+var paramRules = map[string]func(string) error{
+	"t":       nonEmpty(200),
+	"s":       nonEmpty(200),
+	"i":       matches(IMDBIDPattern, "an IMDb ID like tt0133093"),
+	"y":       matches(YearPattern, "a four-digit year"),
+	"type":    oneOf(MediaTypes...),
+	"plot":    oneOf("short", "full"),
+	"Season":  intBetween(1, 1000),
+	"Episode": intBetween(1, 10000),
+	"page":    intBetween(1, 100),
+}
+
+// ParamError is returned for params OMDb wouldn't understand; nothing
+// was sent.
+type ParamError struct {
+	Err error
+}
+
+func (e *ParamError) Error() string { return e.Err.Error() }
+
+func (e *ParamError) Unwrap() error { return e.Err }
+
+// Query turns lookup params into a properly escaped OMDb query, checking
+// every value first. One of t, i or s is required. Params whose names
+// start with "_" are the caller's own bookkeeping and are left out.
+func Query(params map[string]string, apiKey string) (url.Values, error) {
+	q := url.Values{}
+	for k, v := range params {
+		if strings.HasPrefix(k, "_") {
+			continue
+		}
+		rule, ok := paramRules[k]
+		if !ok {
+			return nil, &ParamError{fmt.Errorf("unsupported OMDb parameter %q", k)}
+		}
+		v = strings.TrimSpace(v)
+		if err := rule(v); err != nil {
+			return nil, &ParamError{fmt.Errorf("invalid %s: %w", k, err)}
+		}
+		q.Set(k, v)
+	}
+	if q.Get("t") == "" && q.Get("i") == "" && q.Get("s") == "" {
+		return nil, &ParamError{fmt.Errorf("one of t, i or s is required")}
+	}
+	q.Set("apikey", apiKey)
+	return q, nil
+}
+
+func nonEmpty(maxLen int) func(string) error {
+	return func(v string) error {
+		if v == "" {
+			return fmt.Errorf("must not be empty")
+		}
+		if len(v) > maxLen {
+			return fmt.Errorf("must be at most %d characters", maxLen)
+		}
+		return nil
+	}
+}
+
+func matches(re *regexp.Regexp, what string) func(string) error {
+	return func(v string) error {
+		if !re.MatchString(v) {
+			return fmt.Errorf("must be %s", what)
+		}
+		return nil
+	}
+}
+
+func oneOf(allowed ...string) func(string) error {
+	return func(v string) error {
+		for _, a := range allowed {
+			if v == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+	}
+}
+
+func intBetween(lo, hi int) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < lo || n > hi {
+			return fmt.Errorf("must be a number from %d to %d", lo, hi)
+		}
+		return nil
+	}
+}
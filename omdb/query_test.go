@@ -0,0 +1,138 @@
+package omdb
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestQueryMapsParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]string
+		want   url.Values
+	}{
+		{
+			name:   "title",
+			params: map[string]string{"t": "The Matrix"},
+			want:   url.Values{"t": {"The Matrix"}, "apikey": {"k"}},
+		},
+		{
+			name:   "id with plot",
+			params: map[string]string{"i": "tt0133093", "plot": "full"},
+			want:   url.Values{"i": {"tt0133093"}, "plot": {"full"}, "apikey": {"k"}},
+		},
+		{
+			name:   "search with type, year and page",
+			params: map[string]string{"s": "matrix", "type": "movie", "y": "1999", "page": "2"},
+			want:   url.Values{"s": {"matrix"}, "type": {"movie"}, "y": {"1999"}, "page": {"2"}, "apikey": {"k"}},
+		},
+		{
+			name:   "season and episode",
+			params: map[string]string{"i": "tt0903747", "Season": "1", "Episode": "3"},
+			want:   url.Values{"i": {"tt0903747"}, "Season": {"1"}, "Episode": {"3"}, "apikey": {"k"}},
+		},
+		{
+			name:   "values are trimmed",
+			params: map[string]string{"t": "  Heat ", "y": " 1995"},
+			want:   url.Values{"t": {"Heat"}, "y": {"1995"}, "apikey": {"k"}},
+		},
+		{
+			name:   "underscore params stay out of the query",
+			params: map[string]string{"i": "tt0133093", "_tenant": "acme", "_cost": "42"},
+			want:   url.Values{"i": {"tt0133093"}, "apikey": {"k"}},
+		},
+		{
+			name:   "special characters are escaped by Encode",
+			params: map[string]string{"t": "Tom & Jerry"},
+			want:   url.Values{"t": {"Tom & Jerry"}, "apikey": {"k"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Query(tt.params, "k")
+			if err != nil {
+				t.Fatalf("Query(%v) error: %v", tt.params, err)
+			}
+			if got.Encode() != tt.want.Encode() {
+				t.Errorf("Query(%v) = %s, want %s", tt.params, got.Encode(), tt.want.Encode())
+			}
+		})
+	}
+}
+
+func TestQueryRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]string
+		wantErr string
+	}{
+		{"nothing to look up", map[string]string{}, "one of t, i or s is required"},
+		{"only a year", map[string]string{"y": "1999"}, "one of t, i or s is required"},
+		{"only underscore params", map[string]string{"_tenant": "acme", "_cost": "1"}, "one of t, i or s is required"},
+		{"season without a title", map[string]string{"Season": "1"}, "one of t, i or s is required"},
+		{"unknown parameter", map[string]string{"t": "Heat", "r": "xml"}, `unsupported OMDb parameter "r"`},
+		{"api key smuggled in", map[string]string{"t": "Heat", "apikey": "other"}, `unsupported OMDb parameter "apikey"`},
+		{"lowercase season", map[string]string{"i": "tt0903747", "season": "1"}, `unsupported OMDb parameter "season"`},
+		{"blank title", map[string]string{"t": "   "}, "invalid t: must not be empty"},
+		{"long search", map[string]string{"s": strings.Repeat("a", 201)}, "invalid s: must be at most 200 characters"},
+		{"malformed id", map[string]string{"i": "0133093"}, "invalid i: must be an IMDb ID"},
+		{"short id", map[string]string{"i": "tt123"}, "invalid i: must be an IMDb ID"},
+		{"two-digit year", map[string]string{"t": "Heat", "y": "95"}, "invalid y: must be a four-digit year"},
+		{"unknown type", map[string]string{"s": "matrix", "type": "book"}, "invalid type: must be one of movie, series, episode, game"},
+		{"unknown plot", map[string]string{"t": "Heat", "plot": "long"}, "invalid plot: must be one of short, full"},
+		{"season zero", map[string]string{"i": "tt0903747", "Season": "0"}, "invalid Season: must be a number from 1 to 1000"},
+		{"episode too high", map[string]string{"i": "tt0903747", "Season": "1", "Episode": "10001"}, "invalid Episode: must be a number from 1 to 10000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Query(tt.params, "k")
+			if err == nil {
+				t.Fatalf("Query(%v) succeeded, want error containing %q", tt.params, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Query(%v) error = %q, want it to contain %q", tt.params, err, tt.wantErr)
+			}
+			var pe *ParamError
+			if !errors.As(err, &pe) {
+				t.Errorf("Query(%v) error is %T, want *ParamError", tt.params, err)
+			}
+		})
+	}
+}
+
+func TestQueryPageBounds(t *testing.T) {
+	tests := []struct {
+		page string
+		ok   bool
+	}{
+		{"1", true},
+		{"2", true},
+		{"100", true},
+		{" 7 ", true},
+		{"0", false},
+		{"-1", false},
+		{"101", false},
+		{"1.5", false},
+		{"two", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.page, func(t *testing.T) {
+			q, err := Query(map[string]string{"s": "matrix", "page": tt.page}, "k")
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("page %q: unexpected error %v", tt.page, err)
+				}
+				if got, want := q.Get("page"), strings.TrimSpace(tt.page); got != want {
+					t.Errorf("page %q: query has page=%q, want %q", tt.page, got, want)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "invalid page: must be a number from 1 to 100") {
+				t.Errorf("page %q: error = %v, want the page bounds error", tt.page, err)
+			}
+		})
+	}
+}
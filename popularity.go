@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"movie-api/omdb"
+)
+
+const popularityBucket = "popularity"
+
+// titlePopularity counts how often a title has been served. Score is the
+// same count decayed by the tracker's half-life as of LastServed, so it
+// favours what is popular now over what was popular once.
+type titlePopularity struct {
+	IMDBID     string    `json:"imdbID"`
+	Served     int64     `json:"served"`
+	Score      float64   `json:"score"`
+	LastServed time.Time `json:"last_served"`
+}
+
+// popularityTracker keeps the counts in memory and periodically adds
+// what was served since to the store, so replicas sharing it add up
+// their counts, then reloads them.
+type popularityTracker struct {
+	mu     sync.Mutex
+	titles map[string]*titlePopularity
+	// added is what was served here since the last flush.
+	added    map[string]*titlePopularity
+	halfLife time.Duration
+}
+
+var popularity = newPopularityTracker(3 * 24 * time.Hour)
+
+func newPopularityTracker(halfLife time.Duration) *popularityTracker {
+	return &popularityTracker{
+		titles:   map[string]*titlePopularity{},
+		added:    map[string]*titlePopularity{},
+		halfLife: halfLife,
+	}
+}
+
+// Load reads the counts from the store, on top of which it keeps what
+// hasn't been flushed yet.
+func (p *popularityTracker) Load() {
+	titles := map[string]*titlePopularity{}
+	store.ForEach(popularityBucket, func(key string, value []byte) error {
+		var t titlePopularity
+		if json.Unmarshal(value, &t) == nil {
+			titles[key] = &t
+		}
+		return nil
+	})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, d := range p.added {
+		merged := p.merge(titles[id], d)
+		titles[id] = &merged
+	}
+	p.titles = titles
+}
+
+// merge adds up two counts of the same title; a may be nil.
+func (p *popularityTracker) merge(a, b *titlePopularity) titlePopularity {
+	if a == nil {
+		return *b
+	}
+	at := a.LastServed
+	if b.LastServed.After(at) {
+		at = b.LastServed
+	}
+	return titlePopularity{
+		IMDBID:     b.IMDBID,
+		Served:     a.Served + b.Served,
+		Score:      p.decayed(a, at) + p.decayed(b, at),
+		LastServed: at,
+	}
+}
+
+// decayed is t's score as of now.
+func (p *popularityTracker) decayed(t *titlePopularity, now time.Time) float64 {
+	if p.halfLife <= 0 {
+		return t.Score
+	}
+	return t.Score * math.Exp2(-float64(now.Sub(t.LastServed))/float64(p.halfLife))
+}
+
+// Served records that a title was served.
+func (p *popularityTracker) Served(id string) {
+	if !omdb.IMDBIDPattern.MatchString(id) {
+		return
+	}
+	now := time.Now().UTC()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range []map[string]*titlePopularity{p.titles, p.added} {
+		t := m[id]
+		if t == nil {
+			t = &titlePopularity{IMDBID: id}
+			m[id] = t
+		}
+		t.Score = p.decayed(t, now) + 1
+		t.Served++
+		t.LastServed = now
+	}
+}
+
+// Score is a title's current decayed score; 0 if it was never served.
+func (p *popularityTracker) Score(id string) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.titles[id]; ok {
+		return p.decayed(t, time.Now())
+	}
+	return 0
+}
+
+// Top returns the n titles with the highest current score, with Score
+// brought up to date; n <= 0 returns them all.
+func (p *popularityTracker) Top(n int) []titlePopularity {
+	now := time.Now()
+	p.mu.Lock()
+	out := make([]titlePopularity, 0, len(p.titles))
+	for _, t := range p.titles {
+		current := *t
+		current.Score = roundTo(p.decayed(t, now), 3)
+		out = append(out, current)
+	}
+	p.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].IMDBID < out[j].IMDBID
+	})
+	if n > 0 && n < len(out) {
+		out = out[:n]
+	}
+	return out
+}
+
+func (p *popularityTracker) Flush() {
+	p.mu.Lock()
+	added := p.added
+	p.added = map[string]*titlePopularity{}
+	p.mu.Unlock()
+
+	var failed int
+	for id, d := range added {
+		var t titlePopularity
+		found, err := store.Modify(popularityBucket, id, &t, func() error {
+			t = p.merge(&t, d)
+			return nil
+		})
+		if err == nil && !found {
+			err = store.Put(popularityBucket, id, d)
+		}
+		if err != nil {
+			failed++
+			p.mu.Lock()
+			merged := p.merge(p.added[id], d)
+			p.added[id] = &merged
+			p.mu.Unlock()
+		}
+	}
+	if failed > 0 {
+		slog.Warn("popularity flush failed", "titles", failed)
+	}
+	p.Load()
+}
+
+func flushPopularity(every time.Duration) {
+	for range time.Tick(every) {
+		popularity.Flush()
+	}
+}
+
+var cachedTitlePattern = regexp.MustCompile(`(?:^|&)i=(tt\d{7,10})&`)
+
+// cachePriority ranks a cache entry for eviction by the popularity of the
+// title it holds. Searches and other entries rank lowest.
+func cachePriority(key string) float64 {
+	if m := cachedTitlePattern.FindStringSubmatch(key); m != nil {
+		return popularity.Score(m[1])
+	}
+	return 0
+}
+
+// warmList is the n most popular titles, the ones worth having cached
+// before anyone asks for them. n <= 0 turns warming off.
+func warmList(n int) []string {
+	ids := []string{}
+	if n <= 0 {
+		return ids
+	}
+	for _, t := range popularity.Top(n) {
+		ids = append(ids, t.IMDBID)
+	}
+	return ids
+}
+
+// warmCache looks up the titles that aren't cached, one at a time, and
+// returns how many it fetched. A failed lookup doesn't stop the rest; the
+// error reports how many failed and the last failure.
+func warmCache(ids []string) (int, error) {
+	fetched, failed := 0, 0
+	var lastErr error
+	for _, id := range ids {
+		params := map[string]string{"i": id}
+		if _, ok := upstreamCache.Peek(cacheKey(params)); ok {
+			continue
+		}
+		if _, err := fetchMovie(params); err != nil {
+			slog.Warn("cache warming lookup failed", "imdbID", id, "error", err)
+			failed, lastErr = failed+1, err
+			continue
+		}
+		fetched++
+	}
+	if fetched > 0 {
+		slog.Info("warmed cache", "titles", len(ids), "fetched", fetched)
+	}
+	if failed > 0 {
+		return fetched, fmt.Errorf("%d of %d cache warming lookups failed, last: %w", failed, len(ids), lastErr)
+	}
+	return fetched, nil
+}
+
+type warmListQuery struct {
+	Limit int `form:"limit,default=50" binding:"min=1,max=1000"`
+}
+
+// getWarmList shows the titles cache warming would fetch and whether
+// each is already cached.
+func getWarmList(c *gin.Context) {
+	var q warmListQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	items := []gin.H{}
+	for _, t := range popularity.Top(q.Limit) {
+		_, cached := upstreamCache.Peek(cacheKey(map[string]string{"i": t.IMDBID}))
+		items = append(items, gin.H{"imdbID": t.IMDBID, "score": t.Score, "served": t.Served, "cached": cached})
+	}
+	c.JSON(http.StatusOK, gin.H{"titles": items, "total": len(items)})
+}
+
+func init() {
+	registerJobKind("cache_warm", jobKind{
+		run: func(j *Job) error {
+			var ids []string
+			if err := json.Unmarshal(j.Payload, &ids); err != nil {
+				return err
+			}
+			_, err := warmCache(ids)
+			return err
+		},
+		maxAttempts: 3,
+	})
+}
+
+// postCacheWarm queues a job that fetches the uncached titles of the warm
+// list.
+func postCacheWarm(c *gin.Context) {
+	var q warmListQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	ids := warmList(q.Limit)
+	j, err := enqueueJob("cache_warm", "", ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not queue cache warming"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"titles": len(ids), "job": j.ID})
+}
+
+type trendingQuery struct {
+	Limit int    `form:"limit,default=20" binding:"min=1,max=100"`
+	Type  string `form:"type" binding:"omitempty,oneof=movie series episode game"`
+}
+
+// getTrending lists the titles served most lately, from the catalog; it
+// makes no upstream calls, so titles the catalog doesn't have are left
+// out.
+func getTrending(c *gin.Context) {
+	var q trendingQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	costOf(c).fromDataset()
+	items := []gin.H{}
+	for _, t := range popularity.Top(0) {
+		if len(items) == q.Limit {
+			break
+		}
+		m, ok := catalog.Get(t.IMDBID)
+		if !ok || (q.Type != "" && m.Type != q.Type) {
+			continue
+		}
+		items = append(items, gin.H{
+			"imdbID": m.IMDBID, "Title": m.Title, "Year": m.Year, "Type": m.Type,
+			"Poster": m.Poster, "imdbRating": m.IMDBRating,
+			"score": t.Score, "served": t.Served,
+		})
+	}
+	respondList(c, http.StatusOK, items, listMeta{Total: len(items)}, gin.H{"titles": items})
+}
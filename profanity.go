@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentFilterConfig turns on profanity masking in free text for
+// family-facing deployments. Fields names the JSON keys whose string
+// values are masked, wherever they appear in a response.
+type ContentFilterConfig struct {
+	Enabled bool     `json:"enabled"`
+	Fields  []string `json:"fields"`
+}
+
+func defaultContentFilterConfig() ContentFilterConfig {
+	return ContentFilterConfig{Fields: []string{"Plot", "body", "summary"}}
+}
+
+// wordList is one language's profanity. Entries ending in * match any
+// word with that prefix.
+type wordList struct {
+	words    map[string]bool
+	prefixes []string
+}
+
+func (l *wordList) matches(word string) bool {
+	if l.words[word] {
+		return true
+	}
+	for _, p := range l.prefixes {
+		if strings.HasPrefix(word, p) {
+			return true
+		}
+	}
+	return false
+}
+
+var profanity = map[string]*wordList{}
+
+// loadProfanity reads the lists shipped in web/profanity, then any
+// <lang>.txt in dir, which add to the built-in list of that language.
+func loadProfanity(dir string) {
+	builtin, _ := fs.Sub(webFiles, "web/profanity")
+	readWordLists(builtin)
+	if dir != "" {
+		readWordLists(os.DirFS(dir))
+	}
+}
+
+func readWordLists(fsys fs.FS) {
+	files, _ := fs.Glob(fsys, "*.txt")
+	for _, name := range files {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			slog.Warn("could not read word list", "file", name, "error", err)
+			continue
+		}
+		lang := strings.ToLower(strings.TrimSuffix(filepath.Base(name), ".txt"))
+		list, ok := profanity[lang]
+		if !ok {
+			list = &wordList{words: map[string]bool{}}
+			profanity[lang] = list
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+			if word == "" || strings.HasPrefix(word, "#") {
+				continue
+			}
+			if prefix, ok := strings.CutSuffix(word, "*"); ok {
+				list.prefixes = append(list.prefixes, prefix)
+			} else {
+				list.words[word] = true
+			}
+		}
+	}
+}
+
+// maskProfanity replaces every listed word in text with its first letter
+// followed by asterisks, checking the lists of langs. It reports whether
+// anything was masked.
+func maskProfanity(text string, langs ...string) (string, bool) {
+	var lists []*wordList
+	for _, lang := range langs {
+		if l, ok := profanity[lang]; ok {
+			lists = append(lists, l)
+		}
+	}
+	if len(lists) == 0 {
+		return text, false
+	}
+
+	var out strings.Builder
+	masked := false
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	for len(text) > 0 {
+		end := strings.IndexFunc(text, func(r rune) bool { return !isWord(r) })
+		if end == 0 {
+			_, size := utf8.DecodeRuneInString(text)
+			out.WriteString(text[:size])
+			text = text[size:]
+			continue
+		}
+		if end < 0 {
+			end = len(text)
+		}
+		word := text[:end]
+		text = text[end:]
+		lower := strings.ToLower(word)
+		hit := false
+		for _, l := range lists {
+			if l.matches(lower) {
+				hit = true
+				break
+			}
+		}
+		if !hit {
+			out.WriteString(word)
+			continue
+		}
+		masked = true
+		first, size := utf8.DecodeRuneInString(word)
+		out.WriteRune(first)
+		out.WriteString(strings.Repeat("*", utf8.RuneCountInString(word[size:])))
+	}
+	return out.String(), masked
+}
+
+// filterContent masks profanity in successful JSON responses when the
+// content filter is enabled. The request language's list applies on top
+// of the default language's, since plots and comments are mostly English.
+func filterContent(c *gin.Context) {
+	fc := cfg().ContentFilter
+	if !fc.Enabled || len(fc.Fields) == 0 {
+		c.Next()
+		return
+	}
+	fields := make(map[string]bool, len(fc.Fields))
+	for _, f := range fc.Fields {
+		fields[f] = true
+	}
+	langs := []string{defaultLanguage}
+	if lang := requestLanguage(c); lang != defaultLanguage {
+		langs = append(langs, lang)
+	}
+
+	w := &maskingWriter{heldJSONWriter: heldJSONWriter{ResponseWriter: c.Writer}, fields: fields, langs: langs}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+	w.flush()
+}
+
+// maskingWriter holds back successful JSON bodies so they can be filtered
+// once the handler is done.
+type maskingWriter struct {
+	heldJSONWriter
+	fields map[string]bool
+	langs  []string
+}
+
+func (w *maskingWriter) flush() {
+	if w.buf.Len() == 0 {
+		return
+	}
+	body := w.buf.Bytes()
+	if payload, ok := w.payload(); ok && w.mask(payload) {
+		if out, err := json.Marshal(payload); err == nil {
+			body = out
+		}
+	}
+	w.ResponseWriter.Write(body)
+}
+
+// mask filters the configured fields anywhere in v, in place.
+func (w *maskingWriter) mask(v interface{}) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if s, ok := child.(string); ok && w.fields[k] {
+				if masked, hit := maskProfanity(s, w.langs...); hit {
+					v[k] = masked
+					changed = true
+				}
+				continue
+			}
+			if w.mask(child) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if w.mask(child) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
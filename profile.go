@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type tasteStat struct {
+	Name      string  `json:"name"`
+	Count     int     `json:"count"`
+	AvgRating float64 `json:"avg_rating,omitempty"`
+	ratingSum float64
+	rated     int
+}
+
+type contrarianOpinion struct {
+	IMDBID     string  `json:"imdbID"`
+	Title      string  `json:"Title"`
+	Rating     float64 `json:"rating"`
+	IMDBRating float64 `json:"imdbRating"`
+	Difference float64 `json:"difference"`
+}
+
+// tasteProfile is a user's watch history reduced to the things they seem
+// to like. Most consumers only want the top few of each dimension.
+type tasteProfile struct {
+	Watches        int                 `json:"watches"`
+	Rated          int                 `json:"rated"`
+	Genres         []tasteStat         `json:"favorite_genres"`
+	Directors      []tasteStat         `json:"favorite_directors"`
+	Decades        []tasteStat         `json:"favorite_decades"`
+	AvgRatingGiven float64             `json:"avg_rating_given"`
+	AvgIMDbRating  float64             `json:"avg_imdb_rating"`
+	RatingBias     float64             `json:"rating_bias"`
+	MostContrarian []contrarianOpinion `json:"most_contrarian"`
+}
+
+func buildTasteProfile(watches []Watch, top int) tasteProfile {
+	genres := map[string]*tasteStat{}
+	directors := map[string]*tasteStat{}
+	decades := map[string]*tasteStat{}
+	add := func(stats map[string]*tasteStat, name string, rating float64) {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "N/A" {
+			return
+		}
+		s := stats[name]
+		if s == nil {
+			s = &tasteStat{Name: name}
+			stats[name] = s
+		}
+		s.Count++
+		if rating > 0 {
+			s.ratingSum += rating
+			s.rated++
+		}
+	}
+
+	p := tasteProfile{Watches: len(watches)}
+	var given, imdb float64
+	pairs := 0
+	contrarian := []contrarianOpinion{}
+	seen := map[string]bool{}
+	for _, w := range watches {
+		// Rewatches count towards tastes but a title is only compared to
+		// IMDb once.
+		for _, g := range strings.Split(w.Genre, ",") {
+			add(genres, g, w.Rating)
+		}
+		for _, d := range strings.Split(w.Director, ",") {
+			add(directors, d, w.Rating)
+		}
+		if year, err := strconv.Atoi(w.Year[:min(4, len(w.Year))]); err == nil {
+			add(decades, strconv.Itoa(year/10*10)+"s", w.Rating)
+		}
+
+		if w.Rating == 0 || seen[w.IMDBID] {
+			continue
+		}
+		seen[w.IMDBID] = true
+		p.Rated++
+		imdbRating, ok := parseRating(w.IMDBRating)
+		if !ok {
+			continue
+		}
+		given += w.Rating
+		imdb += imdbRating
+		pairs++
+		contrarian = append(contrarian, contrarianOpinion{
+			IMDBID:     w.IMDBID,
+			Title:      w.Title,
+			Rating:     w.Rating,
+			IMDBRating: imdbRating,
+			Difference: roundTo(w.Rating-imdbRating, 1),
+		})
+	}
+
+	if pairs > 0 {
+		p.AvgRatingGiven = roundTo(given/float64(pairs), 2)
+		p.AvgIMDbRating = roundTo(imdb/float64(pairs), 2)
+		p.RatingBias = roundTo(p.AvgRatingGiven-p.AvgIMDbRating, 2)
+	}
+	sort.Slice(contrarian, func(i, j int) bool {
+		return math.Abs(contrarian[i].Difference) > math.Abs(contrarian[j].Difference)
+	})
+	if len(contrarian) > top {
+		contrarian = contrarian[:top]
+	}
+	p.MostContrarian = contrarian
+	p.Genres = rankTaste(genres, top)
+	p.Directors = rankTaste(directors, top)
+	p.Decades = rankTaste(decades, top)
+	return p
+}
+
+// rankTaste orders by how often something was watched, then by how well it
+// was rated.
+func rankTaste(stats map[string]*tasteStat, n int) []tasteStat {
+	out := make([]tasteStat, 0, len(stats))
+	for _, s := range stats {
+		if s.rated > 0 {
+			s.AvgRating = roundTo(s.ratingSum/float64(s.rated), 2)
+		}
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		if out[i].AvgRating != out[j].AvgRating {
+			return out[i].AvgRating > out[j].AvgRating
+		}
+		return out[i].Name < out[j].Name
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+func getTasteProfile(c *gin.Context) {
+	c.JSON(http.StatusOK, buildTasteProfile(userWatches(currentUser(c).ID), 5))
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const progressBucket = "progress"
+
+// SeriesProgress records how far a user has watched a series: every
+// episode up to and including Season/Episode counts as watched.
+type SeriesProgress struct {
+	UserID    string    `json:"-"`
+	SeriesID  string    `json:"series_id"`
+	Title     string    `json:"title"`
+	Season    int       `json:"season"`
+	Episode   int       `json:"episode"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func progressKey(userID, seriesID string) string {
+	return userID + "/" + seriesID
+}
+
+func loadProgress(userID, seriesID string) (*SeriesProgress, bool) {
+	var p SeriesProgress
+	found, err := store.Get(progressBucket, progressKey(userID, seriesID), &p)
+	if err != nil || !found {
+		return nil, false
+	}
+	return &p, true
+}
+
+type seriesEpisode struct {
+	Season   int    `json:"season"`
+	Episode  int    `json:"episode"`
+	Title    string `json:"title"`
+	Released string `json:"released,omitempty"`
+	IMDBID   string `json:"imdbID"`
+	Aired    bool   `json:"aired"`
+}
+
+// seriesEpisodes flattens season pages into airing order. Episodes
+// without a parseable release date count as not aired yet.
+func seriesEpisodes(seasons []*SeasonResponse, now time.Time) []seriesEpisode {
+	out := []seriesEpisode{}
+	for _, s := range seasons {
+		season, _ := strconv.Atoi(s.Season)
+		for _, ep := range s.Episodes {
+			n, err := strconv.Atoi(ep.Episode)
+			if err != nil {
+				continue
+			}
+			released, err := time.Parse("2006-01-02", ep.Released)
+			e := seriesEpisode{Season: season, Episode: n, Title: ep.Title, IMDBID: ep.IMDBID, Aired: err == nil && !released.After(now)}
+			if err == nil {
+				e.Released = ep.Released
+			}
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Season != out[j].Season {
+			return out[i].Season < out[j].Season
+		}
+		return out[i].Episode < out[j].Episode
+	})
+	return out
+}
+
+func episodeAfter(season, episode, upToSeason, upToEpisode int) bool {
+	return season > upToSeason || (season == upToSeason && episode > upToEpisode)
+}
+
+type progressStatus struct {
+	SeriesProgress
+	WatchedEpisodes int            `json:"watched_episodes"`
+	AiredEpisodes   int            `json:"aired_episodes"`
+	UnwatchedAired  int            `json:"unwatched_aired_episodes"`
+	PercentComplete float64        `json:"percent_complete"`
+	CaughtUp        bool           `json:"caught_up"`
+	NextEpisode     *seriesEpisode `json:"next_episode"`
+}
+
+// progressOf works out completion against the episodes aired so far. The
+// next episode is the first one after the recorded progress, whether or
+// not it has aired.
+func progressOf(p SeriesProgress, episodes []seriesEpisode) progressStatus {
+	st := progressStatus{SeriesProgress: p}
+	for i, ep := range episodes {
+		after := episodeAfter(ep.Season, ep.Episode, p.Season, p.Episode)
+		if after && st.NextEpisode == nil {
+			st.NextEpisode = &episodes[i]
+		}
+		if !ep.Aired {
+			continue
+		}
+		st.AiredEpisodes++
+		if !after {
+			st.WatchedEpisodes++
+		}
+	}
+	st.UnwatchedAired = st.AiredEpisodes - st.WatchedEpisodes
+	if st.AiredEpisodes > 0 {
+		st.PercentComplete = roundTo(100*float64(st.WatchedEpisodes)/float64(st.AiredEpisodes), 1)
+	}
+	st.CaughtUp = st.UnwatchedAired == 0
+	return st
+}
+
+func seriesProgressStatus(c *gin.Context, p SeriesProgress) (progressStatus, error) {
+	_, seasons, err := lookupSeries(c, seriesQuery{ID: p.SeriesID})
+	if err != nil {
+		return progressStatus{}, err
+	}
+	return progressOf(p, seriesEpisodes(seasons, time.Now())), nil
+}
+
+// getProgress lists every series the user has recorded progress for,
+// most recently updated first.
+func getProgress(c *gin.Context) {
+	u := currentUser(c)
+	var all []SeriesProgress
+	store.ForEachPrefix(progressBucket, u.ID+"/", func(_ string, value []byte) error {
+		var p SeriesProgress
+		if json.Unmarshal(value, &p) == nil {
+			all = append(all, p)
+		}
+		return nil
+	})
+	sort.Slice(all, func(i, j int) bool { return all[i].UpdatedAt.After(all[j].UpdatedAt) })
+
+	out := []progressStatus{}
+	for _, p := range all {
+		st, err := seriesProgressStatus(c, p)
+		if err != nil {
+			respondUpstreamError(c, err)
+			return
+		}
+		out = append(out, st)
+	}
+	respondList(c, http.StatusOK, out, listMeta{Total: len(out)}, out)
+}
+
+// currentProgressStatus is the status for the :seriesID of the request;
+// a series without recorded progress counts as not started.
+func currentProgressStatus(c *gin.Context) (progressStatus, bool) {
+	u := currentUser(c)
+	p, ok := loadProgress(u.ID, c.Param("seriesID"))
+	if !ok {
+		p = &SeriesProgress{UserID: u.ID, SeriesID: c.Param("seriesID")}
+		series, err := fetchMovie(scopedParams(c, map[string]string{"i": p.SeriesID}))
+		if err != nil {
+			respondUpstreamError(c, err)
+			return progressStatus{}, false
+		}
+		p.Title = series.Title
+	}
+	st, err := seriesProgressStatus(c, *p)
+	if err != nil {
+		respondUpstreamError(c, err)
+		return progressStatus{}, false
+	}
+	return st, true
+}
+
+func getSeriesProgress(c *gin.Context) {
+	if st, ok := currentProgressStatus(c); ok {
+		c.JSON(http.StatusOK, st)
+	}
+}
+
+// getNextEpisode returns the first episode after the user's progress. It
+// is 404 once the user has watched every episode OMDb lists.
+func getNextEpisode(c *gin.Context) {
+	st, ok := currentProgressStatus(c)
+	if !ok {
+		return
+	}
+	if st.NextEpisode == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no unwatched episodes left"})
+		return
+	}
+	c.JSON(http.StatusOK, st.NextEpisode)
+}
+
+var episodeCode = regexp.MustCompile(`^[Ss](\d{1,4})[Ee](\d{1,5})$`)
+
+type progressRequest struct {
+	UpTo    string `json:"up_to"`
+	Season  int    `json:"season"`
+	Episode int    `json:"episode"`
+}
+
+// putSeriesProgress records progress as {"up_to": "S02E05"} or
+// {"season": 2, "episode": 5}. The episode must exist; {"season": 0,
+// "episode": 0} resets to not started.
+func putSeriesProgress(c *gin.Context) {
+	var req progressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide {"up_to": "S02E05"} or {"season": 2, "episode": 5}`})
+		return
+	}
+	if req.UpTo != "" {
+		m := episodeCode.FindStringSubmatch(strings.TrimSpace(req.UpTo))
+		if m == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "up_to must look like S02E05"})
+			return
+		}
+		req.Season, _ = strconv.Atoi(m[1])
+		req.Episode, _ = strconv.Atoi(m[2])
+	}
+	if req.Season < 0 || req.Episode < 0 || (req.Season == 0) != (req.Episode == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "season and episode must both be positive, or both 0"})
+		return
+	}
+
+	u := currentUser(c)
+	series, seasons, err := lookupSeries(c, seriesQuery{ID: c.Param("seriesID")})
+	if err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+	if series.Type != "series" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": series.IMDBID + " is a " + series.Type + ", not a series"})
+		return
+	}
+	episodes := seriesEpisodes(seasons, time.Now())
+	if req.Season > 0 {
+		found := false
+		for _, ep := range episodes {
+			if ep.Season == req.Season && ep.Episode == req.Episode {
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no such episode: S" + strconv.Itoa(req.Season) + "E" + strconv.Itoa(req.Episode)})
+			return
+		}
+	}
+
+	p := SeriesProgress{
+		UserID:    u.ID,
+		SeriesID:  series.IMDBID,
+		Title:     series.Title,
+		Season:    req.Season,
+		Episode:   req.Episode,
+		UpdatedAt: time.Now().UTC(),
+	}
+	if err := store.Put(progressBucket, progressKey(u.ID, p.SeriesID), p); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save progress"})
+		return
+	}
+	c.JSON(http.StatusOK, progressOf(p, episodes))
+}
+
+func deleteSeriesProgress(c *gin.Context) {
+	if err := store.Delete(progressBucket, progressKey(currentUser(c).ID, c.Param("seriesID"))); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete progress"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
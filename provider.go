@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"movie-api/omdb"
+)
+
+// Fetcher is what handlers look titles up through, by way of
+// fetchFromOMDb: it decodes the answer into out and owns caching, request
+// limits and fallbacks. Tests can swap it out whole, or keep it and swap
+// the Provider beneath it.
+type Fetcher interface {
+	Fetch(params map[string]string, out interface{}) error
+}
+
+var fetcher Fetcher = cachedFetcher{}
+
+func fetchFromOMDb(params map[string]string, out interface{}) error {
+	return fetcher.Fetch(params, out)
+}
+
+// Provider answers OMDb-style lookups with the raw OMDb JSON body. It is
+// the seam between the cachedFetcher and the network, so tests and
+// staging can substitute a fake.
+type Provider interface {
+	Lookup(params map[string]string) ([]byte, error)
+}
+
+var provider Provider = omdbProvider{}
+
+// omdbProvider calls the real OMDb API using the tenant's key and the
+// configured base URL and transport.
+type omdbProvider struct{}
+
+func (omdbProvider) Lookup(params map[string]string) ([]byte, error) {
+	client := omdb.Client{
+		HTTP:    omdbClient,
+		BaseURL: cfg().OMDbBaseURL,
+		APIKey:  omdbKeyFor(params[tenantParam]),
+		Observe: func(call omdb.Call) {
+			recordUpstream(params, call.Query, call.Start, call.Status, call.Err)
+			load.observeUpstream(time.Since(call.Start))
+		},
+	}
+	body, err := client.Lookup(params)
+	var pe *omdb.ParamError
+	if errors.As(err, &pe) {
+		return nil, invalidParameter(err)
+	}
+	omdbHealth.record(err)
+	if err != nil {
+		return nil, upstreamUnavailable(err)
+	}
+	return body, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Public pages live outside /api at stable, shareable URLs and are served
+// as JSON by default or as a server-rendered page to browsers.
+
+func wantsHTML(c *gin.Context) bool {
+	if !cfg().HTMLPages {
+		return false
+	}
+	if f := c.Query("format"); f != "" {
+		return f == "html"
+	}
+	return c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML
+}
+
+type publicProfile struct {
+	Handle string       `json:"handle"`
+	Name   string       `json:"name,omitempty"`
+	Taste  tasteProfile `json:"taste"`
+	Lists  []*List      `json:"lists"`
+}
+
+func getPublicProfile(c *gin.Context) {
+	var id string
+	tenant := currentPrincipal(c).Tenant
+	found, _ := store.Get(usersByHandleBucket, tenantKey(tenant, c.Param("handle")), &id)
+	u, ok := loadUser(id)
+	if !found || !ok || u.TenantID != tenant || !u.ProfilePublic {
+		c.JSON(http.StatusNotFound, gin.H{"error": "profile not found"})
+		return
+	}
+
+	profile := publicProfile{
+		Handle: u.Handle,
+		Name:   u.Name,
+		Taste:  buildTasteProfile(userWatches(u.ID), 5),
+		Lists:  userLists(u.ID, func(l *List) bool { return l.Visibility == visibilityPublic }),
+	}
+	// Individual ratings stay private even on a public profile.
+	profile.Taste.MostContrarian = nil
+
+	if wantsHTML(c) {
+		name := profile.Name
+		if name == "" {
+			name = "@" + profile.Handle
+		}
+		c.HTML(http.StatusOK, "profile.tmpl", gin.H{
+			"Meta": pageMeta{
+				Title:       name + " on Movie API",
+				Description: fmt.Sprintf("%d public lists", len(profile.Lists)),
+				Type:        "profile",
+				URL:         publicURL(c, "/u/"+profile.Handle),
+			},
+			"Profile": profile,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+func getPublicList(c *gin.Context) {
+	l, ok := loadList(c.Param("id"))
+	if !ok || l.Visibility == visibilityPrivate {
+		c.JSON(http.StatusNotFound, gin.H{"error": "list not found"})
+		return
+	}
+	if wantsHTML(c) {
+		description := l.Description
+		if description == "" {
+			description = fmt.Sprintf("A list of %d titles", len(l.Items))
+		}
+		c.HTML(http.StatusOK, "list.tmpl", gin.H{
+			"Meta": pageMeta{
+				Title:       l.Name,
+				Description: description,
+				Type:        "website",
+				URL:         publicURL(c, "/l/"+l.ID),
+			},
+			"List": l,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, l)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// getMovieQR renders a PNG QR code for /api/qr/{imdbID}.png. By default it
+// encodes the title's shortlink, which scans more reliably than the longer
+// page URL; ?target=page encodes the page URL directly.
+func getMovieQR(c *gin.Context) {
+	id, ok := strings.CutSuffix(c.Param("file"), ".png")
+	if !ok || id == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "use /api/qr/{imdbID}.png"})
+		return
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("size", "256"))
+	if err != nil || size < 64 || size > 1024 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size must be between 64 and 1024"})
+		return
+	}
+	if _, err := fetchMovie(scopedParams(c, map[string]string{"i": id})); err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+
+	target := publicURL(c, "/m/"+id)
+	if c.DefaultQuery("target", "short") == "short" {
+		link, _, err := ensureShortlink(currentPrincipal(c).Tenant, "movie", id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create shortlink"})
+			return
+		}
+		target = publicURL(c, "/s/"+link.Code)
+	}
+
+	png, err := qrcode.Encode(target, qrcode.Medium, size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not render QR code"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Data(http.StatusOK, "image/png", png)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type quizQuestion struct {
+	Question   string   `json:"question"`
+	Choices    []string `json:"choices"`
+	Answer     int      `json:"answer"`
+	Difficulty string   `json:"difficulty"`
+	Kind       string   `json:"kind"`
+	IMDBID     string   `json:"imdbID"`
+}
+
+// quizKinds are tried in random order for each title until one can be
+// built; each returns false when the title lacks the data it needs.
+var quizKinds = []func(m *MovieResponse, pool []*MovieResponse, r *rand.Rand) (quizQuestion, bool){
+	yearQuestion,
+	directorQuestion,
+	castQuestion,
+	plotQuestion,
+}
+
+func getQuiz(c *gin.Context) {
+	var q quizQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	genre, count := q.Genre, q.Count
+
+	pool := catalog.All(func(m *MovieResponse) bool {
+		return m.Type != "episode" && (genre == "" || containsFold(m.Genre, genre))
+	})
+	if len(pool) < 4 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "not enough cached titles to build a quiz yet; browse some titles first",
+			"known": len(pool),
+		})
+		return
+	}
+
+	// The catalog comes back in no particular order; sorting it first lets
+	// a seed decide the quiz alone.
+	sort.Slice(pool, func(i, j int) bool { return pool[i].IMDBID < pool[j].IMDBID })
+	r := requestRand(c)
+	r.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+
+	questions := []quizQuestion{}
+	for _, m := range pool {
+		if len(questions) >= count {
+			break
+		}
+		for _, k := range r.Perm(len(quizKinds)) {
+			if q, ok := quizKinds[k](m, pool, r); ok {
+				questions = append(questions, q)
+				break
+			}
+		}
+	}
+
+	costOf(c).fromDataset()
+	setProvenance(c)
+	c.JSON(http.StatusOK, gin.H{
+		"genre":     genre,
+		"count":     len(questions),
+		"questions": questions,
+	})
+}
+
+func yearQuestion(m *MovieResponse, _ []*MovieResponse, r *rand.Rand) (quizQuestion, bool) {
+	year, err := strconv.Atoi(m.Year)
+	if err != nil {
+		return quizQuestion{}, false
+	}
+	offsets := []int{-3, -2, -1, 1, 2, 3}
+	r.Shuffle(len(offsets), func(i, j int) { offsets[i], offsets[j] = offsets[j], offsets[i] })
+	choices := []string{m.Year}
+	for _, off := range offsets[:3] {
+		choices = append(choices, strconv.Itoa(year+off))
+	}
+	return newQuestion(m, "year", fmt.Sprintf("In what year was %q released?", m.Title), choices, r), true
+}
+
+func directorQuestion(m *MovieResponse, pool []*MovieResponse, r *rand.Rand) (quizQuestion, bool) {
+	director := firstListItem(m.Director)
+	if director == "" {
+		return quizQuestion{}, false
+	}
+	choices, ok := distractors(director, pool, r, func(o *MovieResponse) string { return firstListItem(o.Director) })
+	if !ok {
+		return quizQuestion{}, false
+	}
+	return newQuestion(m, "director", fmt.Sprintf("Who directed %q (%s)?", m.Title, m.Year), choices, r), true
+}
+
+func castQuestion(m *MovieResponse, pool []*MovieResponse, r *rand.Rand) (quizQuestion, bool) {
+	actor := firstListItem(m.Actors)
+	if actor == "" {
+		return quizQuestion{}, false
+	}
+	choices, ok := distractors(actor, pool, r, func(o *MovieResponse) string {
+		if strings.Contains(m.Actors, firstListItem(o.Actors)) {
+			return ""
+		}
+		return firstListItem(o.Actors)
+	})
+	if !ok {
+		return quizQuestion{}, false
+	}
+	return newQuestion(m, "cast", fmt.Sprintf("Who stars in %q (%s)?", m.Title, m.Year), choices, r), true
+}
+
+func plotQuestion(m *MovieResponse, pool []*MovieResponse, r *rand.Rand) (quizQuestion, bool) {
+	if m.Plot == "" || m.Plot == "N/A" || strings.Contains(m.Plot, m.Title) {
+		return quizQuestion{}, false
+	}
+	choices, ok := distractors(m.Title, pool, r, func(o *MovieResponse) string { return o.Title })
+	if !ok {
+		return quizQuestion{}, false
+	}
+	return newQuestion(m, "plot", fmt.Sprintf("Which title is this? %q", m.Plot), choices, r), true
+}
+
+// distractors returns answer plus three different wrong choices drawn from
+// pool.
+func distractors(answer string, pool []*MovieResponse, r *rand.Rand, pick func(*MovieResponse) string) ([]string, bool) {
+	choices := []string{answer}
+	used := map[string]bool{answer: true}
+	for _, i := range r.Perm(len(pool)) {
+		if len(choices) == 4 {
+			break
+		}
+		v := pick(pool[i])
+		if v == "" || used[v] {
+			continue
+		}
+		used[v] = true
+		choices = append(choices, v)
+	}
+	return choices, len(choices) == 4
+}
+
+// newQuestion shuffles choices (whose first element is the right answer)
+// and rates difficulty by how well-known the title is.
+func newQuestion(m *MovieResponse, kind, question string, choices []string, r *rand.Rand) quizQuestion {
+	correct := choices[0]
+	r.Shuffle(len(choices), func(i, j int) { choices[i], choices[j] = choices[j], choices[i] })
+	answer := 0
+	for i, c := range choices {
+		if c == correct {
+			answer = i
+		}
+	}
+
+	votes := parseVotes(m.IMDBVotes)
+	difficulty := "hard"
+	switch {
+	case votes >= 500000:
+		difficulty = "easy"
+	case votes >= 100000:
+		difficulty = "medium"
+	}
+	if kind == "year" && difficulty == "easy" {
+		difficulty = "medium"
+	}
+
+	return quizQuestion{
+		Question:   question,
+		Choices:    choices,
+		Answer:     answer,
+		Difficulty: difficulty,
+		Kind:       kind,
+		IMDBID:     m.IMDBID,
+	}
+}
+
+func firstListItem(list string) string {
+	first := strings.TrimSpace(strings.Split(list, ",")[0])
+	if first == "N/A" {
+		return ""
+	}
+	return first
+}
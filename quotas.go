@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const quotaUsageBucket = "quota_usage"
+
+// QuotaLimits caps requests per UTC day and calendar month. Zero means
+// unlimited.
+type QuotaLimits struct {
+	Daily   int64 `json:"daily,omitempty"`
+	Monthly int64 `json:"monthly,omitempty"`
+}
+
+// QuotasConfig holds the defaults for callers without their own limits.
+type QuotasConfig struct {
+	Users QuotaLimits `json:"users"`
+	Keys  QuotaLimits `json:"keys"`
+}
+
+// quotaCounter counts requests per scope ("key:ID", "user:ID",
+// "tenant:ID") and period. Counts live in memory and what was added to
+// them is flushed to the store periodically, so a crash loses at most one
+// flush interval. Flushes add to the stored count rather than overwrite
+// it, so replicas sharing a store sum their requests; each replica sees
+// the others' after its next flush.
+type quotaCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+	// added is what was counted here since the last flush.
+	added map[string]int64
+}
+
+var quotas = &quotaCounter{counts: map[string]int64{}, added: map[string]int64{}}
+
+type quotaPeriod struct {
+	name    string
+	key     string
+	resetAt time.Time
+	limit   func(QuotaLimits) int64
+}
+
+func quotaPeriods(now time.Time) []quotaPeriod {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return []quotaPeriod{
+		{"daily", "d:" + day.Format("2006-01-02"), day.AddDate(0, 0, 1), func(l QuotaLimits) int64 { return l.Daily }},
+		{"monthly", "m:" + month.Format("2006-01"), month.AddDate(0, 1, 0), func(l QuotaLimits) int64 { return l.Monthly }},
+	}
+}
+
+// get must be called with mu held.
+func (q *quotaCounter) get(key string) int64 {
+	n, ok := q.counts[key]
+	if !ok {
+		store.Get(quotaUsageBucket, key, &n)
+		q.counts[key] = n
+	}
+	return n
+}
+
+func (q *quotaCounter) Used(scope string, p quotaPeriod) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.get(scope + "|" + p.key)
+}
+
+// Take consumes one request from every scope if none of them is over its
+// limit. On refusal it returns the exhausted scope and period.
+func (q *quotaCounter) Take(scopes []quotaScope, now time.Time) (*quotaScope, *quotaPeriod, bool) {
+	periods := quotaPeriods(now)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range scopes {
+		for j := range periods {
+			limit := periods[j].limit(scopes[i].Limits)
+			if limit > 0 && q.get(scopes[i].Name+"|"+periods[j].key) >= limit {
+				return &scopes[i], &periods[j], false
+			}
+		}
+	}
+	for _, s := range scopes {
+		for _, p := range periods {
+			key := s.Name + "|" + p.key
+			n := q.get(key) + 1
+			q.counts[key] = n
+			q.added[key]++
+			if limit := p.limit(s.Limits); limit >= 10 && n == limit*9/10 {
+				announce(eventQuotaNearlyExhausted, fmt.Sprintf("%s has used 90%% of its %s quota (%d of %d requests).", s.Name, p.name, n, limit))
+			}
+		}
+	}
+	return nil, nil, true
+}
+
+// Flush adds what was counted since the last flush to the store, picks
+// up what other replicas counted and forgets the counts of past periods.
+func (q *quotaCounter) Flush() {
+	current := map[string]bool{}
+	for _, p := range quotaPeriods(time.Now()) {
+		current[p.key] = true
+	}
+	q.mu.Lock()
+	added := q.added
+	q.added = map[string]int64{}
+	for key := range q.counts {
+		if _, period, _ := strings.Cut(key, "|"); !current[period] {
+			delete(q.counts, key)
+		}
+	}
+	keys := make([]string, 0, len(q.counts))
+	for key := range q.counts {
+		keys = append(keys, key)
+	}
+	q.mu.Unlock()
+
+	var failed int
+	for key, n := range added {
+		if err := addQuotaUsage(key, n); err != nil {
+			failed++
+			q.mu.Lock()
+			q.added[key] += n
+			q.mu.Unlock()
+		}
+	}
+	if failed > 0 {
+		slog.Warn("quota flush failed", "counters", failed)
+	}
+	stored := make(map[string]int64, len(keys))
+	for _, key := range keys {
+		var n int64
+		if _, err := store.Get(quotaUsageBucket, key, &n); err == nil {
+			stored[key] = n
+		}
+	}
+	q.mu.Lock()
+	for key, n := range stored {
+		if _, ok := q.counts[key]; ok {
+			q.counts[key] = n + q.added[key]
+		}
+	}
+	q.mu.Unlock()
+
+	var expired []string
+	store.ForEach(quotaUsageBucket, func(key string, _ []byte) error {
+		if _, period, _ := strings.Cut(key, "|"); !current[period] {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	for _, key := range expired {
+		store.Delete(quotaUsageBucket, key)
+	}
+}
+
+func addQuotaUsage(key string, n int64) error {
+	var count int64
+	found, err := store.Modify(quotaUsageBucket, key, &count, func() error {
+		count += n
+		return nil
+	})
+	if err == nil && !found {
+		err = store.Put(quotaUsageBucket, key, n)
+	}
+	return err
+}
+
+func flushQuotas(every time.Duration) {
+	for range time.Tick(every) {
+		quotas.Flush()
+	}
+}
+
+type quotaScope struct {
+	Name   string
+	Limits QuotaLimits
+}
+
+// quotaScopes lists the limits that apply to the caller: their key or
+// user account, plus their tenant. Admins and anonymous callers have no
+// quota (anonymous traffic is covered by rate limiting).
+func quotaScopes(p *principal) []quotaScope {
+	var scopes []quotaScope
+	switch {
+	case p.Role == roleAdmin || p.Kind == "":
+		return nil
+	case p.Kind == "key" && p.Key != nil:
+		limits := p.Key.Quota
+		if limits == (QuotaLimits{}) {
+			limits = cfg().Quotas.Keys
+		}
+		scopes = append(scopes, quotaScope{"key:" + p.ID, limits})
+	case p.Kind == "user":
+		scopes = append(scopes, quotaScope{"user:" + p.ID, cfg().Quotas.Users})
+	}
+	if p.Tenant != "" {
+		if t, ok := loadTenant(p.Tenant); ok {
+			scopes = append(scopes, quotaScope{"tenant:" + t.ID, t.Quota})
+		}
+	}
+	return scopes
+}
+
+// enforceQuota counts /api requests against the caller's quotas and
+// rejects them with 429 once one is used up.
+func enforceQuota(c *gin.Context) {
+	if !strings.HasPrefix(c.Request.URL.Path, "/api/") || c.Request.URL.Path == "/api/usage" {
+		c.Next()
+		return
+	}
+	scopes := quotaScopes(currentPrincipal(c))
+	if len(scopes) == 0 {
+		c.Next()
+		return
+	}
+	now := time.Now()
+	scope, period, ok := quotas.Take(scopes, now)
+	if !ok {
+		limit := period.limit(scope.Limits)
+		setQuotaHeaders(c, limit, 0, period.resetAt)
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(period.resetAt).Seconds())+1))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error":    period.name + " quota exceeded",
+			"scope":    scope.Name,
+			"limit":    limit,
+			"reset_at": period.resetAt,
+		})
+		return
+	}
+	// Report whichever limited quota has the least left.
+	best := int64(-1)
+	for _, s := range scopes {
+		for _, p := range quotaPeriods(now) {
+			limit := p.limit(s.Limits)
+			if limit == 0 {
+				continue
+			}
+			if remaining := limit - quotas.Used(s.Name, p); best < 0 || remaining < best {
+				best = remaining
+				setQuotaHeaders(c, limit, remaining, p.resetAt)
+			}
+		}
+	}
+	c.Next()
+}
+
+func setQuotaHeaders(c *gin.Context, limit, remaining int64, reset time.Time) {
+	c.Header("X-Quota-Limit", strconv.FormatInt(limit, 10))
+	c.Header("X-Quota-Remaining", strconv.FormatInt(max(remaining, 0), 10))
+	c.Header("X-Quota-Reset", strconv.FormatInt(reset.Unix(), 10))
+}
+
+// getUsage shows the caller how much of each quota they've used.
+func getUsage(c *gin.Context) {
+	p := currentPrincipal(c)
+	if p.Kind == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	now := time.Now()
+	report := []gin.H{}
+	for _, s := range quotaScopes(p) {
+		for _, period := range quotaPeriods(now) {
+			used := quotas.Used(s.Name, period)
+			entry := gin.H{"scope": s.Name, "period": period.name, "used": used, "reset_at": period.resetAt}
+			if limit := period.limit(s.Limits); limit > 0 {
+				entry["limit"] = limit
+				entry["remaining"] = max(limit-used, 0)
+			}
+			report = append(report, entry)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"quotas": report})
+}
+
+// patchAPIKey updates a key's quota; a zero quota falls back to the
+// configured default for keys.
+func patchAPIKey(c *gin.Context) {
+	var req struct {
+		Quota *QuotaLimits `json:"quota"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Quota == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide {"quota": {"daily": N, "monthly": N}}`})
+		return
+	}
+	_, hash, _ := findAPIKeyByID(c.Param("id"))
+	var k APIKey
+	found, err := store.Modify(apiKeysBucket, hash, &k, func() error {
+		k.Quota = *req.Quota
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save key"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+		return
+	}
+	k.Hash = ""
+	k.Signing, k.SigningSecret = k.SigningSecret != "", ""
+	c.JSON(http.StatusOK, k)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestKeyQuota(t *testing.T) {
+	w := request(http.MethodPost, "/admin/api-keys", adminHeaders, `{"name":"metered","role":"user","quota":{"daily":2}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create key: status %d; body %s", w.Code, w.Body)
+	}
+	created := decodeObject(t, w)
+	key := map[string]string{"X-API-Key": created["key"].(string)}
+	id := created["api_key"].(map[string]interface{})["id"].(string)
+
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "first request",
+			path:        "/api/movie?id=tt0133093",
+			headers:     key,
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"X-Quota-Limit": "2", "X-Quota-Remaining": "1"},
+			wantLookups: -1,
+		},
+		{
+			name:        "usage isn't counted",
+			path:        "/api/usage",
+			headers:     key,
+			wantStatus:  http.StatusOK,
+			wantLookups: 0,
+		},
+		{
+			name:        "last request of the day",
+			path:        "/api/movie?id=tt0133093",
+			headers:     key,
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"X-Quota-Remaining": "0"},
+			wantLookups: -1,
+		},
+		{
+			name:        "over quota",
+			path:        "/api/movie?id=tt0133093",
+			headers:     key,
+			wantStatus:  http.StatusTooManyRequests,
+			wantJSON:    map[string]string{"error": "daily quota exceeded", "scope": "key:" + id, "limit": "2"},
+			wantHeaders: map[string]string{"X-Quota-Remaining": "0"},
+			wantLookups: 0,
+		},
+		{
+			name:        "quota of an unknown key",
+			method:      http.MethodPatch,
+			path:        "/admin/api-keys/nowhere",
+			headers:     adminHeaders,
+			body:        `{"quota":{"daily":5}}`,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "quota missing",
+			method:      http.MethodPatch,
+			path:        "/admin/api-keys/" + id,
+			headers:     adminHeaders,
+			body:        `{}`,
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+		{
+			name:        "raise the quota",
+			method:      http.MethodPatch,
+			path:        "/admin/api-keys/" + id,
+			headers:     adminHeaders,
+			body:        `{"quota":{"daily":5}}`,
+			wantStatus:  http.StatusOK,
+			wantNoKeys:  []string{"hash", "signing_secret"},
+			wantLookups: 0,
+		},
+		{
+			name:        "under the new quota",
+			path:        "/api/movie?id=tt0133093",
+			headers:     key,
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"X-Quota-Limit": "5", "X-Quota-Remaining": "2"},
+			wantLookups: -1,
+		},
+	})
+
+	w = request(http.MethodGet, "/api/usage", key, "")
+	var usage struct {
+		Quotas []struct {
+			Scope     string `json:"scope"`
+			Period    string `json:"period"`
+			Used      int64  `json:"used"`
+			Limit     int64  `json:"limit"`
+			Remaining int64  `json:"remaining"`
+		} `json:"quotas"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &usage); err != nil {
+		t.Fatalf("usage: %v; body %s", err, w.Body)
+	}
+	var daily bool
+	for _, q := range usage.Quotas {
+		if q.Scope == "key:"+id && q.Period == "daily" {
+			daily = true
+			if q.Used != 3 || q.Limit != 5 || q.Remaining != 2 {
+				t.Errorf("daily usage = %+v, want 3 used of 5", q)
+			}
+		}
+	}
+	if !daily {
+		t.Errorf("usage has no daily quota for the key: %s", w.Body)
+	}
+}
+
+func TestQuotaDefaults(t *testing.T) {
+	withConfig(t, func(c *Config) { c.Quotas.Users = QuotaLimits{Daily: 1} })
+	user, id := newUser(t)
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "anonymous callers have no quota",
+			path:        "/api/usage",
+			wantStatus:  http.StatusUnauthorized,
+			wantLookups: 0,
+		},
+		{
+			name:        "admins have no quota",
+			path:        "/api/movie?id=tt0133093",
+			headers:     adminHeaders,
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"X-Quota-Limit": ""},
+			wantLookups: -1,
+		},
+		{
+			name:        "the user default applies",
+			path:        "/api/movie?id=tt0133093",
+			headers:     user,
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"X-Quota-Limit": "1", "X-Quota-Remaining": "0"},
+			wantLookups: -1,
+		},
+		{
+			name:        "user over quota",
+			path:        "/api/movie?id=tt0133093",
+			headers:     user,
+			wantStatus:  http.StatusTooManyRequests,
+			wantJSON:    map[string]string{"scope": "user:" + id},
+			wantLookups: 0,
+		},
+	})
+}
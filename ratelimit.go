@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// windowLimiter allows up to limit events per key within each fixed window.
+// With Redis the windows are shared by every replica; without, they are
+// per process and reset on restart.
+type windowLimiter struct {
+	name    string
+	mu      sync.Mutex
+	windows map[string]*limitWindow
+}
+
+type limitWindow struct {
+	start time.Time
+	count int
+}
+
+func newWindowLimiter(name string) *windowLimiter {
+	return &windowLimiter{name: name, windows: map[string]*limitWindow{}}
+}
+
+// Allow records an event for key and reports whether it is within limit.
+// When it isn't, it also returns how long until the window resets.
+func (l *windowLimiter) Allow(key string, limit int, window time.Duration) (bool, time.Duration) {
+	if limit <= 0 {
+		return true, 0
+	}
+	if sharedRedis != nil {
+		ok, retry, err := l.allowShared(key, limit, window)
+		if err == nil {
+			return ok, retry
+		}
+		slog.Warn("shared rate limit unavailable; using this replica's", "limiter", l.name, "error", err)
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w := l.windows[key]
+	if w == nil || now.Sub(w.start) >= window {
+		w = &limitWindow{start: now}
+		l.windows[key] = w
+		if len(l.windows) > 10000 {
+			l.sweep(now, window)
+		}
+	}
+	if w.count >= limit {
+		return false, window - now.Sub(w.start)
+	}
+	w.count++
+	return true, 0
+}
+
+// allowShared counts the event in Redis. The window starts with the
+// first event, when the counter is created with the window as its TTL.
+func (l *windowLimiter) allowShared(key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	ctx := context.Background()
+	k := sharedKey("limit:"+l.name, key)
+	count, err := sharedRedis.Incr(ctx, k).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	if count == 1 {
+		if err := sharedRedis.PExpire(ctx, k, window).Err(); err != nil {
+			return false, 0, err
+		}
+	}
+	if count > int64(limit) {
+		ttl, err := sharedRedis.PTTL(ctx, k).Result()
+		if err != nil {
+			return false, 0, err
+		}
+		return false, max(ttl, 0), nil
+	}
+	return true, 0, nil
+}
+
+func (l *windowLimiter) sweep(now time.Time, window time.Duration) {
+	for k, w := range l.windows {
+		if now.Sub(w.start) >= window {
+			delete(l.windows, k)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseRating parses an OMDb rating such as "7.8". ok is false for "N/A",
+// blanks and anything that isn't a rating, so callers can tell an unrated
+// title from one rated 0.
+func parseRating(s string) (rating float64, ok bool) {
+	r, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(r) || r < 0 || r > 10 {
+		return 0, false
+	}
+	return r, true
+}
+
+// parseVotes parses OMDb's "1,234,567"; unknown counts are 0.
+func parseVotes(s string) int {
+	n, err := strconv.Atoi(strings.ReplaceAll(strings.TrimSpace(s), ",", ""))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// ratingKey orders titles best rated first. Unrated titles come after all
+// rated ones; ties go to the title with more votes, then to the lower
+// imdbID so the order is total.
+type ratingKey struct {
+	Rating float64
+	Rated  bool
+	Votes  int
+	ID     string
+}
+
+func ratingKeyOf(rating, votes, id string) ratingKey {
+	r, ok := parseRating(rating)
+	return ratingKey{Rating: r, Rated: ok, Votes: parseVotes(votes), ID: id}
+}
+
+// before reports whether k sorts before o.
+func (k ratingKey) before(o ratingKey) bool {
+	switch {
+	case k.Rated != o.Rated:
+		return k.Rated
+	case k.Rating != o.Rating:
+		return k.Rating > o.Rating
+	case k.Votes != o.Votes:
+		return k.Votes > o.Votes
+	}
+	return k.ID < o.ID
+}
+
+// Sort orders for top lists.
+const (
+	sortWeighted = "weighted"
+	sortRating   = "rating"
+)
+
+// RatingsConfig is the prior of the weighted rating.
+type RatingsConfig struct {
+	// PriorVotes is how many votes a title needs before its own rating
+	// counts as much as the prior.
+	PriorVotes int `json:"prior_votes"`
+	// PriorMean is the rating a title with no votes is assumed to have.
+	PriorMean float64 `json:"prior_mean"`
+}
+
+func defaultRatingsConfig() RatingsConfig {
+	return RatingsConfig{PriorVotes: 25000, PriorMean: 6.9}
+}
+
+// weightedRating is IMDb's Bayesian estimate: the title's rating pulled
+// toward the prior mean, less so the more votes it has, so a 9.5 from 40
+// voters doesn't outrank a 9.0 from a million.
+func weightedRating(rating float64, votes int) float64 {
+	prior := cfg().Ratings
+	v, m := float64(votes), float64(prior.PriorVotes)
+	if v+m == 0 {
+		return roundTo(rating, 2)
+	}
+	return roundTo(v/(v+m)*rating+m/(v+m)*prior.PriorMean, 2)
+}
+
+// sortKeyOf is ratingKeyOf ordering by the weighted rating when by is
+// sortWeighted.
+func sortKeyOf(by, rating, votes, id string) ratingKey {
+	k := ratingKeyOf(rating, votes, id)
+	if by == sortWeighted && k.Rated {
+		k.Rating = weightedRating(k.Rating, k.Votes)
+	}
+	return k
+}
+
+// metascore reads the Metacritic score (0-100) from Metascore or, failing
+// that, the Ratings list.
+func metascore(m *MovieResponse) (int, bool) {
+	if n, err := strconv.Atoi(strings.TrimSpace(m.Metascore)); err == nil && n >= 0 && n <= 100 {
+		return n, true
+	}
+	return criticScore(m, "Metacritic", "/100")
+}
+
+// rottenTomatoes reads the Rotten Tomatoes percentage from the Ratings list.
+func rottenTomatoes(m *MovieResponse) (int, bool) {
+	return criticScore(m, "Rotten Tomatoes", "%")
+}
+
+func criticScore(m *MovieResponse, source, suffix string) (int, bool) {
+	for _, r := range m.Ratings {
+		if r.Source != source {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(r.Value), suffix))
+		if err != nil || n < 0 || n > 100 {
+			return 0, false
+		}
+		return n, true
+	}
+	return 0, false
+}
+
+// criticFields are the numeric critic scores list entries carry; a score
+// OMDb doesn't have is left out.
+type criticFields struct {
+	Metascore      *int `json:"metascore,omitempty"`
+	RottenTomatoes *int `json:"rt_score,omitempty"`
+}
+
+func criticFieldsOf(m *MovieResponse) criticFields {
+	var f criticFields
+	if n, ok := metascore(m); ok {
+		f.Metascore = &n
+	}
+	if n, ok := rottenTomatoes(m); ok {
+		f.RottenTomatoes = &n
+	}
+	return f
+}
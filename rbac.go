@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	roleAdmin     = "admin"
+	roleModerator = "moderator"
+	roleUser      = "user"
+	roleReadOnly  = "read_only"
+)
+
+var roles = []string{roleAdmin, roleModerator, roleUser, roleReadOnly}
+
+const (
+	apiKeysBucket = "api_keys"
+	// apiKeyIDsBucket maps a key's public ID to the hash it is stored under.
+	apiKeyIDsBucket = "api_key_ids"
+)
+
+// principal is whoever a request is authenticated as: a user (JWT or
+// session cookie), a registered API key, the ADMIN_TOKEN break-glass credential, or nobody.
+type principal struct {
+	Kind string // "user", "key", "admin_token" or "" for anonymous
+	ID   string
+	Role string
+	User *User
+	// Tenant is the tenant the caller belongs to, "" for the default one.
+	Tenant string
+	Key    *APIKey
+}
+
+// APIKey is a server-to-server credential with a fixed role. Only the
+// SHA-256 of the key is stored.
+type APIKey struct {
+	ID        string      `json:"id"`
+	Name      string      `json:"name"`
+	Role      string      `json:"role"`
+	TenantID  string      `json:"tenant_id,omitempty"`
+	Quota     QuotaLimits `json:"quota"`
+	Hash      string      `json:"hash,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	// SigningSecret lets the key's client sign requests instead of
+	// sending the key; see signing.go.
+	SigningSecret string `json:"signing_secret,omitempty"`
+	Signing       bool   `json:"signing"`
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// findAPIKeyByID returns the key with the given public ID and the hash
+// it is stored under.
+func findAPIKeyByID(id string) (*APIKey, string, bool) {
+	var hash string
+	if found, err := store.Get(apiKeyIDsBucket, id, &hash); err != nil || !found {
+		return nil, "", false
+	}
+	var k APIKey
+	if found, err := store.Get(apiKeysBucket, hash, &k); err != nil || !found {
+		return nil, "", false
+	}
+	return &k, hash, true
+}
+
+// indexAPIKeys fills apiKeyIDsBucket for keys created before it existed.
+func indexAPIKeys() {
+	index := map[string]interface{}{}
+	store.ForEach(apiKeysBucket, func(key string, value []byte) error {
+		var k APIKey
+		if json.Unmarshal(value, &k) == nil && k.ID != "" {
+			index[k.ID] = key
+		}
+		return nil
+	})
+	if len(index) == 0 {
+		return
+	}
+	if err := store.PutAll(apiKeyIDsBucket, index); err != nil {
+		slog.Error("index api keys", "error", err)
+	}
+}
+
+func findAPIKey(key string) (*APIKey, bool) {
+	var k APIKey
+	found, err := store.Get(apiKeysBucket, hashAPIKey(key), &k)
+	if err != nil || !found {
+		return nil, false
+	}
+	return &k, true
+}
+
+// authenticate resolves the caller for every request. Anonymous requests
+// pass through; presenting a bad bearer token or API key is rejected
+// outright.
+func authenticate(adminToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := &principal{}
+		if raw, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+			switch {
+			case adminToken != "" && subtle.ConstantTimeCompare([]byte(raw), []byte(adminToken)) == 1:
+				p = &principal{Kind: "admin_token", Role: roleAdmin}
+			default:
+				id, err := parseToken(raw)
+				if err != nil {
+					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+					return
+				}
+				u, ok := loadUser(id)
+				if !ok {
+					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "user no longer exists"})
+					return
+				}
+				p = &principal{Kind: "user", ID: u.ID, Role: u.role(), User: u, Tenant: u.TenantID}
+			}
+		} else if keyID := c.GetHeader("X-Signature-Key"); keyID != "" {
+			sp, errMsg := signedPrincipal(c, keyID)
+			if errMsg != "" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": errMsg})
+				return
+			}
+			p = sp
+		} else if key := c.GetHeader("X-API-Key"); key != "" {
+			k, ok := findAPIKey(key)
+			if !ok {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+				return
+			}
+			p = &principal{Kind: "key", ID: k.ID, Role: k.Role, Tenant: k.TenantID, Key: k}
+		} else if sp, csrfErr := sessionPrincipal(c); csrfErr != "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": csrfErr})
+			return
+		} else if sp != nil {
+			p = sp
+		}
+		c.Set("principal", p)
+		c.Next()
+	}
+}
+
+func currentPrincipal(c *gin.Context) *principal {
+	if p, ok := c.Get("principal"); ok {
+		return p.(*principal)
+	}
+	return &principal{}
+}
+
+// requireRole lets the request through only if the caller has one of the
+// given roles.
+func requireRole(allowed ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := currentPrincipal(c)
+		if p.Kind == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			return
+		}
+		if !slices.Contains(allowed, p.Role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient role"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// denyReadOnly blocks mutating methods for read-only callers.
+func denyReadOnly(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if currentPrincipal(c).Role == roleReadOnly {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "this credential is read-only"})
+			return
+		}
+	}
+	c.Next()
+}
+
+// getUsers lists users, optionally only those of ?tenant=.
+func getUsers(c *gin.Context) {
+	tenant, filtered := c.GetQuery("tenant")
+	users := []gin.H{}
+	store.ForEach(usersBucket, func(_ string, value []byte) error {
+		var u User
+		if json.Unmarshal(value, &u) == nil && (!filtered || u.TenantID == tenant) {
+			users = append(users, u.public())
+		}
+		return nil
+	})
+	c.JSON(http.StatusOK, users)
+}
+
+func putUserRole(c *gin.Context) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !slices.Contains(roles, req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be one of " + strings.Join(roles, ", ")})
+		return
+	}
+	u, ok := loadUser(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	u.Role = req.Role
+	if err := store.Put(usersBucket, u.ID, u); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save user"})
+		return
+	}
+	c.JSON(http.StatusOK, u.public())
+}
+
+func getAPIKeys(c *gin.Context) {
+	keys := []APIKey{}
+	store.ForEach(apiKeysBucket, func(_ string, value []byte) error {
+		var k APIKey
+		if json.Unmarshal(value, &k) == nil {
+			k.Hash = ""
+			k.Signing, k.SigningSecret = k.SigningSecret != "", ""
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	c.JSON(http.StatusOK, keys)
+}
+
+// postAPIKey creates a key; the plaintext is only ever returned here.
+// Keys for a tenant act on that tenant's users and cache and can't be
+// admin keys.
+func postAPIKey(c *gin.Context) {
+	var req struct {
+		Name     string      `json:"name"`
+		Role     string      `json:"role"`
+		TenantID string      `json:"tenant_id"`
+		Quota    QuotaLimits `json:"quota"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" || !slices.Contains(roles, req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide {"name": "...", "role": "` + strings.Join(roles, "|") + `"}`})
+		return
+	}
+	if req.TenantID != "" {
+		if _, ok := loadTenant(req.TenantID); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown tenant"})
+			return
+		}
+		if req.Role == roleAdmin {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tenant keys cannot have the admin role"})
+			return
+		}
+	}
+	plaintext := "mk_" + randomHex(24)
+	k := APIKey{ID: randomHex(6), Name: req.Name, Role: req.Role, TenantID: req.TenantID, Quota: req.Quota, Hash: hashAPIKey(plaintext), CreatedAt: time.Now().UTC()}
+	if err := store.Put(apiKeysBucket, k.Hash, k); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save key"})
+		return
+	}
+	if err := store.Put(apiKeyIDsBucket, k.ID, k.Hash); err != nil {
+		store.Delete(apiKeysBucket, k.Hash)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save key"})
+		return
+	}
+	k.Hash = ""
+	c.JSON(http.StatusCreated, gin.H{"key": plaintext, "api_key": k})
+}
+
+func deleteAPIKey(c *gin.Context) {
+	_, hash, _ := findAPIKeyByID(c.Param("id"))
+	if hash == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+		return
+	}
+	if err := store.Delete(apiKeysBucket, hash); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete key"})
+		return
+	}
+	store.Delete(apiKeyIDsBucket, c.Param("id"))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	keyFor := func(role string) map[string]string {
+		return map[string]string{"X-API-Key": newAPIKey(t, role, "")}
+	}
+	admin, moderator, user, readOnly := keyFor(roleAdmin), keyFor(roleModerator), keyFor(roleUser), keyFor(roleReadOnly)
+	signedIn, _ := newUser(t)
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "anonymous on an admin route",
+			path:        "/admin/users",
+			wantStatus:  http.StatusUnauthorized,
+			wantLookups: 0,
+		},
+		{
+			name:        "user key on an admin route",
+			path:        "/admin/users",
+			headers:     user,
+			wantStatus:  http.StatusForbidden,
+			wantLookups: 0,
+		},
+		{
+			name:        "moderator key on an admin route",
+			path:        "/admin/users",
+			headers:     moderator,
+			wantStatus:  http.StatusForbidden,
+			wantLookups: 0,
+		},
+		{
+			name:        "admin key on an admin route",
+			path:        "/admin/users",
+			headers:     admin,
+			wantStatus:  http.StatusOK,
+			wantLookups: 0,
+		},
+		{
+			name:        "signed-in user on the moderation queue",
+			path:        "/admin/moderation/queue",
+			headers:     signedIn,
+			wantStatus:  http.StatusForbidden,
+			wantLookups: 0,
+		},
+		{
+			name:        "moderator key on the moderation queue",
+			path:        "/admin/moderation/queue",
+			headers:     moderator,
+			wantStatus:  http.StatusOK,
+			wantLookups: 0,
+		},
+		{
+			name:        "admin key on the moderation queue",
+			path:        "/admin/moderation/queue",
+			headers:     admin,
+			wantStatus:  http.StatusOK,
+			wantLookups: 0,
+		},
+		{
+			name:        "read-only key reads",
+			path:        "/api/movie?id=tt0133093",
+			headers:     readOnly,
+			wantStatus:  http.StatusOK,
+			wantLookups: -1,
+		},
+		{
+			name:        "read-only key writes",
+			method:      http.MethodPost,
+			path:        "/api/shorten",
+			headers:     readOnly,
+			body:        `{"imdbID":"tt0133093"}`,
+			wantStatus:  http.StatusForbidden,
+			wantJSON:    map[string]string{"error": "this credential is read-only"},
+			wantLookups: 0,
+		},
+		{
+			name:        "keys can't sign in as a user",
+			path:        "/api/users/me",
+			headers:     user,
+			wantStatus:  http.StatusUnauthorized,
+			wantLookups: 0,
+		},
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	w := request(http.MethodPost, "/admin/api-keys", adminHeaders, `{"name":"ci","role":"user"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create key: status %d; body %s", w.Code, w.Body)
+	}
+	created := decodeObject(t, w)
+	key := created["key"].(string)
+	id := created["api_key"].(map[string]interface{})["id"].(string)
+	tenant := newTenant(t)
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "unknown role",
+			method:      http.MethodPost,
+			path:        "/admin/api-keys",
+			headers:     adminHeaders,
+			body:        `{"name":"ci","role":"root"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+		{
+			name:        "unknown tenant",
+			method:      http.MethodPost,
+			path:        "/admin/api-keys",
+			headers:     adminHeaders,
+			body:        `{"name":"ci","role":"user","tenant_id":"nowhere"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+		{
+			name:        "tenant admin key",
+			method:      http.MethodPost,
+			path:        "/admin/api-keys",
+			headers:     adminHeaders,
+			body:        `{"name":"ci","role":"admin","tenant_id":"` + tenant + `"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+		{
+			name:        "the new key works",
+			path:        "/api/usage",
+			headers:     map[string]string{"X-API-Key": key},
+			wantStatus:  http.StatusOK,
+			wantLookups: 0,
+		},
+		{
+			name:        "delete it",
+			method:      http.MethodDelete,
+			path:        "/admin/api-keys/" + id,
+			headers:     adminHeaders,
+			wantStatus:  http.StatusNoContent,
+			wantLookups: 0,
+		},
+		{
+			name:        "a deleted key is refused",
+			path:        "/api/usage",
+			headers:     map[string]string{"X-API-Key": key},
+			wantStatus:  http.StatusUnauthorized,
+			wantLookups: 0,
+		},
+		{
+			name:        "delete it again",
+			method:      http.MethodDelete,
+			path:        "/admin/api-keys/" + id,
+			headers:     adminHeaders,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+	})
+
+	w = request(http.MethodGet, "/admin/api-keys", adminHeaders, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("list keys: status %d; body %s", w.Code, w.Body)
+	}
+	if body := w.Body.String(); strings.Contains(body, `"hash"`) || strings.Contains(body, `"signing_secret"`) || strings.Contains(body, "mk_") {
+		t.Errorf("key listing shows key material: %s", body)
+	}
+}
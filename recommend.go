@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const recommenderExperiment = "recommender"
+
+// recommender builds the "recommendations" payload for a favorite movie,
+// making its lookups through d and keeping only titles that pass filter.
+type recommender func(fav *MovieResponse, d *deepening, filter titleFilter) gin.H
+
+var recommenders = map[string]recommender{
+	"content":       contentRecommendations,
+	"collaborative": collaborativeRecommendations,
+	"blended":       blendedRecommendations,
+}
+
+func getRecommendations(c *gin.Context) {
+	if estimating(c, getRecommendations) {
+		return
+	}
+	var q recommendationsQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+
+	favMovie, err := fetchMovie(scopedParams(c, map[string]string{"t": q.FavoriteMovie}))
+	if err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+
+	variant, overridden := "", false
+	if flagEnabled(c, flagRecommenderVariants) {
+		variant, overridden = assignVariant(c, recommenderExperiment)
+	}
+	recommend, ok := recommenders[variant]
+	if !ok {
+		variant = "content"
+		recommend = contentRecommendations
+	}
+	if !overridden {
+		experiments.Impression(recommenderExperiment, variant)
+	}
+
+	d := newDeepening(c, cfg().Deepening.RecommendationBudget)
+	recommendations := recommend(favMovie, d, q.titleFilter)
+	c.Header("X-Recommender-Variant", variant)
+	resp := gin.H{
+		"favorite_movie":    favMovie.Title,
+		"favorite_movie_id": favMovie.IMDBID,
+		"variant":           variant,
+		"recommendations":   recommendations,
+		"deepening":         d,
+		"truncated":         costOf(c).isTruncated(),
+	}
+	if len(d.Warnings) > 0 {
+		c.Header("X-Partial-Results", "true")
+		resp["partial"] = true
+		resp["warnings"] = d.Warnings
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+type recommendationFeedback struct {
+	FavoriteMovieID string `json:"favorite_movie_id"`
+	IMDBID          string `json:"imdbID"`
+	Variant         string `json:"variant"`
+	Liked           bool   `json:"liked"`
+}
+
+func postRecommendationFeedback(c *gin.Context) {
+	var fb recommendationFeedback
+	if err := c.ShouldBindJSON(&fb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if fb.FavoriteMovieID == "" || fb.IMDBID == "" || fb.Variant == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "favorite_movie_id, imdbID and variant are required"})
+		return
+	}
+	if _, ok := recommenders[fb.Variant]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown variant " + fb.Variant})
+		return
+	}
+
+	experiments.Feedback(recommenderExperiment, fb.Variant, fb.FavoriteMovieID, fb.IMDBID, fb.Liked)
+	slog.Info("recommendation feedback",
+		"experiment", recommenderExperiment,
+		"variant", fb.Variant,
+		"favorite_movie_id", fb.FavoriteMovieID,
+		"imdbID", fb.IMDBID,
+		"liked", fb.Liked,
+	)
+	c.Status(http.StatusNoContent)
+}
+
+// contentRecommendations is the original recommender: titles found by
+// searching the favorite's genres, directors and actors.
+func contentRecommendations(fav *MovieResponse, d *deepening, filter titleFilter) gin.H {
+	seen := map[string]bool{fav.IMDBID: true}
+	return gin.H{
+		"by_genre":    collectRecommendations(d, filter, seen, "Genre", strings.Split(fav.Genre, ","), 20),
+		"by_director": collectRecommendations(d, filter, seen, "Director", strings.Split(fav.Director, ","), 20),
+		"by_actor":    collectRecommendations(d, filter, seen, "Actor", strings.Split(fav.Actors, ","), 20),
+	}
+}
+
+// blendedRecommendations merges the content groups into a single list
+// ranked by rating.
+func blendedRecommendations(fav *MovieResponse, d *deepening, filter titleFilter) gin.H {
+	groups := contentRecommendations(fav, d, filter)
+	blended := []gin.H{}
+	for _, key := range []string{"by_genre", "by_director", "by_actor"} {
+		blended = append(blended, groups[key].([]gin.H)...)
+	}
+	sortByRating(blended)
+	if len(blended) > 20 {
+		blended = blended[:20]
+	}
+	return gin.H{"blended": blended}
+}
+
+// collaborativeRecommendations returns titles other users liked when they
+// were recommended alongside fav, topped up with content-based results
+// while there isn't enough feedback yet.
+func collaborativeRecommendations(fav *MovieResponse, d *deepening, filter titleFilter) gin.H {
+	results := []gin.H{}
+	seen := map[string]bool{fav.IMDBID: true}
+	d.stage("Liked")
+	for _, id := range experiments.Liked(fav.IMDBID) {
+		if len(results) >= 20 {
+			break
+		}
+		movie, err := d.movie(id)
+		if err != nil || !d.matches(filter, movie) {
+			continue
+		}
+		seen[id] = true
+		results = append(results, recommendationItem(movie, "Liked by others"))
+		d.found()
+	}
+	if len(results) < 20 {
+		results = append(results, collectRecommendations(d, filter, seen, "Genre", strings.Split(fav.Genre, ","), 20-len(results))...)
+	}
+	return gin.H{"collaborative": results}
+}
+
+// collectRecommendations searches the keywords for up to limit rated
+// titles. If the first three pages of each don't find enough it searches
+// deeper pages, then tops up with unrated titles.
+func collectRecommendations(d *deepening, filter titleFilter, seen map[string]bool, level string, keywords []string, limit int) []gin.H {
+	results := []gin.H{}
+	unrated := []*MovieResponse{}
+
+	scan := func(from, to int) {
+		for _, kw := range keywords {
+			kw = strings.TrimSpace(kw)
+			if kw == "" || kw == "N/A" {
+				continue
+			}
+
+			for page := from; page <= to && len(results) < limit; page++ {
+				search, err := d.search(kw, page)
+				if errors.Is(err, errBudgetSpent) {
+					return
+				}
+				if err != nil || search == nil {
+					continue
+				}
+
+				var pending []string
+				onPage := map[string]bool{}
+				for _, s := range search.Search {
+					if !seen[s.IMDBID] && !onPage[s.IMDBID] {
+						onPage[s.IMDBID] = true
+						pending = append(pending, s.IMDBID)
+					}
+				}
+				// Look up no more titles at once than could still make the
+				// list, so a full list doesn't cost lookups it won't use.
+				for len(pending) > 0 && len(results) < limit {
+					batch := pending[:min(len(pending), limit-len(results))]
+					pending = pending[len(batch):]
+					movies, errs := d.movies(recommendationLookups, batch)
+					for i := range batch {
+						movie, err := movies[i], errs[i]
+						if errors.Is(err, errBudgetSpent) {
+							return
+						}
+						if err != nil || !d.matches(filter, movie) {
+							continue
+						}
+						seen[batch[i]] = true
+						if _, rated := parseRating(movie.IMDBRating); !rated {
+							unrated = append(unrated, movie)
+							continue
+						}
+						results = append(results, recommendationItem(movie, level))
+						d.found()
+					}
+				}
+			}
+			if len(results) >= limit {
+				return
+			}
+		}
+	}
+
+	d.stage(level)
+	scan(1, 3)
+	if maxPages := cfg().Deepening.MaxPages; len(results) < limit && maxPages > 3 && !d.exhausted() {
+		d.stage(level + ":more_pages")
+		scan(4, maxPages)
+	}
+	if len(results) < limit && len(unrated) > 0 {
+		d.stage(level + ":unrated")
+		for _, movie := range unrated {
+			if len(results) >= limit {
+				break
+			}
+			results = append(results, recommendationItem(movie, level))
+			d.found()
+		}
+	}
+	sortByRating(results)
+	return results
+}
+
+func recommendationItem(movie *MovieResponse, why string) gin.H {
+	item := gin.H{
+		"Title":      movie.Title,
+		"Year":       movie.Year,
+		"Genre":      movie.Genre,
+		"imdbRating": movie.IMDBRating,
+		"imdbVotes":  movie.IMDBVotes,
+		"imdbID":     movie.IMDBID,
+		"Why":        why,
+	}
+	if n, ok := metascore(movie); ok {
+		item["metascore"] = n
+	}
+	if n, ok := rottenTomatoes(movie); ok {
+		item["rt_score"] = n
+	}
+	return item
+}
+
+// sortByRating orders recommendation items by ratingKey.
+func sortByRating(results []gin.H) {
+	key := func(item gin.H) ratingKey {
+		return ratingKeyOf(item["imdbRating"].(string), item["imdbVotes"].(string), item["imdbID"].(string))
+	}
+	sort.SliceStable(results, func(i, j int) bool { return key(results[i]).before(key(results[j])) })
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorReport is a panic or internal error as it is sent to the
+// reporting hook.
+type errorReport struct {
+	ID        string       `json:"id"`
+	At        time.Time    `json:"at"`
+	Kind      string       `json:"kind"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"request_id,omitempty"`
+	Method    string       `json:"method,omitempty"`
+	Path      string       `json:"path,omitempty"`
+	Route     string       `json:"route,omitempty"`
+	Instance  string       `json:"instance"`
+	Stack     []stackFrame `json:"stack,omitempty"`
+}
+
+type stackFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// ErrorReporter forwards errors to an error tracker.
+type ErrorReporter interface {
+	Report(ctx context.Context, r *errorReport) error
+}
+
+var errorReporter ErrorReporter
+
+// newErrorReporterFromEnv reports to Sentry when SENTRY_DSN is set, and
+// otherwise POSTs reports as JSON to ERROR_REPORT_URL, if that is set.
+func newErrorReporterFromEnv() (ErrorReporter, error) {
+	if dsn := secret("SENTRY_DSN"); dsn != "" {
+		return newSentryReporter(dsn)
+	}
+	if hook := envString("ERROR_REPORT_URL", ""); hook != "" {
+		return &hookReporter{url: hook}, nil
+	}
+	return nil, nil
+}
+
+// panicLog writes panics as JSON lines, stack included, so log pipelines
+// can pick them up whole.
+var panicLog = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+
+// recoverPanics turns a panic in a handler into a 500 with the usual error
+// body and the request ID, logs it with its stack and reports it.
+func recoverPanics(c *gin.Context) {
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		if err, ok := p.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+			panic(p)
+		}
+		r := newErrorReport(c, "panic", fmt.Sprint(p), 3)
+		if r.RequestID == "" {
+			// It panicked before the request got an ID.
+			r.RequestID = randomHex(8)
+			c.Header("X-Request-ID", r.RequestID)
+		}
+		panicLog.Error("panic serving request",
+			"report_id", r.ID,
+			"request_id", r.RequestID,
+			"method", r.Method,
+			"path", r.Path,
+			"route", r.Route,
+			"panic", r.Message,
+			"stack", r.Stack,
+		)
+		sendErrorReport(r)
+		if !c.Writer.Written() {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error":      "internal server error",
+				"code":       "internal_error",
+				"request_id": r.RequestID,
+			})
+			return
+		}
+		c.Abort()
+	}()
+	c.Next()
+}
+
+// reportError forwards an internal error that was answered with a 500.
+func reportError(c *gin.Context, err error) {
+	r := newErrorReport(c, "error", err.Error(), 2)
+	slog.Error("internal error", "report_id", r.ID, "request_id", r.RequestID, "route", r.Route, "error", err)
+	sendErrorReport(r)
+}
+
+// newErrorReport describes what went wrong in c, with the stack above
+// skip frames.
+func newErrorReport(c *gin.Context, kind, message string, skip int) *errorReport {
+	r := &errorReport{
+		ID:        randomHex(16),
+		At:        time.Now().UTC(),
+		Kind:      kind,
+		Message:   message,
+		RequestID: c.GetString("cost.id"),
+		Instance:  instanceID,
+		Stack:     callers(skip + 1),
+	}
+	if c.Request != nil {
+		r.Method, r.Path, r.Route = c.Request.Method, c.Request.URL.Path, c.FullPath()
+	}
+	return r
+}
+
+func callers(skip int) []stackFrame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var stack []stackFrame
+	for {
+		f, more := frames.Next()
+		// The runtime's own frames, panicking included, say nothing about
+		// where it went wrong.
+		if !strings.HasPrefix(f.Function, "runtime.") {
+			stack = append(stack, stackFrame{Function: f.Function, File: f.File, Line: f.Line})
+		}
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+func sendErrorReport(r *errorReport) {
+	if errorReporter == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := errorReporter.Report(ctx, r); err != nil {
+			slog.Warn("could not send error report", "report_id", r.ID, "error", err)
+		}
+	}()
+}
+
+// hookReporter POSTs each report as JSON to a URL.
+type hookReporter struct {
+	url string
+}
+
+func (h *hookReporter) Report(ctx context.Context, r *errorReport) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return postReport(ctx, h.url, body, nil)
+}
+
+// sentryReporter sends reports to Sentry's store endpoint, as an event
+// with the stack as the exception's stacktrace.
+type sentryReporter struct {
+	endpoint string
+	auth     string
+}
+
+// newSentryReporter parses a DSN like https://KEY@HOST/PROJECT.
+func newSentryReporter(dsn string) (*sentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.Host == "" {
+		return nil, errors.New("SENTRY_DSN should look like https://KEY@HOST/PROJECT")
+	}
+	project := strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		u.Path, project = "/"+project[:i], project[i+1:]
+	} else {
+		u.Path = ""
+	}
+	if project == "" {
+		return nil, errors.New("SENTRY_DSN has no project ID")
+	}
+	return &sentryReporter{
+		endpoint: fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path, project),
+		auth:     fmt.Sprintf("Sentry sentry_version=7, sentry_client=movie-api/1, sentry_key=%s", u.User.Username()),
+	}, nil
+}
+
+func (s *sentryReporter) Report(ctx context.Context, r *errorReport) error {
+	// Sentry lists frames oldest first.
+	frames := make([]gin.H, 0, len(r.Stack))
+	for i := len(r.Stack) - 1; i >= 0; i-- {
+		f := r.Stack[i]
+		frames = append(frames, gin.H{"function": f.Function, "filename": f.File, "lineno": f.Line, "in_app": strings.HasPrefix(f.Function, "main.")})
+	}
+	event := gin.H{
+		"event_id":    r.ID,
+		"timestamp":   r.At.Format(time.RFC3339),
+		"level":       "error",
+		"platform":    "go",
+		"server_name": r.Instance,
+		"message":     r.Message,
+		"exception": gin.H{"values": []gin.H{{
+			"type":       r.Kind,
+			"value":      r.Message,
+			"stacktrace": gin.H{"frames": frames},
+		}}},
+		"tags": gin.H{"request_id": r.RequestID, "route": r.Route},
+	}
+	if r.Path != "" {
+		event["request"] = gin.H{"method": r.Method, "url": r.Path}
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return postReport(ctx, s.endpoint, body, map[string]string{"X-Sentry-Auth": s.auth})
+}
+
+func postReport(ctx context.Context, target string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("error tracker returned %s", resp.Status)
+	}
+	return nil
+}
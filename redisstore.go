@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisStore keeps each bucket in a Redis hash, so every replica sees the
+// same data. Walks fetch the bucket and sort its keys, which is fine for
+// the sizes buckets have here.
+type redisStore struct {
+	client *redis.Client
+}
+
+const redisStorePrefix = "movie-api:store:"
+
+// modifyRetries is how often Modify retries when another writer changed
+// the key between its read and its write.
+const modifyRetries = 10
+
+func openRedisStore(url string) (*redisStore, error) {
+	client, err := newRedisClient(url, "STORE_URL")
+	if err != nil {
+		return nil, err
+	}
+	return &redisStore{client: client}, nil
+}
+
+func (s *redisStore) hash(bucket string) string {
+	return redisStorePrefix + bucket
+}
+
+func (s *redisStore) Shared() bool { return true }
+
+func (s *redisStore) Put(bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(context.Background(), s.hash(bucket), key, data).Err()
+}
+
+func (s *redisStore) PutAll(bucket string, values map[string]interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+	fields := make([]interface{}, 0, 2*len(values))
+	for k, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, k, data)
+	}
+	return s.client.HSet(context.Background(), s.hash(bucket), fields...).Err()
+}
+
+func (s *redisStore) Get(bucket, key string, v interface{}) (bool, error) {
+	data, err := s.client.HGet(context.Background(), s.hash(bucket), key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func (s *redisStore) Modify(bucket, key string, v interface{}, fn func() error) (bool, error) {
+	ctx := context.Background()
+	hash := s.hash(bucket)
+	var found bool
+	txf := func(tx *redis.Tx) error {
+		found = false
+		data, err := tx.HGet(ctx, hash, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+		data, err = json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, hash, key, data)
+			return nil
+		})
+		return err
+	}
+	// The whole hash is watched, so a write to any key of the bucket
+	// forces a retry; buckets with hot keys are small.
+	for i := 0; i < modifyRetries; i++ {
+		err := s.client.Watch(ctx, txf, hash)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return found, err
+	}
+	return found, redis.TxFailedErr
+}
+
+func (s *redisStore) Delete(bucket, key string) error {
+	return s.client.HDel(context.Background(), s.hash(bucket), key).Err()
+}
+
+func (s *redisStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return s.ForEachPrefix(bucket, "", fn)
+}
+
+func (s *redisStore) ForEachPrefix(bucket, prefix string, fn func(key string, value []byte) error) error {
+	all, err := s.client.HGetAll(context.Background(), s.hash(bucket)).Result()
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn(k, []byte(all[k])); err != nil {
+			if errors.Is(err, errStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheRefresher re-fetches the most popular titles shortly before their
+// cache entries expire, so a hot title never makes a request wait on
+// OMDb. It spends at most budget upstream calls an hour.
+//
+// The in-memory cache is per process, so every replica refreshes its own,
+// each with an equal share of CACHE_REFRESH_BUDGET.
+type cacheRefresher struct {
+	titles int
+	ahead  time.Duration
+	budget int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	spent       int
+	stats       refreshStats
+}
+
+type refreshStats struct {
+	LastRun   time.Time `json:"last_run"`
+	Refreshed int64     `json:"refreshed"`
+	Failed    int64     `json:"failed"`
+	// OverBudget counts entries left to expire because the hour's budget
+	// was spent.
+	OverBudget int64 `json:"over_budget"`
+	// SpentThisHour and Budget are the calls made in the current hour and
+	// the most allowed.
+	SpentThisHour int `json:"spent_this_hour"`
+	Budget        int `json:"budget"`
+}
+
+var refresher = &cacheRefresher{}
+
+func newCacheRefresher() *cacheRefresher {
+	budget := envInt("CACHE_REFRESH_BUDGET", 100)
+	if budget > 0 {
+		budget = max(budget/replicaCount(), 1)
+	}
+	return &cacheRefresher{
+		titles: envInt("CACHE_REFRESH_TITLES", 200),
+		ahead:  envDuration("CACHE_REFRESH_AHEAD", 10*time.Minute),
+		budget: budget,
+	}
+}
+
+func refreshHotEntries(every time.Duration) {
+	for range time.Tick(every) {
+		if !draining.Load() {
+			refresher.Run()
+		}
+	}
+}
+
+// take reserves one upstream call from the hour's budget.
+func (r *cacheRefresher) take() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := time.Now(); now.Sub(r.windowStart) >= time.Hour {
+		r.windowStart, r.spent = now, 0
+	}
+	if r.spent >= r.budget {
+		return false
+	}
+	r.spent++
+	return true
+}
+
+// Run refreshes the popular titles whose entries expire within ahead.
+// Titles that aren't cached at all are left to cache warming.
+func (r *cacheRefresher) Run() {
+	var refreshed, failed, overBudget int64
+	for _, id := range warmList(r.titles) {
+		params := map[string]string{"i": id}
+		key := cacheKey(params)
+		ttl := memoryCache.TTL(key)
+		if ttl == 0 || ttl > r.ahead {
+			continue
+		}
+		if !r.take() {
+			overBudget++
+			continue
+		}
+		if err := refreshTitle(key, params); err != nil {
+			slog.Warn("cache refresh failed", "imdbID", id, "error", err)
+			failed++
+			continue
+		}
+		refreshed++
+	}
+	r.mu.Lock()
+	r.stats.LastRun = time.Now().UTC()
+	r.stats.Refreshed += refreshed
+	r.stats.Failed += failed
+	r.stats.OverBudget += overBudget
+	r.mu.Unlock()
+	if refreshed+failed+overBudget > 0 {
+		slog.Info("refreshed hot cache entries", "refreshed", refreshed, "failed", failed, "over_budget", overBudget)
+	}
+}
+
+// refreshTitle looks a title up again, bypassing the cache. The old
+// entry is only replaced by a good answer, so a failed refresh leaves it
+// to serve until it expires.
+func refreshTitle(key string, params map[string]string) error {
+	body, err := provider.Lookup(params)
+	if err != nil {
+		return err
+	}
+	var movie MovieResponse
+	if err := decodeOMDb(body, &movie); err != nil {
+		if strings.Contains(err.Error(), "limit reached") {
+			omdbHealth.quotaExhausted(err.Error())
+		}
+		return err
+	}
+	upstreamCache.Set(key, body, cacheTTL(params))
+	applyOverride(&movie)
+	catalog.Add(&movie)
+	return nil
+}
+
+func (r *cacheRefresher) Stats() refreshStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats
+	s.Budget = r.budget
+	if time.Since(r.windowStart) < time.Hour {
+		s.SpentThisHour = r.spent
+	}
+	return s
+}
+
+func getCacheRefreshStats(c *gin.Context) {
+	c.JSON(http.StatusOK, refresher.Stats())
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// relatedLookups caps the detail lookups one related-titles request makes.
+const relatedLookups = 20
+
+var romanNumerals = map[string]int{
+	"ii": 2, "iii": 3, "iv": 4, "v": 5, "vi": 6, "vii": 7, "viii": 8, "ix": 9, "x": 10,
+}
+
+var franchiseStopwords = map[string]bool{
+	"the": true, "and": true, "of": true, "a": true, "an": true, "part": true, "chapter": true,
+}
+
+// franchiseTitle splits a title into the part a series of films shares and
+// its installment number: "Toy Story 3" is ("toy story", 3), "Rocky II:
+// Rocky Strikes Back" is ("rocky", 2). Titles without a number get 0.
+func franchiseTitle(title string) (base string, number int) {
+	head, _, _ := strings.Cut(title, ":")
+	head, _, _ = strings.Cut(head, " - ")
+	words := strings.Fields(strings.ToLower(head))
+	if n := len(words); n > 1 {
+		last := words[n-1]
+		if v, err := strconv.Atoi(last); err == nil && v > 0 && v < 100 {
+			number, words = v, words[:n-1]
+		} else if v, ok := romanNumerals[last]; ok {
+			number, words = v, words[:n-1]
+		}
+		if n := len(words); number > 0 && n > 1 && (words[n-1] == "part" || words[n-1] == "chapter") {
+			words = words[:n-1]
+		}
+	}
+	return strings.Join(words, " "), number
+}
+
+// franchiseTokens are the significant words of a title's shared part.
+func franchiseTokens(base string) map[string]bool {
+	tokens := map[string]bool{}
+	for _, w := range strings.Fields(base) {
+		if !franchiseStopwords[w] {
+			tokens[w] = true
+		}
+	}
+	return tokens
+}
+
+type relatedTitle struct {
+	IMDBID     string   `json:"imdbID"`
+	Title      string   `json:"Title"`
+	Year       string   `json:"Year"`
+	Relation   string   `json:"relation"`
+	Confidence float64  `json:"confidence"`
+	Signals    []string `json:"signals"`
+}
+
+// relateTitles scores how likely other is an installment of the same
+// franchise as m. Relation is "sequel" or "prequel" by installment number
+// or release year, or "related" when only the people and years line up.
+func relateTitles(m, other *MovieResponse) relatedTitle {
+	r := relatedTitle{IMDBID: other.IMDBID, Title: other.Title, Year: other.Year, Signals: []string{}}
+	base, number := franchiseTitle(m.Title)
+	otherBase, otherNumber := franchiseTitle(other.Title)
+
+	score := 0.0
+	franchise := false
+	switch {
+	case base != "" && (base == otherBase || strings.HasPrefix(otherBase, base+" ") || strings.HasPrefix(base, otherBase+" ")):
+		score += 0.5
+		franchise = true
+		r.Signals = append(r.Signals, "franchise_title")
+	default:
+		tokens, otherTokens := franchiseTokens(base), franchiseTokens(otherBase)
+		shared := 0
+		for t := range tokens {
+			if otherTokens[t] {
+				shared++
+			}
+		}
+		if shared > 0 {
+			overlap := float64(shared) / float64(max(len(tokens), len(otherTokens)))
+			score += 0.4 * overlap
+			franchise = overlap >= 0.5
+			r.Signals = append(r.Signals, "shared_title_words")
+		}
+	}
+	if number > 0 || otherNumber > 0 {
+		score += 0.15
+		r.Signals = append(r.Signals, "numbering")
+	}
+	if sharesAny(m.Director, other.Director) {
+		score += 0.15
+		r.Signals = append(r.Signals, "shared_director")
+	}
+	if n := sharedCount(m.Actors, other.Actors); n > 0 {
+		score += min(0.1*float64(n), 0.2)
+		r.Signals = append(r.Signals, "shared_cast")
+	}
+	year, otherYear := startYear(m.Year), startYear(other.Year)
+	if year > 0 && otherYear > 0 && abs(year-otherYear) <= 5 {
+		score += 0.1
+		r.Signals = append(r.Signals, "adjacent_years")
+	}
+	r.Confidence = math.Round(min(score, 1)*100) / 100
+
+	after := otherYear > year
+	if number > 0 && otherNumber > 0 && number != otherNumber {
+		after = otherNumber > number
+	} else if number == 0 && otherNumber > 1 {
+		after = true
+	}
+	switch {
+	case !franchise:
+		r.Relation = "related"
+	case after:
+		r.Relation = "sequel"
+	default:
+		r.Relation = "prequel"
+	}
+	return r
+}
+
+func sharesAny(a, b string) bool {
+	return sharedCount(a, b) > 0
+}
+
+// sharedCount counts the names two comma-separated OMDb lists share.
+func sharedCount(a, b string) int {
+	n := 0
+	for _, name := range strings.Split(a, ",") {
+		if name = strings.TrimSpace(name); name != "" && name != "N/A" && containsFold(b, name) {
+			n++
+		}
+	}
+	return n
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// getRelatedTitles finds likely sequels and prequels of a title by
+// searching for its franchise name and checking what it finds, plus
+// catalog titles with the same name.
+func getRelatedTitles(c *gin.Context) {
+	var q idQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	movie, err := fetchMovie(scopedParams(c, map[string]string{"i": q.ID}))
+	if err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+	base, _ := franchiseTitle(movie.Title)
+
+	candidates := map[string]bool{}
+	for _, m := range catalog.All(func(m *MovieResponse) bool {
+		otherBase, _ := franchiseTitle(m.Title)
+		return m.IMDBID != movie.IMDBID && otherBase == base
+	}) {
+		candidates[m.IMDBID] = true
+	}
+	if base != "" {
+		for page := 1; page <= 2; page++ {
+			params := searchParams(base, page)
+			params["type"] = movie.Type
+			results, err := fetchSearch(scopedParams(c, params))
+			if err != nil {
+				break
+			}
+			for _, item := range results.Search {
+				if item.IMDBID != movie.IMDBID {
+					candidates[item.IMDBID] = true
+				}
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(candidates))
+	for id := range candidates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if len(ids) > relatedLookups {
+		ids = ids[:relatedLookups]
+	}
+
+	related := []relatedTitle{}
+	for _, id := range ids {
+		other, err := fetchMovie(scopedParams(c, map[string]string{"i": id}))
+		if err != nil {
+			continue
+		}
+		if r := relateTitles(movie, other); r.Confidence >= 0.4 {
+			related = append(related, r)
+		}
+	}
+	sort.Slice(related, func(i, j int) bool {
+		if related[i].Confidence != related[j].Confidence {
+			return related[i].Confidence > related[j].Confidence
+		}
+		return related[i].IMDBID < related[j].IMDBID
+	})
+	if len(related) > q.Limit {
+		related = related[:q.Limit]
+	}
+
+	setProvenance(c)
+	c.JSON(http.StatusOK, gin.H{
+		"imdbID":  movie.IMDBID,
+		"Title":   movie.Title,
+		"related": related,
+	})
+}
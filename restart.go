@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Deploys replace the binary and send SIGUSR2. The running process starts
+// the new binary, handing it the listening socket (fd 3) and a pipe (fd 4)
+// the new process writes to once it serves. Only then does the old process
+// stop accepting, finish the requests in flight, ask notification sockets
+// to reconnect, and exit. The socket is never closed, so no connection is
+// refused along the way.
+//
+// A bbolt file (the store without STORE_URL, or the disk cache) can only
+// be open in one process, so the new process couldn't report ready while
+// the old one still serves. With one open, SIGUSR2 is refused and the old
+// process keeps serving; restart it with SIGTERM instead.
+//
+// SIGTERM and SIGINT drain the same way without starting a successor.
+
+// upgradeEnv is set for a process started by an upgrade.
+const upgradeEnv = "MOVIE_API_UPGRADE"
+
+const (
+	inheritedListenerFD = 3
+	readyPipeFD         = 4
+)
+
+// draining is set once the process has begun shutting down; it starts no
+// more background work.
+var draining atomic.Bool
+
+// holdsFileLocks is set when a bbolt file is open, which rules out an
+// upgrade that overlaps the old and new process.
+var holdsFileLocks atomic.Bool
+
+func upgrading() bool {
+	return os.Getenv(upgradeEnv) != ""
+}
+
+func shutdownTimeout() time.Duration {
+	return envDuration("SHUTDOWN_TIMEOUT", 2*time.Minute)
+}
+
+// boltOpenTimeout is how long to wait for a bbolt file's lock.
+func boltOpenTimeout() time.Duration {
+	return time.Second
+}
+
+func listen(addr string) (net.Listener, error) {
+	if !upgrading() {
+		return net.Listen("tcp", addr)
+	}
+	f := os.NewFile(inheritedListenerFD, "listener")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// signalReady tells the process that started this one that it serves.
+func signalReady() {
+	if !upgrading() {
+		return
+	}
+	f := os.NewFile(readyPipeFD, "ready")
+	f.Write([]byte("ready"))
+	f.Close()
+}
+
+// serve runs the server until it is told to stop or upgrade, then drains
+// it and returns.
+func serve(handler http.Handler, addr string) error {
+	ln, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: handler}
+	srv.RegisterOnShutdown(notificationHub.CloseAll)
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Serve(ln) }()
+	signalReady()
+	slog.Info("serving", "addr", ln.Addr().String(), "pid", os.Getpid(), "upgraded", upgrading())
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR2)
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case sig := <-sigs:
+			if sig == syscall.SIGUSR2 {
+				if err := startSuccessor(ln); err != nil {
+					slog.Error("upgrade failed; still serving", "error", err)
+					continue
+				}
+			}
+			return drain(srv)
+		}
+	}
+}
+
+// errHoldsFileLocks refuses an upgrade while a bbolt file is open.
+var errHoldsFileLocks = errors.New("a bbolt file is open, so a new process can't start alongside this one; set STORE_URL and turn off the disk cache for upgrades in place, or restart with SIGTERM")
+
+// startSuccessor starts the current binary with the listening socket and
+// waits until it serves.
+func startSuccessor(ln net.Listener) error {
+	if holdsFileLocks.Load() {
+		return errHoldsFileLocks
+	}
+	tcp, ok := ln.(*net.TCPListener)
+	if !ok {
+		return errors.New("listener can't be handed over")
+	}
+	f, err := tcp.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	exe, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, upgradeEnv+"=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, upgradeEnv+"=1")
+	cmd.ExtraFiles = []*os.File{f, readyW}
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+	go cmd.Wait()
+	slog.Info("started new process", "pid", cmd.Process.Pid)
+
+	ready.SetReadDeadline(time.Now().Add(time.Minute))
+	buf := make([]byte, 5)
+	if _, err := ready.Read(buf); err != nil {
+		cmd.Process.Kill()
+		return fmt.Errorf("new process didn't become ready: %w", err)
+	}
+	return nil
+}
+
+// drain stops accepting connections, lets requests in flight finish within
+// SHUTDOWN_TIMEOUT, and closes notification sockets with a request to
+// reconnect.
+func drain(srv *http.Server) error {
+	draining.Store(true)
+	leader.Resign()
+	timeout := shutdownTimeout()
+	slog.Info("draining", "timeout", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	notificationHub.Wait(ctx)
+	if err != nil {
+		slog.Warn("requests still in flight were cut off", "error", err)
+	}
+	return nil
+}
+
+// flushState writes what is only counted in memory before the process
+// exits.
+func flushState() {
+	catalog.Flush()
+	popularity.Flush()
+	quotas.Flush()
+	experiments.Flush()
+	usage.Flush(envDuration("ANALYTICS_RETENTION", 90*24*time.Hour))
+}
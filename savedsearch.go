@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const savedSearchesBucket = "saved_searches"
+
+const maxSavedSearches = 25
+
+// Notification channels a saved search can use. Webhook also covers the
+// notification socket, as notify does.
+const (
+	channelWebhook = "webhook"
+	channelEmail   = "email"
+)
+
+// SavedSearch is a filter combination a user wants to hear about. The
+// background check matches it against the title catalog and notifies
+// about titles it hasn't matched before. Known starts with the titles
+// matching when it is saved, so the user isn't told about those; it is
+// stored but left out of responses.
+type SavedSearch struct {
+	ID            string            `json:"id"`
+	UserID        string            `json:"-"`
+	Name          string            `json:"name"`
+	Filter        savedSearchFilter `json:"filter"`
+	Channels      []string          `json:"channels"`
+	Known         []string          `json:"known,omitempty"`
+	Matches       int               `json:"matches"`
+	LastCheckedAt time.Time         `json:"last_checked_at"`
+	LastFoundAt   *time.Time        `json:"last_found_at,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+// savedSearchFilter is what a saved search matches on, e.g. "Horror,
+// 7.5 and up, 2020s" is {"genre": "Horror", "min_rating": 7.5, "decade":
+// "2020s"}.
+type savedSearchFilter struct {
+	Query        string  `json:"q,omitempty"`
+	Type         string  `json:"type,omitempty"`
+	Genre        string  `json:"genre,omitempty"`
+	MinRating    float64 `json:"min_rating,omitempty"`
+	Decade       string  `json:"decade,omitempty"`
+	Country      string  `json:"country,omitempty"`
+	Language     string  `json:"language,omitempty"`
+	MinMetascore int     `json:"min_metascore,omitempty"`
+	MinRT        int     `json:"min_rt,omitempty"`
+	Tag          string  `json:"tag,omitempty"`
+}
+
+func (f savedSearchFilter) validate() error {
+	switch {
+	case f == savedSearchFilter{}:
+		return fmt.Errorf("filter needs at least one of q, type, genre, min_rating, decade, country, language, min_metascore, min_rt or tag")
+	case len(f.Query) > 200 || len(f.Genre) > 50 || len(f.Country) > 50 || len(f.Language) > 50:
+		return fmt.Errorf("filter values are too long")
+	case f.Type != "" && !slices.Contains([]string{"movie", "series", "episode", "game"}, f.Type):
+		return fmt.Errorf("type must be one of movie, series, episode, game")
+	case f.MinRating < 0 || f.MinRating > 10:
+		return fmt.Errorf("min_rating must be between 0 and 10")
+	case f.Decade != "" && !decadePattern.MatchString(f.Decade):
+		return fmt.Errorf("decade must be a decade like 1980s")
+	case f.MinMetascore < 0 || f.MinMetascore > 100 || f.MinRT < 0 || f.MinRT > 100:
+		return fmt.Errorf("min_metascore and min_rt must be between 0 and 100")
+	}
+	if f.Tag != "" {
+		if _, ok := normalizeTag(f.Tag); !ok {
+			return fmt.Errorf("invalid tag")
+		}
+	}
+	return nil
+}
+
+// matcher returns the filter as a predicate over catalog titles; tagged
+// is the set of titles carrying the filter's tag for its owner.
+func (f savedSearchFilter) matcher(tagged map[string]bool) func(*MovieResponse) bool {
+	query := strings.ToLower(f.Query)
+	from := 0
+	if f.Decade != "" {
+		from, _ = strconv.Atoi(f.Decade[:4])
+	}
+	critics := titleFilter{regionQuery{f.Country, f.Language}, criticsQuery{f.MinMetascore, f.MinRT}}
+	return func(m *MovieResponse) bool {
+		if query != "" && !strings.Contains(strings.ToLower(m.Title), query) {
+			return false
+		}
+		if (f.Type != "" && m.Type != f.Type) || (f.Genre != "" && !containsFold(m.Genre, f.Genre)) {
+			return false
+		}
+		if f.MinRating > 0 {
+			if r, ok := parseRating(m.IMDBRating); !ok || r < f.MinRating {
+				return false
+			}
+		}
+		if y := startYear(m.Year); f.Decade != "" && (y < from || y >= from+10) {
+			return false
+		}
+		if f.Tag != "" && !tagged[m.IMDBID] {
+			return false
+		}
+		return critics.matches(m)
+	}
+}
+
+// matchingTitles runs the search over the catalog for its owner, best
+// rated first.
+func (s *SavedSearch) matchingTitles() []*MovieResponse {
+	var tagged map[string]bool
+	if s.Filter.Tag != "" {
+		tag, _ := normalizeTag(s.Filter.Tag)
+		tenant := ""
+		if u, ok := loadUser(s.UserID); ok {
+			tenant = u.TenantID
+		}
+		tagged = taggedIDs(append(globalTags(tenant), ownerTags(s.UserID)...), tag)
+	}
+	found := catalog.All(s.Filter.matcher(tagged))
+	sort.Slice(found, func(i, j int) bool {
+		return ratingKeyOf(found[i].IMDBRating, found[i].IMDBVotes, found[i].IMDBID).
+			before(ratingKeyOf(found[j].IMDBRating, found[j].IMDBVotes, found[j].IMDBID))
+	})
+	return found
+}
+
+func savedSearchKey(userID, id string) string {
+	return userID + "/" + id
+}
+
+// userSavedSearches returns the user's searches, oldest first, without
+// their known titles.
+func userSavedSearches(userID string) []SavedSearch {
+	searches := []SavedSearch{}
+	store.ForEachPrefix(savedSearchesBucket, userID+"/", func(_ string, value []byte) error {
+		var s SavedSearch
+		if json.Unmarshal(value, &s) == nil {
+			s.UserID, s.Known = userID, nil
+			searches = append(searches, s)
+		}
+		return nil
+	})
+	sort.Slice(searches, func(i, j int) bool { return searches[i].CreatedAt.Before(searches[j].CreatedAt) })
+	return searches
+}
+
+type savedSearchRequest struct {
+	Name     string            `json:"name"`
+	Filter   savedSearchFilter `json:"filter"`
+	Channels []string          `json:"channels"`
+}
+
+func postSavedSearch(c *gin.Context) {
+	var req savedSearchRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide {"name": "...", "filter": {...}, "channels": ["webhook", "email"]}`})
+		return
+	}
+	if err := req.Filter.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Channels) == 0 {
+		req.Channels = []string{channelWebhook}
+	}
+	for _, ch := range req.Channels {
+		if ch != channelWebhook && ch != channelEmail {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown channel " + ch, "channels": []string{channelWebhook, channelEmail}})
+			return
+		}
+	}
+	if slices.Contains(req.Channels, channelEmail) && mailer == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email notifications are not available on this server"})
+		return
+	}
+
+	u := currentUser(c)
+	if len(userSavedSearches(u.ID)) >= maxSavedSearches {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("you can save up to %d searches", maxSavedSearches)})
+		return
+	}
+	now := time.Now().UTC()
+	s := SavedSearch{
+		ID:            randomHex(8),
+		UserID:        u.ID,
+		Name:          strings.TrimSpace(req.Name),
+		Filter:        req.Filter,
+		Channels:      slices.Compact(slices.Sorted(slices.Values(req.Channels))),
+		LastCheckedAt: now,
+		CreatedAt:     now,
+	}
+	for _, m := range s.matchingTitles() {
+		s.Known = append(s.Known, m.IMDBID)
+	}
+	s.Matches = len(s.Known)
+	if err := store.Put(savedSearchesBucket, savedSearchKey(u.ID, s.ID), s); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save search"})
+		return
+	}
+	s.Known = nil
+	c.JSON(http.StatusCreated, s)
+}
+
+func getSavedSearches(c *gin.Context) {
+	searches := userSavedSearches(currentUser(c).ID)
+	respondList(c, http.StatusOK, searches, listMeta{Total: len(searches)}, searches)
+}
+
+func ownedSavedSearch(c *gin.Context) (*SavedSearch, bool) {
+	u := currentUser(c)
+	var s SavedSearch
+	if found, _ := store.Get(savedSearchesBucket, savedSearchKey(u.ID, c.Param("id")), &s); !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "saved search not found"})
+		return nil, false
+	}
+	s.UserID = u.ID
+	return &s, true
+}
+
+func deleteSavedSearch(c *gin.Context) {
+	s, ok := ownedSavedSearch(c)
+	if !ok {
+		return
+	}
+	if err := store.Delete(savedSearchesBucket, savedSearchKey(s.UserID, s.ID)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete saved search"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getSavedSearchResults runs a saved search now, from the catalog.
+func getSavedSearchResults(c *gin.Context) {
+	var q numberedPageQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	s, ok := ownedSavedSearch(c)
+	if !ok {
+		return
+	}
+	found := s.matchingTitles()
+	from := min((q.Page-1)*q.Limit, len(found))
+	items := make([]searchItem, 0, q.Limit)
+	for _, m := range found[from:min(from+q.Limit, len(found))] {
+		items = append(items, searchItem{Title: m.Title, Year: m.Year, IMDBID: m.IMDBID, Type: m.Type})
+	}
+	costOf(c).fromDataset()
+	respondList(c, http.StatusOK, items, listMeta{Total: len(found), Page: q.Page}, gin.H{"id": s.ID, "name": s.Name, "total": len(found), "page": q.Page, "items": items})
+}
+
+// savedSearchMatch is the payload of a saved_search.new_titles event.
+type savedSearchMatch struct {
+	SearchID string       `json:"search_id"`
+	Name     string       `json:"name"`
+	Titles   []searchItem `json:"titles"`
+}
+
+// checkSavedSearches runs checkAllSavedSearches on a fixed schedule.
+func checkSavedSearches(every time.Duration) {
+	for range time.Tick(every) {
+		leaderOnly("saved_searches", func() { checkAllSavedSearches(time.Now().UTC()) })
+	}
+}
+
+// checkAllSavedSearches matches every saved search against the catalog
+// and tells its owner about titles it hasn't matched before. It costs no
+// upstream calls; new titles arrive as the catalog grows.
+func checkAllSavedSearches(now time.Time) {
+	var searches []SavedSearch
+	store.ForEach(savedSearchesBucket, func(key string, value []byte) error {
+		var s SavedSearch
+		if json.Unmarshal(value, &s) == nil {
+			s.UserID, _, _ = strings.Cut(key, "/")
+			searches = append(searches, s)
+		}
+		return nil
+	})
+
+	for _, s := range searches {
+		known := map[string]bool{}
+		for _, id := range s.Known {
+			known[id] = true
+		}
+		found := s.matchingTitles()
+		var fresh []searchItem
+		ids := make([]string, 0, len(found))
+		for _, m := range found {
+			ids = append(ids, m.IMDBID)
+			if !known[m.IMDBID] {
+				fresh = append(fresh, searchItem{Title: m.Title, Year: m.Year, IMDBID: m.IMDBID, Type: m.Type})
+			}
+		}
+		if len(fresh) > 0 {
+			notifySavedSearch(s, savedSearchMatch{SearchID: s.ID, Name: s.Name, Titles: fresh})
+			s.LastFoundAt = &now
+		}
+		// Titles that stop matching, say after a rating drops, are
+		// forgotten, so they count as new if they match again.
+		s.Known, s.Matches, s.LastCheckedAt = ids, len(ids), now
+		var current SavedSearch
+		_, err := store.Modify(savedSearchesBucket, savedSearchKey(s.UserID, s.ID), &current, func() error {
+			current.Known, current.Matches, current.LastCheckedAt, current.LastFoundAt = s.Known, s.Matches, s.LastCheckedAt, s.LastFoundAt
+			return nil
+		})
+		if err != nil {
+			slog.Warn("could not update saved search", "user", s.UserID, "search", s.ID, "error", err)
+		}
+	}
+}
+
+func notifySavedSearch(s SavedSearch, match savedSearchMatch) {
+	if slices.Contains(s.Channels, channelWebhook) {
+		notify(s.UserID, eventSavedSearchNewTitles, match)
+	}
+	if !slices.Contains(s.Channels, channelEmail) || mailer == nil {
+		return
+	}
+	u, ok := loadUser(s.UserID)
+	if !ok {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "New titles match your saved search %q:\n\n", s.Name)
+	for _, t := range match.Titles {
+		fmt.Fprintf(&b, "  %s (%s)  %s/m/%s\n", t.Title, t.Year, strings.TrimRight(cfg().PublicBaseURL, "/"), t.IMDBID)
+	}
+	subject := fmt.Sprintf("%d new titles for %q", len(match.Titles), s.Name)
+	if len(match.Titles) == 1 {
+		subject = fmt.Sprintf("A new title for %q", s.Name)
+	}
+	if err := mailer.Send(u.Email, subject, b.String(), nil); err != nil {
+		slog.Warn("saved search email failed", "user", s.UserID, "search", s.ID, "error", err)
+	}
+}
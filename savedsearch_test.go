@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSavedSearchHandlers(t *testing.T) {
+	user, _ := newUser(t)
+	other, _ := newUser(t)
+	// Saved searches run over the catalog, which learns titles from lookups.
+	if w := request(http.MethodGet, "/api/movie?id=tt0133093", nil, ""); w.Code != http.StatusOK {
+		t.Fatalf("lookup: status %d; body %s", w.Code, w.Body)
+	}
+	w := request(http.MethodPost, "/api/users/me/saved-searches", user, `{"name":"  Matrix  ","filter":{"q":"matrix"}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("save search: status %d; body %s", w.Code, w.Body)
+	}
+	saved := decodeObject(t, w)
+	id := saved["id"].(string)
+	if saved["name"] != "Matrix" || saved["matches"] != float64(1) || saved["known"] != nil {
+		t.Errorf("saved search = %v, want the trimmed name, one match and no known titles", saved)
+	}
+
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "no name",
+			method:      http.MethodPost,
+			path:        "/api/users/me/saved-searches",
+			headers:     user,
+			body:        `{"filter":{"q":"matrix"}}`,
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+		{
+			name:        "empty filter",
+			method:      http.MethodPost,
+			path:        "/api/users/me/saved-searches",
+			headers:     user,
+			body:        `{"name":"everything","filter":{}}`,
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+		{
+			name:        "unknown type",
+			method:      http.MethodPost,
+			path:        "/api/users/me/saved-searches",
+			headers:     user,
+			body:        `{"name":"films","filter":{"type":"film"}}`,
+			wantStatus:  http.StatusBadRequest,
+			wantJSON:    map[string]string{"error": "type must be one of movie, series, episode, game"},
+			wantLookups: 0,
+		},
+		{
+			name:        "rating out of range",
+			method:      http.MethodPost,
+			path:        "/api/users/me/saved-searches",
+			headers:     user,
+			body:        `{"name":"best","filter":{"min_rating":11}}`,
+			wantStatus:  http.StatusBadRequest,
+			wantJSON:    map[string]string{"error": "min_rating must be between 0 and 10"},
+			wantLookups: 0,
+		},
+		{
+			name:        "not a decade",
+			method:      http.MethodPost,
+			path:        "/api/users/me/saved-searches",
+			headers:     user,
+			body:        `{"name":"1999","filter":{"decade":"1999"}}`,
+			wantStatus:  http.StatusBadRequest,
+			wantJSON:    map[string]string{"error": "decade must be a decade like 1980s"},
+			wantLookups: 0,
+		},
+		{
+			name:        "unknown channel",
+			method:      http.MethodPost,
+			path:        "/api/users/me/saved-searches",
+			headers:     user,
+			body:        `{"name":"texts","filter":{"q":"heat"},"channels":["sms"]}`,
+			wantStatus:  http.StatusBadRequest,
+			wantJSON:    map[string]string{"error": "unknown channel sms"},
+			wantLookups: 0,
+		},
+		{
+			name:        "results",
+			path:        "/api/users/me/saved-searches/" + id + "/results",
+			headers:     user,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"id": id, "total": "1"},
+			wantLookups: 0,
+		},
+		{
+			name:        "someone else's results",
+			path:        "/api/users/me/saved-searches/" + id + "/results",
+			headers:     other,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "someone else's search",
+			method:      http.MethodDelete,
+			path:        "/api/users/me/saved-searches/" + id,
+			headers:     other,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "delete",
+			method:      http.MethodDelete,
+			path:        "/api/users/me/saved-searches/" + id,
+			headers:     user,
+			wantStatus:  http.StatusNoContent,
+			wantLookups: 0,
+		},
+		{
+			name:        "delete twice",
+			method:      http.MethodDelete,
+			path:        "/api/users/me/saved-searches/" + id,
+			headers:     user,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+	})
+
+	if w := request(http.MethodGet, "/api/users/me/saved-searches", user, ""); strings.Contains(w.Body.String(), id) {
+		t.Errorf("a deleted search is still listed: %s", w.Body)
+	}
+}
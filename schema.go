@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Response schema versions. Clients opt in to a newer one with the
+// X-API-Version header or ?schema=; unpinned requests keep getting v1, so
+// a new version never changes the shape an existing client sees.
+const (
+	schemaV1      = 1
+	schemaV2      = 2
+	schemaV3      = 3
+	latestSchema  = schemaV3
+	defaultSchema = schemaV1
+)
+
+// responseSchema resolves the schema version for the request and echoes
+// it in X-API-Version. It writes a 400 and reports false for an unknown
+// version.
+func responseSchema(c *gin.Context) (int, bool) {
+	raw := c.GetHeader("X-API-Version")
+	if raw == "" {
+		raw = c.Query("schema")
+	}
+	version := defaultSchema
+	if raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < schemaV1 || v > latestSchema {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown API version " + raw, "latest": latestSchema})
+			return 0, false
+		}
+		version = v
+	}
+	c.Header("X-API-Version", strconv.Itoa(version))
+	return version, true
+}
+
+// Schema 3 wraps list responses in a {data, meta} envelope; see
+// respondList.
+
+// movieProjection is the /api/movie body. v1 is the original shape: its
+// fields never change or go away, but optional ones that only some titles
+// have (provider, availability, tags) are added at every version. v2 adds
+// Type and the fields nearly every client needs for a title card, plus
+// released_iso, box_office, weighted_rating and the numeric critic scores
+// where known.
+func movieProjection(m *MovieResponse, version int) gin.H {
+	out := gin.H{
+		"Title":    m.Title,
+		"Year":     m.Year,
+		"Plot":     m.Plot,
+		"Country":  m.Country,
+		"Awards":   m.Awards,
+		"Director": m.Director,
+		"Ratings":  m.Ratings,
+	}
+	if m.Provider != "" {
+		out["provider"] = m.Provider
+	}
+	if m.Availability != "" {
+		out["availability"] = m.Availability
+	}
+	if len(m.Tags) > 0 {
+		out["tags"] = m.Tags
+	}
+	if version >= schemaV2 {
+		out["Type"] = m.Type
+		out["imdbID"] = m.IMDBID
+		out["imdbRating"] = m.IMDBRating
+		out["Poster"] = m.Poster
+		out["Runtime"] = m.Runtime
+		out["Rated"] = m.Rated
+		out["Language"] = m.Language
+		out["Genre"] = m.Genre
+		out["Released"] = m.Released
+		if usd, ok := parseBoxOffice(m.BoxOffice); ok {
+			out["box_office"] = money{usd, "USD"}
+		}
+		addReleaseDates(out, m.Released, nil)
+		if r, ok := parseRating(m.IMDBRating); ok {
+			out["weighted_rating"] = weightedRating(r, parseVotes(m.IMDBVotes))
+		}
+		if n, ok := metascore(m); ok {
+			out["metascore"] = n
+		}
+		if n, ok := rottenTomatoes(m); ok {
+			out["rt_score"] = n
+		}
+	}
+	return out
+}
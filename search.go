@@ -0,0 +1,214 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getSearch(c *gin.Context) {
+	var q searchQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+
+	cur := searchCursor{Q: q.Q, Type: q.Type, Year: q.Year, Tag: q.Tag, Page: q.Page, Pages: q.Pages}
+	if q.Cursor != "" {
+		if err := decodeCursor(q.Cursor, &cur); err != nil || cur.Page < 1 || cur.Page > 100 || cur.Pages > maxMergedPages {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errBadCursor.Error()})
+			return
+		}
+		cur.Pages = max(cur.Pages, 1)
+	}
+
+	params := searchParams(cur.Q, cur.Page)
+	params["type"] = cur.Type
+	if cur.Year != "" {
+		params["y"] = cur.Year
+	}
+	results, err := fetchSearch(scopedParams(c, params))
+	if err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+	page := *results
+	if cur.After != "" {
+		for i, item := range page.Search {
+			if item.IMDBID == cur.After {
+				page.Search = page.Search[i+1:]
+				break
+			}
+		}
+	}
+	total, _ := strconv.Atoi(results.TotalResults)
+	meta := listMeta{Total: total, Page: cur.Page}
+	last := cur.Page
+	lastItems := results.Search
+	if cur.Pages > 1 {
+		var merge searchMerge
+		page.Search, merge, last, lastItems = mergeSearchPages(c, params, page.Search, cur.Page, cur.Pages, total)
+		if merge.Complete {
+			total = len(page.Search)
+		} else {
+			total -= merge.Duplicates
+		}
+		page.TotalResults = strconv.Itoa(total)
+		page.Merge = &merge
+		meta.Total, meta.Merge, meta.Warnings = total, &merge, merge.Warnings
+	}
+	page.RequestedYear = cur.Year
+	if cur.Tag != "" {
+		page.Search = filterByTag(c, page.Search, cur.Tag)
+	}
+	omdbTotal, _ := strconv.Atoi(results.TotalResults)
+	if n := len(lastItems); n > 0 && last*10 < omdbTotal && last < 100 {
+		next := cur
+		next.Page = last + 1
+		next.After = lastItems[n-1].IMDBID
+		page.NextCursor = encodeCursor(next)
+	}
+	meta.NextCursor = page.NextCursor
+	if q.Enrich {
+		items, summary := enrichSearch(c, page.Search)
+		meta.Enrichment = &summary
+		respondList(c, http.StatusOK, items, meta, enrichedSearchResults{SearchResults: page, Search: items, Enrichment: summary})
+		return
+	}
+	respondList(c, http.StatusOK, page.Search, meta, page)
+}
+
+// maxMergedPages caps ?pages=; each page is an upstream call.
+const maxMergedPages = 5
+
+// searchMerge reports how the pages of a merged search were combined.
+type searchMerge struct {
+	Pages      int `json:"pages"`
+	Duplicates int `json:"duplicates"`
+	// Complete is set when the merge started at page 1 and reached the
+	// last page, so the total is the exact number of distinct titles.
+	// Otherwise it is OMDb's total less the duplicates seen.
+	Complete bool            `json:"complete"`
+	Warnings []lookupWarning `json:"-"`
+}
+
+// mergeSearchPages fetches the pages after first, up to want pages in
+// all, and appends their hits to items, dropping titles already listed.
+// OMDb's order is kept: by page, then by position on the page. A page
+// that fails ends the merge early with a warning. It returns the last
+// page fetched and the hits OMDb returned on it, for the next cursor.
+func mergeSearchPages(c *gin.Context, params map[string]string, items []searchItem, first, want, total int) ([]searchItem, searchMerge, int, []searchItem) {
+	merge := searchMerge{Pages: 1}
+	seen := map[string]bool{}
+	merged := make([]searchItem, 0, len(items))
+	add := func(found []searchItem) {
+		for _, item := range found {
+			if seen[item.IMDBID] {
+				merge.Duplicates++
+				continue
+			}
+			seen[item.IMDBID] = true
+			merged = append(merged, item)
+		}
+	}
+	add(items)
+	last, lastItems := first, items
+	for p := first + 1; p < first+want && p <= 100 && (p-1)*10 < total; p++ {
+		next := make(map[string]string, len(params))
+		for k, v := range params {
+			next[k] = v
+		}
+		next["page"] = strconv.Itoa(p)
+		results, err := fetchSearch(scopedParams(c, next))
+		if err != nil {
+			merge.Warnings = append(merge.Warnings, newLookupWarning(next, err))
+			break
+		}
+		add(results.Search)
+		merge.Pages++
+		last, lastItems = p, results.Search
+	}
+	merge.Complete = first == 1 && last*10 >= total
+	return merged, merge, last, lastItems
+}
+
+// filterByTag keeps the results carrying tag. It filters the page OMDb
+// returned, so a page can come back short or empty while later pages
+// still have matches; totals stay OMDb's.
+func filterByTag(c *gin.Context, items []searchItem, tag string) []searchItem {
+	tag, _ = normalizeTag(tag)
+	tagged := taggedIDs(visibleTags(c), tag)
+	out := []searchItem{}
+	for _, item := range items {
+		if tagged[item.IMDBID] {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// getSearchAll searches movies and series together. Results from the two
+// are interleaved so neither crowds the other off the page, and each
+// carries a media_type.
+func getSearchAll(c *gin.Context) {
+	var q searchQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+
+	var lists [][]gin.H
+	total := 0
+	var lastErr error
+	for _, mediaType := range []string{"movie", "series"} {
+		params := searchParams(q.Q, q.Page)
+		params["type"] = mediaType
+		if q.Year != "" {
+			params["y"] = q.Year
+		}
+		results, err := fetchSearch(scopedParams(c, params))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		n, _ := strconv.Atoi(results.TotalResults)
+		total += n
+		found := results.Search
+		if q.Tag != "" {
+			found = filterByTag(c, found, q.Tag)
+		}
+		items := make([]gin.H, 0, len(found))
+		for _, item := range found {
+			items = append(items, gin.H{
+				"Title":      item.Title,
+				"Year":       item.Year,
+				"imdbID":     item.IMDBID,
+				"Type":       item.Type,
+				"media_type": mediaType,
+			})
+		}
+		lists = append(lists, items)
+	}
+	if len(lists) == 0 {
+		respondUpstreamError(c, lastErr)
+		return
+	}
+
+	merged := []gin.H{}
+	for i := 0; ; i++ {
+		added := false
+		for _, items := range lists {
+			if i < len(items) {
+				merged = append(merged, items[i])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	resp := gin.H{"Search": merged, "totalResults": strconv.Itoa(total), "Response": "True"}
+	if q.Year != "" {
+		resp["requested_year"] = q.Year
+	}
+	respondList(c, http.StatusOK, merged, listMeta{Total: total, Page: q.Page}, resp)
+}
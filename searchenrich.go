@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// searchEnrichConcurrency is how many detail lookups an enriched search
+// runs at once.
+const searchEnrichConcurrency = 4
+
+// enrichedSearchItem is a search hit with the details a result card
+// needs. Enriched is false for hits the budget didn't stretch to or
+// whose lookup failed; they carry only what the search returned.
+type enrichedSearchItem struct {
+	searchItem
+	Enriched       bool     `json:"enriched"`
+	IMDBRating     string   `json:"imdbRating,omitempty"`
+	Genres         []string `json:"genres,omitempty"`
+	Runtime        string   `json:"Runtime,omitempty"`
+	RuntimeMinutes int      `json:"runtime_minutes,omitempty"`
+	Poster         string   `json:"Poster,omitempty"`
+}
+
+// searchEnrichment reports what enriching a page cost.
+type searchEnrichment struct {
+	Budget   int `json:"budget"`
+	Spent    int `json:"spent"`
+	Enriched int `json:"enriched"`
+	Skipped  int `json:"skipped"`
+}
+
+type enrichedSearchResults struct {
+	SearchResults
+	Search     []enrichedSearchItem `json:"Search"`
+	Enrichment searchEnrichment     `json:"enrichment"`
+}
+
+// enrichSearch looks up the details of every hit on the page. Titles in
+// the catalog or the cache are free; the others are looked up, several
+// at a time, until the budget is spent. A title counts against the
+// budget if it wasn't cached when the page was read.
+func enrichSearch(c *gin.Context, items []searchItem) ([]enrichedSearchItem, searchEnrichment) {
+	summary := searchEnrichment{Budget: cfg().Deepening.SearchEnrichBudget}
+	out := make([]enrichedSearchItem, len(items))
+	var fetch []int
+	for i, item := range items {
+		out[i].searchItem = item
+		if m, ok := catalog.Get(item.IMDBID); ok {
+			out[i].fill(m)
+			continue
+		}
+		if _, cached := upstreamCache.Peek(cacheKey(scopedParams(c, map[string]string{"i": item.IMDBID}))); !cached {
+			if summary.Spent >= summary.Budget {
+				continue
+			}
+			summary.Spent++
+		}
+		fetch = append(fetch, i)
+	}
+
+	sem := make(chan struct{}, searchEnrichConcurrency)
+	var wg sync.WaitGroup
+	for _, i := range fetch {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(item *enrichedSearchItem) {
+			defer func() { <-sem; wg.Done() }()
+			m, err := fetchMovie(scopedParams(c, map[string]string{"i": item.IMDBID}))
+			if err != nil {
+				if !errors.Is(err, errRequestLimit) {
+					slog.Debug("search enrichment lookup failed", "imdbID", item.IMDBID, "error", err)
+				}
+				return
+			}
+			item.fill(m)
+		}(&out[i])
+	}
+	wg.Wait()
+
+	for _, item := range out {
+		if item.Enriched {
+			summary.Enriched++
+		} else {
+			summary.Skipped++
+		}
+	}
+	return out, summary
+}
+
+func (e *enrichedSearchItem) fill(m *MovieResponse) {
+	e.Enriched = true
+	e.IMDBRating = m.IMDBRating
+	e.Runtime = m.Runtime
+	e.RuntimeMinutes = runtimeMinutes(m.Runtime)
+	e.Poster = m.Poster
+	for _, g := range strings.Split(m.Genre, ",") {
+		if g = strings.TrimSpace(g); g != "" && g != "N/A" {
+			e.Genres = append(e.Genres, g)
+		}
+	}
+}
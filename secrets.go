@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// managedSecrets are the values a secrets provider may supply. Anything
+// the provider doesn't have falls back to the environment variable of the
+// same name.
+var managedSecrets = []string{"OMDB_API_KEY", "JWT_SECRET", "REDIS_URL"}
+
+// SecretsProvider loads the current secrets as name/value pairs. It is
+// called at startup and again on every refresh, so a rotated secret is
+// picked up without a restart.
+type SecretsProvider interface {
+	Load(ctx context.Context) (map[string]string, error)
+}
+
+// vaultSecrets reads a KV secret from Vault. Both KV engine versions
+// work: for v2 the path includes "data/", e.g. secret/data/movie-api.
+// With VAULT_TOKEN_FILE the token is re-read on every load, so a Vault
+// Agent sink can renew it underneath us.
+type vaultSecrets struct {
+	addr      string
+	path      string
+	token     string
+	tokenFile string
+	client    *http.Client
+}
+
+func (v *vaultSecrets) Load(ctx context.Context) (map[string]string, error) {
+	token := v.token
+	if v.tokenFile != "" {
+		data, err := os.ReadFile(v.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(v.addr, "/")+"/v1/"+strings.TrimPrefix(v.path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %s for %s", resp.Status, v.path)
+	}
+	var out struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	// KV v2 nests the values under data.data, next to data.metadata.
+	if inner, ok := out.Data["data"]; ok {
+		if _, versioned := out.Data["metadata"]; versioned {
+			return stringValues(inner)
+		}
+	}
+	raw, _ := json.Marshal(out.Data)
+	return stringValues(raw)
+}
+
+// awsSecrets reads one secret from AWS Secrets Manager whose SecretString
+// is a JSON object of name/value pairs. Credentials come from the usual
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+type awsSecrets struct {
+	endpoint     string
+	region       string
+	secretID     string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+func (a *awsSecrets) Load(ctx context.Context) (map[string]string, error) {
+	body, _ := json.Marshal(map[string]string{"SecretId": a.secretID})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if a.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.sessionToken)
+	}
+	signAWSv4(req, body, a.region, "secretsmanager", a.accessKey, a.secretKey, time.Now().UTC())
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("secrets manager returned %s for %s", resp.Status, a.secretID)
+	}
+	var out struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return stringValues([]byte(out.SecretString))
+}
+
+// signAWSv4 adds a Signature Version 4 Authorization header covering the
+// request's x-amz-* headers, Content-Type, Host and body.
+func signAWSv4(req *http.Request, body []byte, region, service, accessKey, secretKey string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, sha256Hex(body)}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sopsSecrets decrypts a SOPS-encrypted file with the sops binary, which
+// finds its own keys (age, PGP, KMS) the way it does on the command line.
+// The file must decrypt to a flat object of name/value pairs.
+type sopsSecrets struct {
+	bin  string
+	path string
+}
+
+func (s *sopsSecrets) Load(ctx context.Context) (map[string]string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.bin, "--decrypt", "--output-type", "json", s.path)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sops --decrypt %s: %v: %s", s.path, err, strings.TrimSpace(stderr.String()))
+	}
+	return stringValues(out)
+}
+
+// stringValues decodes a JSON object, keeping only its string values.
+func stringValues(data []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.New("secret is not a JSON object of name/value pairs")
+	}
+	values := map[string]string{}
+	for name, v := range raw {
+		if s, ok := v.(string); ok {
+			values[name] = s
+		}
+	}
+	return values, nil
+}
+
+// newSecretsProviderFromEnv returns nil unless SECRETS_PROVIDER is set,
+// which leaves every secret to its environment variable.
+func newSecretsProviderFromEnv() (SecretsProvider, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch provider := envString("SECRETS_PROVIDER", ""); provider {
+	case "":
+		return nil, nil
+	case "vault":
+		v := &vaultSecrets{
+			addr:      envString("VAULT_ADDR", "http://127.0.0.1:8200"),
+			path:      envString("VAULT_SECRET_PATH", "secret/data/movie-api"),
+			token:     envString("VAULT_TOKEN", ""),
+			tokenFile: envString("VAULT_TOKEN_FILE", ""),
+			client:    client,
+		}
+		if v.token == "" && v.tokenFile == "" {
+			return nil, errors.New("SECRETS_PROVIDER=vault needs VAULT_TOKEN or VAULT_TOKEN_FILE")
+		}
+		return v, nil
+	case "aws":
+		region := envString("AWS_REGION", envString("AWS_DEFAULT_REGION", ""))
+		a := &awsSecrets{
+			endpoint:     envString("AWS_SECRETS_ENDPOINT", "https://secretsmanager."+region+".amazonaws.com/"),
+			region:       region,
+			secretID:     envString("AWS_SECRET_ID", "movie-api"),
+			accessKey:    envString("AWS_ACCESS_KEY_ID", ""),
+			secretKey:    envString("AWS_SECRET_ACCESS_KEY", ""),
+			sessionToken: envString("AWS_SESSION_TOKEN", ""),
+			client:       client,
+		}
+		if a.region == "" || a.accessKey == "" || a.secretKey == "" {
+			return nil, errors.New("SECRETS_PROVIDER=aws needs AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		return a, nil
+	case "sops":
+		s := &sopsSecrets{bin: envString("SOPS_BIN", "sops"), path: envString("SOPS_FILE", "")}
+		if s.path == "" {
+			return nil, errors.New("SECRETS_PROVIDER=sops needs SOPS_FILE")
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown SECRETS_PROVIDER %q; use vault, aws or sops", provider)
+	}
+}
+
+var secrets = struct {
+	sync.RWMutex
+	provider SecretsProvider
+	values   map[string]string
+}{values: map[string]string{}}
+
+// secret returns the named secret from the provider, or from the
+// environment when there is no provider or it doesn't have one.
+func secret(name string) string {
+	secrets.RLock()
+	v := secrets.values[name]
+	secrets.RUnlock()
+	if v != "" {
+		return v
+	}
+	return os.Getenv(name)
+}
+
+// initSecrets loads the secrets once at startup; failing to reach a
+// configured provider is fatal rather than silently falling back.
+func initSecrets() error {
+	provider, err := newSecretsProviderFromEnv()
+	if err != nil || provider == nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	values, err := provider.Load(ctx)
+	if err != nil {
+		return err
+	}
+	secrets.Lock()
+	secrets.provider, secrets.values = provider, values
+	secrets.Unlock()
+	found := []string{}
+	for _, name := range managedSecrets {
+		if values[name] != "" {
+			found = append(found, name)
+		}
+	}
+	slog.Info("loaded secrets", "provider", os.Getenv("SECRETS_PROVIDER"), "secrets", found)
+	return nil
+}
+
+// refreshSecrets reloads the secrets on every tick and applies the ones
+// that changed. A failed reload keeps the values we have.
+func refreshSecrets(every time.Duration) {
+	secrets.RLock()
+	provider := secrets.provider
+	secrets.RUnlock()
+	if provider == nil {
+		return
+	}
+	for range time.Tick(every) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		values, err := provider.Load(ctx)
+		cancel()
+		if err != nil {
+			slog.Warn("could not refresh secrets; keeping the current ones", "error", err)
+			continue
+		}
+		before := map[string]string{}
+		for _, name := range managedSecrets {
+			before[name] = secret(name)
+		}
+		secrets.Lock()
+		secrets.values = values
+		secrets.Unlock()
+		for _, name := range managedSecrets {
+			if now := secret(name); now != before[name] {
+				slog.Info("secret rotated", "secret", name)
+				rotateSecret(name, now)
+			}
+		}
+	}
+}
+
+// rotateSecret applies a secret that changed while running. The OMDb key
+// is read per request and Redis credentials per new connection, so those
+// take effect by themselves.
+func rotateSecret(name, value string) {
+	switch name {
+	case "OMDB_API_KEY":
+		state, detail := checkOMDbKey()
+		omdbKey.set(state, detail)
+		if state != keyValid {
+			slog.Error("rotated OMDB_API_KEY did not validate", "state", state, "omdb_error", detail)
+		}
+	case "JWT_SECRET":
+		rotateJWTSecret(value)
+	case "REDIS_URL":
+		slog.Info("new Redis connections will use the rotated credentials; a changed host needs a restart")
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math/rand/v2"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// With TEST_MODE=true a request can pin its randomness with an
+// X-Random-Seed header, so integration tests and demos get the same quiz
+// for the same seed and catalog. Every response that used randomness says
+// which seed it used in X-Random-Seed, so a surprising one can be replayed.
+// Outside test mode the header is ignored.
+
+const randomSeedHeader = "X-Random-Seed"
+
+func testMode() bool {
+	return envString("TEST_MODE", "") == "true"
+}
+
+// requestRand returns the random source for a request's response: seeded
+// from X-Random-Seed in test mode, otherwise from a fresh seed.
+func requestRand(c *gin.Context) *rand.Rand {
+	seed := rand.Uint64()
+	if testMode() {
+		if v, err := strconv.ParseUint(c.GetHeader(randomSeedHeader), 10, 64); err == nil {
+			seed = v
+		}
+	}
+	c.Header(randomSeedHeader, strconv.FormatUint(seed, 10))
+	return rand.New(rand.NewPCG(seed, seed))
+}
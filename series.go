@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSeasons caps how many season pages one series request reads.
+const maxSeasons = 40
+
+// lookupSeries fetches the series a seriesQuery names and all its season
+// pages. Seasons OMDb has no page for are skipped.
+func lookupSeries(c *gin.Context, q seriesQuery) (*MovieResponse, []*SeasonResponse, error) {
+	params := map[string]string{"type": "series"}
+	if q.ID != "" {
+		params["i"] = q.ID
+	} else {
+		params["t"] = q.Title
+	}
+	series, err := fetchMovie(scopedParams(c, params))
+	if err != nil {
+		return nil, nil, err
+	}
+	total, _ := strconv.Atoi(series.TotalSeasons)
+	seasons := []*SeasonResponse{}
+	for s := 1; s <= min(total, maxSeasons); s++ {
+		var season SeasonResponse
+		err := fetchFromOMDb(scopedParams(c, map[string]string{"i": series.IMDBID, "Season": strconv.Itoa(s)}), &season)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		seasons = append(seasons, &season)
+	}
+	return series, seasons, nil
+}
+
+type seasonAverage struct {
+	Season        int     `json:"season"`
+	Average       float64 `json:"average"`
+	Episodes      int     `json:"episodes"`
+	RatedEpisodes int     `json:"rated_episodes"`
+}
+
+// seasonAverages averages the rated episodes of each season. Seasons with
+// no rated episodes are left out.
+func seasonAverages(seasons []*SeasonResponse) []seasonAverage {
+	out := []seasonAverage{}
+	for _, s := range seasons {
+		n, _ := strconv.Atoi(s.Season)
+		avg := seasonAverage{Season: n, Episodes: len(s.Episodes)}
+		sum := 0.0
+		for _, ep := range s.Episodes {
+			if r, ok := parseRating(ep.IMDBRating); ok {
+				sum += r
+				avg.RatedEpisodes++
+			}
+		}
+		if avg.RatedEpisodes == 0 {
+			continue
+		}
+		avg.Average = roundTo(sum/float64(avg.RatedEpisodes), 2)
+		out = append(out, avg)
+	}
+	return out
+}
+
+// Trend classification thresholds: a slope of at least trendSlope rating
+// points per season is a trend, and a drop of trendDrop below the peak
+// that never recovers is a decline after the peak.
+const (
+	trendSlope = 0.05
+	trendDrop  = 0.3
+)
+
+type seriesTrend struct {
+	Trend      string  `json:"trend"`
+	Summary    string  `json:"summary"`
+	Slope      float64 `json:"slope"`
+	PeakSeason int     `json:"peak_season"`
+}
+
+// classifyTrend describes how season averages move: "improving",
+// "declining", "steady", or "declining" after a peak when the show fell
+// off past some season and stayed down.
+func classifyTrend(avgs []seasonAverage) seriesTrend {
+	if len(avgs) < 3 {
+		return seriesTrend{Trend: "unknown", Summary: "not enough rated seasons"}
+	}
+
+	// Least-squares slope of average over season number.
+	var sx, sy, sxx, sxy float64
+	n := float64(len(avgs))
+	peak := avgs[0]
+	for _, a := range avgs {
+		x, y := float64(a.Season), a.Average
+		sx, sy, sxx, sxy = sx+x, sy+y, sxx+x*x, sxy+x*y
+		if a.Average > peak.Average {
+			peak = a
+		}
+	}
+	slope := 0.0
+	if d := n*sxx - sx*sx; d != 0 {
+		slope = (n*sxy - sx*sy) / d
+	}
+	t := seriesTrend{Slope: math.Round(slope*1000) / 1000, PeakSeason: peak.Season}
+
+	// Fell off after the peak: every season from some point on is well
+	// below it. The peak itself never is, so i stays above 0.
+	i := len(avgs)
+	for i > 0 && avgs[i-1].Season > peak.Season && avgs[i-1].Average <= peak.Average-trendDrop {
+		i--
+	}
+	if i < len(avgs) {
+		t.Trend = "declining"
+		t.Summary = fmt.Sprintf("declining after season %d", avgs[i-1].Season)
+		return t
+	}
+
+	switch {
+	case slope >= trendSlope:
+		t.Trend, t.Summary = "improving", "improving over its run"
+	case slope <= -trendSlope:
+		t.Trend, t.Summary = "declining", "declining over its run"
+	default:
+		t.Trend, t.Summary = "steady", "steady throughout"
+	}
+	return t
+}
+
+func getSeriesTrend(c *gin.Context) {
+	var q seriesQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	series, seasons, err := lookupSeries(c, q)
+	if err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+	avgs := seasonAverages(seasons)
+	setProvenance(c)
+	c.JSON(http.StatusOK, gin.H{
+		"Title":   series.Title,
+		"imdbID":  series.IMDBID,
+		"seasons": avgs,
+		"trend":   classifyTrend(avgs),
+	})
+}
+
+// plotMarkers are episode title words that suggest an episode carries the
+// main story, so skipping it is a bad idea whatever its rating.
+var plotMarkers = []struct{ word, reason string }{
+	{"pilot", "pilot"},
+	{"premiere", "premiere"},
+	{"finale", "finale"},
+	{"part ", "multi_part"},
+	{"(1)", "multi_part"},
+	{"(2)", "multi_part"},
+	{"conclusion", "multi_part"},
+}
+
+type skippableEpisode struct {
+	Season       int      `json:"season"`
+	Episode      int      `json:"episode"`
+	Title        string   `json:"Title"`
+	IMDBRating   string   `json:"imdbRating"`
+	IMDBID       string   `json:"imdbID"`
+	PlotRelevant bool     `json:"plot_relevant"`
+	Reasons      []string `json:"reasons,omitempty"`
+}
+
+// plotReasons guesses from its position and title why an episode may
+// matter to the story. Season openers and closers usually do.
+func plotReasons(title string, episode, seasonLength int) []string {
+	reasons := []string{}
+	if episode == 1 {
+		reasons = append(reasons, "season_opener")
+	}
+	if episode == seasonLength && seasonLength > 1 {
+		reasons = append(reasons, "season_closer")
+	}
+	lower := strings.ToLower(title)
+	for _, m := range plotMarkers {
+		if strings.Contains(lower, m.word) && !slices.Contains(reasons, m.reason) {
+			reasons = append(reasons, m.reason)
+		}
+	}
+	return reasons
+}
+
+// getSkippableEpisodes lists the episodes rated below ?threshold= in
+// airing order, each flagged if it looks plot-relevant. Unrated episodes
+// are never listed.
+func getSkippableEpisodes(c *gin.Context) {
+	var q skippableQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	series, seasons, err := lookupSeries(c, q.seriesQuery)
+	if err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+
+	skippable := []skippableEpisode{}
+	total, rated := 0, 0
+	for _, s := range seasons {
+		season, _ := strconv.Atoi(s.Season)
+		for _, ep := range s.Episodes {
+			total++
+			r, ok := parseRating(ep.IMDBRating)
+			if !ok {
+				continue
+			}
+			rated++
+			if r >= q.Threshold {
+				continue
+			}
+			n, _ := strconv.Atoi(ep.Episode)
+			reasons := plotReasons(ep.Title, n, len(s.Episodes))
+			skippable = append(skippable, skippableEpisode{
+				Season:       season,
+				Episode:      n,
+				Title:        ep.Title,
+				IMDBRating:   ep.IMDBRating,
+				IMDBID:       ep.IMDBID,
+				PlotRelevant: len(reasons) > 0,
+				Reasons:      reasons,
+			})
+		}
+	}
+
+	setProvenance(c)
+	c.JSON(http.StatusOK, gin.H{
+		"Title":          series.Title,
+		"imdbID":         series.IMDBID,
+		"threshold":      q.Threshold,
+		"total_episodes": total,
+		"rated_episodes": rated,
+		"skippable":      skippable,
+	})
+}
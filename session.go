@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionsBucket    = "sessions"
+	sessionCookieName = "movie_session"
+	csrfCookieName    = "csrf_token"
+	csrfHeaderName    = "X-CSRF-Token"
+	sessionTTL        = 30 * 24 * time.Hour
+)
+
+// browserSession backs cookie authentication for same-site browser clients.
+// The CSRF token follows the double-submit pattern: it's readable from a
+// non-HttpOnly cookie and must be echoed in X-CSRF-Token on writes.
+type browserSession struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	CSRFToken string    `json:"csrf_token"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func sessionCookieSecure() bool {
+	return envString("SESSION_COOKIE_SECURE", "true") == "true"
+}
+
+func startSession(c *gin.Context, u *User) (*browserSession, error) {
+	now := time.Now().UTC()
+	s := &browserSession{
+		ID:        randomHex(32),
+		UserID:    u.ID,
+		CSRFToken: randomHex(16),
+		CreatedAt: now,
+		ExpiresAt: now.Add(sessionTTL),
+	}
+	if err := store.Put(sessionsBucket, s.ID, s); err != nil {
+		return nil, err
+	}
+	maxAge := int(sessionTTL.Seconds())
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookieName, s.ID, maxAge, "/", "", sessionCookieSecure(), true)
+	c.SetCookie(csrfCookieName, s.CSRFToken, maxAge, "/", "", sessionCookieSecure(), false)
+	return s, nil
+}
+
+// sessionPrincipal resolves the session cookie, if any. It returns nil and
+// no error for requests without a usable session.
+func sessionPrincipal(c *gin.Context) (*principal, string) {
+	id, err := c.Cookie(sessionCookieName)
+	if err != nil || id == "" {
+		return nil, ""
+	}
+	var s browserSession
+	if found, _ := store.Get(sessionsBucket, id, &s); !found || time.Now().After(s.ExpiresAt) {
+		return nil, ""
+	}
+	u, ok := loadUser(s.UserID)
+	if !ok {
+		return nil, ""
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		given := c.GetHeader(csrfHeaderName)
+		if subtle.ConstantTimeCompare([]byte(given), []byte(s.CSRFToken)) != 1 {
+			return nil, "missing or invalid " + csrfHeaderName
+		}
+	}
+	return &principal{Kind: "user", ID: u.ID, Role: u.role(), User: u, Tenant: u.TenantID}, ""
+}
+
+func postSession(c *gin.Context) {
+	var req credentials
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	u, ok := checkCredentials(currentPrincipal(c).Tenant, req)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid email or password"})
+		return
+	}
+	s, err := startSession(c, u)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not start session"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"csrf_token": s.CSRFToken, "expires_at": s.ExpiresAt, "user": u.public()})
+}
+
+func deleteSession(c *gin.Context) {
+	if id, err := c.Cookie(sessionCookieName); err == nil {
+		store.Delete(sessionsBucket, id)
+	}
+	clearSessionCookies(c)
+	c.Status(http.StatusNoContent)
+}
+
+func clearSessionCookies(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookieName, "", -1, "/", "", sessionCookieSecure(), true)
+	c.SetCookie(csrfCookieName, "", -1, "/", "", sessionCookieSecure(), false)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// State that must agree across replicas lives in the store or in Redis:
+//
+//   - the store (STORE_URL, a Redis URL, when replicated) holds users,
+//     lists, keys, quota and analytics counters, popularity, experiment
+//     results and everything else that is persisted;
+//   - Redis (REDIS_URL) carries cache invalidations, notifications for
+//     sockets open on other replicas, rate-limit windows, the nonces
+//     of signed requests and the lease of the replica that runs the
+//     background jobs (see leader.go).
+//
+// What stays per process is derived and rebuilt from those: the response
+// cache, the title catalog and search index, computed genre lists, and
+// observations of OMDb's health and latency.
+
+// sharedRedis is the REDIS_URL connection, or nil when there is none and
+// the process keeps that state to itself.
+var sharedRedis *redis.Client
+
+// newRedisClient connects to url. Credentials are looked up again from
+// the secret named secretName for every new connection, so rotating the
+// password doesn't need a restart.
+func newRedisClient(url, secretName string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	opts.CredentialsProvider = func() (string, string) {
+		if current, err := redis.ParseURL(secret(secretName)); err == nil {
+			return current.Username, current.Password
+		}
+		return opts.Username, opts.Password
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// replicated reports whether the deployment runs more than one replica,
+// as REPLICAS says.
+func replicated() bool {
+	return replicaCount() > 1
+}
+
+// replicaCount is how many replicas REPLICAS says the deployment runs,
+// at least one.
+func replicaCount() int {
+	n, _ := strconv.Atoi(os.Getenv("REPLICAS"))
+	return max(n, 1)
+}
+
+// checkBackingServices refuses to start a replicated deployment whose
+// state isn't shared, since replicas would then disagree about users,
+// quotas and limits depending on which one a request reaches.
+func checkBackingServices() error {
+	var missing []string
+	if !store.Shared() {
+		missing = append(missing, "STORE_URL must point the store at Redis (a bbolt file can't be shared)")
+	}
+	if sharedRedis == nil {
+		missing = append(missing, "REDIS_URL must be set for cache invalidation, notifications, rate limits and nonces")
+	}
+	if !replicated() {
+		if len(missing) > 0 {
+			slog.Info("running as a single replica; state is local to this process")
+		}
+		return nil
+	}
+	if len(missing) > 0 {
+		return errors.New("REPLICAS > 1 needs shared state: " + strings.Join(missing, "; "))
+	}
+	slog.Info("state is shared across replicas", "replicas", os.Getenv("REPLICAS"))
+	return nil
+}
+
+const notificationChannel = "movie-api:notifications"
+
+type notification struct {
+	UserID string          `json:"user_id"`
+	Event  string          `json:"event"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// publishNotification hands a socket notification to every replica, the
+// one holding the user's socket included. Without Redis it goes straight
+// to this process's sockets.
+func publishNotification(userID, event string, data interface{}) {
+	if sharedRedis == nil {
+		notificationHub.Send(userID, event, data)
+		return
+	}
+	raw, err := json.Marshal(data)
+	if err == nil {
+		var payload []byte
+		payload, err = json.Marshal(notification{UserID: userID, Event: event, Data: raw})
+		if err == nil {
+			err = sharedRedis.Publish(context.Background(), notificationChannel, payload).Err()
+		}
+	}
+	if err != nil {
+		slog.Warn("could not publish notification; delivering locally", "event", event, "error", err)
+		notificationHub.Send(userID, event, data)
+	}
+}
+
+// relayNotifications delivers published notifications to this process's
+// sockets.
+func relayNotifications(client *redis.Client) {
+	sub := client.Subscribe(context.Background(), notificationChannel)
+	for m := range sub.Channel() {
+		var n notification
+		if err := json.Unmarshal([]byte(m.Payload), &n); err != nil {
+			slog.Warn("notification relay: bad message", "error", err)
+			continue
+		}
+		notificationHub.Send(n.UserID, n.Event, n.Data)
+	}
+}
+
+func sharedKey(kind, key string) string {
+	return fmt.Sprintf("movie-api:%s:%s", kind, key)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/rand"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	shortlinksBucket         = "shortlinks"
+	shortlinksByTargetBucket = "shortlinks_by_target"
+	shortCodeAlphabet        = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	shortCodeLength          = 7
+)
+
+// Shortlink only ever points at our own movie or list pages, so it can't be
+// used as an open redirect. Codes are unique across tenants so anyone can
+// follow one, but each tenant gets its own code and click count per target.
+type Shortlink struct {
+	Code      string    `json:"code"`
+	TenantID  string    `json:"tenant_id,omitempty"`
+	Kind      string    `json:"kind"`
+	Ref       string    `json:"ref"`
+	Clicks    int64     `json:"clicks"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s Shortlink) path() string {
+	if s.Kind == "list" {
+		return "/l/" + s.Ref
+	}
+	return "/m/" + s.Ref
+}
+
+func newShortCode() string {
+	b := make([]byte, shortCodeLength)
+	max := big.NewInt(int64(len(shortCodeAlphabet)))
+	for i := range b {
+		n, _ := rand.Int(rand.Reader, max)
+		b[i] = shortCodeAlphabet[n.Int64()]
+	}
+	return string(b)
+}
+
+type shortenRequest struct {
+	IMDBID string `json:"imdbID"`
+	ListID string `json:"list_id"`
+}
+
+func postShorten(c *gin.Context) {
+	var req shortenRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.IMDBID == "") == (req.ListID == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide exactly one of {"imdbID": "tt..."} or {"list_id": "..."}`})
+		return
+	}
+
+	link := Shortlink{Kind: "movie", Ref: req.IMDBID}
+	if req.ListID != "" {
+		l, ok := loadList(req.ListID)
+		if !ok || l.Visibility == visibilityPrivate {
+			c.JSON(http.StatusNotFound, gin.H{"error": "list not found or private"})
+			return
+		}
+		link = Shortlink{Kind: "list", Ref: l.ID}
+	} else if _, err := fetchMovie(scopedParams(c, map[string]string{"i": req.IMDBID})); err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+
+	link, created, err := ensureShortlink(currentPrincipal(c).Tenant, link.Kind, link.Ref)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save shortlink"})
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	respondShortlink(c, status, link)
+}
+
+// ensureShortlink returns tenant's code for a target, creating one on
+// first use; the same target always gets the same code.
+func ensureShortlink(tenant, kind, ref string) (Shortlink, bool, error) {
+	link := Shortlink{TenantID: tenant, Kind: kind, Ref: ref}
+	var existing string
+	if found, _ := store.Get(shortlinksByTargetBucket, tenantKey(tenant, link.path()), &existing); found {
+		if found, _ := store.Get(shortlinksBucket, existing, &link); found {
+			return link, false, nil
+		}
+	}
+
+	link.CreatedAt = time.Now().UTC()
+	for {
+		link.Code = newShortCode()
+		var taken Shortlink
+		if found, _ := store.Get(shortlinksBucket, link.Code, &taken); !found {
+			break
+		}
+	}
+	if err := store.Put(shortlinksBucket, link.Code, link); err != nil {
+		return link, false, err
+	}
+	return link, true, store.Put(shortlinksByTargetBucket, tenantKey(tenant, link.path()), link.Code)
+}
+
+func respondShortlink(c *gin.Context, status int, link Shortlink) {
+	c.JSON(status, gin.H{
+		"code":      link.Code,
+		"short_url": publicURL(c, "/s/"+link.Code),
+		"target":    publicURL(c, link.path()),
+		"clicks":    link.Clicks,
+	})
+}
+
+func getShortlinkRedirect(c *gin.Context) {
+	var link Shortlink
+	found, err := store.Modify(shortlinksBucket, c.Param("code"), &link, func() error {
+		link.Clicks++
+		return nil
+	})
+	if err != nil || !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "short link not found"})
+		return
+	}
+	c.Redirect(http.StatusFound, link.path())
+}
+
+func getShortlinkStats(c *gin.Context) {
+	var link Shortlink
+	if found, _ := store.Get(shortlinksBucket, c.Param("code"), &link); !found || link.TenantID != currentPrincipal(c).Tenant {
+		c.JSON(http.StatusNotFound, gin.H{"error": "short link not found"})
+		return
+	}
+	respondShortlink(c, http.StatusOK, link)
+}
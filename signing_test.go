@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signed returns headers that sign a request with keyID's secret at ts.
+func signed(keyID, secret, method, uri, body string, ts time.Time) map[string]string {
+	timestamp, nonce := strconv.FormatInt(ts.Unix(), 10), randomHex(8)
+	return map[string]string{
+		"X-Signature-Key":       keyID,
+		"X-Signature-Timestamp": timestamp,
+		"X-Signature-Nonce":     nonce,
+		"X-Signature":           sign(secret, signingPayload(method, uri, timestamp, nonce, []byte(body))),
+	}
+}
+
+func TestSignedRequests(t *testing.T) {
+	w := request(http.MethodPost, "/admin/api-keys", adminHeaders, `{"name":"signer","role":"user"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create key: status %d; body %s", w.Code, w.Body)
+	}
+	id := decodeObject(t, w)["api_key"].(map[string]interface{})["id"].(string)
+	w = request(http.MethodPut, "/admin/api-keys/"+id+"/signing-secret", adminHeaders, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("issue signing secret: status %d; body %s", w.Code, w.Body)
+	}
+	secret := decodeObject(t, w)["signing_secret"].(string)
+	now := time.Now()
+	replayed := signed(id, secret, http.MethodGet, "/api/usage", "", now)
+	tampered := signed(id, secret, http.MethodPost, "/api/shorten", `{"imdbID":"tt0133093"}`, now)
+	unsigned := signed(id, secret, http.MethodGet, "/api/usage", "", now)
+	delete(unsigned, "X-Signature")
+
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "signed request",
+			path:        "/api/usage",
+			headers:     replayed,
+			wantStatus:  http.StatusOK,
+			wantKeys:    []string{"quotas"},
+			wantLookups: 0,
+		},
+		{
+			name:        "replayed",
+			path:        "/api/usage",
+			headers:     replayed,
+			wantStatus:  http.StatusUnauthorized,
+			wantJSON:    map[string]string{"error": "nonce already used"},
+			wantLookups: 0,
+		},
+		{
+			name:        "signed for another path",
+			path:        "/api/usage?all=1",
+			headers:     signed(id, secret, http.MethodGet, "/api/usage", "", now),
+			wantStatus:  http.StatusUnauthorized,
+			wantJSON:    map[string]string{"error": "invalid signature"},
+			wantLookups: 0,
+		},
+		{
+			name:        "body changed after signing",
+			method:      http.MethodPost,
+			path:        "/api/shorten",
+			headers:     tampered,
+			body:        `{"imdbID":"tt0068646"}`,
+			wantStatus:  http.StatusUnauthorized,
+			wantJSON:    map[string]string{"error": "invalid signature"},
+			wantLookups: 0,
+		},
+		{
+			name:        "wrong secret",
+			path:        "/api/usage",
+			headers:     signed(id, "ms_guess", http.MethodGet, "/api/usage", "", now),
+			wantStatus:  http.StatusUnauthorized,
+			wantJSON:    map[string]string{"error": "invalid signature"},
+			wantLookups: 0,
+		},
+		{
+			name:        "stale timestamp",
+			path:        "/api/usage",
+			headers:     signed(id, secret, http.MethodGet, "/api/usage", "", now.Add(-signatureTolerance-time.Minute)),
+			wantStatus:  http.StatusUnauthorized,
+			wantJSON:    map[string]string{"error": "signature timestamp is missing or outside the allowed window"},
+			wantLookups: 0,
+		},
+		{
+			name:        "missing signature",
+			path:        "/api/usage",
+			headers:     unsigned,
+			wantStatus:  http.StatusUnauthorized,
+			wantLookups: 0,
+		},
+		{
+			name:        "secret for an unknown key",
+			method:      http.MethodPut,
+			path:        "/admin/api-keys/nowhere/signing-secret",
+			headers:     adminHeaders,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "withdraw the secret",
+			method:      http.MethodDelete,
+			path:        "/admin/api-keys/" + id + "/signing-secret",
+			headers:     adminHeaders,
+			wantStatus:  http.StatusNoContent,
+			wantLookups: 0,
+		},
+		{
+			name:        "a withdrawn secret no longer signs",
+			path:        "/api/usage",
+			headers:     signed(id, secret, http.MethodGet, "/api/usage", "", now),
+			wantStatus:  http.StatusUnauthorized,
+			wantJSON:    map[string]string{"error": "invalid signature"},
+			wantLookups: 0,
+		},
+	})
+}
+
+// jwksKIDs returns the key IDs published at /.well-known/jwks.json.
+func jwksKIDs(t *testing.T) []string {
+	t.Helper()
+	w := request(http.MethodGet, "/.well-known/jwks.json", nil, "")
+	var jwks struct {
+		Keys []struct {
+			KID string `json:"kid"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &jwks); err != nil {
+		t.Fatalf("jwks: %v; body %s", err, w.Body)
+	}
+	var kids []string
+	for _, k := range jwks.Keys {
+		kids = append(kids, k.KID)
+	}
+	return kids
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	user, _ := newUser(t)
+	old := activeSigningKey().KID
+	w := request(http.MethodPost, "/admin/jwt-keys/rotate", adminHeaders, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("rotate: status %d; body %s", w.Code, w.Body)
+	}
+	rotated := decodeObject(t, w)["kid"].(string)
+	if kids := strings.Join(jwksKIDs(t), " "); !strings.Contains(kids, old) || !strings.Contains(kids, rotated) {
+		t.Fatalf("jwks has %s; want both %s and %s", kids, old, rotated)
+	}
+
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "jwks can be cached",
+			path:        "/.well-known/jwks.json",
+			wantStatus:  http.StatusOK,
+			wantKeys:    []string{"keys"},
+			wantHeaders: map[string]string{"Cache-Control": "public, max-age=3600"},
+			wantLookups: 0,
+		},
+		{
+			name:        "tokens from before the rotation still verify",
+			path:        "/api/users/me",
+			headers:     user,
+			wantStatus:  http.StatusOK,
+			wantLookups: 0,
+		},
+		{
+			name:        "the active key can't be deleted",
+			method:      http.MethodDelete,
+			path:        "/admin/jwt-keys/" + rotated,
+			headers:     adminHeaders,
+			wantStatus:  http.StatusConflict,
+			wantLookups: 0,
+		},
+		{
+			name:        "revoke the old key",
+			method:      http.MethodDelete,
+			path:        "/admin/jwt-keys/" + old,
+			headers:     adminHeaders,
+			wantStatus:  http.StatusNoContent,
+			wantLookups: 0,
+		},
+		{
+			name:        "its tokens stop verifying",
+			path:        "/api/users/me",
+			headers:     user,
+			wantStatus:  http.StatusUnauthorized,
+			wantLookups: 0,
+		},
+		{
+			name:        "unknown key",
+			method:      http.MethodDelete,
+			path:        "/admin/jwt-keys/" + old,
+			headers:     adminHeaders,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "users can't rotate",
+			method:      http.MethodPost,
+			path:        "/admin/jwt-keys/rotate",
+			headers:     map[string]string{"X-API-Key": newAPIKey(t, roleUser, "")},
+			wantStatus:  http.StatusForbidden,
+			wantLookups: 0,
+		},
+	})
+
+	if kids := jwksKIDs(t); len(kids) != 1 || kids[0] != rotated {
+		t.Errorf("jwks has %v after revoking the old key, want only %s", kids, rotated)
+	}
+}
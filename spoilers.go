@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// spoilerSafeWords caps a shortened plot that has no early sentence break.
+const spoilerSafeWords = 25
+
+// spoilerSafePlot keeps the first sentence of plot, which on OMDb is
+// nearly always the premise, and at most spoilerSafeWords of it.
+func spoilerSafePlot(plot string) string {
+	if plot == "" || plot == "N/A" {
+		return plot
+	}
+	for i := 0; i < len(plot)-1; i++ {
+		if strings.ContainsRune(".!?", rune(plot[i])) && plot[i+1] == ' ' {
+			plot = plot[:i+1]
+			break
+		}
+	}
+	if words := strings.Fields(plot); len(words) > spoilerSafeWords {
+		plot = strings.Join(words[:spoilerSafeWords], " ") + "…"
+	}
+	return plot
+}
+
+// hasWatched reports whether the user logged a watch of imdbID.
+func hasWatched(userID, imdbID string) bool {
+	for _, w := range userWatches(userID) {
+		if w.IMDBID == imdbID {
+			return true
+		}
+	}
+	return false
+}
+
+// watchedUpTo returns the furthest episode of the series the user has
+// recorded progress to or logged a watch of, or zeros if neither.
+func watchedUpTo(userID, seriesID string) (season, episode int) {
+	if p, ok := loadProgress(userID, seriesID); ok {
+		season, episode = p.Season, p.Episode
+	}
+	for _, w := range userWatches(userID) {
+		if w.SeriesID != seriesID {
+			continue
+		}
+		if episodeAfter(w.Season, w.Episode, season, episode) {
+			season, episode = w.Season, w.Episode
+		}
+	}
+	return season, episode
+}
+
+func isFollowing(userID, seriesID string) bool {
+	found, _ := store.Get(followsBucket, followKey(userID, seriesID), &Follow{})
+	return found
+}
+
+// applySpoilerFree rewrites resp["Plot"] for ?spoiler_free=true. Titles
+// the caller has watched keep their plot; others get the premise only.
+// Episodes of a followed series past the caller's progress lose the plot
+// altogether. Anonymous callers have watched nothing.
+func applySpoilerFree(c *gin.Context, resp gin.H, movie *MovieResponse) {
+	var userID string
+	if u := currentPrincipal(c).User; u != nil {
+		userID = u.ID
+	}
+	if userID != "" && hasWatched(userID, movie.IMDBID) {
+		return
+	}
+	if userID != "" && movie.Type == "episode" && isFollowing(userID, movie.SeriesID) {
+		season, _ := strconv.Atoi(movie.Season)
+		episode, _ := strconv.Atoi(movie.Episode)
+		upToSeason, upToEpisode := watchedUpTo(userID, movie.SeriesID)
+		if episodeAfter(season, episode, upToSeason, upToEpisode) {
+			resp["Plot"] = ""
+			resp["plot_hidden"] = true
+		}
+		return
+	}
+	if plot, _ := resp["Plot"].(string); plot != "" {
+		if short := spoilerSafePlot(plot); short != plot {
+			resp["Plot"] = short
+			resp["plot_truncated"] = true
+		}
+	}
+}
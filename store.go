@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store is the service's own persistent state (as opposed to the response
+// cache, which only holds upstream bodies). Values are JSON-encoded and
+// grouped into buckets, which are created on first write.
+type Store interface {
+	Put(bucket, key string, v interface{}) error
+	// PutAll writes every value in a single transaction.
+	PutAll(bucket string, values map[string]interface{}) error
+	// Get decodes the value stored under key into v and reports whether
+	// it was found.
+	Get(bucket, key string, v interface{}) (bool, error)
+	// Modify decodes the value under key into v, calls fn, and writes v
+	// back atomically, so concurrent read-modify-writes don't race. It
+	// reports false without calling fn when the key doesn't exist.
+	Modify(bucket, key string, v interface{}, fn func() error) (bool, error)
+	Delete(bucket, key string) error
+	// ForEach calls fn with every raw value in bucket in key order.
+	// Returning errStopIteration from fn ends the walk early without an
+	// error.
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+	// ForEachPrefix is ForEach restricted to keys starting with prefix.
+	// Keys of per-user records are "<userID>/<recordID>" so this lists one
+	// user's data without a scan of the whole bucket.
+	ForEachPrefix(bucket, prefix string, fn func(key string, value []byte) error) error
+	// Shared reports whether other replicas see the same data.
+	Shared() bool
+	Close() error
+}
+
+// openStore opens the store at location: a Redis URL, which replicas
+// can share, or the path of a bbolt file, which only one process can
+// open.
+func openStore(location string) (Store, error) {
+	if strings.HasPrefix(location, "redis://") || strings.HasPrefix(location, "rediss://") {
+		return openRedisStore(location)
+	}
+	db, err := openBoltFile(location)
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+// openBoltFile opens a bbolt file, which locks it to this process.
+func openBoltFile(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout()})
+	if err == nil {
+		holdsFileLocks.Store(true)
+	}
+	return db, err
+}
+
+// boltStore keeps the store in a local bbolt file.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func (s *boltStore) Shared() bool { return false }
+
+func (s *boltStore) Put(bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+// PutAll writes every value in a single transaction.
+func (s *boltStore) PutAll(bucket string, values map[string]interface{}) error {
+	encoded := make(map[string][]byte, len(values))
+	for k, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		encoded[k] = data
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		for k, data := range encoded {
+			if err := b.Put([]byte(k), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Get decodes the value stored under key into v and reports whether it
+// was found.
+func (s *boltStore) Get(bucket, key string, v interface{}) (bool, error) {
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		// bbolt's slices are only valid inside the transaction.
+		data := b.Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, v)
+	})
+	return found, err
+}
+
+// Modify decodes the value under key into v, calls fn, and writes v back,
+// all in one transaction so concurrent read-modify-writes don't race. It
+// reports false without calling fn when the key doesn't exist.
+func (s *boltStore) Modify(bucket, key string, v interface{}, fn func() error) (bool, error) {
+	found := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		data := b.Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = true
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+	return found, err
+}
+
+func (s *boltStore) Delete(bucket, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+// ForEach calls fn with every raw value in bucket in key order. Returning
+// errStopIteration from fn ends the walk early without an error.
+func (s *boltStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+	if errors.Is(err, errStopIteration) {
+		return nil
+	}
+	return err
+}
+
+// ForEachPrefix is ForEach restricted to keys starting with prefix. Keys
+// of per-user records are "<userID>/<recordID>" so this lists one user's
+// data without a scan of the whole bucket.
+func (s *boltStore) ForEachPrefix(bucket, prefix string, fn func(key string, value []byte) error) error {
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			if err := fn(string(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errStopIteration) {
+		return nil
+	}
+	return err
+}
+
+var errStopIteration = errors.New("stop iteration")
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const summariesBucket = "summaries"
+
+var summaryStyles = map[string]string{
+	"spoiler_free": "Write a two or three sentence synopsis that sets up the premise without revealing twists, deaths, or the ending.",
+	"one_line":     "Write a single-sentence logline of at most 25 words. Do not reveal the ending.",
+	"detailed":     "Write a detailed synopsis of one or two paragraphs. Spoilers are allowed.",
+}
+
+type movieSummary struct {
+	IMDBID      string    `json:"imdbID"`
+	Title       string    `json:"Title"`
+	Style       string    `json:"style"`
+	Summary     string    `json:"summary"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+func getMovieSummary(c *gin.Context) {
+	if llm == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "summaries are disabled; set LLM_PROVIDER"})
+		return
+	}
+	var q summaryQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	id, style := q.ID, q.Style
+	instruction := summaryStyles[style]
+
+	key := id + ":" + style
+	var cached movieSummary
+	if found, _ := store.Get(summariesBucket, key, &cached); found {
+		c.JSON(http.StatusOK, cached)
+		return
+	}
+
+	movie, err := fetchMovie(scopedParams(c, map[string]string{"i": id, "plot": "full"}))
+	if err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+
+	prompt := fmt.Sprintf("Title: %s (%s)\nGenre: %s\nDirector: %s\nPlot: %s",
+		movie.Title, movie.Year, movie.Genre, movie.Director, movie.Plot)
+	text, err := llm.Complete(c.Request.Context(), "You write movie synopses. "+instruction, prompt)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "summary generation failed: " + err.Error()})
+		return
+	}
+
+	summary := movieSummary{
+		IMDBID:      movie.IMDBID,
+		Title:       movie.Title,
+		Style:       style,
+		Summary:     text,
+		GeneratedAt: time.Now().UTC(),
+	}
+	store.Put(summariesBucket, key, summary)
+	c.JSON(http.StatusOK, summary)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"movie-api/omdb"
+)
+
+const titleTagsBucket = "title_tags"
+
+// globalTagOwner owns the tags admins attach for everyone in the default
+// tenant; other tenants' are owned by tenantKey(tenant, globalTagOwner).
+// User IDs are hex, so neither can collide with one.
+const globalTagOwner = "global"
+
+func globalTagOwnerFor(tenant string) string {
+	return tenantKey(tenant, globalTagOwner)
+}
+
+const maxTagsPerTitle = 20
+
+// titleTag is one tag on one title, keyed <owner>/<imdbID>/<tag>. Title
+// and Year are copied in so tag pages need no upstream calls.
+type titleTag struct {
+	Tag       string    `json:"tag"`
+	IMDBID    string    `json:"imdbID"`
+	Title     string    `json:"Title"`
+	Year      string    `json:"Year"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// normalizeTag lowercases a tag and collapses its whitespace, so "Plane
+// Movie" and "plane  movie" are the same tag.
+func normalizeTag(s string) (string, bool) {
+	tag := strings.ToLower(strings.Join(strings.Fields(s), " "))
+	if tag == "" || len(tag) > 40 || strings.Contains(tag, "/") {
+		return "", false
+	}
+	return tag, true
+}
+
+func tagKey(owner, imdbID, tag string) string {
+	return owner + "/" + imdbID + "/" + tag
+}
+
+// ownerTags returns every tag owner has attached, in key order.
+func ownerTags(owner string) []titleTag {
+	tags := []titleTag{}
+	store.ForEachPrefix(titleTagsBucket, owner+"/", func(_ string, value []byte) error {
+		var t titleTag
+		if json.Unmarshal(value, &t) == nil {
+			tags = append(tags, t)
+		}
+		return nil
+	})
+	return tags
+}
+
+// globalTags is what tenant's admins have tagged plus the tags of
+// imported catalog data.
+func globalTags(tenant string) []titleTag {
+	tags := ownerTags(globalTagOwnerFor(tenant))
+	catalogOverrides.mu.RLock()
+	for _, o := range catalogOverrides.m {
+		for _, raw := range o.Tags {
+			if tag, ok := normalizeTag(raw); ok {
+				tags = append(tags, titleTag{Tag: tag, IMDBID: o.IMDBID, Title: o.Title, Year: o.Year, CreatedAt: o.ImportedAt})
+			}
+		}
+	}
+	catalogOverrides.mu.RUnlock()
+	return tags
+}
+
+// visibleTags is every tag the request may see: the global ones and,
+// when signed in, the user's own.
+func visibleTags(c *gin.Context) []titleTag {
+	tags := globalTags(currentPrincipal(c).Tenant)
+	if u := currentPrincipal(c).User; u != nil {
+		tags = append(tags, ownerTags(u.ID)...)
+	}
+	return tags
+}
+
+// taggedIDs returns the set of titles carrying tag.
+func taggedIDs(tags []titleTag, tag string) map[string]bool {
+	ids := map[string]bool{}
+	for _, t := range tags {
+		if t.Tag == tag {
+			ids[t.IMDBID] = true
+		}
+	}
+	return ids
+}
+
+func tagsOn(tags []titleTag, imdbID string) []string {
+	out := []string{}
+	seen := map[string]bool{}
+	for _, t := range tags {
+		if t.IMDBID == imdbID && !seen[t.Tag] {
+			seen[t.Tag] = true
+			out = append(out, t.Tag)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// getTitleTags returns a title's global tags and, when signed in, the
+// user's own.
+func getTitleTags(c *gin.Context) {
+	imdbID := c.Param("id")
+	resp := gin.H{"imdbID": imdbID, "global": tagsOn(globalTags(currentPrincipal(c).Tenant), imdbID)}
+	if u := currentPrincipal(c).User; u != nil {
+		resp["mine"] = tagsOn(ownerTags(u.ID), imdbID)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+type tagRequest struct {
+	Tag string `json:"tag"`
+}
+
+func postUserTag(c *gin.Context) {
+	addTag(c, currentUser(c).ID)
+}
+
+func deleteUserTag(c *gin.Context) {
+	removeTag(c, currentUser(c).ID)
+}
+
+func postGlobalTag(c *gin.Context) {
+	addTag(c, globalTagOwnerFor(currentPrincipal(c).Tenant))
+}
+
+func deleteGlobalTag(c *gin.Context) {
+	removeTag(c, globalTagOwnerFor(currentPrincipal(c).Tenant))
+}
+
+func addTag(c *gin.Context, owner string) {
+	var req tagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide {"tag": "..."}`})
+		return
+	}
+	tag, ok := normalizeTag(req.Tag)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tag must be 1-40 characters and can't contain /"})
+		return
+	}
+	imdbID := c.Param("id")
+	if !omdb.IMDBIDPattern.MatchString(imdbID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid IMDb ID"})
+		return
+	}
+	existing := 0
+	store.ForEachPrefix(titleTagsBucket, owner+"/"+imdbID+"/", func(string, []byte) error {
+		existing++
+		return nil
+	})
+	if existing >= maxTagsPerTitle {
+		c.JSON(http.StatusConflict, gin.H{"error": "this title already has as many tags as it can take"})
+		return
+	}
+	movie, err := fetchMovie(scopedParams(c, map[string]string{"i": imdbID}))
+	if err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+	t := titleTag{Tag: tag, IMDBID: movie.IMDBID, Title: movie.Title, Year: movie.Year, CreatedAt: time.Now().UTC()}
+	if err := store.Put(titleTagsBucket, tagKey(owner, imdbID, tag), t); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save tag"})
+		return
+	}
+	c.JSON(http.StatusCreated, t)
+}
+
+func removeTag(c *gin.Context, owner string) {
+	tag, _ := normalizeTag(c.Param("tag"))
+	key := tagKey(owner, c.Param("id"), tag)
+	var t titleTag
+	if found, _ := store.Get(titleTagsBucket, key, &t); !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tag not found"})
+		return
+	}
+	if err := store.Delete(titleTagsBucket, key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete tag"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type tagCount struct {
+	Tag    string `json:"tag"`
+	Titles int    `json:"titles"`
+}
+
+// countTags counts the distinct titles per tag accepted by keep, most
+// used first.
+func countTags(tags []titleTag, keep func(string) bool) []tagCount {
+	titles := map[string]map[string]bool{}
+	for _, t := range tags {
+		if !keep(t.Tag) {
+			continue
+		}
+		if titles[t.Tag] == nil {
+			titles[t.Tag] = map[string]bool{}
+		}
+		titles[t.Tag][t.IMDBID] = true
+	}
+	out := make([]tagCount, 0, len(titles))
+	for tag, ids := range titles {
+		out = append(out, tagCount{tag, len(ids)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Titles != out[j].Titles {
+			return out[i].Titles > out[j].Titles
+		}
+		return out[i].Tag < out[j].Tag
+	})
+	return out
+}
+
+type tagSuggestQuery struct {
+	Q     string `form:"q" binding:"max=40"`
+	Limit int    `form:"limit,default=10" binding:"min=1,max=50"`
+}
+
+// getTagSuggestions autocompletes tags: those starting with ?q= first,
+// then those with a word starting with it, most used first.
+func getTagSuggestions(c *gin.Context) {
+	var q tagSuggestQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	prefix := strings.ToLower(strings.Join(strings.Fields(q.Q), " "))
+	counts := countTags(visibleTags(c), func(tag string) bool {
+		return prefix == "" || strings.HasPrefix(tag, prefix) || strings.Contains(tag, " "+prefix)
+	})
+	sort.SliceStable(counts, func(i, j int) bool {
+		return strings.HasPrefix(counts[i].Tag, prefix) && !strings.HasPrefix(counts[j].Tag, prefix)
+	})
+	counts = counts[:min(q.Limit, len(counts))]
+	respondList(c, http.StatusOK, counts, listMeta{Total: len(counts)}, gin.H{"tags": counts})
+}
+
+func getMyTags(c *gin.Context) {
+	counts := countTags(ownerTags(currentUser(c).ID), func(string) bool { return true })
+	respondList(c, http.StatusOK, counts, listMeta{Total: len(counts)}, gin.H{"tags": counts})
+}
+
+// getTaggedTitles lists the titles carrying a tag, global or the user's
+// own, with details from the catalog where it has them.
+func getTaggedTitles(c *gin.Context) {
+	var q numberedPageQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	tag, ok := normalizeTag(c.Param("tag"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tag"})
+		return
+	}
+	byID := map[string]gin.H{}
+	add := func(tags []titleTag, flag string) {
+		for _, t := range tags {
+			if t.Tag != tag {
+				continue
+			}
+			entry, seen := byID[t.IMDBID]
+			if !seen {
+				entry = gin.H{"imdbID": t.IMDBID, "Title": t.Title, "Year": t.Year, "global": false, "mine": false}
+				if m, ok := catalog.Get(t.IMDBID); ok {
+					entry["Title"], entry["Year"], entry["Type"] = m.Title, m.Year, m.Type
+					entry["Poster"], entry["imdbRating"] = m.Poster, m.IMDBRating
+				}
+				byID[t.IMDBID] = entry
+			}
+			entry[flag] = true
+		}
+	}
+	add(globalTags(currentPrincipal(c).Tenant), "global")
+	if u := currentPrincipal(c).User; u != nil {
+		add(ownerTags(u.ID), "mine")
+	}
+	items := make([]gin.H, 0, len(byID))
+	for _, entry := range byID {
+		items = append(items, entry)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i]["imdbID"].(string) < items[j]["imdbID"].(string) })
+	from := min((q.Page-1)*q.Limit, len(items))
+	page := items[from:min(from+q.Limit, len(items))]
+	respondList(c, http.StatusOK, page, listMeta{Total: len(items), Page: q.Page}, gin.H{"tag": tag, "total": len(items), "page": q.Page, "items": page})
+}
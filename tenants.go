@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const tenantsBucket = "tenants"
+
+// tenantParam carries the tenant through OMDb params so that it becomes
+// part of the cache key. It is never sent upstream.
+const tenantParam = "_tenant"
+
+// Tenant is an app sharing this deployment. Its users and client keys are
+// invisible to other tenants and its upstream responses are cached
+// separately. The default tenant has an empty ID and owns everything that
+// predates tenancy.
+type Tenant struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// OMDbAPIKey replaces the deployment-wide key for this tenant's
+	// upstream calls when set.
+	OMDbAPIKey string `json:"omdb_api_key,omitempty"`
+	// Quota caps the combined requests of all the tenant's users and keys.
+	Quota     QuotaLimits `json:"quota"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+func (t Tenant) public() gin.H {
+	return gin.H{
+		"id":               t.ID,
+		"name":             t.Name,
+		"has_omdb_api_key": t.OMDbAPIKey != "",
+		"quota":            t.Quota,
+		"created_at":       t.CreatedAt,
+	}
+}
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,31}$`)
+
+func loadTenant(id string) (*Tenant, bool) {
+	var t Tenant
+	found, err := store.Get(tenantsBucket, id, &t)
+	if err != nil || !found {
+		return nil, false
+	}
+	return &t, true
+}
+
+// tenantKey scopes a store key to a tenant, leaving default-tenant keys
+// unchanged.
+func tenantKey(tenantID, key string) string {
+	if tenantID == "" {
+		return key
+	}
+	return tenantID + "/" + key
+}
+
+// scopedParams tags OMDb params with the caller's tenant and with the
+// request, so the lookup's cost is attributed to it.
+func scopedParams(c *gin.Context, params map[string]string) map[string]string {
+	if tenant := currentPrincipal(c).Tenant; tenant != "" {
+		params[tenantParam] = tenant
+	}
+	if id := c.GetString("cost.id"); id != "" {
+		params[costParam] = id
+	}
+	return params
+}
+
+// omdbKeyFor returns the OMDb key to use for a tenant's upstream calls.
+func omdbKeyFor(tenantID string) string {
+	if tenantID != "" {
+		if t, ok := loadTenant(tenantID); ok && t.OMDbAPIKey != "" {
+			return t.OMDbAPIKey
+		}
+	}
+	return omdbAPIKey()
+}
+
+type tenantRequest struct {
+	ID         string       `json:"id"`
+	Name       *string      `json:"name"`
+	OMDbAPIKey *string      `json:"omdb_api_key"`
+	Quota      *QuotaLimits `json:"quota"`
+}
+
+// tenantIDs lists every tenant's ID.
+func tenantIDs() []string {
+	ids := []string{}
+	store.ForEach(tenantsBucket, func(id string, _ []byte) error {
+		ids = append(ids, id)
+		return nil
+	})
+	return ids
+}
+
+func getTenants(c *gin.Context) {
+	tenants := []gin.H{}
+	store.ForEach(tenantsBucket, func(_ string, value []byte) error {
+		var t Tenant
+		if json.Unmarshal(value, &t) == nil {
+			tenants = append(tenants, t.public())
+		}
+		return nil
+	})
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i]["id"].(string) < tenants[j]["id"].(string) })
+	c.JSON(http.StatusOK, tenants)
+}
+
+func postTenant(c *gin.Context) {
+	var req tenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !tenantIDPattern.MatchString(req.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide {"id": "lowercase-slug", "name": "..."}`})
+		return
+	}
+	if _, exists := loadTenant(req.ID); exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "tenant already exists"})
+		return
+	}
+	t := &Tenant{ID: req.ID, Name: req.ID, CreatedAt: time.Now().UTC()}
+	applyTenantRequest(t, req)
+	if err := store.Put(tenantsBucket, t.ID, t); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save tenant"})
+		return
+	}
+	c.JSON(http.StatusCreated, t.public())
+}
+
+func patchTenant(c *gin.Context) {
+	var req tenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var t Tenant
+	found, err := store.Modify(tenantsBucket, c.Param("id"), &t, func() error {
+		applyTenantRequest(&t, req)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save tenant"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
+		return
+	}
+	c.JSON(http.StatusOK, t.public())
+}
+
+func applyTenantRequest(t *Tenant, req tenantRequest) {
+	if req.Name != nil && strings.TrimSpace(*req.Name) != "" {
+		t.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.OMDbAPIKey != nil {
+		t.OMDbAPIKey = *req.OMDbAPIKey
+	}
+	if req.Quota != nil {
+		t.Quota = *req.Quota
+	}
+}
+
+// deleteTenant removes the tenant and revokes its client keys. Its users
+// are kept but can no longer be reached through a tenant key.
+func deleteTenant(c *gin.Context) {
+	id := c.Param("id")
+	if _, ok := loadTenant(id); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
+		return
+	}
+	var keys []APIKey
+	store.ForEach(apiKeysBucket, func(key string, value []byte) error {
+		var k APIKey
+		if json.Unmarshal(value, &k) == nil && k.TenantID == id {
+			k.Hash = key
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	for _, k := range keys {
+		store.Delete(apiKeysBucket, k.Hash)
+		store.Delete(apiKeyIDsBucket, k.ID)
+	}
+	if err := store.Delete(tenantsBucket, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete tenant"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	tenant := newTenant(t)
+	tenantKey := map[string]string{"X-API-Key": newAPIKey(t, roleUser, tenant)}
+	creds := `{"email":"` + randomHex(6) + `@example.com","password":"correct horse"}`
+	w := request(http.MethodPost, "/api/auth/register", tenantKey, creds)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register in tenant: status %d; body %s", w.Code, w.Body)
+	}
+	got := decodeObject(t, w)
+	tenantUser := map[string]string{"Authorization": "Bearer " + got["token"].(string)}
+	tenantComment := newComment(t, tenantUser)
+	defaultUser, _ := newUser(t)
+	defaultComment := newComment(t, defaultUser)
+
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "a tenant user can't sign in outside the tenant",
+			method:      http.MethodPost,
+			path:        "/api/auth/login",
+			body:        creds,
+			wantStatus:  http.StatusUnauthorized,
+			wantLookups: 0,
+		},
+		{
+			name:        "the email is free in the default tenant",
+			method:      http.MethodPost,
+			path:        "/api/auth/register",
+			body:        creds,
+			wantStatus:  http.StatusCreated,
+			wantLookups: 0,
+		},
+		{
+			name:        "sign in within the tenant",
+			method:      http.MethodPost,
+			path:        "/api/auth/login",
+			headers:     tenantKey,
+			body:        creds,
+			wantStatus:  http.StatusOK,
+			wantKeys:    []string{"token"},
+			wantLookups: 0,
+		},
+		{
+			name:        "another tenant's comment can't be deleted",
+			method:      http.MethodDelete,
+			path:        "/api/movies/tt0133093/comments/" + tenantComment,
+			headers:     defaultUser,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "another tenant's comment can't be reported",
+			method:      http.MethodPost,
+			path:        "/api/movies/tt0133093/comments/" + defaultComment + "/report",
+			headers:     tenantUser,
+			body:        `{"reason":"spam"}`,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+	})
+
+	for _, tc := range []struct {
+		name      string
+		headers   map[string]string
+		want, not string
+	}{
+		{"tenant", tenantKey, tenantComment, defaultComment},
+		{"default tenant", nil, defaultComment, tenantComment},
+	} {
+		w := request(http.MethodGet, "/api/movies/tt0133093/comments", tc.headers, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s comments: status %d; body %s", tc.name, w.Code, w.Body)
+		}
+		if body := w.Body.String(); !strings.Contains(body, tc.want) || strings.Contains(body, tc.not) {
+			t.Errorf("%s comments: want %s and not %s in %s", tc.name, tc.want, tc.not, body)
+		}
+	}
+
+	w = request(http.MethodGet, "/admin/users?tenant="+tenant, adminHeaders, "")
+	var users []map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+		t.Fatalf("tenant users: %v; body %s", err, w.Body)
+	}
+	if len(users) != 1 || users[0]["tenant_id"] != tenant {
+		t.Errorf("tenant users = %v, want only the tenant's user", users)
+	}
+}
+
+func TestTenantCache(t *testing.T) {
+	tenantKey := map[string]string{"X-API-Key": newAPIKey(t, roleUser, newTenant(t))}
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "default tenant lookup",
+			path:        "/api/movie?id=tt0113277",
+			wantStatus:  http.StatusOK,
+			wantLookups: 1,
+		},
+		{
+			name:        "the tenant doesn't share the default tenant's cache",
+			path:        "/api/movie?id=tt0113277",
+			headers:     tenantKey,
+			wantStatus:  http.StatusOK,
+			wantLookups: 1,
+		},
+		{
+			name:        "the tenant has its own cache",
+			path:        "/api/movie?id=tt0113277",
+			headers:     tenantKey,
+			wantStatus:  http.StatusOK,
+			wantLookups: 0,
+		},
+	})
+}
+
+func TestTenantHandlers(t *testing.T) {
+	tenant := newTenant(t)
+	key := map[string]string{"X-API-Key": newAPIKey(t, roleUser, tenant)}
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "invalid ID",
+			method:      http.MethodPost,
+			path:        "/admin/tenants",
+			headers:     adminHeaders,
+			body:        `{"id":"Not A Slug"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantLookups: 0,
+		},
+		{
+			name:        "duplicate ID",
+			method:      http.MethodPost,
+			path:        "/admin/tenants",
+			headers:     adminHeaders,
+			body:        `{"id":"` + tenant + `"}`,
+			wantStatus:  http.StatusConflict,
+			wantLookups: 0,
+		},
+		{
+			name:        "set an OMDb key",
+			method:      http.MethodPatch,
+			path:        "/admin/tenants/" + tenant,
+			headers:     adminHeaders,
+			body:        `{"name":"Renamed","omdb_api_key":"tenant-secret"}`,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"name": "Renamed", "has_omdb_api_key": "true"},
+			wantNoKeys:  []string{"omdb_api_key"},
+			wantLookups: 0,
+		},
+		{
+			name:        "patch an unknown tenant",
+			method:      http.MethodPatch,
+			path:        "/admin/tenants/nowhere",
+			headers:     adminHeaders,
+			body:        `{"name":"Nowhere"}`,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "tenant keys can't reach admin routes",
+			path:        "/admin/tenants",
+			headers:     map[string]string{"X-API-Key": newAPIKey(t, roleModerator, tenant)},
+			wantStatus:  http.StatusForbidden,
+			wantLookups: 0,
+		},
+		{
+			name:        "delete the tenant",
+			method:      http.MethodDelete,
+			path:        "/admin/tenants/" + tenant,
+			headers:     adminHeaders,
+			wantStatus:  http.StatusNoContent,
+			wantLookups: 0,
+		},
+		{
+			name:        "its keys are revoked",
+			path:        "/api/usage",
+			headers:     key,
+			wantStatus:  http.StatusUnauthorized,
+			wantLookups: 0,
+		},
+		{
+			name:        "delete it again",
+			method:      http.MethodDelete,
+			path:        "/admin/tenants/" + tenant,
+			headers:     adminHeaders,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+	})
+}
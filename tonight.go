@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Mood maps a ?mood= onto genres: titles with any of Genres suit it, and
+// titles with any of Avoid are left out.
+type Mood struct {
+	Genres []string `json:"genres"`
+	Avoid  []string `json:"avoid,omitempty"`
+}
+
+func defaultMoods() map[string]Mood {
+	return map[string]Mood{
+		"light":      {Genres: []string{"Comedy", "Animation", "Family", "Musical", "Romance"}, Avoid: []string{"Horror", "War"}},
+		"dark":       {Genres: []string{"Thriller", "Horror", "Crime", "Mystery", "Film-Noir"}},
+		"intense":    {Genres: []string{"Action", "Thriller", "War", "Adventure", "Sci-Fi"}},
+		"thoughtful": {Genres: []string{"Drama", "Biography", "History", "Documentary"}},
+		"epic":       {Genres: []string{"Adventure", "Fantasy", "History", "War", "Western"}},
+	}
+}
+
+func moodNames(moods map[string]Mood) []string {
+	names := make([]string, 0, len(moods))
+	for name := range moods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// moodMatch is the share of the mood's genres a title has, or -1 if it
+// has a genre the mood avoids.
+func moodMatch(mood Mood, genre string) float64 {
+	has := map[string]bool{}
+	for _, g := range strings.Split(genre, ",") {
+		has[strings.ToLower(strings.TrimSpace(g))] = true
+	}
+	for _, g := range mood.Avoid {
+		if has[strings.ToLower(g)] {
+			return -1
+		}
+	}
+	matched := 0
+	for _, g := range mood.Genres {
+		if has[strings.ToLower(g)] {
+			matched++
+		}
+	}
+	if len(mood.Genres) == 0 {
+		return 0
+	}
+	return float64(matched) / float64(len(mood.Genres))
+}
+
+type tonightPick struct {
+	IMDBID     string   `json:"imdbID"`
+	Title      string   `json:"Title"`
+	Year       string   `json:"Year"`
+	Genre      string   `json:"Genre"`
+	Runtime    int      `json:"runtime_minutes"`
+	IMDBRating string   `json:"imdbRating"`
+	Score      float64  `json:"score"`
+	Reasons    []string `json:"reasons"`
+}
+
+// getTonight picks a few watchlist titles for tonight: ones that fit in
+// ?minutes=, suit ?mood= and rate at least ?min_rating=. They are ranked
+// by weighted rating, plus up to two points for matching the mood and
+// one for using the evening well. Titles the catalog doesn't know yet
+// are fetched.
+func getTonight(c *gin.Context) {
+	var q tonightQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	moods := cfg().Tonight.Moods
+	mood, ok := moods[q.Mood]
+	if q.Mood != "" && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mood must be one of " + strings.Join(moodNames(moods), ", ")})
+		return
+	}
+	l := watchlistFor(c)
+	if l == nil {
+		return
+	}
+
+	watched := map[string]bool{}
+	for _, w := range userWatches(currentUser(c).ID) {
+		watched[w.IMDBID] = true
+	}
+	picks := []tonightPick{}
+	for _, item := range l.Items {
+		if watched[item.IMDBID] {
+			continue
+		}
+		m, ok := catalog.Get(item.IMDBID)
+		if !ok {
+			fetched, err := fetchMovie(scopedParams(c, map[string]string{"i": item.IMDBID}))
+			if errors.Is(err, errRequestLimit) {
+				continue
+			}
+			if err != nil {
+				respondUpstreamError(c, err)
+				return
+			}
+			m = fetched
+		}
+		runtime := runtimeMinutes(m.Runtime)
+		rating, rated := parseRating(m.IMDBRating)
+		p := tonightPick{IMDBID: m.IMDBID, Title: m.Title, Year: m.Year, Genre: m.Genre, Runtime: runtime, IMDBRating: m.IMDBRating}
+
+		if q.Minutes > 0 {
+			if runtime == 0 || runtime > q.Minutes {
+				continue
+			}
+			fit := float64(runtime) / float64(q.Minutes)
+			p.Score += fit
+			p.Reasons = append(p.Reasons, fmt.Sprintf("%d of your %d minutes", runtime, q.Minutes))
+		}
+		if q.MinRating > 0 && (!rated || rating < q.MinRating) {
+			continue
+		}
+		if q.Mood != "" {
+			match := moodMatch(mood, m.Genre)
+			if match <= 0 {
+				continue
+			}
+			p.Score += 2 * match
+			p.Reasons = append(p.Reasons, "fits the "+q.Mood+" mood")
+		}
+		if rated {
+			p.Score += weightedRating(rating, parseVotes(m.IMDBVotes))
+			p.Reasons = append(p.Reasons, "rated "+m.IMDBRating+" on IMDb")
+		} else {
+			p.Score += cfg().Ratings.PriorMean
+		}
+		p.Score = roundTo(p.Score, 3)
+		picks = append(picks, p)
+	}
+
+	sort.Slice(picks, func(i, j int) bool {
+		if picks[i].Score != picks[j].Score {
+			return picks[i].Score > picks[j].Score
+		}
+		return picks[i].IMDBID < picks[j].IMDBID
+	})
+	candidates := len(picks)
+	if len(picks) > q.Limit {
+		picks = picks[:q.Limit]
+	}
+	respondList(c, http.StatusOK, picks, listMeta{Total: candidates}, gin.H{
+		"minutes":    q.Minutes,
+		"mood":       q.Mood,
+		"candidates": candidates,
+		"picks":      picks,
+	})
+}
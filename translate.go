@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const plotTranslationsBucket = "plot_translations"
+
+// Translator translates text into a target language given as a BCP 47
+// tag such as "de" or "pt-BR".
+type Translator interface {
+	Translate(ctx context.Context, text, target string) (string, error)
+}
+
+var translator Translator
+
+// deepLTranslator talks to the DeepL API (free or pro endpoint).
+type deepLTranslator struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func (d *deepLTranslator) Translate(ctx context.Context, text, target string) (string, error) {
+	form := url.Values{"text": {text}, "target_lang": {strings.ToUpper(target)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+d.apiKey)
+	var out struct {
+		Translations []struct {
+			Text string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := doTranslation(d.client, req, &out); err != nil {
+		return "", err
+	}
+	if len(out.Translations) == 0 {
+		return "", errors.New("translation provider returned nothing")
+	}
+	return out.Translations[0].Text, nil
+}
+
+// googleTranslator talks to the Google Cloud Translation v2 API.
+type googleTranslator struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func (g *googleTranslator) Translate(ctx context.Context, text, target string) (string, error) {
+	body, _ := json.Marshal(map[string]string{"q": text, "target": target, "format": "text"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.url+"?key="+url.QueryEscape(g.apiKey), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var out struct {
+		Data struct {
+			Translations []struct {
+				TranslatedText string `json:"translatedText"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+	if err := doTranslation(g.client, req, &out); err != nil {
+		return "", err
+	}
+	if len(out.Data.Translations) == 0 {
+		return "", errors.New("translation provider returned nothing")
+	}
+	return out.Data.Translations[0].TranslatedText, nil
+}
+
+func doTranslation(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return withoutURL(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("translation provider returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// llmTranslator uses the configured language model, e.g. a local one.
+type llmTranslator struct {
+	llm LLM
+}
+
+func (l *llmTranslator) Translate(ctx context.Context, text, target string) (string, error) {
+	system := "Translate the user's text into the language with BCP 47 tag " + target +
+		". Reply with the translation only."
+	out, err := l.llm.Complete(ctx, system, text)
+	return strings.TrimSpace(out), err
+}
+
+// newTranslatorFromEnv returns nil unless TRANSLATION_PROVIDER is set,
+// which leaves plots untranslated. The llm provider needs LLM_PROVIDER.
+func newTranslatorFromEnv() Translator {
+	client := &http.Client{Timeout: 20 * time.Second}
+	switch envString("TRANSLATION_PROVIDER", "") {
+	case "deepl":
+		return &deepLTranslator{
+			url:    envString("TRANSLATION_URL", "https://api-free.deepl.com/v2/translate"),
+			apiKey: envString("TRANSLATION_API_KEY", ""),
+			client: client,
+		}
+	case "google":
+		return &googleTranslator{
+			url:    envString("TRANSLATION_URL", "https://translation.googleapis.com/language/translate/v2"),
+			apiKey: envString("TRANSLATION_API_KEY", ""),
+			client: client,
+		}
+	case "llm":
+		if llm != nil {
+			return &llmTranslator{llm: llm}
+		}
+		slog.Warn("TRANSLATION_PROVIDER=llm needs LLM_PROVIDER; plot translation is disabled")
+	}
+	return nil
+}
+
+type plotTranslation struct {
+	Original   string    `json:"original"`
+	Translated string    `json:"translated"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// translatePlot returns the plot of a title in lang, from the store when
+// it has been translated before. A cached translation of a plot that has
+// since changed upstream is redone.
+func translatePlot(ctx context.Context, imdbID, plot, lang string) (string, error) {
+	key := imdbID + ":" + strings.ToLower(lang)
+	var cached plotTranslation
+	if found, _ := store.Get(plotTranslationsBucket, key, &cached); found && cached.Original == plot {
+		return cached.Translated, nil
+	}
+	text, err := translator.Translate(ctx, plot, lang)
+	if err != nil {
+		return "", err
+	}
+	store.Put(plotTranslationsBucket, key, plotTranslation{Original: plot, Translated: text, CreatedAt: time.Now().UTC()})
+	return text, nil
+}
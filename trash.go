@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const trashBucket = "trash"
+
+const (
+	trashList    = "list"
+	trashComment = "comment"
+)
+
+// TrashConfig sets how long deleted lists and comments can be restored.
+type TrashConfig struct {
+	Retention Duration `json:"retention"`
+}
+
+// trashEntry is something a user deleted. A trashed list lives only here,
+// in Data, until it is restored or purged. A trashed comment stays in
+// place with status "deleted" so replies keep their context; purging it
+// erases its body for good.
+type trashEntry struct {
+	Kind      string          `json:"kind"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	IMDBID    string          `json:"imdbID,omitempty"`
+	TenantID  string          `json:"tenant_id,omitempty"`
+	DeletedAt time.Time       `json:"deleted_at"`
+	ExpiresAt time.Time       `json:"expires_at"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+func trashKey(userID, kind, id string) string {
+	return userID + "/" + kind + "/" + id
+}
+
+func moveToTrash(userID string, e trashEntry) error {
+	e.DeletedAt = time.Now().UTC()
+	e.ExpiresAt = e.DeletedAt.Add(time.Duration(cfg().Trash.Retention))
+	return store.Put(trashBucket, trashKey(userID, e.Kind, e.ID), e)
+}
+
+func moveListToTrash(l *List) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if err := moveToTrash(l.OwnerID, trashEntry{Kind: trashList, ID: l.ID, Name: l.Name, Data: data}); err != nil {
+		return err
+	}
+	return store.Delete(listsBucket, l.ID)
+}
+
+func moveCommentToTrash(cm *Comment) error {
+	name := cm.Body
+	if r := []rune(name); len(r) > 80 {
+		name = string(r[:80]) + "…"
+	}
+	return moveToTrash(cm.UserID, trashEntry{Kind: trashComment, ID: cm.ID, Name: name, IMDBID: cm.IMDBID, TenantID: cm.TenantID})
+}
+
+func getTrash(c *gin.Context) {
+	entries := []trashEntry{}
+	store.ForEachPrefix(trashBucket, currentUser(c).ID+"/", func(_ string, value []byte) error {
+		var e trashEntry
+		if json.Unmarshal(value, &e) == nil {
+			e.Data = nil
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].DeletedAt.After(entries[j].DeletedAt) })
+	respondList(c, http.StatusOK, entries, listMeta{Total: len(entries)}, entries)
+}
+
+// trashedEntry loads the :kind/:id entry of the caller's trash. It
+// writes the error response itself and returns nil on failure.
+func trashedEntry(c *gin.Context) (*trashEntry, string) {
+	key := trashKey(currentUser(c).ID, c.Param("kind"), c.Param("id"))
+	var e trashEntry
+	found, err := store.Get(trashBucket, key, &e)
+	if err != nil || !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not in the trash"})
+		return nil, ""
+	}
+	return &e, key
+}
+
+func postTrashRestore(c *gin.Context) {
+	e, key := trashedEntry(c)
+	if e == nil {
+		return
+	}
+	var restored interface{}
+	switch e.Kind {
+	case trashList:
+		var l List
+		if err := json.Unmarshal(e.Data, &l); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read trashed list"})
+			return
+		}
+		if _, exists := loadList(l.ID); exists {
+			c.JSON(http.StatusConflict, gin.H{"error": "a list with this ID exists again"})
+			return
+		}
+		if err := saveList(&l); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not restore list"})
+			return
+		}
+		restored = l
+	case trashComment:
+		cm, err := updateComment(e.TenantID, e.IMDBID, e.ID, func(cm *Comment) error {
+			if cm.Status != commentDeleted || cm.DeletedBy == deletedByModerator {
+				return errCommentNotFound
+			}
+			cm.Status, cm.DeletedBy = commentVisible, ""
+			return nil
+		})
+		if errors.Is(err, errCommentNotFound) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the comment was changed by a moderator and can't be restored"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not restore comment"})
+			return
+		}
+		restored = cm
+	}
+	store.Delete(trashBucket, key)
+	c.JSON(http.StatusOK, restored)
+}
+
+// deleteTrashEntry purges one entry right away instead of waiting for
+// the retention window.
+func deleteTrashEntry(c *gin.Context) {
+	e, key := trashedEntry(c)
+	if e == nil {
+		return
+	}
+	if err := purgeTrashEntry(key, e); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not purge"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func purgeTrashEntry(key string, e *trashEntry) error {
+	if e.Kind == trashComment {
+		if cm, ok := loadComment(e.TenantID, e.IMDBID, e.ID); ok && cm.Status == commentDeleted {
+			cm.Body, cm.AuthorName = "", ""
+			if err := store.Put(commentsBucket, commentKey(cm.TenantID, cm.IMDBID, cm.ID), cm); err != nil {
+				return err
+			}
+		}
+	}
+	return store.Delete(trashBucket, key)
+}
+
+// purgeTrash periodically purges entries past their retention window.
+func purgeTrash(every time.Duration) {
+	for range time.Tick(every) {
+		leaderOnly("trash_purge", purgeExpiredTrash)
+	}
+}
+
+func purgeExpiredTrash() {
+	now := time.Now()
+	expired := map[string]*trashEntry{}
+	store.ForEach(trashBucket, func(key string, value []byte) error {
+		var e trashEntry
+		if json.Unmarshal(value, &e) == nil && now.After(e.ExpiresAt) {
+			expired[key] = &e
+		}
+		return nil
+	})
+	for key, e := range expired {
+		if err := purgeTrashEntry(key, e); err != nil {
+			slog.Warn("trash purge failed", "entry", key, "error", err)
+		}
+	}
+	if len(expired) > 0 {
+		slog.Info("purged trash", "entries", len(expired))
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTrashLists(t *testing.T) {
+	user, _ := newUser(t)
+	other, _ := newUser(t)
+	restored, purged := newList(t, user), newList(t, user)
+	for _, id := range []string{restored, purged} {
+		if w := request(http.MethodDelete, "/api/lists/"+id, user, ""); w.Code != http.StatusNoContent {
+			t.Fatalf("delete list: status %d; body %s", w.Code, w.Body)
+		}
+	}
+	w := request(http.MethodGet, "/api/users/me/trash", user, "")
+	if body := w.Body.String(); !strings.Contains(body, restored) || !strings.Contains(body, purged) {
+		t.Fatalf("trash doesn't hold the deleted lists: %s", body)
+	}
+	if strings.Contains(w.Body.String(), `"data":{`) {
+		t.Errorf("trash listing includes the trashed data: %s", w.Body)
+	}
+
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "a trashed list is gone",
+			path:        "/api/lists/" + restored,
+			headers:     user,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "someone else's trash",
+			method:      http.MethodPost,
+			path:        "/api/users/me/trash/list/" + restored + "/restore",
+			headers:     other,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "restore",
+			method:      http.MethodPost,
+			path:        "/api/users/me/trash/list/" + restored + "/restore",
+			headers:     user,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"id": restored, "name": "Crime"},
+			wantLookups: 0,
+		},
+		{
+			name:        "the restored list is back",
+			path:        "/api/lists/" + restored,
+			headers:     user,
+			wantStatus:  http.StatusOK,
+			wantLookups: -1,
+		},
+		{
+			name:        "restore twice",
+			method:      http.MethodPost,
+			path:        "/api/users/me/trash/list/" + restored + "/restore",
+			headers:     user,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+		{
+			name:        "purge",
+			method:      http.MethodDelete,
+			path:        "/api/users/me/trash/list/" + purged,
+			headers:     user,
+			wantStatus:  http.StatusNoContent,
+			wantLookups: 0,
+		},
+		{
+			name:        "a purged list can't be restored",
+			method:      http.MethodPost,
+			path:        "/api/users/me/trash/list/" + purged + "/restore",
+			headers:     user,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+	})
+}
+
+func TestTrashComments(t *testing.T) {
+	user, _ := newUser(t)
+	restored, purged := newComment(t, user), newComment(t, user)
+	for _, id := range []string{restored, purged} {
+		if w := request(http.MethodDelete, "/api/movies/tt0133093/comments/"+id, user, ""); w.Code != http.StatusOK {
+			t.Fatalf("delete comment: status %d; body %s", w.Code, w.Body)
+		}
+	}
+
+	runHandlerTests(t, []handlerTest{
+		{
+			name:        "restore",
+			method:      http.MethodPost,
+			path:        "/api/users/me/trash/comment/" + restored + "/restore",
+			headers:     user,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"id": restored, "status": commentVisible, "body": "Whoa."},
+			wantNoKeys:  []string{"deleted_by"},
+			wantLookups: 0,
+		},
+		{
+			name:        "the restored comment can be deleted again",
+			method:      http.MethodDelete,
+			path:        "/api/movies/tt0133093/comments/" + restored,
+			headers:     user,
+			wantStatus:  http.StatusOK,
+			wantJSON:    map[string]string{"status": commentDeleted},
+			wantLookups: 0,
+		},
+		{
+			name:        "purge",
+			method:      http.MethodDelete,
+			path:        "/api/users/me/trash/comment/" + purged,
+			headers:     user,
+			wantStatus:  http.StatusNoContent,
+			wantLookups: 0,
+		},
+		{
+			name:        "a purged comment can't be restored",
+			method:      http.MethodPost,
+			path:        "/api/users/me/trash/comment/" + purged + "/restore",
+			headers:     user,
+			wantStatus:  http.StatusNotFound,
+			wantLookups: 0,
+		},
+	})
+
+	if cm, ok := loadComment("", "tt0133093", purged); !ok || cm.Body != "" || cm.AuthorName != "" {
+		t.Errorf("purged comment = %+v, want its body and author erased", cm)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// registerUI serves the embedded single-page client at / with its assets
+// under /static, and loads the templates for the server-rendered pages.
+func registerUI(router *gin.Engine) {
+	static, _ := fs.Sub(webFiles, "web/static")
+	router.StaticFS("/static", http.FS(static))
+	router.GET("/", func(c *gin.Context) {
+		c.FileFromFS("/", http.FS(static))
+	})
+	router.SetHTMLTemplate(template.Must(template.ParseFS(webFiles, "web/templates/*.tmpl")))
+}
+
+// pageMeta feeds the OpenGraph/Twitter tags in head.tmpl so shared links
+// unfurl with a title, description and poster.
+type pageMeta struct {
+	Title       string
+	Description string
+	Type        string
+	URL         string
+	Image       string
+}
+
+// publicURL makes path absolute, preferring the configured public base URL
+// since the Host header behind a proxy is often internal.
+func publicURL(c *gin.Context, path string) string {
+	if base := cfg().PublicBaseURL; base != "" {
+		return strings.TrimRight(base, "/") + path
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + path
+}
+
+func htmlPagesEnabled(c *gin.Context) bool {
+	if !cfg().HTMLPages {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return false
+	}
+	return true
+}
+
+func getMoviePage(c *gin.Context) {
+	if !htmlPagesEnabled(c) {
+		return
+	}
+	movie, err := fetchMovie(scopedParams(c, map[string]string{"i": c.Param("imdbID")}))
+	if err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+	if fc := cfg().ContentFilter; fc.Enabled {
+		filtered := *movie
+		filtered.Plot, _ = maskProfanity(movie.Plot, defaultLanguage)
+		movie = &filtered
+	}
+	meta := pageMeta{
+		Title:       movie.Title + " (" + movie.Year + ")",
+		Description: movie.Plot,
+		Type:        "video.movie",
+		URL:         publicURL(c, "/m/"+movie.IMDBID),
+	}
+	if movie.Poster != "" && movie.Poster != "N/A" {
+		meta.Image = movie.Poster
+	}
+	c.HTML(http.StatusOK, "movie.tmpl", gin.H{"Meta": meta, "Movie": movie})
+}
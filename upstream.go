@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// omdbClient is the HTTP client for upstream lookups. It honors
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY and is configured from:
+//
+//	UPSTREAM_USER_AGENT      User-Agent header sent to OMDb
+//	UPSTREAM_TIMEOUT         whole-request timeout (default 15s)
+//	UPSTREAM_DIAL_TIMEOUT    TCP connect timeout (default 5s)
+//	UPSTREAM_TLS_CA_FILE     extra PEM CA bundle, e.g. for an intercepting proxy
+//	UPSTREAM_TLS_MIN_VERSION 1.2 or 1.3
+//	UPSTREAM_TLS_INSECURE    "true" skips certificate verification
+var omdbClient = &http.Client{Timeout: 15 * time.Second}
+
+func newUpstreamClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	switch v := envString("UPSTREAM_TLS_MIN_VERSION", "1.2"); v {
+	case "1.2":
+	case "1.3":
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("UPSTREAM_TLS_MIN_VERSION must be 1.2 or 1.3, got %q", v)
+	}
+	if path := envString("UPSTREAM_TLS_CA_FILE", ""); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", path)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	tlsConfig.InsecureSkipVerify = envString("UPSTREAM_TLS_INSECURE", "") == "true"
+
+	dialer := &net.Dialer{Timeout: envDuration("UPSTREAM_DIAL_TIMEOUT", 5*time.Second), KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+		ForceAttemptHTTP2:   true,
+	}
+	return &http.Client{
+		Timeout:   envDuration("UPSTREAM_TIMEOUT", 15*time.Second),
+		Transport: userAgentTransport{envString("UPSTREAM_USER_AGENT", "movie-api/1"), transport},
+	}, nil
+}
+
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
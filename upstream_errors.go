@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// upstreamError is a failed OMDb lookup classified by what the client
+// should make of it.
+type upstreamError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *upstreamError) Error() string {
+	return e.Message
+}
+
+// omdbError classifies the Error string of a Response=False body.
+func omdbError(message string) error {
+	lower := strings.ToLower(message)
+	switch {
+	case strings.Contains(lower, "not found"), strings.Contains(lower, "incorrect imdb id"):
+		return &upstreamError{http.StatusNotFound, "not_found", message}
+	case strings.Contains(lower, "limit reached"):
+		return &upstreamError{http.StatusTooManyRequests, "upstream_limit_reached", message}
+	case strings.Contains(lower, "too many results"):
+		return &upstreamError{http.StatusBadRequest, "query_too_broad", message}
+	case strings.Contains(lower, "api key"):
+		return &upstreamError{http.StatusBadGateway, "upstream_auth_failed", message}
+	default:
+		return &upstreamError{http.StatusBadGateway, "upstream_error", message}
+	}
+}
+
+func upstreamUnavailable(err error) error {
+	return &upstreamError{http.StatusBadGateway, "upstream_unavailable", "OMDb request failed: " + withoutURL(err).Error()}
+}
+
+// withoutURL drops the request URL from transport errors, since it
+// contains the API key.
+func withoutURL(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return ue.Err
+	}
+	return err
+}
+
+func decodeFailed(err error) error {
+	return &upstreamError{http.StatusInternalServerError, "decode_error", "could not decode OMDb response: " + err.Error()}
+}
+
+func invalidParameter(err error) error {
+	return &upstreamError{http.StatusBadRequest, "invalid_parameter", err.Error()}
+}
+
+// isNotFound reports whether OMDb had nothing for the lookup.
+func isNotFound(err error) bool {
+	var ue *upstreamError
+	return errors.As(err, &ue) && ue.Code == "not_found"
+}
+
+// isAuthFailure reports whether OMDb rejected the API key.
+func isAuthFailure(err error) bool {
+	var ue *upstreamError
+	return errors.As(err, &ue) && ue.Code == "upstream_auth_failed"
+}
+
+// respondUpstreamError writes err with the status it maps to. Errors that
+// weren't classified are treated as upstream failures.
+func respondUpstreamError(c *gin.Context, err error) {
+	var ue *upstreamError
+	if !errors.As(err, &ue) {
+		ue = &upstreamError{http.StatusBadGateway, "upstream_error", err.Error()}
+	}
+	if ue.Status == http.StatusTooManyRequests {
+		c.Header("Retry-After", "3600")
+	}
+	if ue.Status == http.StatusInternalServerError {
+		reportError(c, err)
+	}
+	c.JSON(ue.Status, gin.H{"error": ue.Message, "code": ue.Code})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const watchesBucket = "watches"
+
+// Watch is one logged viewing. Title metadata is copied in at logging time
+// so reports don't need an upstream call per entry.
+type Watch struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"-"`
+	IMDBID     string    `json:"imdbID"`
+	Title      string    `json:"Title"`
+	Year       string    `json:"Year"`
+	Type       string    `json:"Type"`
+	Genre      string    `json:"Genre"`
+	Director   string    `json:"Director"`
+	Runtime    int       `json:"runtime_minutes"`
+	IMDBRating string    `json:"imdbRating"`
+	Rating     float64   `json:"rating,omitempty"`
+	WatchedAt  time.Time `json:"watched_at"`
+	// SeriesID, Season and Episode are only set for episodes.
+	SeriesID string `json:"seriesID,omitempty"`
+	Season   int    `json:"Season,omitempty"`
+	Episode  int    `json:"Episode,omitempty"`
+}
+
+func userWatches(userID string) []Watch {
+	watches := []Watch{}
+	store.ForEachPrefix(watchesBucket, userID+"/", func(_ string, value []byte) error {
+		var w Watch
+		if json.Unmarshal(value, &w) == nil {
+			watches = append(watches, w)
+		}
+		return nil
+	})
+	sort.Slice(watches, func(i, j int) bool {
+		if !watches[i].WatchedAt.Equal(watches[j].WatchedAt) {
+			return watches[i].WatchedAt.Before(watches[j].WatchedAt)
+		}
+		return watches[i].ID < watches[j].ID
+	})
+	return watches
+}
+
+type watchRequest struct {
+	IMDBID    string     `json:"imdbID"`
+	Rating    float64    `json:"rating"`
+	WatchedAt *time.Time `json:"watched_at"`
+}
+
+func postWatch(c *gin.Context) {
+	var req watchRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.IMDBID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Please provide {"imdbID": "tt...", "rating": 1-10, "watched_at": RFC3339}`})
+		return
+	}
+	if req.Rating < 0 || req.Rating > 10 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rating must be between 1 and 10"})
+		return
+	}
+	movie, err := fetchMovie(scopedParams(c, map[string]string{"i": req.IMDBID}))
+	if err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+
+	u := currentUser(c)
+	w := Watch{
+		ID:         strconv.FormatInt(time.Now().UnixNano(), 36),
+		UserID:     u.ID,
+		IMDBID:     movie.IMDBID,
+		Title:      movie.Title,
+		Year:       movie.Year,
+		Type:       movie.Type,
+		Genre:      movie.Genre,
+		Director:   movie.Director,
+		Runtime:    runtimeMinutes(movie.Runtime),
+		IMDBRating: movie.IMDBRating,
+		Rating:     req.Rating,
+		WatchedAt:  time.Now().UTC(),
+	}
+	if movie.Type == "episode" {
+		w.SeriesID = movie.SeriesID
+		w.Season, _ = strconv.Atoi(movie.Season)
+		w.Episode, _ = strconv.Atoi(movie.Episode)
+	}
+	if req.WatchedAt != nil {
+		w.WatchedAt = req.WatchedAt.UTC()
+	}
+	if err := store.Put(watchesBucket, u.ID+"/"+w.ID, w); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save watch"})
+		return
+	}
+	c.JSON(http.StatusCreated, w)
+}
+
+// getWatches returns the whole history, or with ?limit= or ?cursor= one
+// page of it as {"items": [...], "next_cursor": "..."}.
+func getWatches(c *gin.Context) {
+	var q pageQuery
+	if !bindQuery(c, &q) {
+		return
+	}
+	watches := userWatches(currentUser(c).ID)
+	total := len(watches)
+	if q.Limit == 0 && q.Cursor == "" {
+		respondList(c, http.StatusOK, watches, listMeta{Total: total}, watches)
+		return
+	}
+	if q.Limit == 0 {
+		q.Limit = 50
+	}
+	if q.Cursor != "" {
+		var cur timeCursor
+		if err := decodeCursor(q.Cursor, &cur); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		i := sort.Search(len(watches), func(i int) bool {
+			at := watches[i].WatchedAt
+			return at.After(cur.At) || (at.Equal(cur.At) && watches[i].ID > cur.ID)
+		})
+		watches = watches[i:]
+	}
+	resp := gin.H{"items": watches}
+	meta := listMeta{Total: total}
+	if len(watches) > q.Limit {
+		last := watches[q.Limit-1]
+		watches = watches[:q.Limit]
+		meta.NextCursor = encodeCursor(timeCursor{At: last.WatchedAt, ID: last.ID})
+		resp["items"], resp["next_cursor"] = watches, meta.NextCursor
+	}
+	respondList(c, http.StatusOK, watches, meta, resp)
+}
+
+func deleteWatch(c *gin.Context) {
+	if err := store.Delete(watchesBucket, currentUser(c).ID+"/"+c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete watch"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// runtimeMinutes parses OMDb's "142 min"; unknown runtimes are 0.
+func runtimeMinutes(runtime string) int {
+	n, _ := strconv.Atoi(strings.TrimSuffix(runtime, " min"))
+	return n
+}
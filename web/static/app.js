@@ -0,0 +1,107 @@
+// A deliberately small single-page client for the API. Routing is done on
+// the URL hash so the server only has to serve index.html.
+(function () {
+  const app = document.getElementById("app");
+
+  const esc = (s) => String(s ?? "").replace(/[&<>"']/g, (c) =>
+    ({ "&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;" }[c]));
+
+  // Lists are read from schema 3's {data, meta} envelope, so ask for it.
+  async function api(path) {
+    const res = await fetch(path, { credentials: "same-origin", headers: { "X-API-Version": "3" } });
+    const body = await res.json().catch(() => ({}));
+    if (!res.ok) throw new Error(body.error || res.statusText);
+    return body;
+  }
+
+  function card(m, extra) {
+    return `<div class="card">
+      <a href="#/movie/${esc(m.imdbID)}">${esc(m.Title)}</a>
+      <div class="muted">${esc(m.Year)}${m.imdbRating ? " · ★ " + esc(m.imdbRating) : ""}</div>
+      ${extra ? `<div class="muted">${esc(extra)}</div>` : ""}
+    </div>`;
+  }
+
+  const loading = () => { app.innerHTML = `<p class="muted">Loading…</p>`; };
+  const fail = (err) => { app.innerHTML += `<p class="error">${esc(err.message)}</p>`; };
+
+  function searchPage(params) {
+    const q = params.get("q") || "";
+    app.innerHTML = `<form id="f"><input name="q" placeholder="Search titles" value="${esc(q)}" autofocus><button>Search</button></form><div id="results"></div>`;
+    document.getElementById("f").onsubmit = (e) => {
+      e.preventDefault();
+      location.hash = "#/?q=" + encodeURIComponent(e.target.q.value);
+    };
+    if (!q) return;
+    const results = document.getElementById("results");
+    results.innerHTML = `<p class="muted">Searching…</p>`;
+    api("/api/search?q=" + encodeURIComponent(q))
+      .then((r) => { results.innerHTML = `<div class="grid">${r.data.map((m) => card(m, m.Type)).join("")}</div>`; })
+      .catch((err) => { results.innerHTML = `<p class="error">${esc(err.message)}</p>`; });
+  }
+
+  async function moviePage(id) {
+    loading();
+    try {
+      const m = await api("/api/movie?id=" + encodeURIComponent(id));
+      app.innerHTML = `<h1>${esc(m.Title)} <span class="muted">(${esc(m.Year)})</span></h1>
+        <p>${esc(m.Plot)}</p>
+        <p class="muted">Directed by ${esc(m.Director)} · ${esc(m.Country)}</p>
+        <p class="muted">${esc(m.Awards)}</p>
+        <ul>${(m.Ratings || []).map((r) => `<li>${esc(r.Source)}: ${esc(r.Value)}</li>`).join("")}</ul>
+        <p><a href="#/recommendations?favorite_movie=${encodeURIComponent(m.Title)}">More like this →</a></p>`;
+    } catch (err) { app.innerHTML = ""; fail(err); }
+  }
+
+  function genrePage(params) {
+    const genre = params.get("genre") || "";
+    const genres = ["Action", "Adventure", "Animation", "Comedy", "Crime", "Documentary", "Drama",
+      "Family", "Fantasy", "Horror", "Mystery", "Romance", "Sci-Fi", "Thriller", "War", "Western"];
+    app.innerHTML = `<form id="f"><select name="genre">${genres.map((g) =>
+      `<option${g === genre ? " selected" : ""}>${g}</option>`).join("")}</select><button>Browse</button></form><div id="results"></div>`;
+    document.getElementById("f").onsubmit = (e) => {
+      e.preventDefault();
+      location.hash = "#/genre?genre=" + encodeURIComponent(e.target.genre.value);
+    };
+    if (!genre) return;
+    const results = document.getElementById("results");
+    results.innerHTML = `<p class="muted">Scanning titles, this can take a while…</p>`;
+    api("/api/movies/genre?genre=" + encodeURIComponent(genre))
+      .then((r) => { results.innerHTML = `<div class="grid">${r.data.map((m) => card(m)).join("")}</div>`; })
+      .catch((err) => { results.innerHTML = `<p class="error">${esc(err.message)}</p>`; });
+  }
+
+  function recommendationsPage(params) {
+    const fav = params.get("favorite_movie") || "";
+    app.innerHTML = `<form id="f"><input name="fav" placeholder="Your favorite movie" value="${esc(fav)}"><button>Recommend</button></form><div id="results"></div>`;
+    document.getElementById("f").onsubmit = (e) => {
+      e.preventDefault();
+      location.hash = "#/recommendations?favorite_movie=" + encodeURIComponent(e.target.fav.value);
+    };
+    if (!fav) return;
+    const results = document.getElementById("results");
+    results.innerHTML = `<p class="muted">Finding recommendations…</p>`;
+    api("/api/movies/recommendations?favorite_movie=" + encodeURIComponent(fav))
+      .then((r) => {
+        results.innerHTML = `<p class="muted">Because you like ${esc(r.favorite_movie)}</p>` +
+          Object.entries(r.recommendations).map(([group, list]) =>
+            `<h2>${esc(group.replace(/^by_/, "By ").replace(/_/g, " "))}</h2><div class="grid">${list.map((m) => card(m, m.Why)).join("")}</div>`).join("");
+      })
+      .catch((err) => { results.innerHTML = `<p class="error">${esc(err.message)}</p>`; });
+  }
+
+  function route() {
+    const [path, query] = location.hash.replace(/^#/, "").split("?");
+    const params = new URLSearchParams(query || "");
+    const parts = (path || "/").split("/").filter(Boolean);
+    switch (parts[0]) {
+      case "movie": return moviePage(parts[1]);
+      case "genre": return genrePage(params);
+      case "recommendations": return recommendationsPage(params);
+      default: return searchPage(params);
+    }
+  }
+
+  window.addEventListener("hashchange", route);
+  route();
+})();
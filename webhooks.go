@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	webhooksBucket          = "webhooks"
+	webhookDeliveriesBucket = "webhook_deliveries"
+)
+
+// Webhook event types.
+const (
+	eventPing                   = "ping"
+	eventWatchlistTitleReleased = "watchlist.title_released"
+	eventSeriesNewEpisode       = "series.new_episode"
+	eventJobCompleted           = "job.completed"
+	eventSavedSearchNewTitles   = "saved_search.new_titles"
+)
+
+var webhookEvents = []string{eventWatchlistTitleReleased, eventSeriesNewEpisode, eventJobCompleted, eventSavedSearchNewTitles}
+
+// webhookRetryDelays is the wait before each retry; a delivery is attempted
+// len(webhookRetryDelays)+1 times in total.
+var webhookRetryDelays = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute, 30 * time.Minute}
+
+func init() {
+	registerJobKind("webhook_delivery", jobKind{
+		run:         runWebhookDelivery,
+		maxAttempts: len(webhookRetryDelays) + 1,
+		backoff: func(attempt int) time.Duration {
+			return webhookRetryDelays[min(attempt, len(webhookRetryDelays))-1]
+		},
+	})
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+type Webhook struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type webhookDelivery struct {
+	ID         string    `json:"id"`
+	WebhookID  string    `json:"webhook_id"`
+	Event      string    `json:"event"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Success    bool      `json:"success"`
+	At         time.Time `json:"at"`
+}
+
+func webhookKey(userID, id string) string {
+	return userID + "/" + id
+}
+
+func userWebhooks(userID string) []Webhook {
+	hooks := []Webhook{}
+	store.ForEachPrefix(webhooksBucket, userID+"/", func(_ string, value []byte) error {
+		var h Webhook
+		if json.Unmarshal(value, &h) == nil {
+			hooks = append(hooks, h)
+		}
+		return nil
+	})
+	return hooks
+}
+
+// publishEvent delivers an event to every webhook the user subscribed to
+// it. Deliveries are queued as jobs; failures are retried and recorded in
+// the delivery log.
+func publishEvent(userID, event string, data interface{}) {
+	for _, h := range userWebhooks(userID) {
+		if slices.Contains(h.Events, event) {
+			deliverWebhook(h, event, data)
+		}
+	}
+}
+
+// webhookJob is the payload of a webhook_delivery job. The body is built
+// once so every attempt sends the same delivery.
+type webhookJob struct {
+	UserID     string          `json:"user_id"`
+	WebhookID  string          `json:"webhook_id"`
+	Event      string          `json:"event"`
+	DeliveryID string          `json:"delivery_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func deliverWebhook(h Webhook, event string, data interface{}) {
+	deliveryID := randomHex(8)
+	body, err := json.Marshal(gin.H{
+		"id":         deliveryID,
+		"event":      event,
+		"created_at": time.Now().UTC(),
+		"data":       data,
+	})
+	if err == nil {
+		_, err = enqueueJob("webhook_delivery", "", webhookJob{UserID: h.UserID, WebhookID: h.ID, Event: event, DeliveryID: deliveryID, Body: body})
+	}
+	if err != nil {
+		slog.Error("could not queue webhook delivery", "webhook", h.ID, "error", err)
+	}
+}
+
+func runWebhookDelivery(j *Job) error {
+	var p webhookJob
+	if err := json.Unmarshal(j.Payload, &p); err != nil {
+		return err
+	}
+	var h Webhook
+	if found, err := store.Get(webhooksBucket, webhookKey(p.UserID, p.WebhookID), &h); err != nil {
+		return err
+	} else if !found {
+		// The webhook was deleted since; there's no one to deliver to.
+		return nil
+	}
+	d := webhookDelivery{ID: p.DeliveryID, WebhookID: h.ID, Event: p.Event, Attempt: j.Attempts, At: time.Now().UTC()}
+	status, err := sendWebhook(h, p.Event, p.DeliveryID, p.Body)
+	d.StatusCode, d.Success = status, err == nil
+	if err != nil {
+		d.Error = err.Error()
+	}
+	store.Put(webhookDeliveriesBucket, h.ID+"/"+d.At.Format(time.RFC3339Nano), d)
+	return err
+}
+
+// sendWebhook POSTs body signed with the webhook's secret. Receivers verify
+// X-Webhook-Signature = "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)).
+func sendWebhook(h Webhook, event, deliveryID string, body []byte) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(h.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "movie-api-webhooks/1")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Delivery", deliveryID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+func postWebhook(c *gin.Context) {
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an absolute http(s) URL"})
+		return
+	}
+	if len(req.Events) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "subscribe to at least one event"})
+		return
+	}
+	for _, e := range req.Events {
+		if !slices.Contains(webhookEvents, e) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown event " + e, "events": webhookEvents})
+			return
+		}
+	}
+	if req.Secret == "" {
+		req.Secret = randomHex(24)
+	}
+
+	h := Webhook{
+		ID:        randomHex(8),
+		UserID:    currentUser(c).ID,
+		URL:       req.URL,
+		Secret:    req.Secret,
+		Events:    req.Events,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := store.Put(webhooksBucket, webhookKey(h.UserID, h.ID), h); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save webhook"})
+		return
+	}
+	// The secret is shown once, on creation.
+	c.JSON(http.StatusCreated, h)
+}
+
+func getWebhooks(c *gin.Context) {
+	hooks := userWebhooks(currentUser(c).ID)
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks, "events": webhookEvents})
+}
+
+func ownedWebhook(c *gin.Context) (*Webhook, bool) {
+	var h Webhook
+	found, err := store.Get(webhooksBucket, webhookKey(currentUser(c).ID, c.Param("id")), &h)
+	if err != nil || !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return nil, false
+	}
+	return &h, true
+}
+
+func deleteWebhook(c *gin.Context) {
+	h, ok := ownedWebhook(c)
+	if !ok {
+		return
+	}
+	if err := store.Delete(webhooksBucket, webhookKey(h.UserID, h.ID)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete webhook"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// postWebhookTest sends a ping synchronously so the caller can see whether
+// their receiver works.
+func postWebhookTest(c *gin.Context) {
+	h, ok := ownedWebhook(c)
+	if !ok {
+		return
+	}
+	deliveryID := randomHex(8)
+	body, _ := json.Marshal(gin.H{"id": deliveryID, "event": eventPing, "created_at": time.Now().UTC()})
+	status, err := sendWebhook(*h, eventPing, deliveryID, body)
+	d := webhookDelivery{ID: deliveryID, WebhookID: h.ID, Event: eventPing, Attempt: 1, StatusCode: status, Success: err == nil, At: time.Now().UTC()}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	store.Put(webhookDeliveriesBucket, h.ID+"/"+d.At.Format(time.RFC3339Nano), d)
+	c.JSON(http.StatusOK, d)
+}
+
+func getWebhookDeliveries(c *gin.Context) {
+	h, ok := ownedWebhook(c)
+	if !ok {
+		return
+	}
+	deliveries := []webhookDelivery{}
+	store.ForEachPrefix(webhookDeliveriesBucket, h.ID+"/", func(_ string, value []byte) error {
+		var d webhookDelivery
+		if json.Unmarshal(value, &d) == nil {
+			deliveries = append(deliveries, d)
+		}
+		return nil
+	})
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].At.After(deliveries[j].At) })
+	if len(deliveries) > 100 {
+		deliveries = deliveries[:100]
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type countedName struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// topCounts returns the n most frequent names, ties broken alphabetically.
+func topCounts(counts map[string]int, n int) []countedName {
+	out := make([]countedName, 0, len(counts))
+	for name, count := range counts {
+		out = append(out, countedName{name, count})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+type monthSummary struct {
+	Month   string  `json:"month"`
+	Watches int     `json:"watches"`
+	Hours   float64 `json:"hours"`
+}
+
+func getYearInReview(c *gin.Context) {
+	year, err := strconv.Atoi(c.DefaultQuery("year", strconv.Itoa(time.Now().Year())))
+	if err != nil || year < 1900 || year > 3000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "year must be a four-digit year"})
+		return
+	}
+
+	months := make([]monthSummary, 12)
+	for i := range months {
+		months[i].Month = time.Month(i + 1).String()
+	}
+	genres := map[string]int{}
+	directors := map[string]int{}
+	minutes := 0
+	var rated []Watch
+	var watches []Watch
+	for _, w := range userWatches(currentUser(c).ID) {
+		if w.WatchedAt.Year() != year {
+			continue
+		}
+		watches = append(watches, w)
+		minutes += w.Runtime
+		m := &months[w.WatchedAt.Month()-1]
+		m.Watches++
+		m.Hours += float64(w.Runtime) / 60
+		for _, g := range strings.Split(w.Genre, ",") {
+			if g = strings.TrimSpace(g); g != "" && g != "N/A" {
+				genres[g]++
+			}
+		}
+		for _, d := range strings.Split(w.Director, ",") {
+			if d = strings.TrimSpace(d); d != "" && d != "N/A" {
+				directors[d]++
+			}
+		}
+		if w.Rating > 0 {
+			rated = append(rated, w)
+		}
+	}
+	for i := range months {
+		months[i].Hours = roundTo(months[i].Hours, 1)
+	}
+
+	resp := gin.H{
+		"year":          year,
+		"total_watches": len(watches),
+		"unique_titles": uniqueTitles(watches),
+		"hours_watched": roundTo(float64(minutes)/60, 1),
+		"top_genres":    topCounts(genres, 5),
+		"top_directors": topCounts(directors, 5),
+		"months":        months,
+	}
+	if len(rated) > 0 {
+		sort.SliceStable(rated, func(i, j int) bool { return rated[i].Rating > rated[j].Rating })
+		resp["highest_rated"] = rated[0]
+		resp["lowest_rated"] = rated[len(rated)-1]
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func uniqueTitles(watches []Watch) int {
+	seen := map[string]bool{}
+	for _, w := range watches {
+		seen[w.IMDBID] = true
+	}
+	return len(seen)
+}
+
+func roundTo(v float64, places int) float64 {
+	p, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'f', places, 64), 64)
+	return p
+}